@@ -0,0 +1,102 @@
+// Command check-aof checks that the AOF of a Godis server holds complete,
+// valid commands up to its end, as redis-check-aof does, and reports where
+// the first corruption starts. With --fix, it truncates the file there,
+// dropping everything that follows, provided no file of the AOF after it
+// holds commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/manimovassagh/Godis/internal/aof"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+// run checks the AOF named by args, writes its report to out and returns the
+// exit status.
+func run(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("check-aof", flag.ContinueOnError)
+	flags.SetOutput(out)
+	fix := flags.Bool("fix", false, "truncate the file at the first corruption")
+	flags.Usage = func() {
+		fmt.Fprintln(out, "Usage: check-aof [--fix] <appendonlydir|file.manifest|file.aof>")
+	}
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		flags.Usage()
+		return 1
+	}
+	paths, err := files(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(out, "Cannot read the AOF: %v\n", err)
+		return 1
+	}
+	for i, path := range paths {
+		err := aof.CheckFile(path)
+		if err == nil {
+			fmt.Fprintf(out, "%s: OK\n", path)
+			continue
+		}
+		fmt.Fprintf(out, "%s: %v\n", path, err)
+		var corruption *aof.CorruptionError
+		if !errors.As(err, &corruption) {
+			return 1
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(out, "Cannot read %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Fprintf(out, "AOF analyzed: filename=%s, size=%d, ok_up_to=%d, diff=%d\n",
+			path, info.Size(), corruption.Offset, info.Size()-corruption.Offset)
+		switch {
+		case !*fix:
+			fmt.Fprintln(out, "Run again with --fix to truncate the file at the first corruption")
+			return 1
+		case !allEmpty(paths[i+1:]):
+			fmt.Fprintf(out, "%s is not the last file of the AOF holding commands, and can't be fixed by truncating it\n", path)
+			return 1
+		}
+		if err := os.Truncate(path, corruption.Offset); err != nil {
+			fmt.Fprintf(out, "Failed to truncate %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Fprintf(out, "Successfully truncated %s to %d bytes\n", path, corruption.Offset)
+	}
+	return 0
+}
+
+// files returns the files of the AOF at path, which is either the directory
+// of an AOF, its manifest, or a single AOF file.
+func files(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return nil, err
+	case info.IsDir():
+		return aof.Files(path)
+	case strings.HasSuffix(path, ".manifest"):
+		return aof.Files(filepath.Dir(path))
+	default:
+		return []string{path}, nil
+	}
+}
+
+// allEmpty reports whether the files at paths are all empty.
+func allEmpty(paths []string) bool {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() > 0 {
+			return false
+		}
+	}
+	return true
+}
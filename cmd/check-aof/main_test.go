@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// TestCheckSingleFile tests that a truncated file is reported, and only
+// truncated with --fix
+func TestCheckSingleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	valid := protocol.FormatCommand([]string{"SET", "a", "1"})
+	os.WriteFile(path, []byte(valid+"*2\r\n$3\r\nGET"), 0644)
+
+	var out strings.Builder
+	if status := run([]string{path}, &out); status != 1 || !strings.Contains(out.String(), "ok_up_to="+strconv.Itoa(len(valid))) {
+		t.Errorf("Expected the corruption to be reported, got %d and %q", status, out.String())
+	}
+	if content, _ := os.ReadFile(path); len(content) == len(valid) {
+		t.Error("Expected the file to be left alone without --fix")
+	}
+	out.Reset()
+	if status := run([]string{"--fix", path}, &out); status != 0 {
+		t.Errorf("Expected the file to be fixed, got %d and %q", status, out.String())
+	}
+	if content, _ := os.ReadFile(path); string(content) != valid {
+		t.Errorf("Expected the file to be truncated to %q, got %q", valid, content)
+	}
+	out.Reset()
+	if status := run([]string{path}, &out); status != 0 || !strings.HasSuffix(out.String(), ": OK\n") {
+		t.Errorf("Expected the fixed file to be valid, got %d and %q", status, out.String())
+	}
+}
+
+// TestCheckManifest tests that the files listed by a manifest are checked,
+// and that only the last one is fixed
+func TestCheckManifest(t *testing.T) {
+	dir := t.TempDir()
+	valid := protocol.FormatCommand([]string{"SET", "a", "1"})
+	os.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"),
+		[]byte("file base.aof seq 1 type b\nfile incr.aof seq 1 type i\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "base.aof"), []byte(valid+"+OK\r\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "incr.aof"), []byte(valid), 0644)
+
+	var out strings.Builder
+	manifest := filepath.Join(dir, "appendonly.aof.manifest")
+	if status := run([]string{"--fix", manifest}, &out); status != 1 || !strings.Contains(out.String(), "not the last file of the AOF holding commands") {
+		t.Errorf("Expected the base file not to be fixed, got %d and %q", status, out.String())
+	}
+	os.WriteFile(filepath.Join(dir, "base.aof"), []byte(valid), 0644)
+	os.WriteFile(filepath.Join(dir, "incr.aof"), []byte(valid+"*1\r\n"), 0644)
+	out.Reset()
+	if status := run([]string{"--fix", dir}, &out); status != 0 {
+		t.Errorf("Expected the last file to be fixed, got %d and %q", status, out.String())
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "incr.aof")); string(content) != valid {
+		t.Errorf("Expected the last file to be truncated to %q, got %q", valid, content)
+	}
+
+	// Only empty files follow a truncated file moved from a single file AOF.
+	os.WriteFile(filepath.Join(dir, "base.aof"), []byte(valid+"*1\r\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "incr.aof"), nil, 0644)
+	out.Reset()
+	if status := run([]string{"--fix", dir}, &out); status != 0 {
+		t.Errorf("Expected the base file to be fixed, got %d and %q", status, out.String())
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "base.aof")); string(content) != valid {
+		t.Errorf("Expected the base file to be truncated to %q, got %q", valid, content)
+	}
+}
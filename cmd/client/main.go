@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/manimovassagh/Godis/internal/protocol"
@@ -45,7 +46,8 @@ func main() {
 
 		// Parse input into arguments
 		args := parseInput(input)
-		if len(args) == 0 {
+		if args == nil {
+			fmt.Println("Invalid argument(s)")
 			continue
 		}
 
@@ -58,43 +60,187 @@ func main() {
 		}
 
 		// Read response from server
-		response, err := protocol.ReadResponse(serverReader)
+		reply, err := protocol.ReadReply(serverReader)
 		if err != nil {
 			fmt.Printf("Error reading response: %v\n", err)
 			continue
 		}
 
 		// Print the response
-		fmt.Println(response)
+		fmt.Println(formatReply(reply))
 	}
 }
 
-// parseInput splits the input string into arguments, handling quotes
+// parseInput splits the input string into arguments the way redis-cli does.
+// An argument can be wrapped in double quotes, inside which \n, \r, \t, \b,
+// \a, \\, \" and \xHH escape arbitrary bytes, or in single quotes, inside
+// which only \' is an escape. It returns nil if a quote is not closed or is
+// directly followed by something other than a space.
 func parseInput(input string) []string {
 	var args []string
-	var current strings.Builder
-	inQuotes := false
-
-	for i := 0; i < len(input); i++ {
-		c := input[i]
-		switch c {
-		case ' ':
-			if inQuotes {
-				current.WriteByte(c)
-			} else if current.Len() > 0 {
-				args = append(args, current.String())
-				current.Reset()
+	i := 0
+	for {
+		for i < len(input) && isSpace(input[i]) {
+			i++
+		}
+		if i == len(input) {
+			return args
+		}
+		var current []byte
+		inQuotes, inSingleQuotes := false, false
+		for done := false; !done; i++ {
+			if i == len(input) {
+				if inQuotes || inSingleQuotes {
+					return nil
+				}
+				break
+			}
+			c := input[i]
+			switch {
+			case inQuotes:
+				switch {
+				case c == '\\' && i+3 < len(input) && input[i+1] == 'x' && isHexDigit(input[i+2]) && isHexDigit(input[i+3]):
+					current = append(current, hexValue(input[i+2])<<4|hexValue(input[i+3]))
+					i += 3
+				case c == '\\' && i+1 < len(input):
+					i++
+					switch input[i] {
+					case 'n':
+						current = append(current, '\n')
+					case 'r':
+						current = append(current, '\r')
+					case 't':
+						current = append(current, '\t')
+					case 'b':
+						current = append(current, '\b')
+					case 'a':
+						current = append(current, '\a')
+					default:
+						current = append(current, input[i])
+					}
+				case c == '"':
+					if i+1 < len(input) && !isSpace(input[i+1]) {
+						return nil
+					}
+					done = true
+				default:
+					current = append(current, c)
+				}
+			case inSingleQuotes:
+				switch {
+				case c == '\\' && i+1 < len(input) && input[i+1] == '\'':
+					current = append(current, '\'')
+					i++
+				case c == '\'':
+					if i+1 < len(input) && !isSpace(input[i+1]) {
+						return nil
+					}
+					done = true
+				default:
+					current = append(current, c)
+				}
+			case isSpace(c):
+				done = true
+			case c == '"':
+				inQuotes = true
+			case c == '\'':
+				inSingleQuotes = true
+			default:
+				current = append(current, c)
 			}
-		case '"':
-			inQuotes = !inQuotes
-		default:
-			current.WriteByte(c)
 		}
+		args = append(args, string(current))
 	}
+}
 
-	if current.Len() > 0 {
-		args = append(args, current.String())
+// formatReply formats a reply for display. The elements of an array are
+// numbered one per line, as in redis-cli, and those of a nested array are
+// aligned after the number of the array holding them.
+func formatReply(r protocol.Reply) string {
+	switch {
+	case r.Null:
+		return "(nil)"
+	case r.Type == '-':
+		return "(error) " + r.Str
+	case r.Type == ':':
+		return strconv.FormatInt(r.Int, 10)
+	case r.Type != '*':
+		return quoteReply(r.Str)
+	case len(r.Elements) == 0:
+		return "(empty array)"
 	}
+	width := len(strconv.Itoa(len(r.Elements)))
+	indent := strings.Repeat(" ", width+2)
+	var sb strings.Builder
+	for i, element := range r.Elements {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		fmt.Fprintf(&sb, "%*d) ", width, i+1)
+		sb.WriteString(strings.ReplaceAll(formatReply(element), "\n", "\n"+indent))
+	}
+	return sb.String()
+}
 
-	return args
+// quoteReply returns s unchanged if it only holds printable ASCII, and
+// otherwise quoted with the escapes parseInput understands, so that binary
+// replies can be read and pasted back.
+func quoteReply(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] > '~' {
+			return quoteArg(s)
+		}
+	}
+	return s
+}
+
+// quoteArg returns s wrapped in double quotes, with the bytes that are not
+// printable ASCII escaped.
+func quoteArg(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' || c == '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString("\\n")
+		case c == '\r':
+			sb.WriteString("\\r")
+		case c == '\t':
+			sb.WriteString("\\t")
+		case c == '\a':
+			sb.WriteString("\\a")
+		case c == '\b':
+			sb.WriteString("\\b")
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&sb, "\\x%02x", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// isSpace reports whether c separates arguments.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isHexDigit reports whether c is a hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// hexValue returns the value of the hexadecimal digit c.
+func hexValue(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
 }
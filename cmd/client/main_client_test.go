@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bufio"
+	"math/rand"
+	"strings"
 	"testing"
+
+	"github.com/manimovassagh/Godis/internal/protocol"
 )
 
 // TestParseInput tests the parseInput function for correctness
@@ -29,3 +34,84 @@ func TestParseInput(t *testing.T) {
 		}
 	}
 }
+
+// TestParseInputEscapes tests escape sequences, single quotes and unbalanced
+// quotes
+func TestParseInputEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`SET k "a\x00b\nc"`, []string{"SET", "k", "a\x00b\nc"}},
+		{`SET k "\r\t\b\a\\\"\q"`, []string{"SET", "k", "\r\t\b\a\\\"q"}},
+		{`SET k "\xZZ\xff"`, []string{"SET", "k", "xZZ\xff"}},
+		{`SET k 'it\'s "quoted" \n'`, []string{"SET", "k", "it's \"quoted\" \\n"}},
+		{`SET k ''`, []string{"SET", "k", ""}},
+		{"SET  k\tv", []string{"SET", "k", "v"}},
+		{`SET k a\x00`, []string{"SET", "k", "a\\x00"}},
+		{`SET k "open`, nil},
+		{`SET k 'open`, nil},
+		{`SET k "closed"trailing`, nil},
+	}
+
+	for _, test := range tests {
+		args := parseInput(test.input)
+		if (args == nil) != (test.expected == nil) || len(args) != len(test.expected) {
+			t.Errorf("For %q expected %q, got %q", test.input, test.expected, args)
+			continue
+		}
+		for i, arg := range args {
+			if arg != test.expected[i] {
+				t.Errorf("For %q expected arg %q, but got %q", test.input, test.expected[i], arg)
+			}
+		}
+	}
+}
+
+// TestQuoteArgRoundTrip tests that random byte strings quoted for display
+// are parsed back to the same bytes
+func TestQuoteArgRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		b := make([]byte, rng.Intn(32))
+		rng.Read(b)
+		args := parseInput(quoteArg(string(b)))
+		if len(args) != 1 || args[0] != string(b) {
+			t.Fatalf("Expected %q to round-trip through %s, got %q", b, quoteArg(string(b)), args)
+		}
+	}
+	if quoteReply("OK") != "OK" || quoteReply("a\x00") != `"a\x00"` {
+		t.Errorf("Expected only non-printable replies to be quoted")
+	}
+}
+
+// TestFormatReply tests that array replies, nested arrays and nulls are read
+// whole and displayed, leaving the next reply to be read after them
+func TestFormatReply(t *testing.T) {
+	tests := []struct {
+		resp     string
+		expected string
+	}{
+		{"*3\r\n$1\r\na\r\n$-1\r\n$2\r\n\x00b\r\n", "1) a\n2) (nil)\n3) \"\\x00b\""},
+		{"*2\r\n*2\r\n:1\r\n+OK\r\n$1\r\nc\r\n", "1) 1) 1\n   2) OK\n2) c"},
+		{"*10\r\n" + strings.Repeat(":7\r\n", 9) + "*1\r\n-ERR x\r\n", " 1) 7\n 2) 7\n 3) 7\n 4) 7\n 5) 7\n 6) 7\n 7) 7\n 8) 7\n 9) 7\n10) 1) (error) ERR x"},
+		{"*0\r\n", "(empty array)"},
+		{"*-1\r\n", "(nil)"},
+		{"$-1\r\n", "(nil)"},
+	}
+
+	for _, test := range tests {
+		reader := bufio.NewReader(strings.NewReader(test.resp + "+PONG\r\n"))
+		reply, err := protocol.ReadReply(reader)
+		if err != nil {
+			t.Errorf("For %q unexpected error: %v", test.resp, err)
+			continue
+		}
+		if got := formatReply(reply); got != test.expected {
+			t.Errorf("For %q expected %q, got %q", test.resp, test.expected, got)
+		}
+		if next, err := protocol.ReadReply(reader); err != nil || next.Str != "PONG" {
+			t.Errorf("For %q expected the next reply to be PONG, got %q, %v", test.resp, next.Str, err)
+		}
+	}
+}
@@ -2,15 +2,23 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/manimovassagh/Godis/internal/aof"
+	"github.com/manimovassagh/Godis/internal/commands"
+	"github.com/manimovassagh/Godis/internal/config"
 	"github.com/manimovassagh/Godis/internal/server"
 )
 
 func main() {
+	// Apply --name value overrides of the tunable parameters
+	if err := config.ParseArgs(os.Args[1:]); err != nil {
+		log.Fatalf("Invalid arguments: %v", err)
+	}
+
 	// Initialize AOF handler and load existing data
 	aofHandler := aof.GetAOFHandler()
-	if err := aofHandler.LoadCommands(); err != nil {
+	if err := commands.LoadAOF(aofHandler); err != nil {
 		log.Fatalf("Failed to load AOF file: %v", err)
 	}
 
@@ -2,19 +2,80 @@ package aof
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/manimovassagh/Godis/internal/datastore"
 	"github.com/manimovassagh/Godis/internal/protocol"
 )
 
 type AOFHandler struct {
-	file *os.File
+	file aofFile
 	mu   sync.Mutex
+
+	// dir is the directory holding the files of the AOF, which manifest
+	// lists. file is the last incremental file.
+	dir      string
+	manifest *manifest
+	// size is the size of all the files, baseSize the size of the base
+	// file, which auto-aof-rewrite-percentage compares it to, and
+	// incrOffset the size of the files before the one being written.
+	size, baseSize, incrOffset int64
+
+	// inTransaction is set between BeginTransaction and EndTransaction, and
+	// multiWritten once the MULTI opening the transaction has been written.
+	inTransaction bool
+	multiWritten  bool
+
+	// selectedDB is the database the commands written last apply to, or -1
+	// before the first one, so that a SELECT is written whenever it
+	// changes.
+	selectedDB int
+
+	// pending holds the commands not written to the file yet: those of an
+	// open transaction, and those a failed write left behind, which are
+	// written again with the next command or by the background loop.
+	pending []byte
+	// dirty is set when the file was written since its last fsync.
+	dirty bool
+	// writeErr and fsyncErr hold the errors of the last failed write and
+	// fsync, until one succeeds again.
+	writeErr, fsyncErr error
+	// fsyncStart is when the background fsync in progress started, zero
+	// if none is.
+	fsyncStart time.Time
+	// delayedFsyncs counts the writes made while a background fsync had
+	// been running for more than delayedFsyncThreshold.
+	delayedFsyncs int64
+
+	// rewriting is set while a rewrite runs.
+	rewriting bool
+	// rewriteScheduled is set once RewriteDue called for a rewrite, until
+	// Rewrite is called.
+	rewriteScheduled bool
+	rewriteStart     time.Time
+	// lastRewriteTime is how long the last rewrite took, or -1 if none
+	// ran, and lastRewriteErr the error it failed with.
+	lastRewriteTime time.Duration
+	lastRewriteErr  error
+	// lastRewriteFailure is when a rewrite failed last.
+	lastRewriteFailure time.Time
+	// rewrites counts the rewrites started.
+	rewrites int64
+}
+
+// aofFile is the part of *os.File the handler writes to.
+type aofFile interface {
+	Write(b []byte) (int, error)
+	Sync() error
+	Close() error
 }
 
 var (
@@ -24,55 +85,298 @@ var (
 
 func GetAOFHandler() *AOFHandler {
 	once.Do(func() {
-		file, err := os.OpenFile("appendonly.aof", os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+		var err error
+		instance, err = Open(".")
 		if err != nil {
 			panic(fmt.Sprintf("Failed to open AOF file: %v", err))
 		}
-		instance = &AOFHandler{
-			file: file,
-		}
 	})
 	return instance
 }
 
-func (a *AOFHandler) AppendCommand(args []string) {
+// Open opens the AOF kept in the appendonlydir directory under workDir,
+// creating it if needed, and moving into it the single file AOF found in
+// workDir, if any. The manifest must list files that exist. Open then starts
+// the goroutine that fsyncs the file in the background.
+func Open(workDir string) (*AOFHandler, error) {
+	dir := filepath.Join(workDir, dirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m, err = upgrade(workDir, dir, m); err != nil {
+		return nil, err
+	}
+	if err := removeTempFiles(dir); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = &manifest{}
+	}
+	a := &AOFHandler{
+		dir:             dir,
+		manifest:        m,
+		selectedDB:      -1,
+		lastRewriteTime: -1,
+	}
+	a.removeHistory()
+	var lastSize int64
+	for _, info := range m.files() {
+		stat, err := os.Stat(filepath.Join(dir, info.name))
+		if err != nil {
+			return nil, fmt.Errorf("the AOF manifest lists %s: %w", info.name, err)
+		}
+		a.size += stat.Size()
+		if info.kind == baseFile {
+			a.baseSize = stat.Size()
+		} else {
+			lastSize = stat.Size()
+		}
+	}
+	if len(m.incrs) == 0 {
+		err = a.openIncr()
+	} else {
+		last := m.incrs[len(m.incrs)-1].name
+		a.file, err = os.OpenFile(filepath.Join(dir, last), os.O_APPEND|os.O_WRONLY, 0644)
+		a.incrOffset = a.size - lastSize
+	}
+	if err != nil {
+		return nil, err
+	}
+	go a.fsyncLoop(time.Tick(time.Second))
+	return a, nil
+}
+
+// openIncr starts a new incremental file, which the commands logged from
+// then on are written to, after writing the pending ones to the current
+// file and fsyncing it. The caller must hold a.mu and make sure no
+// transaction is open.
+func (a *AOFHandler) openIncr() error {
+	if a.file != nil {
+		a.flush()
+		if a.writeErr != nil {
+			return a.writeErr
+		}
+		if err := a.file.Sync(); err != nil {
+			a.dirty = true
+			a.setFsyncErr(err)
+			return err
+		}
+	}
+	info := a.manifest.nextIncr()
+	path := filepath.Join(a.dir, info.name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	m := a.manifest.clone()
+	m.incrs, m.incrSeq = append(m.incrs, info), info.seq
+	if err := m.persist(a.dir); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+	a.manifest, a.file = m, file
+	a.dirty, a.fsyncErr = false, nil
+	a.incrOffset = a.size
+	// The new file starts in an unknown database, so the commands logged
+	// from now on start with a SELECT.
+	a.selectedDB = -1
+	return nil
+}
+
+// AppendCommand logs a command that applies to the database numbered db.
+// Like the other logging methods, it does nothing on a nil handler, which
+// the client replaying the file uses so that it does not log the commands
+// again.
+func (a *AOFHandler) AppendCommand(db int, args []string) {
+	if a == nil {
+		return
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	cmd := protocol.FormatCommand(args)
-	_, err := a.file.WriteString(cmd)
-	if err != nil {
-		fmt.Printf("Failed to write to AOF: %v\n", err)
+	if a.inTransaction && !a.multiWritten {
+		a.selectDB(db)
+		a.write([]string{"MULTI"})
+		a.multiWritten = true
+	}
+	a.selectDB(db)
+	a.write(args)
+	if !a.inTransaction {
+		a.flush()
 	}
 }
 
-func (a *AOFHandler) LoadCommands() error {
+// selectDB writes a SELECT if db is not the database selected in the file.
+// The caller must hold a.mu.
+func (a *AOFHandler) selectDB(db int) {
+	if db != a.selectedDB {
+		a.write([]string{"SELECT", strconv.Itoa(db)})
+		a.selectedDB = db
+	}
+}
+
+// BeginTransaction starts wrapping the appended commands in a MULTI/EXEC
+// block, so replay applies them all or not at all. The caller must make sure
+// no other client appends commands until EndTransaction. A transaction that
+// appends nothing leaves no trace in the file.
+func (a *AOFHandler) BeginTransaction() {
+	if a == nil {
+		return
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	file, err := os.Open("appendonly.aof")
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // No AOF file exists yet
+	a.inTransaction, a.multiWritten = true, false
+}
+
+// EndTransaction closes the block opened by BeginTransaction.
+func (a *AOFHandler) EndTransaction() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.multiWritten {
+		a.write([]string{"EXEC"})
+	}
+	a.inTransaction, a.multiWritten = false, false
+	a.flush()
+}
+
+// TransactionWritten reports whether a command was appended since
+// BeginTransaction.
+func (a *AOFHandler) TransactionWritten() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.multiWritten
+}
+
+// write queues a single command for the file. The caller must hold a.mu.
+func (a *AOFHandler) write(args []string) {
+	a.pending = append(a.pending, protocol.FormatCommand(args)...)
+}
+
+// LoadCommands replays the commands logged in the AOF by handing each of
+// them to execute, which runs it the way the commands of clients are run.
+// The files listed in the manifest are replayed in order. An incomplete
+// command or transaction at the end of the last file holding commands is
+// dropped under aof-load-truncated, and the file truncated before it.
+func (a *AOFHandler) LoadCommands(execute func(args []string) error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	files := a.manifest.files()
+	for i, info := range files {
+		path := filepath.Join(a.dir, info.name)
+		err := replayFile(path, execute)
+		var corruption *CorruptionError
+		if !errors.As(err, &corruption) {
+			if err != nil {
+				return fmt.Errorf("%s: %w", info.name, err)
+			}
+			continue
+		}
+		if !corruption.Truncated || !loadTruncated.Load() || !a.allEmpty(files[i+1:]) {
+			return fmt.Errorf("%s: %w (make a backup of it, then run check-aof --fix to truncate it there)", info.name, err)
 		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.Truncate(path, corruption.Offset); err != nil {
+			return err
+		}
+		a.size -= stat.Size() - corruption.Offset
+		if info.kind == baseFile {
+			a.baseSize = corruption.Offset
+		}
+		log.Printf("!!! Warning: short read while loading the AOF file %s !!!", info.name)
+		log.Printf("AOF %s loaded anyway because aof-load-truncated is enabled, truncated to %d bytes", info.name, corruption.Offset)
+	}
+	return nil
+}
+
+// allEmpty reports whether files are all empty, like the incremental file
+// Open creates after the file being written when the server stopped.
+func (a *AOFHandler) allEmpty(files []aofFileInfo) bool {
+	for _, info := range files {
+		stat, err := os.Stat(filepath.Join(a.dir, info.name))
+		if err != nil || stat.Size() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// replayFile hands every command of the file at path to execute.
+func replayFile(path string, execute func(args []string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
 		return err
 	}
 	defer file.Close()
-	reader := bufio.NewReader(file)
-	datastore := datastore.GetDataStore()
+	return replayAll(file, execute)
+}
+
+// replayAll hands every command read from r to execute. The commands of a
+// MULTI/EXEC block, MULTI and EXEC included, are only handed over once its
+// EXEC is read. A command that can't be parsed, or a command or block cut
+// short at the end, is reported by a *CorruptionError.
+func replayAll(r io.Reader, execute func(args []string) error) error {
+	counter := &countingReader{r: r}
+	reader := bufio.NewReader(counter)
+	offset := func() int64 {
+		return counter.n - int64(reader.Buffered())
+	}
+	var transaction [][]string
+	// valid is the offset of the end of the last command handed over,
+	// MULTI/EXEC blocks counting as a single command.
+	var valid int64
 	for {
+		start := offset()
 		args, err := protocol.ParseRequest(reader)
 		if err != nil {
-			if err == io.EOF {
-				break
+			switch {
+			case err == io.EOF && offset() == start && transaction == nil:
+				return nil
+			case err == io.EOF || err == io.ErrUnexpectedEOF:
+				return &CorruptionError{Offset: valid, Truncated: true}
+			default:
+				return &CorruptionError{Offset: valid, Err: err}
 			}
-			return err
 		}
-		if len(args) > 0 {
-			cmd := strings.ToUpper(args[0])
-			if cmd == "SET" && len(args) == 3 {
-				datastore.Set(args[1], args[2])
+		if len(args) == 0 {
+			if transaction == nil {
+				valid = offset()
 			}
-			// Implement other commands as needed
+			continue
+		}
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "MULTI" && transaction == nil:
+			transaction = [][]string{args}
+		case cmd == "EXEC" && transaction != nil:
+			for _, queued := range append(transaction, args) {
+				if err := execute(queued); err != nil {
+					return err
+				}
+			}
+			transaction = nil
+			valid = offset()
+		case transaction != nil:
+			transaction = append(transaction, args)
+		default:
+			if err := execute(args); err != nil {
+				return err
+			}
+			valid = offset()
 		}
 	}
-	return nil
 }
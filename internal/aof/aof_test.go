@@ -1,9 +1,18 @@
 package aof
 
 import (
+	"bufio"
+	"errors"
+	"maps"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/manimovassagh/Godis/internal/config"
 	"github.com/manimovassagh/Godis/internal/protocol"
 )
 
@@ -31,7 +40,7 @@ func TestAppendCommand(t *testing.T) {
 
 	// Simulate a command and append it
 	args := []string{"SET", "key", "value"}
-	handler.AppendCommand(args)
+	handler.AppendCommand(0, args)
 
 	// Close the file to ensure all data is written
 	tmpFile.Close()
@@ -48,3 +57,602 @@ func TestAppendCommand(t *testing.T) {
 		t.Errorf("Expected %q, but got %q", expected, string(content))
 	}
 }
+
+// TestSelectDB tests that a SELECT is appended whenever the database of the
+// logged commands changes
+func TestSelectDB(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "appendonly.aof")
+	if err != nil {
+		t.Fatalf("Failed to create temporary AOF file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	handler := &AOFHandler{file: tmpFile, selectedDB: -1}
+
+	handler.AppendCommand(0, []string{"SET", "replay-db-a", "0"})
+	handler.AppendCommand(1, []string{"SET", "replay-db-a", "1"})
+	handler.AppendCommand(1, []string{"COPY", "replay-db-a", "replay-db-b", "DB", "2"})
+	handler.BeginTransaction()
+	handler.AppendCommand(2, []string{"MOVE", "replay-db-b", "3"})
+	handler.EndTransaction()
+	handler.AppendCommand(2, []string{"SWAPDB", "0", "1"})
+	tmpFile.Close()
+
+	content, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to read AOF file: %v", err)
+	}
+	expected := protocol.FormatCommand([]string{"SELECT", "0"}) +
+		protocol.FormatCommand([]string{"SET", "replay-db-a", "0"}) +
+		protocol.FormatCommand([]string{"SELECT", "1"}) +
+		protocol.FormatCommand([]string{"SET", "replay-db-a", "1"}) +
+		protocol.FormatCommand([]string{"COPY", "replay-db-a", "replay-db-b", "DB", "2"}) +
+		protocol.FormatCommand([]string{"SELECT", "2"}) +
+		protocol.FormatCommand([]string{"MULTI"}) +
+		protocol.FormatCommand([]string{"MOVE", "replay-db-b", "3"}) +
+		protocol.FormatCommand([]string{"EXEC"}) +
+		protocol.FormatCommand([]string{"SWAPDB", "0", "1"})
+	if string(content) != expected {
+		t.Fatalf("Expected %q, but got %q", expected, string(content))
+	}
+
+}
+
+// TestTransactionBlock tests that commands appended during a transaction are
+// wrapped in MULTI/EXEC, and that empty transactions are not logged
+func TestTransactionBlock(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "appendonly.aof")
+	if err != nil {
+		t.Fatalf("Failed to create temporary AOF file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	handler := &AOFHandler{file: tmpFile}
+
+	handler.BeginTransaction()
+	handler.EndTransaction()
+	handler.BeginTransaction()
+	handler.AppendCommand(0, []string{"SET", "a", "1"})
+	handler.AppendCommand(0, []string{"SET", "b", "2"})
+	handler.EndTransaction()
+	tmpFile.Close()
+
+	content, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to read AOF file: %v", err)
+	}
+	expected := protocol.FormatCommand([]string{"MULTI"}) +
+		protocol.FormatCommand([]string{"SET", "a", "1"}) +
+		protocol.FormatCommand([]string{"SET", "b", "2"}) +
+		protocol.FormatCommand([]string{"EXEC"})
+	if string(content) != expected {
+		t.Errorf("Expected %q, but got %q", expected, string(content))
+	}
+}
+
+// TestReplayTransactions tests that the commands of a transaction are only
+// replayed once its EXEC is read, so that a transaction cut short at the end
+// of the file is dropped and reported as truncated before its MULTI
+func TestReplayTransactions(t *testing.T) {
+	complete := protocol.FormatCommand([]string{"SET", "a", "1"}) +
+		protocol.FormatCommand([]string{"MULTI"}) +
+		protocol.FormatCommand([]string{"SET", "b", "1"}) +
+		protocol.FormatCommand([]string{"EXEC"})
+	log := complete +
+		protocol.FormatCommand([]string{"MULTI"}) +
+		protocol.FormatCommand([]string{"SET", "c", "1"})
+	var replayed []string
+	err := replayAll(strings.NewReader(log), func(args []string) error {
+		replayed = append(replayed, strings.Join(args, " "))
+		return nil
+	})
+	var corruption *CorruptionError
+	if !errors.As(err, &corruption) || !corruption.Truncated || corruption.Offset != int64(len(complete)) {
+		t.Fatalf("Expected the file to be truncated at %d, got %v", len(complete), err)
+	}
+	if expected := []string{"SET a 1", "MULTI", "SET b 1", "EXEC"}; !slices.Equal(replayed, expected) {
+		t.Errorf("Expected %q to be replayed, got %q", expected, replayed)
+	}
+}
+
+// TestReplayCorruption tests where replay reports a file to be truncated or
+// invalid
+func TestReplayCorruption(t *testing.T) {
+	valid := protocol.FormatCommand([]string{"SET", "a", "1"})
+	next := protocol.FormatCommand([]string{"SET", "b", "2"})
+	for i := 1; i < len(next); i++ {
+		err := replayAll(strings.NewReader(valid+next[:i]), func(args []string) error { return nil })
+		var corruption *CorruptionError
+		if !errors.As(err, &corruption) || !corruption.Truncated || corruption.Offset != int64(len(valid)) {
+			t.Errorf("Expected %q to be truncated at %d, got %v", next[:i], len(valid), err)
+		}
+	}
+	for _, invalid := range []string{"+OK\r\n", "*1\r\n$3\r\nGETX\r\n", "*x\r\n", "*1\r\n:1\r\n"} {
+		err := replayAll(strings.NewReader(valid+invalid+next), func(args []string) error { return nil })
+		var corruption *CorruptionError
+		if !errors.As(err, &corruption) || corruption.Truncated || corruption.Offset != int64(len(valid)) {
+			t.Errorf("Expected %q to be reported as invalid at %d, got %v", invalid, len(valid), err)
+		}
+	}
+}
+
+// TestLoadTruncated tests that an incomplete command at the end of the last
+// file is dropped and truncated under aof-load-truncated only
+func TestLoadTruncated(t *testing.T) {
+	defer config.Set("aof-load-truncated", "yes")
+	workDir := t.TempDir()
+	handler, err := Open(workDir)
+	if err != nil {
+		t.Fatalf("Failed to open the AOF: %v", err)
+	}
+	handler.AppendCommand(0, []string{"SET", "a", "1"})
+	incr := filepath.Join(workDir, dirName, "appendonly.aof.1.incr.aof")
+	complete, _ := os.ReadFile(incr)
+	file, _ := os.OpenFile(incr, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString("*3\r\n$3\r\nSET\r\n$1\r\nb")
+	file.Close()
+	if handler, err = Open(workDir); err != nil {
+		t.Fatalf("Failed to reopen the AOF: %v", err)
+	}
+
+	config.Set("aof-load-truncated", "no")
+	if err := handler.LoadCommands(func(args []string) error { return nil }); err == nil {
+		t.Error("Expected the truncated file to be refused under aof-load-truncated no")
+	}
+	config.Set("aof-load-truncated", "yes")
+	if replay := replayed(t, handler); replay != string(complete) {
+		t.Errorf("Expected %q to be replayed, got %q", complete, replay)
+	}
+	handler.AppendCommand(0, []string{"SET", "b", "1"})
+	expected := string(complete) + formatCommands([]string{"SELECT", "0"}, []string{"SET", "b", "1"})
+	if content, _ := os.ReadFile(incr); string(content) != expected {
+		t.Errorf("Expected the file to be truncated before the new command, got %q", content)
+	}
+	if size := handler.Stats().CurrentSize; size != int64(len(expected)) {
+		t.Errorf("Expected the size to be %d, got %d", len(expected), size)
+	}
+}
+
+// TestLoadTruncatedMigrated tests that a truncated single file AOF moved
+// into the directory is recovered, although an empty incremental file
+// follows it
+func TestLoadTruncatedMigrated(t *testing.T) {
+	workDir := t.TempDir()
+	complete := formatCommands([]string{"SET", "a", "1"})
+	os.WriteFile(filepath.Join(workDir, fileName), []byte(complete+"*2\r\n$3\r\nDEL"), 0644)
+	handler, err := Open(workDir)
+	if err != nil {
+		t.Fatalf("Failed to open the AOF: %v", err)
+	}
+	if replay := replayed(t, handler); replay != complete {
+		t.Errorf("Expected %q to be replayed, got %q", complete, replay)
+	}
+	if content, _ := os.ReadFile(filepath.Join(workDir, dirName, fileName)); string(content) != complete {
+		t.Errorf("Expected the base file to be truncated to %q, got %q", complete, content)
+	}
+	if stats := handler.Stats(); stats.CurrentSize != int64(len(complete)) || stats.BaseSize != int64(len(complete)) {
+		t.Errorf("Unexpected sizes after the truncation: %+v", stats)
+	}
+
+	// A file followed by one holding commands is not truncated.
+	os.WriteFile(filepath.Join(workDir, dirName, fileName), []byte(complete+"*2\r\n"), 0644)
+	handler.AppendCommand(0, []string{"SET", "b", "1"})
+	if err := handler.LoadCommands(func(args []string) error { return nil }); err == nil {
+		t.Error("Expected a truncated file followed by commands to be refused")
+	}
+}
+
+// TestReplayError tests that replay stops at the first command that fails
+func TestReplayError(t *testing.T) {
+	log := protocol.FormatCommand([]string{"SET", "a", "1"}) +
+		protocol.FormatCommand([]string{"NOSUCH"}) +
+		protocol.FormatCommand([]string{"SET", "b", "1"})
+	replayed := 0
+	err := replayAll(bufio.NewReader(strings.NewReader(log)), func(args []string) error {
+		replayed++
+		if args[0] == "NOSUCH" {
+			return errors.New("unknown command")
+		}
+		return nil
+	})
+	if err == nil || replayed != 2 {
+		t.Errorf("Expected replay to stop at the unknown command, got %v after %d commands", err, replayed)
+	}
+}
+
+// TestReplayBinary tests that random binary keys and values written to the
+// AOF are read back unchanged
+func TestReplayBinary(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "appendonly.aof")
+	if err != nil {
+		t.Fatalf("Failed to create temporary AOF file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	handler := &AOFHandler{file: tmpFile}
+
+	rng := rand.New(rand.NewSource(1))
+	values := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		key := make([]byte, 1+rng.Intn(16))
+		value := make([]byte, rng.Intn(64))
+		rng.Read(key)
+		rng.Read(value)
+		k := "replay-bin-" + string(key)
+		values[k] = string(value) + "\r\n\x00"
+		handler.AppendCommand(0, []string{"SET", k, values[k]})
+	}
+	tmpFile.Close()
+
+	file, err := os.Open(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to open AOF file: %v", err)
+	}
+	defer file.Close()
+	replayed := make(map[string]string)
+	err = replayAll(bufio.NewReader(file), func(args []string) error {
+		replayed[args[1]] = args[2]
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to replay: %v", err)
+	}
+	if !maps.Equal(replayed, values) {
+		t.Errorf("Expected the keys and values to be replayed unchanged")
+	}
+}
+
+// faultyFile records what is written to it and how often it is fsynced, and
+// fails its writes and fsyncs with writeErr and syncErr when they are set.
+type faultyFile struct {
+	strings.Builder
+	syncs             int
+	writeErr, syncErr error
+}
+
+func (f *faultyFile) Write(b []byte) (int, error) {
+	if f.writeErr != nil {
+		// Fail half way through, like a full disk.
+		n, _ := f.Builder.Write(b[:len(b)/2])
+		return n, f.writeErr
+	}
+	return f.Builder.Write(b)
+}
+
+func (f *faultyFile) Sync() error {
+	f.syncs++
+	return f.syncErr
+}
+
+func (f *faultyFile) Close() error {
+	return nil
+}
+
+// TestAppendFsync tests when each appendfsync policy fsyncs the file
+func TestAppendFsync(t *testing.T) {
+	defer config.Set("appendfsync", "everysec")
+	for _, test := range []struct {
+		policy        string
+		syncs, ticked int
+	}{
+		{"always", 2, 2},
+		{"everysec", 0, 1},
+		{"no", 0, 0},
+	} {
+		t.Run(test.policy, func(t *testing.T) {
+			if err := config.Set("appendfsync", test.policy); err != nil {
+				t.Fatal(err)
+			}
+			file := &faultyFile{}
+			handler := &AOFHandler{file: file, selectedDB: -1}
+			handler.AppendCommand(0, []string{"SET", "a", "1"})
+			handler.BeginTransaction()
+			handler.AppendCommand(0, []string{"SET", "b", "1"})
+			handler.AppendCommand(0, []string{"SET", "c", "1"})
+			handler.EndTransaction()
+			if file.syncs != test.syncs {
+				t.Errorf("Expected %d fsyncs after the writes, got %d", test.syncs, file.syncs)
+			}
+			handler.backgroundFsync()
+			handler.backgroundFsync()
+			if file.syncs != test.ticked {
+				t.Errorf("Expected %d fsyncs after the background ones, got %d", test.ticked, file.syncs)
+			}
+			if err := handler.WriteError(); err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+// TestAppendFsyncErrors tests that failed writes and fsyncs are reported
+// until they succeed again, and that no command is lost meanwhile
+func TestAppendFsyncErrors(t *testing.T) {
+	defer config.Set("appendfsync", "everysec")
+	config.Set("appendfsync", "everysec")
+	diskErr := errors.New("no space left on device")
+	file := &faultyFile{writeErr: diskErr}
+	handler := &AOFHandler{file: file, selectedDB: -1}
+	handler.AppendCommand(0, []string{"SET", "a", "1"})
+	if err := handler.WriteError(); err != diskErr {
+		t.Fatalf("Expected the write error, got %v", err)
+	}
+	handler.backgroundFsync()
+	if file.syncs != 0 {
+		t.Errorf("Expected no fsync while writes fail, got %d", file.syncs)
+	}
+	handler.AppendCommand(0, []string{"SET", "b", "1"})
+
+	file.writeErr = nil
+	file.syncErr = diskErr
+	handler.backgroundFsync()
+	if err := handler.WriteError(); err != diskErr {
+		t.Fatalf("Expected the fsync error, got %v", err)
+	}
+	expected := protocol.FormatCommand([]string{"SELECT", "0"}) +
+		protocol.FormatCommand([]string{"SET", "a", "1"}) +
+		protocol.FormatCommand([]string{"SET", "b", "1"})
+	if file.String() != expected {
+		t.Errorf("Expected %q, but got %q", expected, file.String())
+	}
+
+	// A failed fsync is retried under every policy.
+	config.Set("appendfsync", "no")
+	file.syncErr = nil
+	handler.backgroundFsync()
+	if err := handler.WriteError(); err != nil {
+		t.Errorf("Expected no error once fsync succeeds, got %v", err)
+	}
+	syncs := file.syncs
+	handler.backgroundFsync()
+	if file.syncs != syncs {
+		t.Errorf("Expected no more fsyncs under no, got %d", file.syncs-syncs)
+	}
+}
+
+// TestDelayedFsync tests that writes made while a background fsync runs for
+// too long are counted
+func TestDelayedFsync(t *testing.T) {
+	handler := &AOFHandler{file: &faultyFile{}, selectedDB: -1}
+	handler.fsyncStart = time.Now().Add(-time.Second)
+	handler.AppendCommand(0, []string{"SET", "a", "1"})
+	handler.fsyncStart = time.Now().Add(-delayedFsyncThreshold - time.Second)
+	handler.AppendCommand(0, []string{"SET", "a", "2"})
+	if n := handler.Stats().DelayedFsyncs; n != 1 {
+		t.Errorf("Expected 1 delayed fsync, got %d", n)
+	}
+}
+
+// waitRewrite waits for the rewrite in progress to finish.
+func waitRewrite(t *testing.T, handler *AOFHandler) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); handler.Stats().RewriteInProgress; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the rewrite to finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// replayed returns the commands replayed from the AOF of handler.
+func replayed(t *testing.T, handler *AOFHandler) string {
+	t.Helper()
+	var b strings.Builder
+	err := handler.LoadCommands(func(args []string) error {
+		b.WriteString(protocol.FormatCommand(args))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to replay the AOF: %v", err)
+	}
+	return b.String()
+}
+
+// formatCommands formats commands the way they are written to the AOF.
+func formatCommands(commands ...[]string) string {
+	var b strings.Builder
+	for _, args := range commands {
+		b.WriteString(protocol.FormatCommand(args))
+	}
+	return b.String()
+}
+
+// TestRewrite tests that a rewrite replaces the files of the AOF with a base
+// file holding the snapshot, followed by the incremental file holding the
+// commands logged while it was written
+func TestRewrite(t *testing.T) {
+	workDir := t.TempDir()
+	handler, err := Open(workDir)
+	if err != nil {
+		t.Fatalf("Failed to open the AOF: %v", err)
+	}
+	handler.AppendCommand(0, []string{"SET", "a", "1"})
+	handler.AppendCommand(0, []string{"SET", "a", "2"})
+
+	release := make(chan struct{})
+	err = handler.Rewrite(func() Snapshot {
+		return func(emit func(args []string)) {
+			<-release
+			emit([]string{"SELECT", "0"})
+			emit([]string{"SET", "a", "2"})
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to start the rewrite: %v", err)
+	}
+	if err := handler.Rewrite(nil); err != ErrRewriteInProgress {
+		t.Errorf("Expected ErrRewriteInProgress, got %v", err)
+	}
+	handler.AppendCommand(1, []string{"SET", "b", "1"})
+	handler.BeginTransaction()
+	handler.AppendCommand(1, []string{"INCR", "b"})
+	close(release)
+	waitRewrite(t, handler)
+	handler.EndTransaction()
+	handler.AppendCommand(1, []string{"DEL", "b"})
+
+	dir := filepath.Join(workDir, dirName)
+	manifest, _ := os.ReadFile(filepath.Join(dir, manifestName))
+	if expected := "file appendonly.aof.1.base.aof seq 1 type b\nfile appendonly.aof.2.incr.aof seq 2 type i\n"; string(manifest) != expected {
+		t.Errorf("Expected the manifest %q, got %q", expected, manifest)
+	}
+	base, _ := os.ReadFile(filepath.Join(dir, "appendonly.aof.1.base.aof"))
+	incr, _ := os.ReadFile(filepath.Join(dir, "appendonly.aof.2.incr.aof"))
+	if expected := formatCommands([]string{"SELECT", "0"}, []string{"SET", "a", "2"}); string(base) != expected {
+		t.Errorf("Expected the base file %q, got %q", expected, base)
+	}
+	expected := formatCommands(
+		[]string{"SELECT", "1"}, []string{"SET", "b", "1"},
+		[]string{"MULTI"}, []string{"INCR", "b"}, []string{"EXEC"},
+		[]string{"DEL", "b"},
+	)
+	if string(incr) != expected {
+		t.Errorf("Expected the incremental file %q, got %q", expected, incr)
+	}
+	if replay := replayed(t, handler); replay != string(base)+string(incr) {
+		t.Errorf("Expected the base and incremental files to be replayed, got %q", replay)
+	}
+	stats := handler.Stats()
+	if stats.CurrentSize != int64(len(base)+len(incr)) || stats.BaseSize != int64(len(base)) ||
+		stats.LastRewriteErr != nil || stats.Rewrites != 1 || stats.LastRewriteTime < 0 {
+		t.Errorf("Unexpected stats after the rewrite: %+v", stats)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("Expected the replaced and temporary files to be gone, got %d files", len(entries))
+	}
+}
+
+// TestParseManifest tests which manifests are accepted
+func TestParseManifest(t *testing.T) {
+	valid := "# comment\nfile a.1.base.aof seq 1 type b\nfile a.1.incr.aof type i seq 1 size 3\n\nfile old type h seq 9\nfile a.3.incr.aof seq 3 type i\n"
+	m, err := parseManifest(valid)
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", valid, err)
+	}
+	if expected := "file a.1.base.aof seq 1 type b\nfile old seq 9 type h\nfile a.1.incr.aof seq 1 type i\nfile a.3.incr.aof seq 3 type i\n"; m.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, m.String())
+	}
+	if next := m.nextIncr(); next.name != "appendonly.aof.4.incr.aof" {
+		t.Errorf("Expected the next incremental file to be numbered 4, got %s", next.name)
+	}
+	for _, invalid := range []string{
+		"",
+		"# only a comment\n",
+		"file a seq 1 type b",
+		"file a seq 1\n",
+		"file a seq 1 type x\n",
+		"file a seq 0 type i\n",
+		"file a seq 1 type i file\n",
+		"file ../a seq 1 type b\n",
+		"file a seq 1 type b\nfile b seq 2 type b\n",
+		"file a seq 2 type i\nfile b seq 2 type i\n",
+	} {
+		if _, err := parseManifest(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// TestOpenUpgrade tests that a single file AOF is moved into the directory
+// as its base file, including after a move cut short
+func TestOpenUpgrade(t *testing.T) {
+	for _, interrupted := range []bool{false, true} {
+		workDir := t.TempDir()
+		legacy := formatCommands([]string{"SET", "a", "1"})
+		os.WriteFile(filepath.Join(workDir, fileName), []byte(legacy), 0644)
+		if interrupted {
+			os.Mkdir(filepath.Join(workDir, dirName), 0755)
+			os.WriteFile(filepath.Join(workDir, dirName, manifestName), []byte("file appendonly.aof seq 1 type b\n"), 0644)
+		}
+		handler, err := Open(workDir)
+		if err != nil {
+			t.Fatalf("Failed to open the AOF: %v", err)
+		}
+		handler.AppendCommand(0, []string{"SET", "b", "1"})
+		if _, err := os.Stat(filepath.Join(workDir, fileName)); !os.IsNotExist(err) {
+			t.Errorf("Expected the single file AOF to be moved, got %v", err)
+		}
+		manifest, _ := os.ReadFile(filepath.Join(workDir, dirName, manifestName))
+		if expected := "file appendonly.aof seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n"; string(manifest) != expected {
+			t.Errorf("Expected the manifest %q, got %q", expected, manifest)
+		}
+		expected := legacy + formatCommands([]string{"SELECT", "0"}, []string{"SET", "b", "1"})
+		if replay := replayed(t, handler); replay != expected {
+			t.Errorf("Expected %q to be replayed, got %q", expected, replay)
+		}
+	}
+}
+
+// TestOpenLeftovers tests that Open removes the temporary and history files
+// left behind, and refuses a manifest listing a missing file
+func TestOpenLeftovers(t *testing.T) {
+	workDir := t.TempDir()
+	dir := filepath.Join(workDir, dirName)
+	os.Mkdir(dir, 0755)
+	files := map[string]string{
+		manifestName:                "file appendonly.aof.2.base.aof seq 2 type b\nfile appendonly.aof.1.base.aof seq 1 type h\nfile appendonly.aof.3.incr.aof seq 3 type i\n",
+		"appendonly.aof.1.base.aof": "old",
+		"appendonly.aof.2.base.aof": formatCommands([]string{"SET", "a", "1"}),
+		"appendonly.aof.3.incr.aof": formatCommands([]string{"SET", "b", "1"}),
+		"temp-rewriteaof-bg-1.aof":  "partial",
+		"temp-" + manifestName:      "file",
+		"appendonly.aof.4.incr.aof": "unlisted",
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+	handler, err := Open(workDir)
+	if err != nil {
+		t.Fatalf("Failed to open the AOF: %v", err)
+	}
+	var names []string
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	expected := []string{"appendonly.aof.2.base.aof", "appendonly.aof.3.incr.aof", "appendonly.aof.4.incr.aof", manifestName}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Expected the files %q, got %q", expected, names)
+	}
+	if replay := replayed(t, handler); replay != files["appendonly.aof.2.base.aof"]+files["appendonly.aof.3.incr.aof"] {
+		t.Errorf("Expected the listed files to be replayed, got %q", replay)
+	}
+	if stats := handler.Stats(); stats.BaseSize != int64(len(files["appendonly.aof.2.base.aof"])) {
+		t.Errorf("Expected the size of the base file, got %d", stats.BaseSize)
+	}
+
+	os.Remove(filepath.Join(dir, "appendonly.aof.2.base.aof"))
+	if _, err := Open(workDir); err == nil {
+		t.Error("Expected a manifest listing a missing file to be refused")
+	}
+}
+
+// TestRewriteDue tests when the growth of the file calls for a rewrite
+func TestRewriteDue(t *testing.T) {
+	defer config.Set("auto-aof-rewrite-percentage", "100")
+	defer config.Set("auto-aof-rewrite-min-size", "64mb")
+	config.Set("auto-aof-rewrite-min-size", "100")
+	handler := &AOFHandler{file: &faultyFile{}, selectedDB: -1, size: 80, baseSize: 80}
+	if handler.RewriteDue() {
+		t.Error("Expected no rewrite below auto-aof-rewrite-min-size")
+	}
+	handler.size = 150
+	if handler.RewriteDue() {
+		t.Error("Expected no rewrite before the file doubled")
+	}
+	handler.size = 160
+	if !handler.RewriteDue() {
+		t.Error("Expected a rewrite once the file doubled")
+	}
+	if handler.RewriteDue() {
+		t.Error("Expected the rewrite to be called for only once")
+	}
+	handler.rewriteScheduled = false
+	handler.lastRewriteFailure = time.Now()
+	if handler.RewriteDue() {
+		t.Error("Expected no rewrite right after a failed one")
+	}
+	handler.lastRewriteFailure = time.Time{}
+	config.Set("auto-aof-rewrite-percentage", "0")
+	if handler.RewriteDue() {
+		t.Error("Expected no rewrite with auto-aof-rewrite-percentage 0")
+	}
+}
@@ -0,0 +1,139 @@
+package aof
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/config"
+)
+
+// Commands are written to the file as they are logged, and appendfsync sets
+// when the file is fsynced, as in Redis: after every command under always,
+// once a second by a background goroutine under everysec, and never under
+// no, leaving it to the operating system. A failed write or fsync is
+// reported by WriteError until a later one succeeds, and the commands that
+// could not be written are kept and written again. Unlike Redis, which exits
+// when a write or fsync fails under always, the server keeps running and
+// refuses writes in the meantime, like it does under everysec, and the
+// write whose logging failed is answered with an error.
+
+// fsyncPolicy is the value of appendfsync.
+type fsyncPolicy int64
+
+const (
+	fsyncAlways fsyncPolicy = iota
+	fsyncEverySec
+	fsyncNo
+)
+
+// delayedFsyncThreshold is how long a background fsync may run before the
+// writes made meanwhile are counted as delayed.
+const delayedFsyncThreshold = 2 * time.Second
+
+var appendFsync atomic.Int64
+
+func init() {
+	appendFsync.Store(int64(fsyncEverySec))
+	config.Register(config.EnumParam("appendfsync", &appendFsync, "always", "everysec", "no"))
+}
+
+// WriteError returns the error of the last failed write or fsync of the
+// file, or nil if none failed since the last successful one.
+func (a *AOFHandler) WriteError() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.writeErr != nil {
+		return a.writeErr
+	}
+	return a.fsyncErr
+}
+
+// FsyncAlways reports whether appendfsync is always, under which a write
+// may be acknowledged only once the AOF logging it is fsynced.
+func (a *AOFHandler) FsyncAlways() bool {
+	return a != nil && fsyncPolicy(appendFsync.Load()) == fsyncAlways
+}
+
+// flush writes the pending commands to the file, and fsyncs it under
+// appendfsync always. The caller must hold a.mu.
+func (a *AOFHandler) flush() {
+	if len(a.pending) > 0 {
+		if !a.fsyncStart.IsZero() && time.Since(a.fsyncStart) > delayedFsyncThreshold {
+			a.delayedFsyncs++
+		}
+		n, err := a.file.Write(a.pending)
+		if n > 0 {
+			a.dirty = true
+			a.size += int64(n)
+		}
+		a.pending = a.pending[n:]
+		if err != nil {
+			if a.writeErr == nil {
+				log.Printf("Error writing to the AOF file: %v", err)
+			}
+			a.writeErr = err
+			return
+		}
+		a.pending, a.writeErr = nil, nil
+	}
+	if a.dirty && fsyncPolicy(appendFsync.Load()) == fsyncAlways {
+		err := a.file.Sync()
+		if err == nil {
+			a.dirty = false
+		}
+		a.setFsyncErr(err)
+	}
+}
+
+// setFsyncErr records the result of an fsync. The caller must hold a.mu.
+func (a *AOFHandler) setFsyncErr(err error) {
+	if err != nil && a.fsyncErr == nil {
+		log.Printf("Error fsyncing the AOF file: %v", err)
+	}
+	a.fsyncErr = err
+}
+
+// fsyncLoop runs backgroundFsync on every tick.
+func (a *AOFHandler) fsyncLoop(ticks <-chan time.Time) {
+	for range ticks {
+		a.backgroundFsync()
+	}
+}
+
+// backgroundFsync writes again the commands a failed write left behind, and
+// fsyncs the file if it was written since its last fsync under appendfsync
+// everysec, or if the last fsync failed. The fsync runs without holding
+// a.mu, so that commands are logged meanwhile.
+func (a *AOFHandler) backgroundFsync() {
+	a.mu.Lock()
+	if a.writeErr != nil && !a.inTransaction {
+		a.flush()
+	}
+	if !a.dirty || a.writeErr != nil || fsyncPolicy(appendFsync.Load()) != fsyncEverySec && a.fsyncErr == nil {
+		a.mu.Unlock()
+		return
+	}
+	a.dirty = false
+	a.fsyncStart = time.Now()
+	file := a.file
+	a.mu.Unlock()
+
+	err := file.Sync()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fsyncStart = time.Time{}
+	if a.file != file {
+		// A rewrite started a new file meanwhile, fsyncing this one
+		// before closing it.
+		return
+	}
+	if err != nil {
+		a.dirty = true
+	}
+	a.setFsyncErr(err)
+}
@@ -0,0 +1,86 @@
+package aof
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/manimovassagh/Godis/internal/config"
+)
+
+// A server stopping in the middle of a write leaves an incomplete command,
+// or transaction, at the end of the last file of the AOF holding commands,
+// which may be followed by the empty incremental file opened on the next
+// start. Under aof-load-truncated, as in Redis, loading drops it with a
+// warning and truncates the file to its last complete command, so that the
+// commands logged from then on do not follow it. Any other invalid content
+// stops the server from starting, and check-aof reports where it starts,
+// and can truncate the file there.
+
+var loadTruncated atomic.Bool
+
+func init() {
+	loadTruncated.Store(true)
+	config.Register(config.BoolParam("aof-load-truncated", &loadTruncated))
+}
+
+// A CorruptionError reports that a file of the AOF only holds valid commands
+// up to Offset.
+type CorruptionError struct {
+	// Offset is where the first command or transaction that is incomplete
+	// or invalid starts.
+	Offset int64
+	// Truncated is set when the file ends before the command or
+	// transaction at Offset does, in which case Err is nil.
+	Truncated bool
+	Err       error
+}
+
+func (e *CorruptionError) Error() string {
+	if e.Truncated {
+		return fmt.Sprintf("unexpected end of file after offset %d", e.Offset)
+	}
+	return fmt.Sprintf("bad file format at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// CheckFile reads the commands of the AOF file at path without running
+// them. It returns a *CorruptionError if the file does not end with a
+// complete command outside of a transaction.
+func CheckFile(path string) error {
+	return replayFile(path, func(args []string) error { return nil })
+}
+
+// Files returns the paths of the files listed by the manifest in dir, in
+// the order they are replayed.
+func Files(dir string) ([]string, error) {
+	m, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errors.New("no AOF manifest found in " + dir)
+	}
+	var paths []string
+	for _, info := range m.files() {
+		paths = append(paths, filepath.Join(dir, info.name))
+	}
+	return paths, nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
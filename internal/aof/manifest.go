@@ -0,0 +1,303 @@
+package aof
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The AOF is kept in a directory as in Redis 7: a base file written by the
+// last rewrite, the incremental files holding the commands logged since, and
+// a manifest listing them. Replaying the AOF replays the base file, then
+// every incremental file in order. A rewrite starts a new incremental file
+// and writes the new base file next to the others, and only then switches
+// the manifest over to them, so that no file is renamed while it is written
+// and the manifest always lists a complete AOF, whenever the server stops.
+// The manifest itself is replaced by renaming a temporary file over it.
+//
+// A file is created before the manifest lists it, so a crash may leave
+// files no manifest lists, which are overwritten when their name comes up
+// again, and temporary files, which Open removes. The files a rewrite
+// replaced are listed as history files until they are deleted.
+
+const (
+	// dirName is the directory holding the AOF, and fileName the name of
+	// the single file AOF it replaces, which its files are named after.
+	dirName      = "appendonlydir"
+	fileName     = "appendonly.aof"
+	manifestName = fileName + ".manifest"
+	// tempPrefix starts the names of the temporary files written in the
+	// directory.
+	tempPrefix = "temp-"
+)
+
+// fileType is the type of a file listed in the manifest.
+type fileType byte
+
+const (
+	baseFile    fileType = 'b'
+	incrFile    fileType = 'i'
+	historyFile fileType = 'h'
+)
+
+// aofFileInfo is a file listed in the manifest.
+type aofFileInfo struct {
+	name string
+	seq  int64
+	kind fileType
+}
+
+// manifest lists the files of the AOF.
+type manifest struct {
+	// base is the base file, nil until the first rewrite, and incrs the
+	// incremental files in the order they are replayed.
+	base    *aofFileInfo
+	incrs   []aofFileInfo
+	history []aofFileInfo
+	// baseSeq and incrSeq are the largest sequence numbers given to a base
+	// and an incremental file, which the next ones are numbered after.
+	baseSeq, incrSeq int64
+}
+
+// files returns the files replayed in order: the base file, then the
+// incremental files.
+func (m *manifest) files() []aofFileInfo {
+	var files []aofFileInfo
+	if m.base != nil {
+		files = append(files, *m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+// clone returns a copy of m that can be modified without affecting m.
+func (m *manifest) clone() *manifest {
+	c := *m
+	if m.base != nil {
+		base := *m.base
+		c.base = &base
+	}
+	c.incrs, c.history = slices.Clone(m.incrs), slices.Clone(m.history)
+	return &c
+}
+
+// nextBase and nextIncr return the base and incremental files that come
+// after those of m.
+func (m *manifest) nextBase() aofFileInfo {
+	seq := m.baseSeq + 1
+	return aofFileInfo{fmt.Sprintf("%s.%d.base.aof", fileName, seq), seq, baseFile}
+}
+
+func (m *manifest) nextIncr() aofFileInfo {
+	seq := m.incrSeq + 1
+	return aofFileInfo{fmt.Sprintf("%s.%d.incr.aof", fileName, seq), seq, incrFile}
+}
+
+// String formats m the way it is written to disk, one line per file.
+func (m *manifest) String() string {
+	var files []aofFileInfo
+	if m.base != nil {
+		files = append(files, *m.base)
+	}
+	var b strings.Builder
+	for _, info := range append(append(files, m.history...), m.incrs...) {
+		fmt.Fprintf(&b, "file %s seq %d type %c\n", info.name, info.seq, info.kind)
+	}
+	return b.String()
+}
+
+// parseManifest parses a manifest written by String. Empty lines and lines
+// starting with # are skipped, as are unknown fields.
+func parseManifest(data string) (*manifest, error) {
+	if !strings.HasSuffix(data, "\n") {
+		return nil, errors.New("the AOF manifest is truncated")
+	}
+	m := &manifest{}
+	for i, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		info, err := parseManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: %w", i+1, err)
+		}
+		switch info.kind {
+		case baseFile:
+			if m.base != nil {
+				return nil, fmt.Errorf("invalid AOF manifest line %d: duplicate base file", i+1)
+			}
+			m.base, m.baseSeq = &info, info.seq
+		case incrFile:
+			if info.seq <= m.incrSeq {
+				return nil, fmt.Errorf("invalid AOF manifest line %d: non-monotonic sequence number", i+1)
+			}
+			m.incrs, m.incrSeq = append(m.incrs, info), info.seq
+		case historyFile:
+			m.history = append(m.history, info)
+		}
+	}
+	if m.base == nil && len(m.incrs) == 0 {
+		return nil, errors.New("the AOF manifest lists no file")
+	}
+	return m, nil
+}
+
+// parseManifestLine parses a line of the manifest, made of the name and
+// value of each field of a file.
+func parseManifestLine(line string) (aofFileInfo, error) {
+	var info aofFileInfo
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return info, errors.New("a field has no value")
+	}
+	for i := 0; i < len(fields); i += 2 {
+		value := fields[i+1]
+		switch fields[i] {
+		case "file":
+			if filepath.Base(value) != value || value == "." || value == ".." {
+				return info, fmt.Errorf("%q is not a file name", value)
+			}
+			info.name = value
+		case "seq":
+			seq, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seq <= 0 {
+				return info, fmt.Errorf("invalid sequence number %q", value)
+			}
+			info.seq = seq
+		case "type":
+			if value != "b" && value != "i" && value != "h" {
+				return info, fmt.Errorf("unknown file type %q", value)
+			}
+			info.kind = fileType(value[0])
+		}
+	}
+	if info.name == "" || info.seq == 0 || info.kind == 0 {
+		return info, errors.New("the file, seq or type field is missing")
+	}
+	return info, nil
+}
+
+// readManifest reads the manifest in dir, or returns nil if there is none.
+func readManifest(dir string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(string(data))
+}
+
+// persist writes m as the manifest in dir, by renaming a temporary file over
+// it once written and fsynced.
+func (m *manifest) persist(dir string) error {
+	temp := filepath.Join(dir, tempPrefix+manifestName)
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(m.String())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, filepath.Join(dir, manifestName))
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir fsyncs dir, making the files created or renamed in it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// upgrade moves the single file AOF in workDir, if there is one, into dir as
+// the base file of a manifest. It is moved if dir has no manifest yet, or if
+// the manifest only lists it and the move was cut short, as in Redis.
+func upgrade(workDir, dir string, m *manifest) (*manifest, error) {
+	legacy := filepath.Join(workDir, fileName)
+	if info, err := os.Stat(legacy); err != nil || !info.Mode().IsRegular() {
+		return m, nil
+	}
+	if m != nil {
+		if m.base == nil || m.base.name != fileName || len(m.incrs) > 0 {
+			return m, nil
+		}
+		if _, err := os.Stat(filepath.Join(dir, fileName)); err == nil {
+			return m, nil
+		}
+	}
+	m = &manifest{base: &aofFileInfo{fileName, 1, baseFile}, baseSeq: 1}
+	if err := m.persist(dir); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(legacy, filepath.Join(dir, fileName)); err != nil {
+		return nil, err
+	}
+	if err := syncDir(dir); err != nil {
+		return nil, err
+	}
+	if err := syncDir(workDir); err != nil {
+		return nil, err
+	}
+	log.Printf("Moved %s into %s as the base file of a multi part AOF", legacy, dir)
+	return m, nil
+}
+
+// removeTempFiles removes the temporary files left in dir by a server that
+// stopped while writing them.
+func removeTempFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), tempPrefix) && entry.Type().IsRegular() {
+			log.Printf("Removing the leftover temporary AOF file %s", entry.Name())
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeHistory deletes the history files and drops them from the
+// manifest. It only logs failures, as the files are not used anymore and
+// removeHistory runs again on the next start. The caller must hold a.mu.
+func (a *AOFHandler) removeHistory() {
+	if len(a.manifest.history) == 0 {
+		return
+	}
+	for _, info := range a.manifest.history {
+		if err := os.Remove(filepath.Join(a.dir, info.name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove the AOF history file %s: %v", info.name, err)
+			return
+		}
+	}
+	m := a.manifest.clone()
+	m.history = nil
+	if err := m.persist(a.dir); err != nil {
+		log.Printf("Failed to write the AOF manifest: %v", err)
+		return
+	}
+	a.manifest = m
+}
@@ -0,0 +1,209 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/config"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// A rewrite replaces the files of the AOF with a base file holding the
+// shortest commands recreating the data set, as BGREWRITEAOF does in Redis.
+// The commands logged from the start of the rewrite go to a new incremental
+// file, while the base file is written in the background, and the manifest
+// then lists the base file and that incremental file only.
+// auto-aof-rewrite-percentage and auto-aof-rewrite-min-size call for a
+// rewrite once the file grew enough since the last one.
+
+// ErrRewriteInProgress is returned by Rewrite when a rewrite is already
+// running.
+var ErrRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// rewriteRetryDelay is how long after a failed rewrite RewriteDue waits
+// before calling for another one.
+const rewriteRetryDelay = time.Minute
+
+var (
+	autoRewritePercentage atomic.Int64
+	autoRewriteMinSize    atomic.Int64
+)
+
+func init() {
+	autoRewritePercentage.Store(100)
+	autoRewriteMinSize.Store(64 * 1024 * 1024)
+	config.Register(config.IntParam("auto-aof-rewrite-percentage", &autoRewritePercentage, 0, math.MaxInt32))
+	config.Register(config.MemoryParam("auto-aof-rewrite-min-size", &autoRewriteMinSize))
+}
+
+// Stats reports on the file and its rewrites, for INFO.
+type Stats struct {
+	CurrentSize, BaseSize int64
+	// DelayedFsyncs counts the writes made while a background fsync had
+	// been running for more than two seconds, which means the disk can't
+	// keep up with the writes.
+	DelayedFsyncs int64
+
+	RewriteInProgress, RewriteScheduled bool
+	// LastRewriteTime and CurrentRewriteTime are how long the last rewrite
+	// took and how long the one in progress has been running, or -1.
+	LastRewriteTime, CurrentRewriteTime time.Duration
+	LastRewriteErr                      error
+	Rewrites                            int64
+}
+
+// Stats returns the state of the file and its rewrites.
+func (a *AOFHandler) Stats() Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := Stats{
+		CurrentSize:        a.size,
+		BaseSize:           a.baseSize,
+		DelayedFsyncs:      a.delayedFsyncs,
+		RewriteInProgress:  a.rewriting,
+		RewriteScheduled:   a.rewriteScheduled,
+		LastRewriteTime:    a.lastRewriteTime,
+		CurrentRewriteTime: -1,
+		LastRewriteErr:     a.lastRewriteErr,
+		Rewrites:           a.rewrites,
+	}
+	if a.rewriting {
+		s.CurrentRewriteTime = time.Since(a.rewriteStart)
+	}
+	return s
+}
+
+// RewriteDue reports whether the file grew by auto-aof-rewrite-percentage
+// since the last rewrite and is larger than auto-aof-rewrite-min-size, in
+// which case it should be rewritten. It reports it once, until Rewrite is
+// called.
+func (a *AOFHandler) RewriteDue() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	percentage := autoRewritePercentage.Load()
+	if percentage == 0 || a.rewriting || a.rewriteScheduled || a.size <= autoRewriteMinSize.Load() {
+		return false
+	}
+	if !a.lastRewriteFailure.IsZero() && time.Since(a.lastRewriteFailure) < rewriteRetryDelay {
+		return false
+	}
+	base := max(a.baseSize, 1)
+	if (a.size-base)*100/base < percentage {
+		return false
+	}
+	a.rewriteScheduled = true
+	return true
+}
+
+// A Snapshot writes the commands recreating the data set as it was when it
+// was taken, passing each of them to emit.
+type Snapshot func(emit func(args []string))
+
+// Rewrite starts rewriting the AOF in the background, unless a rewrite is
+// already running, in which case it returns ErrRewriteInProgress. It calls
+// take for a snapshot of the data set, which is written out from another
+// goroutine. The caller must make sure no command is logged until Rewrite
+// returns, so that the snapshot reflects exactly the commands logged before
+// the rewrite, and that no transaction is open.
+func (a *AOFHandler) Rewrite(take func() Snapshot) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rewriteScheduled = false
+	if a.rewriting {
+		return ErrRewriteInProgress
+	}
+	if err := a.openIncr(); err != nil {
+		a.lastRewriteErr, a.lastRewriteFailure = err, time.Now()
+		log.Printf("Failed to start a background AOF rewrite: %v", err)
+		return err
+	}
+	a.rewriting, a.rewriteStart = true, time.Now()
+	a.rewrites++
+	go a.rewrite(take())
+	return nil
+}
+
+// rewrite writes snapshot to a temporary file, then makes it the base file.
+func (a *AOFHandler) rewrite(snapshot Snapshot) {
+	temp, size, err := a.writeSnapshot(snapshot)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err == nil {
+		err = a.installBase(temp, size)
+	}
+	if err != nil {
+		os.Remove(temp)
+	}
+	a.rewriting = false
+	a.lastRewriteTime, a.lastRewriteErr = time.Since(a.rewriteStart), err
+	if err != nil {
+		a.lastRewriteFailure = time.Now()
+		log.Printf("Background AOF rewrite failed: %v", err)
+		return
+	}
+	a.removeHistory()
+	log.Printf("Background AOF rewrite finished successfully")
+}
+
+// writeSnapshot writes snapshot to a temporary file in the directory of the
+// AOF and fsyncs it. It returns the name of the file and its size.
+func (a *AOFHandler) writeSnapshot(snapshot Snapshot) (string, int64, error) {
+	name := filepath.Join(a.dir, fmt.Sprintf("%srewriteaof-bg-%d.aof", tempPrefix, os.Getpid()))
+	temp, err := os.Create(name)
+	if err != nil {
+		return name, 0, err
+	}
+	defer temp.Close()
+	w := bufio.NewWriter(temp)
+	snapshot(func(args []string) {
+		w.WriteString(protocol.FormatCommand(args))
+	})
+	if err := w.Flush(); err != nil {
+		return name, 0, err
+	}
+	if err := temp.Sync(); err != nil {
+		return name, 0, err
+	}
+	info, err := temp.Stat()
+	if err != nil {
+		return name, 0, err
+	}
+	return name, info.Size(), nil
+}
+
+// installBase renames temp, of the given size, to the next base file, and
+// writes the manifest listing it followed by the incremental file opened
+// when the rewrite started, which is the last one. The files it replaces
+// are listed as history files. The caller must hold a.mu.
+func (a *AOFHandler) installBase(temp string, size int64) error {
+	info := a.manifest.nextBase()
+	path := filepath.Join(a.dir, info.name)
+	if err := os.Rename(temp, path); err != nil {
+		return err
+	}
+	m := a.manifest.clone()
+	last := len(m.incrs) - 1
+	if m.base != nil {
+		m.history = append(m.history, *m.base)
+	}
+	m.history = append(m.history, m.incrs[:last]...)
+	m.base, m.baseSeq, m.incrs = &info, info.seq, m.incrs[last:]
+	if err := m.persist(a.dir); err != nil {
+		os.Remove(path)
+		return err
+	}
+	a.manifest = m
+	a.size += size - a.incrOffset
+	a.baseSize, a.incrOffset = size, size
+	return nil
+}
@@ -0,0 +1,358 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// errUnblocked is the reply of a blocked command cancelled by
+// CLIENT UNBLOCK id ERROR.
+var errUnblocked = errors.New("UNBLOCKED client unblocked via CLIENT UNBLOCK")
+
+// waiter is a client parked by a blocking command until one of its keys can
+// serve it.
+type waiter struct {
+	client *Client
+	// db is the database of the keys, which the client cannot change while
+	// it waits.
+	db   *datastore.DataStore
+	keys []string
+	// serve tries to complete the blocked command from key and records the
+	// reply for the blocked client to write. It returns false if the key
+	// cannot serve it yet. It runs with blocking.mu held, in the goroutine of
+	// whichever client made the key ready.
+	serve func(key string) bool
+	// done is closed once the waiter is finished: served, timed out or
+	// unblocked.
+	done     chan struct{}
+	finished bool
+	served   bool
+	err      error
+}
+
+// blocking holds the waiters of every key in FIFO order, and the waiter of
+// each blocked client by ID for CLIENT UNBLOCK.
+var blocking = struct {
+	mu      sync.Mutex
+	queues  map[dbKey][]*waiter
+	clients map[int64]*waiter
+}{
+	queues:  make(map[dbKey][]*waiter),
+	clients: make(map[int64]*waiter),
+}
+
+// finish removes the waiter from every queue and wakes its client up. The
+// caller must hold blocking.mu.
+func (w *waiter) finish(served bool, err error) {
+	for _, key := range w.keys {
+		bk := dbKey{w.db, key}
+		queue := slices.DeleteFunc(blocking.queues[bk], func(other *waiter) bool { return other == w })
+		if len(queue) == 0 {
+			delete(blocking.queues, bk)
+		} else {
+			blocking.queues[bk] = queue
+		}
+		w.db.UnblockKey(key)
+	}
+	delete(blocking.clients, w.client.id)
+	w.finished, w.served, w.err = true, served, err
+	close(w.done)
+}
+
+// block parks the client until serve succeeds for one of the keys, the
+// timeout expires (a zero timeout waits forever), the client is unblocked
+// or its connection is closed. serve is tried right away as well, so callers do not need a
+// separate non-blocking attempt. It reports whether the command was served.
+// The caller must hold commandLock, taken by lockCommands, which is released
+// while the client waits.
+func (c *Client) block(keys []string, timeout time.Duration, serve func(key string) bool) (bool, error) {
+	if c.inExec {
+		// A transaction cannot wait, so the command behaves as if its
+		// timeout expired unless it can be served right away.
+		blocking.mu.Lock()
+		defer blocking.mu.Unlock()
+		for _, key := range keys {
+			if serve(key) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	w := &waiter{client: c, db: c.datastore, keys: keys, serve: serve, done: make(chan struct{})}
+	blocking.mu.Lock()
+	for _, key := range keys {
+		bk := dbKey{c.datastore, key}
+		blocking.queues[bk] = append(blocking.queues[bk], w)
+		c.datastore.BlockKey(key)
+	}
+	blocking.clients[c.id] = w
+	// Keys are tried only once the waiter is registered, so a write landing
+	// in between is reported by ReadyKeys instead of being missed.
+	for _, key := range keys {
+		if serve(key) {
+			w.finish(true, nil)
+			break
+		}
+	}
+	blocking.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	closed, stopWatching := c.watchConnection()
+	c.unlockCommands()
+	select {
+	case <-w.done:
+	case <-expired:
+	case <-closed:
+	}
+	stopWatching()
+	c.lockCommands(c.exclusive)
+
+	blocking.mu.Lock()
+	defer blocking.mu.Unlock()
+	if !w.finished {
+		w.finish(false, nil)
+	}
+	return w.served, w.err
+}
+
+// watchConnection watches the connection of the blocked client, and returns
+// a channel closed once the connection is, so that the client stops waiting
+// rather than being served data it can't receive. As the client sends
+// nothing while it waits, reading from the connection only returns once it
+// is closed, or once the client sends its next commands, which are left
+// buffered. stop ends the watch. Connections without read deadlines, such
+// as those of tests, are not watched.
+func (c *Client) watchConnection() (closed <-chan struct{}, stop func()) {
+	if c.conn.SetReadDeadline(time.Time{}) != nil {
+		return nil, func() {}
+	}
+	ch, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := c.reader.Peek(1); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			close(ch)
+		}
+	}()
+	return ch, func() {
+		// Interrupt the read, unless it returned already.
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// serveReadyKeys serves, in FIFO order, the clients blocked on keys that
+// received data during the last command. Serving a client can make more keys
+// ready (BLMOVE pushes to its destination), so it loops until none are left.
+func (c *Client) serveReadyKeys() {
+	for {
+		keys := datastore.ReadyKeys()
+		if len(keys) == 0 {
+			return
+		}
+		blocking.mu.Lock()
+		for _, ready := range keys {
+			for _, w := range slices.Clone(blocking.queues[dbKey{ready.DB, ready.Key}]) {
+				if !w.finished && w.serve(ready.Key) {
+					w.finish(true, nil)
+				}
+			}
+		}
+		blocking.mu.Unlock()
+	}
+}
+
+// unblockClient finishes the wait of the client with the given ID, as if it
+// timed out, or with errUnblocked if withError is set. It reports whether the
+// client was blocked.
+func unblockClient(id int64, withError bool) bool {
+	blocking.mu.Lock()
+	defer blocking.mu.Unlock()
+	w, ok := blocking.clients[id]
+	if !ok {
+		return false
+	}
+	var err error
+	if withError {
+		err = errUnblocked
+	}
+	w.finish(false, err)
+	return true
+}
+
+// parseTimeout parses the timeout of the blocking list and sorted set
+// commands, given in seconds with an optional fractional part. It writes an
+// error reply and returns false if the timeout is invalid.
+func (c *Client) parseTimeout(arg string) (time.Duration, bool) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(seconds) || seconds > float64(math.MaxInt64)/float64(time.Second) {
+		protocol.WriteError(c.conn, "ERR timeout is not a float or out of range")
+		return 0, false
+	}
+	if seconds < 0 {
+		protocol.WriteError(c.conn, "ERR timeout is negative")
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// parseBlockTimeout parses the BLOCK option of XREAD and XREADGROUP, given in
+// milliseconds.
+func (c *Client) parseBlockTimeout(arg string) (time.Duration, bool) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || ms > math.MaxInt64/int64(time.Millisecond) {
+		protocol.WriteError(c.conn, "ERR timeout is not an integer or out of range")
+		return 0, false
+	}
+	if ms < 0 {
+		protocol.WriteError(c.conn, "ERR timeout is negative")
+		return 0, false
+	}
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+// bpop handles the BLPOP and BRPOP commands.
+// It takes an array of arguments with the following format: [cmd, key, ..., timeout].
+// It responds with the key and the popped element, and logs the pop to the
+// AOF as LPOP or RPOP.
+func (c *Client) bpop(args []string, left bool) {
+	timeout, ok := c.parseTimeout(args[len(args)-1])
+	if !ok {
+		return
+	}
+	popCmd := "RPOP"
+	if left {
+		popCmd = "LPOP"
+	}
+	var reply []string
+	var opErr error
+	served, err := c.block(args[1:len(args)-1], timeout, func(key string) bool {
+		values, err := c.datastore.Pop(key, left, 1)
+		if err != nil {
+			opErr = err
+			return true
+		}
+		if len(values) == 0 {
+			return false
+		}
+		c.appendAOF([]string{popCmd, key})
+		reply = []string{key, values[0]}
+		return true
+	})
+	switch {
+	case err != nil:
+		protocol.WriteError(c.conn, err.Error())
+	case opErr != nil:
+		protocol.WriteError(c.conn, opErr.Error())
+	case !served:
+		protocol.WriteNullArray(c.conn)
+	default:
+		protocol.WriteArray(c.conn, reply)
+	}
+}
+
+// blmove handles the BLMOVE and BRPOPLPUSH commands.
+// It takes an array of arguments with the following format:
+// ["BLMOVE", source, destination, LEFT|RIGHT, LEFT|RIGHT, timeout], or
+// ["BRPOPLPUSH", source, destination, timeout].
+func (c *Client) blmove(args []string) {
+	legacy := strings.ToUpper(args[0]) == "BRPOPLPUSH"
+	source, destination := args[1], args[2]
+	fromLeft, toLeft := false, true
+	if !legacy {
+		var ok1, ok2 bool
+		fromLeft, ok1 = parseListEnd(args[3])
+		toLeft, ok2 = parseListEnd(args[4])
+		if !ok1 || !ok2 {
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+	}
+	timeout, ok := c.parseTimeout(args[len(args)-1])
+	if !ok {
+		return
+	}
+	var value string
+	var opErr error
+	served, err := c.block([]string{source}, timeout, func(string) bool {
+		v, found, err := c.datastore.LMove(source, destination, fromLeft, toLeft)
+		if err != nil {
+			opErr = err
+			return true
+		}
+		if !found {
+			return false
+		}
+		c.appendAOF([]string{"LMOVE", source, destination, listEndName(fromLeft), listEndName(toLeft)})
+		value = v
+		return true
+	})
+	switch {
+	case err != nil:
+		protocol.WriteError(c.conn, err.Error())
+	case opErr != nil:
+		protocol.WriteError(c.conn, opErr.Error())
+	case !served:
+		protocol.WriteNullBulkString(c.conn)
+	default:
+		protocol.WriteBulkString(c.conn, value)
+	}
+}
+
+// listEndName returns the LMOVE argument naming a list end.
+func listEndName(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+// bzpop handles the BZPOPMIN and BZPOPMAX commands.
+// It takes an array of arguments with the following format: [cmd, key, ..., timeout].
+// It responds with the key, the popped member and its score, and logs the
+// pop to the AOF as ZREM.
+func (c *Client) bzpop(args []string, highest bool) {
+	timeout, ok := c.parseTimeout(args[len(args)-1])
+	if !ok {
+		return
+	}
+	var reply []string
+	var opErr error
+	served, err := c.block(args[1:len(args)-1], timeout, func(key string) bool {
+		members, err := c.datastore.ZPop(key, 1, highest)
+		if err != nil {
+			opErr = err
+			return true
+		}
+		if len(members) == 0 {
+			return false
+		}
+		c.appendAOF([]string{"ZREM", key, members[0].Member})
+		reply = append([]string{key}, flattenZMembers(members, true)...)
+		return true
+	})
+	switch {
+	case err != nil:
+		protocol.WriteError(c.conn, err.Error())
+	case opErr != nil:
+		protocol.WriteError(c.conn, opErr.Error())
+	case !served:
+		protocol.WriteNullArray(c.conn)
+	default:
+		protocol.WriteArray(c.conn, reply)
+	}
+}
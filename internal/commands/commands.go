@@ -2,28 +2,89 @@ package commands
 
 import (
 	"bufio"
+	"bytes"
+	"fmt"
 	"log"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/manimovassagh/Godis/internal/aof"
 	"github.com/manimovassagh/Godis/internal/datastore"
 	"github.com/manimovassagh/Godis/internal/protocol"
+	"github.com/manimovassagh/Godis/internal/pubsub"
 )
 
+const (
+	errNotInteger = "ERR value is not an integer or out of range"
+	errNotFloat   = "ERR value is not a valid float"
+)
+
+// errWrongArgs returns the arity error reported for the named command.
+func errWrongArgs(cmd string) string {
+	return "ERR wrong number of arguments for '" + strings.ToUpper(cmd) + "' command"
+}
+
 type Client struct {
+	id        int64
 	conn      net.Conn
 	reader    *bufio.Reader
-	datastore *datastore.DataStore
+	datastore *datastore.DataStore // the database selected by the client
 	aof       *aof.AOFHandler
+
+	// writeMu serializes command replies with the pub/sub messages written
+	// by the delivery goroutine.
+	writeMu sync.Mutex
+	// sub is the pub/sub subscriber of the client, created by its first
+	// SUBSCRIBE or PSUBSCRIBE, and subscriptions is its number of channels
+	// and patterns. While it is not zero the client is in subscriber mode.
+	sub           *pubsub.Subscriber
+	subscriptions int
+
+	// multi is set between MULTI and EXEC or DISCARD, while queued holds the
+	// commands of the transaction and multiErr records that one of them was
+	// rejected. inExec is set while EXEC runs them. watched maps the keys
+	// watched by the client, in the database selected at WATCH time, to
+	// their version at WATCH time.
+	multi    bool
+	multiErr bool
+	queued   [][]string
+	inExec   bool
+	watched  map[dbKey]uint64
+
+	// exclusive records whether the running command holds commandLock for
+	// writing, see lockCommands.
+	exclusive bool
+
+	// dirty is set by the handler of a write command that changed the data
+	// set, so that the command is logged to the AOF as it was called, while
+	// propagated holds the entries logged in its place instead.
+	dirty      bool
+	propagated [][]string
+
+	// oom records that the memory in use exceeded maxmemory when the
+	// command being run started, so that the scripts it runs can not grow
+	// it further.
+	oom bool
+}
+
+// dbKey is a key in a database.
+type dbKey struct {
+	db  *datastore.DataStore
+	key string
 }
 
+// nextClientID hands out the IDs reported by CLIENT ID.
+var nextClientID atomic.Int64
+
 // NewClient returns a new Client instance that will handle the given connection.
 //
 // It initializes the Client with the given connection, a new bufio.Reader,
-// the global DataStore, and the global AOFHandler.
+// database 0, and the global AOFHandler.
 func NewClient(conn net.Conn) *Client {
 	return &Client{
+		id:        nextClientID.Add(1),
 		conn:      conn,
 		reader:    bufio.NewReader(conn),
 		datastore: datastore.GetDataStore(),
@@ -31,6 +92,69 @@ func NewClient(conn net.Conn) *Client {
 	}
 }
 
+// NewReplayClient returns a client with no connection, which runs the
+// commands replayed from the AOF with Replay.
+func NewReplayClient() *Client {
+	return &Client{
+		id:        nextClientID.Add(1),
+		conn:      &scriptConn{},
+		datastore: datastore.GetDataStore(),
+	}
+}
+
+// Replay runs a command read from the AOF the way the commands of
+// connections are run, following SELECT and queueing the commands of a
+// MULTI/EXEC block until EXEC, but without logging it again. Like the
+// commands of a transaction, it can not block, and it is not subject to
+// maxmemory. It fails on unknown commands, and on commands that reply with
+// an error, which the server never logs.
+func (c *Client) Replay(args []string) error {
+	spec, err := lookupCommand(args)
+	if err != nil {
+		return fmt.Errorf("%s reading the append only file", commandError(spec, args, err))
+	}
+	commandLock.Lock()
+	defer commandLock.Unlock()
+	conn := c.conn.(*scriptConn)
+	conn.buf.Reset()
+	switch {
+	case spec.name == "EXEC" && c.multi:
+		c.exec()
+	case c.multi && spec.name != "MULTI":
+		c.queued = append(c.queued, args)
+		return nil
+	default:
+		c.inExec = true
+		c.call(spec, args)
+		c.inExec = false
+	}
+	reply, err := protocol.ReadReply(bufio.NewReader(&conn.buf))
+	if err != nil {
+		return err
+	}
+	if reply.Type == '-' {
+		return fmt.Errorf("%s replaying %q from the append only file", reply.Str, strings.ToUpper(args[0]))
+	}
+	return nil
+}
+
+// LoadAOF replays the AOF of handler through a replay client. No key
+// expires while it runs, so that the commands logged against a key that was
+// live apply to it, rather than recreating it without its deadline. The
+// keys and hash fields that expire from then on are logged to handler as
+// deleted, as in Redis, so that replaying it deletes them at the same point.
+func LoadAOF(handler *aof.AOFHandler) error {
+	datastore.SetLoading(true)
+	defer datastore.SetLoading(false)
+	if err := handler.LoadCommands(NewReplayClient().Replay); err != nil {
+		return err
+	}
+	datastore.OnExpired(func(db int, args []string) {
+		handler.AppendCommand(db, args)
+	})
+	return nil
+}
+
 // Handle starts a loop that reads commands from the client and executes them.
 // It uses the Client's reader to read requests from the client and the
 // protocol package to parse the requests. It then executes the commands
@@ -38,6 +162,8 @@ func NewClient(conn net.Conn) *Client {
 // the loop exits and the connection is closed.
 func (c *Client) Handle() {
 	defer c.conn.Close()
+	defer c.closePubSub()
+	defer c.unwatchAll()
 	clientAddr := c.conn.RemoteAddr().String()
 	log.Printf("Client connected: %s", clientAddr)
 
@@ -51,22 +177,115 @@ func (c *Client) Handle() {
 			protocol.WriteError(c.conn, "ERR empty command")
 			continue
 		}
-		cmd := strings.ToUpper(args[0])
-		switch cmd {
-		case "PING":
-			c.ping(args)
-		case "ECHO":
-			c.echo(args)
-		case "SET":
-			c.set(args)
-		case "GET":
-			c.get(args)
-		default:
-			protocol.WriteError(c.conn, "ERR unknown command '"+cmd+"'")
+		c.process(args)
+	}
+}
+
+// execute looks the command up in the command table and runs it, replying
+// with an error if it is unknown, has the wrong number of arguments or is not
+// allowed in subscriber mode.
+func (c *Client) execute(args []string) {
+	cmd := strings.ToUpper(args[0])
+	if c.subscriptions > 0 && !subscriberCommands[cmd] {
+		protocol.WriteError(c.conn, "ERR Can't execute '"+strings.ToLower(args[0])+"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+		return
+	}
+	spec, err := lookupCommand(args)
+	if err != nil {
+		protocol.WriteError(c.conn, commandError(spec, args, err))
+		return
+	}
+	if !c.inExec && (c.rejectOOM(spec) || c.rejectDiskError(spec)) {
+		return
+	}
+	c.call(spec, args)
+}
+
+// rejectOOM evicts keys if the memory in use exceeds maxmemory, and replies
+// with an OOM error if it still does and the command may grow it. It reports
+// whether the command was rejected.
+func (c *Client) rejectOOM(spec *commandSpec) bool {
+	err := c.performEvictions()
+	c.oom = err != nil
+	if c.oom && spec.flags&flagDenyOOM != 0 {
+		protocol.WriteError(c.conn, err.Error())
+		return true
+	}
+	return false
+}
+
+// rejectDiskError replies with a MISCONF error if the command may modify the
+// data set while the AOF can't be written or fsynced, so that no write is
+// acknowledged that may be lost. It reports whether the command was
+// rejected.
+func (c *Client) rejectDiskError(spec *commandSpec) bool {
+	if msg := c.diskError(spec); msg != "" {
+		protocol.WriteError(c.conn, msg)
+		return true
+	}
+	return false
+}
+
+// diskError returns the MISCONF error refusing the command if it may modify
+// the data set while the AOF can't be written or fsynced, or an empty
+// string.
+func (c *Client) diskError(spec *commandSpec) string {
+	if spec.flags&flagWrite == 0 {
+		return ""
+	}
+	if err := c.aof.WriteError(); err != nil {
+		return misconfError(err)
+	}
+	return ""
+}
+
+// misconfError returns the error refusing writes because of err, the error
+// of the last write or fsync of the AOF.
+func misconfError(err error) string {
+	return "MISCONF Errors writing to the AOF file: " + err.Error()
+}
+
+// holdReplies holds the replies written to the client under appendfsync
+// always, until the returned function is called once the command ran and
+// was logged. The replies are sent then, unless the AOF failed to be written
+// or fsynced meanwhile, in which case the client gets a MISCONF error
+// instead, so that no write is acknowledged that may be lost.
+func (c *Client) holdReplies() (release func()) {
+	if !c.aof.FsyncAlways() {
+		return func() {}
+	}
+	failing := c.aof.WriteError() != nil
+	held := &heldConn{Conn: c.conn}
+	c.conn = held
+	return func() {
+		c.conn = held.Conn
+		if err := c.aof.WriteError(); err != nil && !failing {
+			protocol.WriteError(c.conn, misconfError(err))
+			return
 		}
+		c.conn.Write(held.buf.Bytes())
 	}
 }
 
+// heldConn collects the replies held by holdReplies.
+type heldConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (hc *heldConn) Write(b []byte) (int, error) {
+	return hc.buf.Write(b)
+}
+
+// performEvictions evicts keys until the memory in use is within maxmemory,
+// logging their deletion to the AOF, and fails with datastore.ErrOOM if it
+// can't.
+func (c *Client) performEvictions() error {
+	return datastore.PerformEvictions(func(db int, key string) {
+		c.aof.AppendCommand(db, []string{"DEL", key})
+	})
+}
+
 // ping handles the PING command for the client. 
 // It takes an array of arguments and responds with the appropriate message ("PONG" if no argument provided).
 func (c *Client) ping(args []string) {
@@ -76,31 +295,82 @@ func (c *Client) ping(args []string) {
 	} else {
 		response = "PONG"
 	}
+	if c.subscriptions > 0 {
+		// In subscriber mode the reply is a message, like the ones
+		// delivered to subscribers.
+		if len(args) == 1 {
+			response = ""
+		}
+		protocol.WriteArray(c.conn, []string{"pong", response})
+		return
+	}
 	protocol.WriteSimpleString(c.conn, response)
 }
 
 // echo handles the ECHO command for the client.
 // It takes an array of arguments and responds with the same string sent by the client.
 func (c *Client) echo(args []string) {
-	if len(args) != 2 {
-		protocol.WriteError(c.conn, "ERR wrong number of arguments for 'ECHO' command")
-		return
-	}
 	protocol.WriteBulkString(c.conn, args[1])
 }
 
 // set handles the SET command for the client.
-// It takes an array of arguments with the following format: ["SET", key, value].
-// It sets the given key-value pair in the in-memory data store and appends the command to the AOF file, then responds with "OK".
+// It takes an array of arguments with the following format:
+// ["SET", key, value, [NX | XX], [GET], [EX seconds | PX milliseconds | EXAT timestamp | PXAT ms-timestamp | KEEPTTL]].
+// It responds with "OK", or a null bulk string if the NX or XX condition was
+// not met. With GET it responds with the old value instead.
+// The write is logged to the AOF without the conditions, and with any expiry
+// as an absolute PXAT deadline so that replaying the file does not extend the key's lifetime.
+// A deadline that passed already is logged as DEL.
 func (c *Client) set(args []string) {
-	if len(args) != 3 {
-		protocol.WriteError(c.conn, "ERR wrong number of arguments for 'SET' command")
+	key, value := args[1], args[2]
+	var opts datastore.SetArgs
+	var expiry, ttl string
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "NX" && !opts.XX:
+			opts.NX = true
+		case option == "XX" && !opts.NX:
+			opts.XX = true
+		case option == "GET":
+			opts.Get = true
+		case option == "KEEPTTL" && expiry == "":
+			opts.KeepTTL = true
+		case isExpireOption(option) && (expiry == "" || expiry == option) && !opts.KeepTTL && i+1 < len(args):
+			expiry, ttl = option, args[i+1]
+			i++
+		default:
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+	}
+	if expiry != "" {
+		at, msg := parseExpireOption(args[0], expiry, ttl)
+		if msg != "" {
+			protocol.WriteError(c.conn, msg)
+			return
+		}
+		opts.ExpireAt = at
+	}
+
+	result, err := c.datastore.SetWithArgs(key, value, opts)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
 		return
 	}
-	key, value := args[1], args[2]
-	c.datastore.Set(key, value)
-	c.aof.AppendCommand(args)
-	protocol.WriteSimpleString(c.conn, "OK")
+	switch {
+	case result.Expired:
+		c.propagate([]string{"DEL", key})
+	case result.Done:
+		c.propagate(setEntry(key, value, opts))
+	}
+	switch {
+	case opts.Get && result.Found:
+		protocol.WriteBulkString(c.conn, result.Old)
+	case opts.Get || !result.Done:
+		protocol.WriteNullBulkString(c.conn)
+	default:
+		protocol.WriteSimpleString(c.conn, "OK")
+	}
 }
 
 // get handles the GET command for the client.
@@ -108,13 +378,11 @@ func (c *Client) set(args []string) {
 // It looks up the given key in the in-memory data store and responds with the
 // associated value. If the key is not found, it responds with a null bulk string.
 func (c *Client) get(args []string) {
-	if len(args) != 2 {
-		protocol.WriteError(c.conn, "ERR wrong number of arguments for 'GET' command")
-		return
-	}
 	key := args[1]
-	value, found := c.datastore.Get(key)
-	if !found {
+	value, found, err := c.datastore.Get(key)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+	} else if !found {
 		protocol.WriteNullBulkString(c.conn)
 	} else {
 		protocol.WriteBulkString(c.conn, value)
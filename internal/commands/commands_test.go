@@ -4,8 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/manimovassagh/Godis/internal/aof"
+	"github.com/manimovassagh/Godis/internal/config"
 	"github.com/manimovassagh/Godis/internal/datastore"
 	"github.com/manimovassagh/Godis/internal/protocol"
 )
@@ -35,6 +43,12 @@ func (m *MockConn) Read(b []byte) (int, error) {
 	return m.readBuffer.Read(b)
 }
 
+// SetReadDeadline reports that the mock connection has no read deadlines,
+// so that blocked clients do not watch it for being closed
+func (m *MockConn) SetReadDeadline(time.Time) error {
+	return os.ErrNoDeadline
+}
+
 // SimulateInput simulates input from the client
 func (m *MockConn) SimulateInput(input string) {
 	m.readBuffer.WriteString(input)
@@ -101,6 +115,1198 @@ func TestSetGetCommand(t *testing.T) {
 		t.Errorf("Expected %q for GET, got %q", expectedGet, mockConn.GetOutput())
 	}
 }
+
+// TestStringCommands tests the string command family and its integer encoding
+func TestStringCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"INCR", "counter"}, ":1\r\n"},
+		{[]string{"INCRBY", "counter", "41"}, ":42\r\n"},
+		{[]string{"DECR", "counter"}, ":41\r\n"},
+		{[]string{"DECRBY", "counter", "-9"}, ":50\r\n"},
+		{[]string{"OBJECT", "ENCODING", "counter"}, "$3\r\nint\r\n"},
+		{[]string{"GET", "counter"}, "$2\r\n50\r\n"},
+		{[]string{"INCRBY", "counter", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"DECRBY", "counter", "-9223372036854775808"}, "-ERR decrement would overflow\r\n"},
+		{[]string{"SET", "counter", "9223372036854775807"}, "+OK\r\n"},
+		{[]string{"INCR", "counter"}, "-ERR increment or decrement would overflow\r\n"},
+		{[]string{"SET", "padded", "007"}, "+OK\r\n"},
+		{[]string{"OBJECT", "ENCODING", "padded"}, "$6\r\nembstr\r\n"},
+		{[]string{"INCR", "padded"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"RPUSH", "strlist", "a"}, ":1\r\n"},
+		{[]string{"INCR", "strlist"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		{[]string{"SET", "float", "10.50"}, "+OK\r\n"},
+		{[]string{"INCRBYFLOAT", "float", "0.1"}, "$4\r\n10.6\r\n"},
+		{[]string{"INCRBYFLOAT", "float", "-5.6"}, "$1\r\n5\r\n"},
+		{[]string{"OBJECT", "ENCODING", "float"}, "$3\r\nint\r\n"},
+		{[]string{"INCRBYFLOAT", "float", "abc"}, "-ERR value is not a valid float\r\n"},
+		{[]string{"SET", "huge", "1e308"}, "+OK\r\n"},
+		{[]string{"INCRBYFLOAT", "huge", "1e308"}, "-ERR increment would produce NaN or Infinity\r\n"},
+
+		{[]string{"APPEND", "greeting", "Hello"}, ":5\r\n"},
+		{[]string{"APPEND", "greeting", " World"}, ":11\r\n"},
+		{[]string{"STRLEN", "greeting"}, ":11\r\n"},
+		{[]string{"STRLEN", "missing"}, ":0\r\n"},
+		{[]string{"GETRANGE", "greeting", "0", "4"}, "$5\r\nHello\r\n"},
+		{[]string{"GETRANGE", "greeting", "-5", "-1"}, "$5\r\nWorld\r\n"},
+		{[]string{"GETRANGE", "greeting", "5", "1"}, "$0\r\n\r\n"},
+		{[]string{"GETRANGE", "greeting", "0", "100"}, "$11\r\nHello World\r\n"},
+		{[]string{"SETRANGE", "greeting", "6", "Redis"}, ":11\r\n"},
+		{[]string{"GET", "greeting"}, "$11\r\nHello Redis\r\n"},
+		{[]string{"SETRANGE", "padme", "3", "x"}, ":4\r\n"},
+		{[]string{"GET", "padme"}, "$4\r\n\x00\x00\x00x\r\n"},
+		{[]string{"SETRANGE", "empty", "5", ""}, ":0\r\n"},
+		{[]string{"GET", "empty"}, "$-1\r\n"},
+		{[]string{"SETRANGE", "greeting", "-1", "x"}, "-ERR offset is out of range\r\n"},
+		{[]string{"SETRANGE", "greeting", "536870912", "x"}, "-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n"},
+
+		{[]string{"MSET", "m1", "a", "m2", "b"}, "+OK\r\n"},
+		{[]string{"MSET", "m1", "a", "m2"}, "-ERR wrong number of arguments for 'MSET' command\r\n"},
+		{[]string{"MGET", "m1", "nosuch", "strlist", "m2"}, "*4\r\n$1\r\na\r\n$-1\r\n$-1\r\n$1\r\nb\r\n"},
+		{[]string{"MSETNX", "m2", "x", "m3", "y"}, ":0\r\n"},
+		{[]string{"MSETNX", "m3", "x", "m4", "y"}, ":1\r\n"},
+		{[]string{"MGET", "m3", "m4"}, "*2\r\n$1\r\nx\r\n$1\r\ny\r\n"},
+	})
+}
+
+// TestBinaryValues tests that keys and values holding CR, LF and NUL bytes
+// are stored and returned unchanged
+func TestBinaryValues(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SET", "bin\r\nkey", "a\r\n\x00b"}, "+OK\r\n"},
+		{[]string{"GET", "bin\r\nkey"}, "$5\r\na\r\n\x00b\r\n"},
+		{[]string{"APPEND", "bin\r\nkey", "\x00\xff"}, ":7\r\n"},
+		{[]string{"GETRANGE", "bin\r\nkey", "3", "-1"}, "$4\r\n\x00b\x00\xff\r\n"},
+		{[]string{"STRLEN", "bin\r\nkey"}, ":7\r\n"},
+		{[]string{"NOSUCH\r\n+OK"}, "-ERR unknown command 'NOSUCH  +OK'\r\n"},
+	})
+}
+
+// TestExpireCommands tests EXPIRE, TTL, PTTL, PERSIST and SET with EX
+func TestExpireCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SET", "expk", "v"}, "+OK\r\n"},
+		{[]string{"TTL", "expk"}, ":-1\r\n"},
+		{[]string{"TTL", "missing"}, ":-2\r\n"},
+		{[]string{"EXPIRE", "expk", "100"}, ":1\r\n"},
+		{[]string{"TTL", "expk"}, ":100\r\n"},
+		{[]string{"PERSIST", "expk"}, ":1\r\n"},
+		{[]string{"PERSIST", "expk"}, ":0\r\n"},
+		{[]string{"EXPIRE", "missing", "10"}, ":0\r\n"},
+		{[]string{"SET", "expk", "v", "EX", "50"}, "+OK\r\n"},
+		{[]string{"TTL", "expk"}, ":50\r\n"},
+		{[]string{"SET", "expk", "v", "EX", "0"}, "-ERR invalid expire time in 'SET' command\r\n"},
+		{[]string{"PEXPIRE", "expk", "-1"}, ":1\r\n"},
+		{[]string{"GET", "expk"}, "$-1\r\n"},
+	})
+}
+
+// TestExpireClock tests that deadlines and TTLs are computed from the clock
+// the data store expires keys by
+func TestExpireClock(t *testing.T) {
+	client, mockConn := createMockClient()
+	current := int64(1_000_000_000_000)
+	datastore.SetNow(current)
+	defer datastore.SetNow(0)
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SET", "clockk", "v", "PX", "1500"}, "+OK\r\n"},
+		{[]string{"PEXPIRETIME", "clockk"}, ":1000000001500\r\n"},
+		{[]string{"PTTL", "clockk"}, ":1500\r\n"},
+		{[]string{"HSET", "clockh", "f", "v"}, ":1\r\n"},
+		{[]string{"HPEXPIRE", "clockh", "1500", "FIELDS", "1", "f"}, "*1\r\n:1\r\n"},
+		{[]string{"HPTTL", "clockh", "FIELDS", "1", "f"}, "*1\r\n:1500\r\n"},
+	})
+	datastore.SetNow(current + 1499)
+	runSteps(t, client, mockConn, []step{
+		{[]string{"PTTL", "clockk"}, ":1\r\n"},
+		{[]string{"HPTTL", "clockh", "FIELDS", "1", "f"}, "*1\r\n:1\r\n"},
+	})
+	datastore.SetNow(current + 1500)
+	runSteps(t, client, mockConn, []step{
+		{[]string{"PTTL", "clockk"}, ":-2\r\n"},
+		{[]string{"HPTTL", "clockh", "FIELDS", "1", "f"}, "*1\r\n:-2\r\n"},
+	})
+}
+
+// TestExpireOptions tests the NX, XX, GT and LT options of EXPIRE and
+// PEXPIRE, and the errors for incompatible and unknown options
+func TestExpireOptions(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SET", "optk", "v"}, "+OK\r\n"},
+		{[]string{"EXPIRE", "optk", "100", "XX"}, ":0\r\n"},
+		{[]string{"EXPIRE", "optk", "100", "GT"}, ":0\r\n"},
+		{[]string{"TTL", "optk"}, ":-1\r\n"},
+		{[]string{"EXPIRE", "optk", "100", "nx"}, ":1\r\n"},
+		{[]string{"EXPIRE", "optk", "200", "NX"}, ":0\r\n"},
+		{[]string{"EXPIRE", "optk", "50", "GT"}, ":0\r\n"},
+		{[]string{"EXPIRE", "optk", "200", "GT"}, ":1\r\n"},
+		{[]string{"TTL", "optk"}, ":200\r\n"},
+		{[]string{"PEXPIRE", "optk", "300000", "LT"}, ":0\r\n"},
+		{[]string{"EXPIRE", "optk", "150", "XX", "LT"}, ":1\r\n"},
+		{[]string{"TTL", "optk"}, ":150\r\n"},
+		{[]string{"PERSIST", "optk"}, ":1\r\n"},
+		{[]string{"EXPIRE", "optk", "10", "XX", "LT"}, ":0\r\n"},
+		{[]string{"EXPIRE", "optk", "10", "LT"}, ":1\r\n"},
+		{[]string{"TTL", "optk"}, ":10\r\n"},
+		{[]string{"EXPIRE", "missing", "10", "NX"}, ":0\r\n"},
+		{[]string{"EXPIRE", "optk", "10", "NX", "XX"}, "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n"},
+		{[]string{"EXPIRE", "optk", "10", "GT", "NX"}, "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n"},
+		{[]string{"EXPIRE", "optk", "10", "GT", "LT"}, "-ERR GT and LT options at the same time are not compatible\r\n"},
+		{[]string{"EXPIRE", "optk", "10", "bogus"}, "-ERR Unsupported option bogus\r\n"},
+		{[]string{"PEXPIREAT", "optk", "1", "GT"}, ":0\r\n"},
+		{[]string{"PEXPIREAT", "optk", "1", "LT"}, ":1\r\n"},
+		{[]string{"EXISTS", "optk"}, ":0\r\n"},
+	})
+}
+
+// TestSetOptions tests the SET options and the GETSET, GETDEL, GETEX, SETNX,
+// SETEX and PSETEX commands
+func TestSetOptions(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SET", "lock", "token", "NX", "PX", "30000"}, "+OK\r\n"},
+		{[]string{"SET", "lock", "other", "NX", "PX", "30000"}, "$-1\r\n"},
+		{[]string{"TTL", "lock"}, ":30\r\n"},
+		{[]string{"SET", "lock", "token2", "xx", "keepttl"}, "+OK\r\n"},
+		{[]string{"TTL", "lock"}, ":30\r\n"},
+		{[]string{"SET", "lock", "token3", "XX", "GET"}, "$6\r\ntoken2\r\n"},
+		{[]string{"TTL", "lock"}, ":-1\r\n"},
+		{[]string{"SET", "nolock", "v", "XX"}, "$-1\r\n"},
+		{[]string{"SET", "nolock", "v", "NX", "GET"}, "$-1\r\n"},
+		{[]string{"SET", "nolock", "w", "NX", "GET"}, "$1\r\nv\r\n"},
+		{[]string{"GET", "nolock"}, "$1\r\nv\r\n"},
+		{[]string{"SET", "abs", "v", "EXAT", "99999999999"}, "+OK\r\n"},
+		{[]string{"EXPIRETIME", "abs"}, ":99999999999\r\n"},
+		{[]string{"SET", "abs", "v", "PXAT", "1"}, "+OK\r\n"},
+		{[]string{"GET", "abs"}, "$-1\r\n"},
+		{[]string{"SET", "k", "v", "NX", "XX"}, "-ERR syntax error\r\n"},
+		{[]string{"SET", "k", "v", "EX", "10", "PX", "100"}, "-ERR syntax error\r\n"},
+		{[]string{"SET", "k", "v", "KEEPTTL", "EX", "10"}, "-ERR syntax error\r\n"},
+		{[]string{"SET", "k", "v", "EX"}, "-ERR syntax error\r\n"},
+		{[]string{"SET", "k", "v", "EX", "ten"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"SET", "k", "v", "PXAT", "-5"}, "-ERR invalid expire time in 'SET' command\r\n"},
+		{[]string{"SET", "k", "v", "EX", "9223372036854775807"}, "-ERR invalid expire time in 'SET' command\r\n"},
+		{[]string{"RPUSH", "setlist", "a"}, ":1\r\n"},
+		{[]string{"SET", "setlist", "v", "GET"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"SET", "setlist", "v"}, "+OK\r\n"},
+
+		{[]string{"GETSET", "gs", "a"}, "$-1\r\n"},
+		{[]string{"EXPIRE", "gs", "100"}, ":1\r\n"},
+		{[]string{"GETSET", "gs", "b"}, "$1\r\na\r\n"},
+		{[]string{"TTL", "gs"}, ":-1\r\n"},
+		{[]string{"GETDEL", "gs"}, "$1\r\nb\r\n"},
+		{[]string{"GETDEL", "gs"}, "$-1\r\n"},
+		{[]string{"SETNX", "snx", "a"}, ":1\r\n"},
+		{[]string{"SETNX", "snx", "b"}, ":0\r\n"},
+		{[]string{"SETEX", "sex", "100", "v"}, "+OK\r\n"},
+		{[]string{"TTL", "sex"}, ":100\r\n"},
+		{[]string{"SETEX", "sex", "0", "v"}, "-ERR invalid expire time in 'SETEX' command\r\n"},
+		{[]string{"PSETEX", "sex", "5000", "w"}, "+OK\r\n"},
+		{[]string{"TTL", "sex"}, ":5\r\n"},
+		{[]string{"GETEX", "sex", "PERSIST"}, "$1\r\nw\r\n"},
+		{[]string{"TTL", "sex"}, ":-1\r\n"},
+		{[]string{"GETEX", "sex", "EX", "60"}, "$1\r\nw\r\n"},
+		{[]string{"TTL", "sex"}, ":60\r\n"},
+		{[]string{"GETEX", "sex"}, "$1\r\nw\r\n"},
+		{[]string{"TTL", "sex"}, ":60\r\n"},
+		{[]string{"GETEX", "sex", "EX", "60", "PERSIST"}, "-ERR syntax error\r\n"},
+		{[]string{"GETEX", "sex", "PX", "0"}, "-ERR invalid expire time in 'GETEX' command\r\n"},
+		{[]string{"GETEX", "missing", "EX", "60"}, "$-1\r\n"},
+		{[]string{"GETEX", "setlist", "PERSIST"}, "$1\r\nv\r\n"},
+		{[]string{"GETEX", "sex", "PXAT", "1"}, "$1\r\nw\r\n"},
+		{[]string{"GET", "sex"}, "$-1\r\n"},
+	})
+}
+
+// TestSetPropagation tests that the SET family is logged in its normalized
+// form
+func TestSetPropagation(t *testing.T) {
+	client, _ := createMockClient()
+	tests := []struct {
+		args     []string
+		expected [][]string
+	}{
+		{[]string{"SET", "prop", "v", "NX", "GET", "EXAT", "99999999999"}, [][]string{{"SET", "prop", "v", "PXAT", "99999999999000"}}},
+		{[]string{"SET", "prop", "v", "NX"}, nil},
+		{[]string{"SET", "prop", "w", "XX", "KEEPTTL"}, [][]string{{"SET", "prop", "w", "KEEPTTL"}}},
+		{[]string{"INCRBYFLOAT", "prop-float", "1.5"}, [][]string{{"SET", "prop-float", "1.5", "KEEPTTL"}}},
+		{[]string{"GETSET", "prop", "x"}, [][]string{{"SET", "prop", "x"}}},
+		{[]string{"GETEX", "prop", "PXAT", "99999999999999"}, [][]string{{"PEXPIREAT", "prop", "99999999999999"}}},
+		{[]string{"GETEX", "prop", "PERSIST"}, [][]string{{"PERSIST", "prop"}}},
+		{[]string{"GETEX", "prop"}, nil},
+		{[]string{"GETDEL", "prop"}, [][]string{{"DEL", "prop"}}},
+		{[]string{"GETDEL", "prop"}, nil},
+	}
+	for _, tt := range tests {
+		client.propagated = nil
+		commandTable[tt.args[0]].handler(client, tt.args)
+		got, expected := "", ""
+		for _, entry := range client.propagated {
+			got += protocol.FormatCommand(entry)
+		}
+		for _, entry := range tt.expected {
+			expected += protocol.FormatCommand(entry)
+		}
+		if got != expected {
+			t.Errorf("For %q expected %q to be logged, got %q", tt.args, expected, got)
+		}
+	}
+}
+
+// TestKeyspaceCommands tests the generic key-management commands
+func TestKeyspaceCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":0\r\n"},
+		{[]string{"RANDOMKEY"}, "$-1\r\n"},
+		{[]string{"MSET", "ks:a", "1", "ks:b", "2"}, "+OK\r\n"},
+		{[]string{"RPUSH", "ks:list", "x", "y"}, ":2\r\n"},
+		{[]string{"DBSIZE"}, ":3\r\n"},
+		{[]string{"EXISTS", "ks:a", "ks:a", "ks:missing"}, ":2\r\n"},
+		{[]string{"TOUCH", "ks:a", "ks:list", "ks:missing"}, ":2\r\n"},
+		{[]string{"TYPE", "ks:a"}, "+string\r\n"},
+		{[]string{"TYPE", "ks:list"}, "+list\r\n"},
+		{[]string{"TYPE", "ks:missing"}, "+none\r\n"},
+		{[]string{"DEL", "ks:a", "ks:missing"}, ":1\r\n"},
+		{[]string{"UNLINK", "ks:a"}, ":0\r\n"},
+		{[]string{"RENAME", "ks:missing", "ks:c"}, "-ERR no such key\r\n"},
+		{[]string{"EXPIRE", "ks:b", "100"}, ":1\r\n"},
+		{[]string{"RENAME", "ks:b", "ks:c"}, "+OK\r\n"},
+		{[]string{"TTL", "ks:c"}, ":100\r\n"},
+		{[]string{"GET", "ks:b"}, "$-1\r\n"},
+		{[]string{"RENAME", "ks:c", "ks:c"}, "+OK\r\n"},
+		{[]string{"RENAMENX", "ks:c", "ks:list"}, ":0\r\n"},
+		{[]string{"RENAMENX", "ks:c", "ks:b"}, ":1\r\n"},
+		{[]string{"COPY", "ks:list", "ks:copy"}, ":1\r\n"},
+		{[]string{"COPY", "ks:b", "ks:copy"}, ":0\r\n"},
+		{[]string{"COPY", "ks:b", "ks:copy", "REPLACE"}, ":1\r\n"},
+		{[]string{"GET", "ks:copy"}, "$1\r\n2\r\n"},
+		{[]string{"COPY", "ks:list", "ks:copy2", "DB", "0"}, ":1\r\n"},
+		{[]string{"RPUSH", "ks:copy2", "z"}, ":3\r\n"},
+		{[]string{"LLEN", "ks:list"}, ":2\r\n"},
+		{[]string{"COPY", "ks:list", "ks:list"}, "-ERR source and destination objects are the same\r\n"},
+		{[]string{"COPY", "ks:list", "ks:x", "DB", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"COPY", "ks:list", "ks:x", "FOO"}, "-ERR syntax error\r\n"},
+		{[]string{"FLUSHDB", "SOON"}, "-ERR syntax error\r\n"},
+		{[]string{"FLUSHALL", "ASYNC"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":0\r\n"},
+		{[]string{"SET", "ks:only", "v"}, "+OK\r\n"},
+		{[]string{"RANDOMKEY"}, "$7\r\nks:only\r\n"},
+		{[]string{"FLUSHDB", "SYNC"}, "+OK\r\n"},
+		{[]string{"EXISTS", "ks:only"}, ":0\r\n"},
+	})
+}
+
+// TestMaxMemory tests the OOM errors under noeviction, eviction and the
+// memory reporting commands
+func TestMaxMemory(t *testing.T) {
+	client, mockConn := createMockClient()
+	defer config.Set("maxmemory-policy", "noeviction")
+	defer config.Set("maxmemory", "0")
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+		{[]string{"SET", "mm:a", "v"}, "+OK\r\n"},
+		{[]string{"MEMORY", "USAGE", "mm:a"}, ":117\r\n"},
+		{[]string{"MEMORY", "USAGE", "mm:a", "SAMPLES", "0"}, ":117\r\n"},
+		{[]string{"MEMORY", "USAGE", "mm:a", "SAMPLES", "-1"}, "-ERR value is out of range, must be positive\r\n"},
+		{[]string{"MEMORY", "USAGE", "mm:missing"}, "$-1\r\n"},
+		{[]string{"CONFIG", "SET", "maxmemory-policy", "lru"}, "-ERR CONFIG SET failed (possibly related to argument 'maxmemory-policy') - argument(s) must be one of the following: noeviction, allkeys-lru, allkeys-lfu, allkeys-random, volatile-lru, volatile-lfu, volatile-random, volatile-ttl\r\n"},
+		{[]string{"CONFIG", "SET", "maxmemory", "100"}, "+OK\r\n"},
+		{[]string{"SET", "mm:b", "v"}, "-OOM command not allowed when used memory > 'maxmemory'.\r\n"},
+		{[]string{"GET", "mm:a"}, "$1\r\nv\r\n"},
+		{[]string{"EVAL", "return redis.pcall('SET', 'mm:b', 'v')['err']", "0"}, "$55\r\nOOM command not allowed when used memory > 'maxmemory'.\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"SET", "mm:b", "v"}, "-OOM command not allowed when used memory > 'maxmemory'.\r\n"},
+		{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{[]string{"CONFIG", "SET", "maxmemory", "1kb"}, "+OK\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"SET", "mm:b", "v"}, "+QUEUED\r\n"},
+		{[]string{"APPEND", "mm:a", strings.Repeat("x", 1000)}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*2\r\n+OK\r\n:1001\r\n"},
+		{[]string{"SET", "mm:c", "v"}, "-OOM command not allowed when used memory > 'maxmemory'.\r\n"},
+		{[]string{"DEL", "mm:a"}, ":1\r\n"},
+		{[]string{"SET", "mm:c", "v"}, "+OK\r\n"},
+		{[]string{"CONFIG", "SET", "maxmemory", "300", "maxmemory-policy", "allkeys-lru"}, "+OK\r\n"},
+		{[]string{"OBJECT", "IDLETIME", "mm:b"}, ":0\r\n"},
+		{[]string{"OBJECT", "FREQ", "mm:b"}, "-ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n"},
+		{[]string{"SET", "mm:d", "v"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":2\r\n"},
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+		{[]string{"SET", "mm:d", "v"}, "+OK\r\n"},
+		{[]string{"CONFIG", "SET", "maxmemory-policy", "allkeys-lfu"}, "+OK\r\n"},
+		{[]string{"OBJECT", "FREQ", "mm:d"}, ":5\r\n"},
+		{[]string{"GET", "mm:d"}, "$1\r\nv\r\n"},
+		{[]string{"OBJECT", "FREQ", "mm:d"}, ":6\r\n"},
+		{[]string{"OBJECT", "IDLETIME", "mm:d"}, "-ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n"},
+	})
+
+	mockConn.writeBuffer.Reset()
+	mockConn.SimulateInput(protocol.FormatCommand([]string{"INFO"}))
+	client.HandleOnce()
+	info := mockConn.GetOutput()
+	for _, field := range []string{"# Memory\r\nused_memory:117\r\n", "maxmemory:300\r\n", "maxmemory_policy:allkeys-lfu\r\n",
+		"# Stats\r\nevicted_keys:", "# Keyspace\r\ndb0:keys=1,expires=0\r\n"} {
+		if !strings.Contains(info, field) {
+			t.Errorf("Expected INFO to contain %q, got %q", field, info)
+		}
+	}
+	mockConn.writeBuffer.Reset()
+	mockConn.SimulateInput(protocol.FormatCommand([]string{"INFO", "KEYSPACE"}))
+	client.HandleOnce()
+	if info := mockConn.GetOutput(); strings.Contains(info, "# Memory") || !strings.Contains(info, "# Keyspace") {
+		t.Errorf("Expected INFO KEYSPACE to only report the keyspace, got %q", info)
+	}
+}
+
+// TestAOFWriteError tests that writes are refused once the AOF can't be
+// written, while reads still run
+func TestAOFWriteError(t *testing.T) {
+	handler, misconf := openFullAOF(t)
+	client, mockConn := createMockClient()
+	client.aof = handler
+
+	runSteps(t, client, mockConn, []step{
+		// The write that fails to be logged was already applied.
+		{[]string{"SET", "disk:a", "1"}, "+OK\r\n"},
+		{[]string{"SET", "disk:a", "2"}, misconf},
+		{[]string{"GET", "disk:a"}, "$1\r\n1\r\n"},
+		{[]string{"EVAL", "return redis.pcall('SET', 'disk:a', '2')['err']", "0"}, "$" + strconv.Itoa(len(misconf)-3) + "\r\n" + misconf[1:]},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"GET", "disk:a"}, "+QUEUED\r\n"},
+		{[]string{"DEL", "disk:a"}, misconf},
+		{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+	})
+
+	mockConn.writeBuffer.Reset()
+	mockConn.SimulateInput(protocol.FormatCommand([]string{"INFO", "persistence"}))
+	client.HandleOnce()
+	if info := mockConn.GetOutput(); !strings.Contains(info, "aof_last_write_status:err\r\n") {
+		t.Errorf("Expected INFO to report the write error, got %q", info)
+	}
+	client.aof = aof.GetAOFHandler()
+	runSteps(t, client, mockConn, []step{{[]string{"DEL", "disk:a"}, ":1\r\n"}})
+}
+
+// TestAOFWriteErrorFsyncAlways tests that under appendfsync always a write
+// that fails to be logged is not acknowledged
+func TestAOFWriteErrorFsyncAlways(t *testing.T) {
+	handler, misconf := openFullAOF(t)
+	client, mockConn := createMockClient()
+	client.aof = handler
+	runSteps(t, client, mockConn, []step{{[]string{"CONFIG", "SET", "appendfsync", "always"}, "+OK\r\n"}})
+	defer config.Set("appendfsync", "everysec")
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SET", "disk:b", "1"}, misconf},
+		{[]string{"GET", "disk:b"}, "$1\r\n1\r\n"},
+	})
+	client.aof = aof.GetAOFHandler()
+	runSteps(t, client, mockConn, []step{{[]string{"DEL", "disk:b"}, ":1\r\n"}})
+}
+
+// openFullAOF opens an AOF whose incremental file is /dev/full, and returns
+// it with the MISCONF error refusing writes once it failed to be written.
+func openFullAOF(t *testing.T) (*aof.AOFHandler, string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "appendonlydir")
+	os.Mkdir(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "appendonly.aof.manifest"), []byte("file appendonly.aof.1.incr.aof seq 1 type i\n"), 0644)
+	os.Symlink("/dev/full", filepath.Join(dir, "appendonly.aof.1.incr.aof"))
+	handler, err := aof.Open(filepath.Dir(dir))
+	if err != nil {
+		t.Skipf("Can't open /dev/full: %v", err)
+	}
+	return handler, "-MISCONF Errors writing to the AOF file: write " + filepath.Join(dir, "appendonly.aof.1.incr.aof") + ": no space left on device\r\n"
+}
+
+// TestRewriteAOF tests that BGREWRITEAOF rewrites the AOF into commands
+// that recreate the same data set, including the commands run meanwhile
+func TestRewriteAOF(t *testing.T) {
+	handler, err := aof.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open the AOF: %v", err)
+	}
+	client, mockConn := createMockClient()
+	client.aof = handler
+	defer flushLibraries()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+		{[]string{"FUNCTION", "LOAD", testLibrary}, "$5\r\nmylib\r\n"},
+		{[]string{"SET", "rw:str", "hello", "PX", "60000"}, "+OK\r\n"},
+		{[]string{"INCRBY", "rw:int", "42"}, ":42\r\n"},
+		{[]string{"RPUSH", "rw:list", "a", "b", "c"}, ":3\r\n"},
+		{[]string{"SADD", "rw:set", "x", "y"}, ":2\r\n"},
+		{[]string{"ZADD", "rw:zset", "1.5", "m", "-inf", "n"}, ":2\r\n"},
+		{[]string{"HSET", "rw:hash", "f1", "v1", "f2", "v2"}, ":2\r\n"},
+		{[]string{"HPEXPIRE", "rw:hash", "60000", "FIELDS", "1", "f2"}, "*1\r\n:1\r\n"},
+		{[]string{"XADD", "rw:stream", "1-1", "f", "v"}, "$3\r\n1-1\r\n"},
+		{[]string{"XADD", "rw:stream", "2-1", "f", "v"}, "$3\r\n2-1\r\n"},
+		{[]string{"XDEL", "rw:stream", "2-1"}, ":1\r\n"},
+		{[]string{"XGROUP", "CREATE", "rw:stream", "g", "0"}, "+OK\r\n"},
+		{[]string{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "rw:stream", ">"}, "*1\r\n*2\r\n$9\r\nrw:stream\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{[]string{"XGROUP", "CREATE", "rw:empty", "g", "$", "MKSTREAM"}, "+OK\r\n"},
+		{[]string{"SELECT", "2"}, "+OK\r\n"},
+		{[]string{"SET", "rw:str", "db2"}, "+OK\r\n"},
+		{[]string{"BGREWRITEAOF"}, "+Background append only file rewriting started\r\n"},
+		{[]string{"SET", "rw:after", "1"}, "+OK\r\n"},
+	})
+	for handler.Stats().RewriteInProgress {
+		time.Sleep(time.Millisecond)
+	}
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SELECT", "0"}, "+OK\r\n"},
+		{[]string{"DEL", "rw:list"}, ":1\r\n"},
+	})
+
+	reads := [][]string{
+		{"FUNCTION", "LIST"}, {"SELECT", "0"},
+		{"GET", "rw:str"}, {"PEXPIRETIME", "rw:str"}, {"GET", "rw:int"}, {"OBJECT", "ENCODING", "rw:int"},
+		{"EXISTS", "rw:list"}, {"SMISMEMBER", "rw:set", "x", "y"}, {"ZRANGE", "rw:zset", "0", "-1", "WITHSCORES"},
+		{"HMGET", "rw:hash", "f1", "f2"}, {"HPEXPIRETIME", "rw:hash", "FIELDS", "2", "f1", "f2"},
+		{"XRANGE", "rw:stream", "-", "+"}, {"XINFO", "STREAM", "rw:stream"}, {"XINFO", "GROUPS", "rw:stream"},
+		{"XPENDING", "rw:stream", "g"}, {"XINFO", "STREAM", "rw:empty"}, {"XINFO", "GROUPS", "rw:empty"},
+		{"SELECT", "2"}, {"GET", "rw:str"}, {"GET", "rw:after"}, {"DBSIZE"},
+	}
+	read := func() string {
+		var b strings.Builder
+		for _, args := range reads {
+			mockConn.writeBuffer.Reset()
+			mockConn.SimulateInput(protocol.FormatCommand(args))
+			client.HandleOnce()
+			b.WriteString(mockConn.GetOutput())
+		}
+		return b.String()
+	}
+	before := read()
+	datastore.FlushAll(false)
+	flushLibraries()
+	if err := LoadAOF(handler); err != nil {
+		t.Fatalf("Failed to replay the rewritten AOF: %v", err)
+	}
+	if after := read(); after != before {
+		t.Errorf("Expected the rewritten AOF to recreate\n%q\ngot\n%q", before, after)
+	}
+	runSteps(t, client, mockConn, []step{{[]string{"FLUSHALL"}, "+OK\r\n"}})
+}
+
+// TestRewriteAOFConcurrentWrites tests that the writes made while the AOF is
+// rewritten are neither lost nor applied twice by the rewritten AOF
+func TestRewriteAOFConcurrentWrites(t *testing.T) {
+	handler, err := aof.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open the AOF: %v", err)
+	}
+	client, mockConn := createMockClient()
+	client.aof = handler
+	const lists = 2000
+	for i := range lists {
+		client.process([]string{"RPUSH", "rw:list:" + strconv.Itoa(i), "a"})
+	}
+	client.process([]string{"BGREWRITEAOF"})
+	for i := 0; handler.Stats().RewriteInProgress || i < lists; i++ {
+		client.process([]string{"RPUSH", "rw:list:" + strconv.Itoa(i%lists), "b"})
+		if i%3 == 0 {
+			client.process([]string{"DEL", "rw:list:" + strconv.Itoa((i+1)%lists)})
+		}
+	}
+
+	read := func() string {
+		mockConn.writeBuffer.Reset()
+		for i := range lists {
+			client.process([]string{"LRANGE", "rw:list:" + strconv.Itoa(i), "0", "-1"})
+		}
+		return mockConn.GetOutput()
+	}
+	before := read()
+	datastore.FlushAll(false)
+	defer datastore.OnExpired(nil)
+	if err := LoadAOF(handler); err != nil {
+		t.Fatalf("Failed to replay the rewritten AOF: %v", err)
+	}
+	if read() != before {
+		t.Error("Expected the rewritten AOF to recreate the lists written meanwhile")
+	}
+	runSteps(t, client, mockConn, []step{{[]string{"FLUSHALL"}, "+OK\r\n"}})
+}
+
+// TestXSetID tests the XSETID command
+func TestXSetID(t *testing.T) {
+	client, mockConn := createMockClient()
+	runSteps(t, client, mockConn, []step{
+		{[]string{"DEL", "xsetid"}, ":0\r\n"},
+		{[]string{"XSETID", "xsetid", "1-0"}, "-ERR no such key\r\n"},
+		{[]string{"XADD", "xsetid", "5-0", "f", "v"}, "$3\r\n5-0\r\n"},
+		{[]string{"XSETID", "xsetid", "4-0"}, "-ERR The ID specified in XSETID is smaller than the target stream top item\r\n"},
+		{[]string{"XSETID", "xsetid", "6-0", "ENTRIESADDED", "0"}, "-ERR The entries_added specified in XSETID is smaller than the target stream length\r\n"},
+		{[]string{"XSETID", "xsetid", "6-0", "ENTRIESADDED", "-1"}, "-ERR entries_added must be positive\r\n"},
+		{[]string{"XSETID", "xsetid", "6-0", "MAXDELETEDID", "7-0"}, "-ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id\r\n"},
+		{[]string{"XSETID", "xsetid", "6-0", "ENTRIESADDED"}, "-ERR syntax error\r\n"},
+		{[]string{"XSETID", "xsetid", "6-0", "ENTRIESADDED", "10", "MAXDELETEDID", "3-0"}, "+OK\r\n"},
+		{[]string{"XADD", "xsetid", "6-0", "f", "v"}, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{[]string{"XSETID", "xsetid", "2-0"}, "-ERR The ID specified in XSETID is smaller than current max_deleted_entry_id\r\n"},
+		{[]string{"SET", "xsetid", "v"}, "+OK\r\n"},
+		{[]string{"XSETID", "xsetid", "1-0"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"DEL", "xsetid"}, ":1\r\n"},
+	})
+}
+
+// TestDatabases tests SELECT, MOVE, SWAPDB, COPY DB and FLUSHDB, and that
+// WATCH and blocked clients stay with the database they were issued in
+func TestDatabases(t *testing.T) {
+	client, mockConn := createMockClient()
+	other, otherConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+		{[]string{"SELECT", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"SELECT", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"SET", "db:a", "0"}, "+OK\r\n"},
+		{[]string{"SELECT", "1"}, "+OK\r\n"},
+		{[]string{"GET", "db:a"}, "$-1\r\n"},
+		{[]string{"SET", "db:a", "1"}, "+OK\r\n"},
+		{[]string{"SET", "db:b", "1"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":2\r\n"},
+		{[]string{"MOVE", "db:a", "0"}, ":0\r\n"},
+		{[]string{"MOVE", "db:b", "0"}, ":1\r\n"},
+		{[]string{"MOVE", "db:b", "1"}, "-ERR source and destination objects are the same\r\n"},
+		{[]string{"MOVE", "db:missing", "0"}, ":0\r\n"},
+		{[]string{"MOVE", "db:a", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"COPY", "db:a", "db:c", "DB", "2"}, ":1\r\n"},
+		{[]string{"DBSIZE"}, ":1\r\n"},
+		{[]string{"SWAPDB", "0", "x"}, "-ERR invalid second DB index\r\n"},
+		{[]string{"SWAPDB", "0", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"SWAPDB", "0", "1"}, "+OK\r\n"},
+		{[]string{"GET", "db:a"}, "$1\r\n0\r\n"},
+		{[]string{"GET", "db:b"}, "$1\r\n1\r\n"},
+		{[]string{"SELECT", "2"}, "+OK\r\n"},
+		{[]string{"GET", "db:c"}, "$1\r\n1\r\n"},
+		{[]string{"FLUSHDB"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":0\r\n"},
+		{[]string{"SELECT", "0"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":1\r\n"},
+		{[]string{"WATCH", "db:a"}, "+OK\r\n"},
+	})
+	runSteps(t, other, otherConn, []step{
+		{[]string{"SELECT", "1"}, "+OK\r\n"},
+		{[]string{"SET", "db:a", "2"}, "+OK\r\n"},
+	})
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SELECT", "1"}, "+OK\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"GET", "db:a"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*1\r\n$1\r\n2\r\n"},
+	})
+
+	_, blockedOut := startBlocked(t, "BLPOP", "db:q", "0")
+	runSteps(t, other, otherConn, []step{
+		{[]string{"RPUSH", "db:q", "x"}, ":1\r\n"},
+		{[]string{"LLEN", "db:q"}, ":1\r\n"},
+		{[]string{"SWAPDB", "1", "0"}, "+OK\r\n"},
+	})
+	if got := awaitReply(t, blockedOut); got != "*2\r\n$4\r\ndb:q\r\n$1\r\nx\r\n" {
+		t.Errorf("Expected SWAPDB to serve the client blocked in the other database, got %q", got)
+	}
+	runSteps(t, client, mockConn, []step{
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+	})
+}
+
+// TestListCommands tests the list command family and WRONGTYPE errors
+func TestListCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"RPUSH", "mylist", "b", "c"}, ":2\r\n"},
+		{[]string{"LPUSH", "mylist", "a"}, ":3\r\n"},
+		{[]string{"LRANGE", "mylist", "0", "-1"}, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"LINDEX", "mylist", "-1"}, "$1\r\nc\r\n"},
+		{[]string{"LINDEX", "mylist", "5"}, "$-1\r\n"},
+		{[]string{"LSET", "mylist", "1", "B"}, "+OK\r\n"},
+		{[]string{"LSET", "mylist", "9", "B"}, "-ERR index out of range\r\n"},
+		{[]string{"LINSERT", "mylist", "AFTER", "B", "x"}, ":4\r\n"},
+		{[]string{"LINSERT", "mylist", "BEFORE", "nope", "x"}, ":-1\r\n"},
+		{[]string{"LREM", "mylist", "0", "x"}, ":1\r\n"},
+		{[]string{"LLEN", "mylist"}, ":3\r\n"},
+		{[]string{"LMOVE", "mylist", "other", "LEFT", "RIGHT"}, "$1\r\na\r\n"},
+		{[]string{"LTRIM", "mylist", "0", "0"}, "+OK\r\n"},
+		{[]string{"RPOP", "mylist"}, "$1\r\nB\r\n"},
+		{[]string{"RPOP", "mylist"}, "$-1\r\n"},
+		{[]string{"LPOP", "mylist", "2"}, "*-1\r\n"},
+		{[]string{"LPOP", "other", "2"}, "*1\r\n$1\r\na\r\n"},
+		{[]string{"SET", "strkey", "v"}, "+OK\r\n"},
+		{[]string{"LPUSH", "strkey", "v"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"RPUSH", "listkey", "v"}, ":1\r\n"},
+		{[]string{"GET", "listkey"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+// TestHashCommands tests the hash command family
+func TestHashCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"HSET", "user:1", "name", "ann", "age", "30"}, ":2\r\n"},
+		{[]string{"HSET", "user:1", "name", "bob"}, ":0\r\n"},
+		{[]string{"HGET", "user:1", "name"}, "$3\r\nbob\r\n"},
+		{[]string{"HMGET", "user:1", "age", "nope"}, "*2\r\n$2\r\n30\r\n$-1\r\n"},
+		{[]string{"HGETALL", "user:1"}, "*4\r\n$3\r\nage\r\n$2\r\n30\r\n$4\r\nname\r\n$3\r\nbob\r\n"},
+		{[]string{"HKEYS", "user:1"}, "*2\r\n$3\r\nage\r\n$4\r\nname\r\n"},
+		{[]string{"HINCRBY", "user:1", "age", "5"}, ":35\r\n"},
+		{[]string{"HINCRBY", "user:1", "name", "5"}, "-ERR hash value is not an integer\r\n"},
+		{[]string{"HINCRBYFLOAT", "user:1", "score", "10.5"}, "$4\r\n10.5\r\n"},
+		{[]string{"HINCRBYFLOAT", "user:1", "score", "0.1"}, "$4\r\n10.6\r\n"},
+		{[]string{"HEXISTS", "user:1", "score"}, ":1\r\n"},
+		{[]string{"HDEL", "user:1", "score", "nope"}, ":1\r\n"},
+		{[]string{"HLEN", "user:1"}, ":2\r\n"},
+		{[]string{"HSCAN", "user:1", "0", "MATCH", "n*"}, "*2\r\n$1\r\n0\r\n*2\r\n$4\r\nname\r\n$3\r\nbob\r\n"},
+		{[]string{"HEXPIRE", "user:1", "100", "FIELDS", "2", "name", "nope"}, "*2\r\n:1\r\n:-2\r\n"},
+		{[]string{"HEXPIRE", "user:1", "200", "NX", "FIELDS", "1", "name"}, "*1\r\n:0\r\n"},
+		{[]string{"HTTL", "user:1", "FIELDS", "2", "name", "age"}, "*2\r\n:100\r\n:-1\r\n"},
+		{[]string{"HPERSIST", "user:1", "FIELDS", "2", "name", "age"}, "*2\r\n:1\r\n:-1\r\n"},
+		{[]string{"HPEXPIRE", "user:1", "0", "FIELDS", "1", "age"}, "*1\r\n:2\r\n"},
+		{[]string{"HTTL", "user:1", "FIELDS", "2", "name"}, "-ERR The `numfields` parameter must match the number of arguments\r\n"},
+		{[]string{"HGETALL", "user:1"}, "*2\r\n$4\r\nname\r\n$3\r\nbob\r\n"},
+		{[]string{"LPUSH", "user:1", "x"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+// scanAll runs a command of the SCAN family from cursor 0 until the
+// iteration completes and returns how many times each element was returned.
+// The cursor goes between prefix and options.
+func scanAll(t *testing.T, client *Client, mockConn *MockConn, prefix []string, options ...string) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls == 10000 {
+			t.Fatalf("Expected %v to complete", prefix)
+		}
+		args := append(append(append([]string{}, prefix...), cursor), options...)
+		mockConn.writeBuffer.Reset()
+		mockConn.SimulateInput(protocol.FormatCommand(args))
+		client.HandleOnce()
+		reply, err := protocol.ReadReply(bufio.NewReader(strings.NewReader(mockConn.GetOutput())))
+		if err != nil || len(reply.Elements) != 2 {
+			t.Fatalf("For %q expected a cursor and elements, got %q", args, mockConn.GetOutput())
+		}
+		for _, element := range reply.Elements[1].Elements {
+			seen[element.Str]++
+		}
+		if cursor = reply.Elements[0].Str; cursor == "0" {
+			return seen
+		}
+	}
+}
+
+// TestScanCommands tests KEYS, SCAN and its MATCH, COUNT and TYPE options,
+// and the cursors of SSCAN, HSCAN and ZSCAN over hash tables
+func TestScanCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+		{[]string{"SCAN", "0"}, "*2\r\n$1\r\n0\r\n*0\r\n"},
+		{[]string{"SCAN", "x"}, "-ERR invalid cursor\r\n"},
+		{[]string{"SCAN", "-1"}, "-ERR invalid cursor\r\n"},
+		{[]string{"SCAN", "0", "COUNT", "0"}, "-ERR syntax error\r\n"},
+		{[]string{"SCAN", "0", "TYPE", "foo"}, "-ERR unknown type name 'foo'\r\n"},
+		{[]string{"SCAN", "0", "NOVALUES"}, "-ERR syntax error\r\n"},
+		{[]string{"HSCAN", "h", "0", "TYPE", "hash"}, "-ERR syntax error\r\n"},
+	})
+	for i := 0; i < 100; i++ {
+		client.datastore.Set("scan:"+strconv.Itoa(i), "v")
+		client.datastore.SAdd("scanset", "m"+strconv.Itoa(i))
+		client.datastore.HSet("scanhash", "f"+strconv.Itoa(i), "v")
+		client.datastore.ZAdd("scanzset", datastore.ZAddOptions{}, datastore.ZMember{Member: "m" + strconv.Itoa(i), Score: float64(i)})
+	}
+
+	keys := scanAll(t, client, mockConn, []string{"SCAN"}, "MATCH", "scan:*", "COUNT", "7")
+	if len(keys) != 100 {
+		t.Errorf("Expected SCAN MATCH to return the 100 string keys, got %d", len(keys))
+	}
+	if keys := scanAll(t, client, mockConn, []string{"SCAN"}, "TYPE", "HASH"); len(keys) != 1 || keys["scanhash"] != 1 {
+		t.Errorf("Expected SCAN TYPE to return only the hash, got %v", keys)
+	}
+	if members := scanAll(t, client, mockConn, []string{"SSCAN", "scanset"}, "COUNT", "5"); len(members) != 100 {
+		t.Errorf("Expected SSCAN to return the 100 members, got %d", len(members))
+	}
+	if pairs := scanAll(t, client, mockConn, []string{"HSCAN", "scanhash"}, "NOVALUES"); len(pairs) != 100 {
+		t.Errorf("Expected HSCAN to return the 100 fields, got %d", len(pairs))
+	}
+	if pairs := scanAll(t, client, mockConn, []string{"ZSCAN", "scanzset"}, "MATCH", "m9*"); len(pairs) != 22 {
+		t.Errorf("Expected ZSCAN MATCH to return 11 members and their scores, got %v", pairs)
+	}
+
+	mockConn.writeBuffer.Reset()
+	mockConn.SimulateInput(protocol.FormatCommand([]string{"KEYS", "scan:1?"}))
+	client.HandleOnce()
+	reply, err := protocol.ReadReply(bufio.NewReader(strings.NewReader(mockConn.GetOutput())))
+	if err != nil || len(reply.Elements) != 10 {
+		t.Errorf("Expected KEYS to return scan:10 to scan:19, got %q", mockConn.GetOutput())
+	}
+	runSteps(t, client, mockConn, []step{
+		{[]string{"KEYS", "nothing*"}, "*0\r\n"},
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+	})
+}
+
+// TestSetCommands tests the set command family, CONFIG and OBJECT ENCODING
+func TestSetCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SADD", "nums", "3", "1", "2", "2"}, ":3\r\n"},
+		{[]string{"SMEMBERS", "nums"}, "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n"},
+		{[]string{"OBJECT", "ENCODING", "nums"}, "$6\r\nintset\r\n"},
+		{[]string{"SISMEMBER", "nums", "2"}, ":1\r\n"},
+		{[]string{"SMISMEMBER", "nums", "2", "9"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"SADD", "nums", "x"}, ":1\r\n"},
+		{[]string{"OBJECT", "ENCODING", "nums"}, "$9\r\nhashtable\r\n"},
+		{[]string{"SREM", "nums", "x", "y"}, ":1\r\n"},
+		{[]string{"SCARD", "nums"}, ":3\r\n"},
+		{[]string{"SADD", "other", "3", "4"}, ":2\r\n"},
+		{[]string{"SINTER", "nums", "other"}, "*1\r\n$1\r\n3\r\n"},
+		{[]string{"SINTERCARD", "2", "nums", "other", "LIMIT", "1"}, ":1\r\n"},
+		{[]string{"SDIFFSTORE", "diff", "other", "nums"}, ":1\r\n"},
+		{[]string{"SMEMBERS", "diff"}, "*1\r\n$1\r\n4\r\n"},
+		{[]string{"SMOVE", "diff", "nums", "4"}, ":1\r\n"},
+		{[]string{"SCARD", "diff"}, ":0\r\n"},
+		{[]string{"SSCAN", "other", "0", "COUNT", "1"}, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\n3\r\n$1\r\n4\r\n"},
+		{[]string{"SPOP", "diff"}, "$-1\r\n"},
+		{[]string{"CONFIG", "SET", "set-max-intset-entries", "1"}, "+OK\r\n"},
+		{[]string{"CONFIG", "GET", "set-max-*"}, "*2\r\n$22\r\nset-max-intset-entries\r\n$1\r\n1\r\n"},
+		{[]string{"SADD", "small", "1", "2"}, ":2\r\n"},
+		{[]string{"OBJECT", "ENCODING", "small"}, "$9\r\nhashtable\r\n"},
+		{[]string{"CONFIG", "SET", "set-max-intset-entries", "512"}, "+OK\r\n"},
+	})
+}
+
+// step is a single command sent to the client and the exact reply expected
+type step struct {
+	args     []string
+	expected string
+}
+
+// TestZSetCommands tests the sorted set commands
+func TestZSetCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"ZADD", "z", "1", "a", "2", "b", "3", "c"}, ":3\r\n"},
+		{[]string{"ZADD", "z", "NX", "XX", "1", "a"}, "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{[]string{"ZADD", "z", "GT", "CH", "0", "a", "5", "b"}, ":1\r\n"},
+		{[]string{"ZADD", "z", "INCR", "1", "a", "2", "b"}, "-ERR INCR option supports a single increment-element pair\r\n"},
+		{[]string{"ZINCRBY", "z", "0.5", "a"}, "$3\r\n1.5\r\n"},
+		{[]string{"ZRANGE", "z", "0", "-1", "WITHSCORES"}, "*6\r\n$1\r\na\r\n$3\r\n1.5\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nb\r\n$1\r\n5\r\n"},
+		{[]string{"ZRANGEBYSCORE", "z", "(1.5", "+inf", "LIMIT", "1", "1"}, "*1\r\n$1\r\nb\r\n"},
+		{[]string{"ZREVRANGE", "z", "0", "0"}, "*1\r\n$1\r\nb\r\n"},
+		{[]string{"ZRANK", "z", "c", "WITHSCORE"}, "*2\r\n:1\r\n$1\r\n3\r\n"},
+		{[]string{"ZREVRANK", "z", "x"}, "$-1\r\n"},
+		{[]string{"ZMSCORE", "z", "a", "x"}, "*2\r\n$3\r\n1.5\r\n$-1\r\n"},
+		{[]string{"ZCOUNT", "z", "2", "(5"}, ":1\r\n"},
+		{[]string{"ZCARD", "z"}, ":3\r\n"},
+		{[]string{"OBJECT", "ENCODING", "z"}, "$8\r\nskiplist\r\n"},
+		{[]string{"ZADD", "w", "1", "a", "1", "d"}, ":2\r\n"},
+		{[]string{"ZUNIONSTORE", "u", "2", "z", "w", "WEIGHTS", "2", "1", "AGGREGATE", "MAX"}, ":4\r\n"},
+		{[]string{"ZSCORE", "u", "a"}, "$1\r\n3\r\n"},
+		{[]string{"ZINTER", "2", "z", "w", "WITHSCORES"}, "*2\r\n$1\r\na\r\n$3\r\n2.5\r\n"},
+		{[]string{"ZPOPMIN", "u", "2"}, "*4\r\n$1\r\nd\r\n$1\r\n1\r\n$1\r\na\r\n$1\r\n3\r\n"},
+		{[]string{"ZREMRANGEBYRANK", "u", "0", "0"}, ":1\r\n"},
+		{[]string{"ZLEXCOUNT", "w", "-", "+"}, ":2\r\n"},
+		{[]string{"ZRANGESTORE", "dst", "z", "0", "1"}, ":2\r\n"},
+		{[]string{"ZREMRANGEBYSCORE", "dst", "-inf", "+inf"}, ":2\r\n"},
+		{[]string{"ZCARD", "dst"}, ":0\r\n"},
+		{[]string{"SADD", "s", "a"}, ":1\r\n"},
+		{[]string{"ZADD", "s", "1", "a"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+func TestStreamCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"XADD", "events", "1-1", "a", "1"}, "$3\r\n1-1\r\n"},
+		{[]string{"XADD", "events", "1-*", "b", "2"}, "$3\r\n1-2\r\n"},
+		{[]string{"XADD", "events", "2", "c", "3"}, "$3\r\n2-0\r\n"},
+		{[]string{"XADD", "events", "1-5", "d", "4"}, "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{[]string{"XADD", "events", "3-0", "odd"}, "-ERR wrong number of arguments for 'XADD' command\r\n"},
+		{[]string{"XADD", "missing", "NOMKSTREAM", "*", "a", "1"}, "$-1\r\n"},
+		{[]string{"XLEN", "events"}, ":3\r\n"},
+		{[]string{"XRANGE", "events", "-", "+", "COUNT", "1"}, "*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{[]string{"XRANGE", "events", "(1-1", "1"}, "*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{[]string{"XREVRANGE", "events", "+", "(1-2"}, "*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{[]string{"XRANGE", "events", "x", "+"}, "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{[]string{"XDEL", "events", "1-2", "9-9"}, ":1\r\n"},
+		{[]string{"XTRIM", "events", "MAXLEN", "1", "LIMIT", "5"}, "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n"},
+		{[]string{"XTRIM", "events", "MAXLEN", "=", "1"}, ":1\r\n"},
+		{[]string{"XADD", "events", "MINID", "3", "3-0", "e", "5"}, "$3\r\n3-0\r\n"},
+		{[]string{"XLEN", "events"}, ":1\r\n"},
+		{[]string{"OBJECT", "ENCODING", "events"}, "$6\r\nstream\r\n"},
+		{[]string{"XINFO", "STREAM", "events"}, "*20\r\n$6\r\nlength\r\n:1\r\n$15\r\nradix-tree-keys\r\n:1\r\n$16\r\nradix-tree-nodes\r\n:1\r\n" +
+			"$17\r\nlast-generated-id\r\n$3\r\n3-0\r\n$20\r\nmax-deleted-entry-id\r\n$3\r\n1-2\r\n$13\r\nentries-added\r\n:4\r\n" +
+			"$23\r\nrecorded-first-entry-id\r\n$3\r\n3-0\r\n$6\r\ngroups\r\n:0\r\n" +
+			"$11\r\nfirst-entry\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\ne\r\n$1\r\n5\r\n$10\r\nlast-entry\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\ne\r\n$1\r\n5\r\n"},
+		{[]string{"XINFO", "STREAM", "missing"}, "-ERR no such key\r\n"},
+	})
+}
+
+func TestStreamGroupCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	entry := func(id, value string) string {
+		return "*2\r\n$3\r\n" + id + "\r\n*2\r\n$1\r\nn\r\n$1\r\n" + value + "\r\n"
+	}
+	runSteps(t, client, mockConn, []step{
+		{[]string{"XGROUP", "CREATE", "jobs", "workers", "$"}, "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n"},
+		{[]string{"XGROUP", "CREATE", "jobs", "workers", "$", "MKSTREAM"}, "+OK\r\n"},
+		{[]string{"XGROUP", "CREATE", "jobs", "workers", "0"}, "-BUSYGROUP Consumer Group name already exists\r\n"},
+		{[]string{"XADD", "jobs", "1-0", "n", "1"}, "$3\r\n1-0\r\n"},
+		{[]string{"XADD", "jobs", "2-0", "n", "2"}, "$3\r\n2-0\r\n"},
+		{[]string{"XREADGROUP", "GROUP", "nobody", "w1", "STREAMS", "jobs", ">"}, "-NOGROUP No such key 'jobs' or consumer group 'nobody' in XREADGROUP with GROUP option\r\n"},
+		{[]string{"XREADGROUP", "GROUP", "workers", "w1", "COUNT", "1", "STREAMS", "jobs", ">"}, "*1\r\n*2\r\n$4\r\njobs\r\n*1\r\n" + entry("1-0", "1")},
+		{[]string{"XREADGROUP", "GROUP", "workers", "w2", "STREAMS", "jobs", ">"}, "*1\r\n*2\r\n$4\r\njobs\r\n*1\r\n" + entry("2-0", "2")},
+		{[]string{"XREADGROUP", "GROUP", "workers", "w2", "STREAMS", "jobs", ">"}, "*-1\r\n"},
+		{[]string{"XREADGROUP", "GROUP", "workers", "w1", "STREAMS", "jobs", "0"}, "*1\r\n*2\r\n$4\r\njobs\r\n*1\r\n" + entry("1-0", "1")},
+		{[]string{"XPENDING", "jobs", "workers"}, "*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n2-0\r\n*2\r\n*2\r\n$2\r\nw1\r\n$1\r\n1\r\n*2\r\n$2\r\nw2\r\n$1\r\n1\r\n"},
+		{[]string{"XACK", "jobs", "workers", "2-0", "3-0"}, ":1\r\n"},
+		{[]string{"XCLAIM", "jobs", "workers", "w2", "0", "1-0", "JUSTID"}, "*1\r\n$3\r\n1-0\r\n"},
+		{[]string{"XCLAIM", "jobs", "workers", "w2", "0", "1-0", "BOGUS"}, "-ERR Unrecognized XCLAIM option 'BOGUS'\r\n"},
+		{[]string{"XAUTOCLAIM", "jobs", "workers", "w3", "0", "0", "COUNT", "5", "JUSTID"}, "*3\r\n$3\r\n0-0\r\n*1\r\n$3\r\n1-0\r\n*0\r\n"},
+		{[]string{"XINFO", "GROUPS", "jobs"}, "*1\r\n*12\r\n$4\r\nname\r\n$7\r\nworkers\r\n$9\r\nconsumers\r\n:3\r\n$7\r\npending\r\n:1\r\n" +
+			"$17\r\nlast-delivered-id\r\n$3\r\n2-0\r\n$12\r\nentries-read\r\n:2\r\n$3\r\nlag\r\n:0\r\n"},
+		{[]string{"XGROUP", "DELCONSUMER", "jobs", "workers", "w3"}, ":1\r\n"},
+		{[]string{"XGROUP", "CREATECONSUMER", "jobs", "workers", "w1"}, ":0\r\n"},
+		{[]string{"XGROUP", "SETID", "jobs", "workers", "0"}, "+OK\r\n"},
+		{[]string{"XINFO", "STREAM", "jobs"}, "*20\r\n$6\r\nlength\r\n:2\r\n$15\r\nradix-tree-keys\r\n:1\r\n$16\r\nradix-tree-nodes\r\n:1\r\n" +
+			"$17\r\nlast-generated-id\r\n$3\r\n2-0\r\n$20\r\nmax-deleted-entry-id\r\n$3\r\n0-0\r\n$13\r\nentries-added\r\n:2\r\n" +
+			"$23\r\nrecorded-first-entry-id\r\n$3\r\n1-0\r\n$6\r\ngroups\r\n:1\r\n" +
+			"$11\r\nfirst-entry\r\n" + entry("1-0", "1") + "$10\r\nlast-entry\r\n" + entry("2-0", "2")},
+		{[]string{"XGROUP", "DESTROY", "jobs", "workers"}, ":1\r\n"},
+	})
+}
+
+// TestBlockingCommands tests that blocked clients are served in FIFO order
+// by writes from other clients, time out, and can be unblocked.
+func TestBlockingCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"RPUSH", "bq", "a"}, ":1\r\n"},
+		{[]string{"BLPOP", "bq", "0"}, "*2\r\n$2\r\nbq\r\n$1\r\na\r\n"},
+		{[]string{"BLPOP", "bq", "0.01"}, "*-1\r\n"},
+		{[]string{"BLPOP", "bq", "-1"}, "-ERR timeout is negative\r\n"},
+		{[]string{"BLPOP", "bq", "x"}, "-ERR timeout is not a float or out of range\r\n"},
+		{[]string{"BRPOPLPUSH", "bq", "bq2", "0.01"}, "$-1\r\n"},
+		{[]string{"BZPOPMIN", "bz", "0.01"}, "*-1\r\n"},
+	})
+
+	first, firstOut := startBlocked(t, "BLPOP", "bq", "bq3", "0")
+	second, secondOut := startBlocked(t, "BRPOP", "bq", "0")
+	runSteps(t, client, mockConn, []step{
+		{[]string{"RPUSH", "bq", "x", "y"}, ":2\r\n"},
+	})
+	if got := awaitReply(t, firstOut); got != "*2\r\n$2\r\nbq\r\n$1\r\nx\r\n" {
+		t.Errorf("First waiter got %q", got)
+	}
+	if got := awaitReply(t, secondOut); got != "*2\r\n$2\r\nbq\r\n$1\r\ny\r\n" {
+		t.Errorf("Second waiter got %q", got)
+	}
+	if first.id == second.id {
+		t.Errorf("Clients share ID %d", first.id)
+	}
+
+	_, moverOut := startBlocked(t, "BLMOVE", "bq4", "bq5", "LEFT", "RIGHT", "0")
+	_, popperOut := startBlocked(t, "BLPOP", "bq5", "0")
+	runSteps(t, client, mockConn, []step{
+		{[]string{"LPUSH", "bq4", "m"}, ":1\r\n"},
+	})
+	if got := awaitReply(t, moverOut); got != "$1\r\nm\r\n" {
+		t.Errorf("BLMOVE got %q", got)
+	}
+	if got := awaitReply(t, popperOut); got != "*2\r\n$3\r\nbq5\r\n$1\r\nm\r\n" {
+		t.Errorf("BLPOP on the BLMOVE destination got %q", got)
+	}
+
+	_, zpopperOut := startBlocked(t, "BZPOPMAX", "bz", "0")
+	runSteps(t, client, mockConn, []step{
+		{[]string{"ZADD", "bz", "1", "a", "2", "b"}, ":2\r\n"},
+	})
+	if got := awaitReply(t, zpopperOut); got != "*3\r\n$2\r\nbz\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+		t.Errorf("BZPOPMAX got %q", got)
+	}
+
+	timedOut, timedOutOut := startBlocked(t, "BLPOP", "bq6", "0")
+	errored, erroredOut := startBlocked(t, "BLPOP", "bq6", "0")
+	id := func(c *Client) string { return strconv.FormatInt(c.id, 10) }
+	runSteps(t, client, mockConn, []step{
+		{[]string{"CLIENT", "UNBLOCK", id(timedOut)}, ":1\r\n"},
+		{[]string{"CLIENT", "UNBLOCK", id(errored), "ERROR"}, ":1\r\n"},
+		{[]string{"CLIENT", "UNBLOCK", id(errored)}, ":0\r\n"},
+		{[]string{"CLIENT", "UNBLOCK", id(errored), "NOW"}, "-ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR\r\n"},
+		{[]string{"CLIENT", "ID"}, ":" + id(client) + "\r\n"},
+	})
+	if got := awaitReply(t, timedOutOut); got != "*-1\r\n" {
+		t.Errorf("CLIENT UNBLOCK TIMEOUT got %q", got)
+	}
+	if got := awaitReply(t, erroredOut); got != "-UNBLOCKED client unblocked via CLIENT UNBLOCK\r\n" {
+		t.Errorf("CLIENT UNBLOCK ERROR got %q", got)
+	}
+}
+
+// TestBlockedDisconnect tests that a client whose connection is closed while
+// it is blocked stops waiting, rather than being served the data pushed
+// afterwards
+func TestBlockedDisconnect(t *testing.T) {
+	conn, remote := net.Pipe()
+	blocked := NewClient(conn)
+	blocked.datastore = datastore.GetDataStore()
+	handled := make(chan struct{})
+	go func() {
+		blocked.Handle()
+		close(handled)
+	}()
+	go remote.Write([]byte(protocol.FormatCommand([]string{"BLPOP", "dq", "0"})))
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		blocking.mu.Lock()
+		_, found := blocking.clients[blocked.id]
+		blocking.mu.Unlock()
+		if found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("BLPOP did not block")
+		}
+	}
+	remote.Close()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the client to stop waiting once disconnected")
+	}
+
+	client, mockConn := createMockClient()
+	runSteps(t, client, mockConn, []step{
+		{[]string{"RPUSH", "dq", "x"}, ":1\r\n"},
+		{[]string{"LPOP", "dq"}, "$1\r\nx\r\n"},
+	})
+}
+
+// TestBlockingStreamReads tests XREAD and XREADGROUP with BLOCK
+func TestBlockingStreamReads(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"XADD", "bs", "1-1", "f", "v"}, "$3\r\n1-1\r\n"},
+		{[]string{"XREAD", "STREAMS", "bs", "0"}, "*1\r\n*2\r\n$2\r\nbs\r\n*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{[]string{"XREAD", "STREAMS", "bs", "1-1"}, "*-1\r\n"},
+		{[]string{"XREAD", "BLOCK", "10", "STREAMS", "bs", "$"}, "*-1\r\n"},
+		{[]string{"XREAD", "STREAMS", "bs", "bs2", "0"}, "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n"},
+		{[]string{"XGROUP", "CREATE", "bs", "g", "$"}, "+OK\r\n"},
+	})
+
+	_, readerOut := startBlocked(t, "XREAD", "BLOCK", "0", "STREAMS", "bs", "bs2", "$", "$")
+	_, groupOut := startBlocked(t, "XREADGROUP", "GROUP", "g", "c", "BLOCK", "0", "STREAMS", "bs", ">")
+	runSteps(t, client, mockConn, []step{
+		{[]string{"XADD", "bs", "2-1", "f", "w"}, "$3\r\n2-1\r\n"},
+		{[]string{"XPENDING", "bs", "g"}, "*4\r\n:1\r\n$3\r\n2-1\r\n$3\r\n2-1\r\n*1\r\n*2\r\n$1\r\nc\r\n$1\r\n1\r\n"},
+	})
+	entry := "*1\r\n*2\r\n$2\r\nbs\r\n*1\r\n*2\r\n$3\r\n2-1\r\n*2\r\n$1\r\nf\r\n$1\r\nw\r\n"
+	if got := awaitReply(t, readerOut); got != entry {
+		t.Errorf("XREAD BLOCK got %q", got)
+	}
+	if got := awaitReply(t, groupOut); got != entry {
+		t.Errorf("XREADGROUP BLOCK got %q", got)
+	}
+}
+
+// awaitReply returns the reply of a client started by startBlocked, failing
+// the test if it is still blocked after a second.
+func awaitReply(t *testing.T, out <-chan string) string {
+	t.Helper()
+	select {
+	case reply := <-out:
+		return reply
+	case <-time.After(time.Second):
+		t.Fatal("Client is still blocked")
+		return ""
+	}
+}
+
+// TestPubSubCommands tests subscriber mode and message delivery
+func TestPubSubCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+	sub, subConn := createMockClient()
+
+	runSteps(t, sub, subConn, []step{
+		{[]string{"UNSUBSCRIBE"}, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n"},
+		{[]string{"SUBSCRIBE", "news", "sport"}, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n"},
+		{[]string{"PSUBSCRIBE", "n*"}, "*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:3\r\n"},
+		{[]string{"GET", "news"}, "-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context\r\n"},
+		{[]string{"PING"}, "*2\r\n$4\r\npong\r\n$0\r\n\r\n"},
+	})
+	subConn.writeBuffer.Reset()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"PUBLISH", "news", "hello"}, ":2\r\n"},
+		{[]string{"PUBLISH", "weather", "sunny"}, ":0\r\n"},
+		{[]string{"PUBSUB", "CHANNELS"}, "*2\r\n$4\r\nnews\r\n$5\r\nsport\r\n"},
+		{[]string{"PUBSUB", "CHANNELS", "s*"}, "*1\r\n$5\r\nsport\r\n"},
+		{[]string{"PUBSUB", "NUMSUB", "news", "weather"}, "*4\r\n$4\r\nnews\r\n:1\r\n$7\r\nweather\r\n:0\r\n"},
+		{[]string{"PUBSUB", "NUMPAT"}, ":1\r\n"},
+		{[]string{"PING"}, "+PONG\r\n"},
+	})
+	awaitOutput(t, sub, subConn, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"+
+		"*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+
+	runSteps(t, sub, subConn, []step{
+		{[]string{"UNSUBSCRIBE"}, "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:2\r\n*3\r\n$11\r\nunsubscribe\r\n$5\r\nsport\r\n:1\r\n"},
+		{[]string{"PUNSUBSCRIBE", "n*"}, "*3\r\n$12\r\npunsubscribe\r\n$2\r\nn*\r\n:0\r\n"},
+		{[]string{"PING"}, "+PONG\r\n"},
+	})
+	runSteps(t, client, mockConn, []step{
+		{[]string{"PUBLISH", "news", "bye"}, ":0\r\n"},
+		{[]string{"PUBSUB", "NUMPAT"}, ":0\r\n"},
+	})
+}
+
+// TestTransactions tests MULTI, EXEC, DISCARD, WATCH and UNWATCH
+func TestTransactions(t *testing.T) {
+	client, mockConn := createMockClient()
+	other, otherConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"EXEC"}, "-ERR EXEC without MULTI\r\n"},
+		{[]string{"DISCARD"}, "-ERR DISCARD without MULTI\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"MULTI"}, "-ERR MULTI calls can not be nested\r\n"},
+		{[]string{"RPUSH", "txlist", "a", "b"}, "+QUEUED\r\n"},
+		{[]string{"LPOP", "txlist"}, "+QUEUED\r\n"},
+		{[]string{"BLPOP", "txempty", "0"}, "+QUEUED\r\n"},
+		{[]string{"SADD", "txlist", "x"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*4\r\n:2\r\n$1\r\na\r\n*-1\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"LPOP", "txlist"}, "+QUEUED\r\n"},
+		{[]string{"DISCARD"}, "+OK\r\n"},
+		{[]string{"LRANGE", "txlist", "0", "-1"}, "*1\r\n$1\r\nb\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"LPOP", "txlist"}, "+QUEUED\r\n"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'GET' command\r\n"},
+		{[]string{"NOSUCHCMD"}, "-ERR unknown command 'NOSUCHCMD'\r\n"},
+		{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{[]string{"LLEN", "txlist"}, ":1\r\n"},
+
+		{[]string{"WATCH", "txlist", "txother"}, "+OK\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"WATCH", "txlist"}, "-ERR WATCH inside MULTI is not allowed\r\n"},
+		{[]string{"LPOP", "txlist"}, "+QUEUED\r\n"},
+	})
+	runSteps(t, other, otherConn, []step{
+		{[]string{"RPUSH", "txlist", "c"}, ":2\r\n"},
+	})
+	runSteps(t, client, mockConn, []step{
+		{[]string{"EXEC"}, "*-1\r\n"},
+		{[]string{"WATCH", "txlist"}, "+OK\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"LPOP", "txlist"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*1\r\n$1\r\nb\r\n"},
+		{[]string{"WATCH", "txlist"}, "+OK\r\n"},
+		{[]string{"UNWATCH"}, "+OK\r\n"},
+	})
+	runSteps(t, other, otherConn, []step{
+		{[]string{"RPUSH", "txlist", "d"}, ":2\r\n"},
+	})
+	runSteps(t, client, mockConn, []step{
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"LPOP", "txlist"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*1\r\n$1\r\nc\r\n"},
+	})
+}
+
+// TestWatchExpiredKey tests that a watched key expiring makes EXEC fail
+func TestWatchExpiredKey(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SET", "txexpiring", "v"}, "+OK\r\n"},
+		{[]string{"PEXPIRE", "txexpiring", "10"}, ":1\r\n"},
+		{[]string{"WATCH", "txexpiring"}, "+OK\r\n"},
+	})
+	time.Sleep(20 * time.Millisecond)
+	runSteps(t, client, mockConn, []step{
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"SET", "txexpiring", "w"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*-1\r\n"},
+	})
+}
+
+// awaitOutput waits until the client has written expected, failing the test
+// if it has not after a second.
+func awaitOutput(t *testing.T, client *Client, mockConn *MockConn, expected string) {
+	t.Helper()
+	var output string
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		client.writeMu.Lock()
+		output = mockConn.GetOutput()
+		client.writeMu.Unlock()
+		if output == expected {
+			return
+		}
+	}
+	t.Errorf("Expected %q, got %q", expected, output)
+}
+
+// startBlocked runs a blocking command on a new client in the background and
+// returns once the client is blocked. The reply is sent on the returned
+// channel.
+func startBlocked(t *testing.T, args ...string) (*Client, <-chan string) {
+	t.Helper()
+	client, mockConn := createMockClient()
+	out := make(chan string, 1)
+	mockConn.SimulateInput(protocol.FormatCommand(args))
+	go func() {
+		client.HandleOnce()
+		out <- mockConn.GetOutput()
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		blocking.mu.Lock()
+		_, blocked := blocking.clients[client.id]
+		blocking.mu.Unlock()
+		if blocked {
+			return client, out
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q did not block", args)
+		}
+	}
+}
+
+// runSteps sends each step's command to the client and checks the reply
+func runSteps(t *testing.T, client *Client, mockConn *MockConn, steps []step) {
+	t.Helper()
+	for _, s := range steps {
+		mockConn.writeBuffer.Reset()
+		mockConn.SimulateInput(protocol.FormatCommand(s.args))
+		client.HandleOnce()
+		if mockConn.GetOutput() != s.expected {
+			t.Errorf("For %q expected %q, got %q", s.args, s.expected, mockConn.GetOutput())
+		}
+	}
+}
+
 // Helper function to create a mock client with in-memory connection
 func createMockClient() (*Client, *MockConn) {
 	mockConn := NewMockConn()
@@ -121,17 +1327,575 @@ func (c *Client) HandleOnce() {
 		protocol.WriteError(c.conn, "ERR empty command")
 		return
 	}
-	cmd := args[0]
-	switch cmd {
-	case "PING":
-		c.ping(args)
-	case "ECHO":
-		c.echo(args)
-	case "SET":
-		c.set(args)
-	case "GET":
-		c.get(args)
-	default:
-		protocol.WriteError(c.conn, "ERR unknown command '"+cmd+"'")
-	}
-}
\ No newline at end of file
+	c.process(args)
+}
+
+// TestScriptCommands tests EVAL, EVALSHA and the script cache
+func TestScriptCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+	sha := sha1hex("return redis.call('GET', KEYS[1])")
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"EVAL", "return 1", "0"}, ":1\r\n"},
+		{[]string{"EVAL", "return {KEYS[1], ARGV[1], 3.9, true, false}", "1", "k", "a"}, "*5\r\n$1\r\nk\r\n$1\r\na\r\n:3\r\n:1\r\n$-1\r\n"},
+		{[]string{"EVAL", "return {1, 2, nil, 4}", "0"}, "*2\r\n:1\r\n:2\r\n"},
+		{[]string{"EVAL", "return {math.floor(2^70), -2^63, 0/0, -2^64}", "0"}, "*4\r\n$19\r\n1.1805916207174e+21\r\n:-9223372036854775808\r\n:-9223372036854775808\r\n$19\r\n-1.844674407371e+19\r\n"},
+		{[]string{"EVAL", "return {pcall(function() error('boom') end)}", "0"}, "*2\r\n$-1\r\n$19\r\nuser_script:1: boom\r\n"},
+		{[]string{"EVAL", "return select(2, pcall(function() error('boom') end))", "0"}, "$19\r\nuser_script:1: boom\r\n"},
+		{[]string{"EVAL", "return cjson.encode(cjson.decode(ARGV[1]))", "0", `{"a":[1,2]}`}, "$11\r\n{\"a\":[1,2]}\r\n"},
+		{[]string{"EVAL", "return bit.tohex(bit.bor(0xf0, 0x0f))", "0"}, "$8\r\n000000ff\r\n"},
+		{[]string{"EVAL", "return setmetatable({}, {__index = function(t, k) return k end}).hello", "0"}, "$5\r\nhello\r\n"},
+		{[]string{"EVAL", "return redis.call('SET', KEYS[1], ARGV[1])", "1", "evalkey", "v"}, "+OK\r\n"},
+		{[]string{"EVAL", "return redis.call('GET', KEYS[1])", "1", "evalkey"}, "$1\r\nv\r\n"},
+		{[]string{"EVAL", "return redis.call('GET', 'evalmissing')", "0"}, "$-1\r\n"},
+		{[]string{"EVAL", "redis.call('RPUSH', 'evallist', 'a', 'b') return redis.call('LRANGE', 'evallist', 0, -1)", "0"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"EVAL", "return redis.call('LPUSH', 'evalkey', 'x')", "0"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"EVAL", "return redis.pcall('LPUSH', 'evalkey', 'x')['err']", "0"}, "$65\r\nWRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"EVAL", "return redis.call('NOSUCHCMD')", "0"}, "-ERR Unknown Redis command called from script\r\n"},
+		{[]string{"EVAL", "return redis.call('MULTI')", "0"}, "-ERR This Redis command is not allowed from script\r\n"},
+		{[]string{"EVAL", "return redis.call('GET')", "0"}, "-ERR Wrong number of args calling Redis command from script\r\n"},
+		{[]string{"EVAL", "return redis.status_reply('FINE')", "0"}, "+FINE\r\n"},
+		{[]string{"EVAL", "return redis.error_reply('MY failure')", "0"}, "-MY failure\r\n"},
+		{[]string{"EVAL", "return x", "0"}, "-ERR user_script:1: Script attempted to access nonexistent global variable 'x'\r\n"},
+		{[]string{"EVAL", "x = 1", "0"}, "-ERR user_script:1: Script attempted to create global variable 'x'\r\n"},
+		{[]string{"EVAL", "error('boom')", "0"}, "-ERR user_script:1: boom\r\n"},
+		{[]string{"EVAL", "return (", "0"}, "-ERR Error compiling script (new function): user_script:1: unexpected symbol near <eof>\r\n"},
+		{[]string{"EVAL", "return 1", "2", "a"}, "-ERR Number of keys can't be greater than number of args\r\n"},
+		{[]string{"EVAL", "return 1", "-1"}, "-ERR Number of keys can't be negative\r\n"},
+
+		{[]string{"SCRIPT", "FLUSH"}, "+OK\r\n"},
+		{[]string{"EVALSHA", sha, "1", "evalkey"}, "-NOSCRIPT No matching script. Please use EVAL.\r\n"},
+		{[]string{"SCRIPT", "LOAD", "return redis.call('GET', KEYS[1])"}, "$40\r\n" + sha + "\r\n"},
+		{[]string{"SCRIPT", "EXISTS", sha, "ffff"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"EVALSHA", strings.ToUpper(sha), "1", "evalkey"}, "$1\r\nv\r\n"},
+		{[]string{"SCRIPT", "KILL"}, "-NOTBUSY No scripts in execution right now.\r\n"},
+
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"EVAL", "return redis.call('INCRNOPE')", "0"}, "+QUEUED\r\n"},
+		{[]string{"EVALSHA", sha, "1", "evalkey"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*2\r\n-ERR Unknown Redis command called from script\r\n$1\r\nv\r\n"},
+	})
+}
+
+// TestScriptKill tests that other clients get a BUSY error while a script
+// runs past the time limit, and that SCRIPT KILL stops it
+func TestScriptKill(t *testing.T) {
+	old := scriptTimeLimit.Load()
+	defer scriptTimeLimit.Store(old)
+	scriptTimeLimit.Store(10)
+
+	client, mockConn := createMockClient()
+	other, otherConn := createMockClient()
+	mockConn.SimulateInput(protocol.FormatCommand([]string{"EVAL", "while true do end", "0"}))
+	out := make(chan string, 1)
+	go func() {
+		client.HandleOnce()
+		out <- mockConn.GetOutput()
+	}()
+	for deadline := time.Now().Add(time.Second); busyError() == ""; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Script did not start")
+		}
+	}
+
+	runSteps(t, other, otherConn, []step{
+		{[]string{"PING"}, "-" + errBusy + "\r\n"},
+		{[]string{"SCRIPT", "KILL"}, "+OK\r\n"},
+	})
+	if reply := awaitReply(t, out); reply != "-ERR "+errScriptKilled+"\r\n" {
+		t.Errorf("Expected the script to be killed, got %q", reply)
+	}
+	runSteps(t, other, otherConn, []step{
+		{[]string{"PING"}, "+PONG\r\n"},
+	})
+}
+
+// testLibrary registers a read-only and a writing function
+const testLibrary = `#!lua name=mylib
+local function get(keys, args) return redis.call('GET', keys[1]) end
+redis.register_function{function_name = 'myget', callback = get, flags = {'no-writes'}, description = 'reads a key'}
+redis.register_function('myset', function(keys, args) return redis.call('SET', keys[1], args[1]) end)`
+
+// TestFunctionCommands tests loading, calling, listing, dumping and
+// restoring function libraries
+func TestFunctionCommands(t *testing.T) {
+	client, mockConn := createMockClient()
+	readOnlyLibrary := "#!lua name=rolib\nredis.register_function{function_name = 'rowrite', callback = function(keys) return redis.call('SET', keys[1], 'x') end, flags = {'no-writes'}}"
+	runSteps(t, client, mockConn, []step{
+		{[]string{"FUNCTION", "FLUSH"}, "+OK\r\n"},
+		{[]string{"FUNCTION", "LOAD", testLibrary}, "$5\r\nmylib\r\n"},
+		{[]string{"FUNCTION", "LOAD", testLibrary}, "-ERR Library 'mylib' already exists\r\n"},
+		{[]string{"FUNCTION", "LOAD", "REPLACE", testLibrary}, "$5\r\nmylib\r\n"},
+		{[]string{"FUNCTION", "LOAD", readOnlyLibrary}, "$5\r\nrolib\r\n"},
+		{[]string{"FCALL", "myset", "1", "fnkey", "v"}, "+OK\r\n"},
+		{[]string{"FCALL_RO", "myget", "1", "fnkey"}, "$1\r\nv\r\n"},
+		{[]string{"FCALL_RO", "myset", "1", "fnkey", "w"}, "-ERR Can not execute a script with write flag using *_ro command.\r\n"},
+		{[]string{"FCALL", "rowrite", "1", "fnkey"}, "-ERR Write commands are not allowed from read-only scripts.\r\n"},
+		{[]string{"FCALL", "nope", "0"}, "-ERR Function not found\r\n"},
+		{[]string{"FCALL", "myget", "2", "fnkey"}, "-ERR Number of keys can't be greater than number of args\r\n"},
+
+		{[]string{"FUNCTION", "LOAD", "return 1"}, "-ERR Missing library metadata\r\n"},
+		{[]string{"FUNCTION", "LOAD", "#!js name=x\n"}, "-ERR Engine 'js' not found\r\n"},
+		{[]string{"FUNCTION", "LOAD", "#!lua\nredis.register_function('x', function() end)"}, "-ERR Library name was not given\r\n"},
+		{[]string{"FUNCTION", "LOAD", "#!lua name=empty\nlocal x = 1"}, "-ERR No functions registered\r\n"},
+		{[]string{"FUNCTION", "LOAD", "#!lua name=other\nredis.register_function('myget', function() end)"}, "-ERR Function myget already exists\r\n"},
+		{[]string{"FUNCTION", "LOAD", "#!lua name=bad\nredis.register_function('f', function() end, 1)"},
+			"-ERR Error registering functions: user_function:2: wrong number of arguments to redis.register_function\r\n"},
+		{[]string{"FUNCTION", "LOAD", "#!lua name=bad\nredis.call('GET', 'k')"},
+			"-ERR Error registering functions: user_function:2: attempt to call field 'call' (a nil value)\r\n"},
+
+		{[]string{"FUNCTION", "LIST", "LIBRARYNAME", "my*"}, "*1\r\n*6\r\n$12\r\nlibrary_name\r\n$5\r\nmylib\r\n$6\r\nengine\r\n$3\r\nLUA\r\n$9\r\nfunctions\r\n*2\r\n" +
+			"*6\r\n$4\r\nname\r\n$5\r\nmyget\r\n$11\r\ndescription\r\n$11\r\nreads a key\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n" +
+			"*6\r\n$4\r\nname\r\n$5\r\nmyset\r\n$11\r\ndescription\r\n$-1\r\n$5\r\nflags\r\n*0\r\n"},
+		{[]string{"FUNCTION", "STATS"}, "*4\r\n$14\r\nrunning_script\r\n$-1\r\n$7\r\nengines\r\n*2\r\n$3\r\nLUA\r\n*4\r\n" +
+			"$15\r\nlibraries_count\r\n:2\r\n$15\r\nfunctions_count\r\n:3\r\n"},
+		{[]string{"FUNCTION", "KILL"}, "-NOTBUSY No scripts in execution right now.\r\n"},
+	})
+
+	payload := dumpLibraries()
+	runSteps(t, client, mockConn, []step{
+		{[]string{"FUNCTION", "DUMP"}, "$" + strconv.Itoa(len(payload)) + "\r\n" + payload + "\r\n"},
+		{[]string{"FUNCTION", "FLUSH"}, "+OK\r\n"},
+		{[]string{"FCALL", "myget", "1", "fnkey"}, "-ERR Function not found\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload}, "+OK\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload}, "-ERR Library 'mylib' already exists\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload, "REPLACE"}, "+OK\r\n"},
+		{[]string{"FUNCTION", "RESTORE", payload[:len(payload)-1] + "x"}, "-ERR payload version or checksum are wrong\r\n"},
+		{[]string{"FUNCTION", "DELETE", "rolib"}, "+OK\r\n"},
+		{[]string{"FUNCTION", "DELETE", "rolib"}, "-ERR Library not found\r\n"},
+		{[]string{"FCALL", "rowrite", "1", "fnkey"}, "-ERR Function not found\r\n"},
+		{[]string{"FCALL_RO", "myget", "1", "fnkey"}, "$1\r\nv\r\n"},
+		{[]string{"FUNCTION", "FLUSH"}, "+OK\r\n"},
+	})
+}
+
+// TestFunctionReplay tests that the FUNCTION commands logged to the AOF
+// restore the libraries
+func TestFunctionReplay(t *testing.T) {
+	flushLibraries()
+	defer flushLibraries()
+	client := NewReplayClient()
+	if err := client.Replay([]string{"FUNCTION", "LOAD", "REPLACE", testLibrary}); err != nil {
+		t.Fatalf("Failed to replay FUNCTION LOAD: %v", err)
+	}
+	if err := client.Replay([]string{"FUNCTION", "LOAD", "REPLACE", testLibrary}); err != nil {
+		t.Fatalf("Failed to replay FUNCTION LOAD twice: %v", err)
+	}
+	if functionRegistry.functions["myget"] == nil {
+		t.Errorf("Expected myget to be registered after replay")
+	}
+	if err := client.Replay([]string{"FUNCTION", "DELETE", "mylib"}); err != nil || len(functionRegistry.libraries) != 0 {
+		t.Errorf("Expected FUNCTION DELETE to remove the library, got %v", err)
+	}
+	if err := client.Replay([]string{"FUNCTION", "LOAD"}); err == nil {
+		t.Errorf("Expected an error for an invalid FUNCTION command")
+	}
+}
+
+// TestFunctionKill tests that other clients get the BUSY error of functions
+// while one runs past the time limit, and that FUNCTION KILL stops it
+func TestFunctionKill(t *testing.T) {
+	old := scriptTimeLimit.Load()
+	defer scriptTimeLimit.Store(old)
+	scriptTimeLimit.Store(10)
+	defer flushLibraries()
+
+	client, mockConn := createMockClient()
+	other, otherConn := createMockClient()
+	runSteps(t, client, mockConn, []step{
+		{[]string{"FUNCTION", "LOAD", "#!lua name=spinlib\nredis.register_function('spin', function() while true do end end)"}, "$7\r\nspinlib\r\n"},
+	})
+	mockConn.writeBuffer.Reset()
+	mockConn.SimulateInput(protocol.FormatCommand([]string{"FCALL", "spin", "0"}))
+	out := make(chan string, 1)
+	go func() {
+		client.HandleOnce()
+		out <- mockConn.GetOutput()
+	}()
+	for deadline := time.Now().Add(time.Second); busyError() == ""; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Function did not start")
+		}
+	}
+
+	runSteps(t, other, otherConn, []step{
+		{[]string{"PING"}, "-" + errBusyFunction + "\r\n"},
+		{[]string{"SCRIPT", "KILL"}, "-" + errBusyFunction + "\r\n"},
+	})
+	otherConn.writeBuffer.Reset()
+	otherConn.SimulateInput(protocol.FormatCommand([]string{"FUNCTION", "STATS"}))
+	other.HandleOnce()
+	if stats := otherConn.GetOutput(); !strings.Contains(stats, "$4\r\nname\r\n$4\r\nspin\r\n$7\r\ncommand\r\n*3\r\n$5\r\nFCALL\r\n") {
+		t.Errorf("Expected FUNCTION STATS to describe the running function, got %q", stats)
+	}
+	runSteps(t, other, otherConn, []step{
+		{[]string{"FUNCTION", "KILL"}, "+OK\r\n"},
+	})
+	if reply := awaitReply(t, out); reply != "-ERR "+errFunctionKilled+"\r\n" {
+		t.Errorf("Expected the function to be killed, got %q", reply)
+	}
+	runSteps(t, other, otherConn, []step{
+		{[]string{"PING"}, "+PONG\r\n"},
+	})
+}
+
+// TestCommandTable tests the COMMAND command and the errors generated from
+// the command table
+func TestCommandTable(t *testing.T) {
+	client, mockConn := createMockClient()
+
+	runSteps(t, client, mockConn, []step{
+		{[]string{"COMMAND", "COUNT"}, ":" + strconv.Itoa(len(commandTable)) + "\r\n"},
+		{[]string{"COMMAND", "INFO", "get", "nosuch"}, "*2\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n" +
+			"*3\r\n+@string\r\n+@read\r\n+@fast\r\n*0\r\n*0\r\n*0\r\n$-1\r\n"},
+		{[]string{"COMMAND", "INFO", "object"}, "*1\r\n*10\r\n$6\r\nobject\r\n:-2\r\n*0\r\n:0\r\n:0\r\n:0\r\n*2\r\n+@keyspace\r\n+@slow\r\n*0\r\n*0\r\n" +
+			"*3\r\n*10\r\n$15\r\nobject|encoding\r\n:3\r\n*1\r\n+readonly\r\n:2\r\n:2\r\n:1\r\n*3\r\n+@keyspace\r\n+@read\r\n+@slow\r\n*0\r\n*0\r\n*0\r\n" +
+			"*10\r\n$15\r\nobject|idletime\r\n:3\r\n*1\r\n+readonly\r\n:2\r\n:2\r\n:1\r\n*3\r\n+@keyspace\r\n+@read\r\n+@slow\r\n*0\r\n*0\r\n*0\r\n" +
+			"*10\r\n$11\r\nobject|freq\r\n:3\r\n*1\r\n+readonly\r\n:2\r\n:2\r\n:1\r\n*3\r\n+@keyspace\r\n+@read\r\n+@slow\r\n*0\r\n*0\r\n*0\r\n"},
+		{[]string{"COMMAND", "INFO", "config|get", "object|nosuch", "get|x"}, "*3\r\n*10\r\n$10\r\nconfig|get\r\n" +
+			":-3\r\n*2\r\n+admin\r\n+noscript\r\n:0\r\n:0\r\n:0\r\n*3\r\n+@admin\r\n+@dangerous\r\n+@slow\r\n*0\r\n*0\r\n*0\r\n$-1\r\n$-1\r\n"},
+		{[]string{"COMMAND", "DOCS", "object|freq"}, "*2\r\n$11\r\nobject|freq\r\n*4\r\n$7\r\nsummary\r\n$67\r\nReturns the logarithmic access frequency counter of a Redis object.\r\n$5\r\ngroup\r\n$7\r\ngeneric\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "SET", "k", "v"}, "*1\r\n$1\r\nk\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "BLPOP", "a", "b", "0"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "ZUNIONSTORE", "dst", "2", "a", "b"}, "*3\r\n$3\r\ndst\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "XREAD", "COUNT", "1", "STREAMS", "s1", "s2", "0", "0"}, "*2\r\n$2\r\ns1\r\n$2\r\ns2\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "EVAL", "return 1", "5", "a"}, "-ERR Invalid arguments specified for command\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "PING"}, "-ERR The command has no key arguments\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "GET"}, "-ERR Invalid number of arguments specified for command\r\n"},
+		{[]string{"COMMAND", "GETKEYS", "NOSUCH", "k"}, "-ERR Invalid command specified\r\n"},
+		{[]string{"COMMAND", "DOCS", "get"}, "*2\r\n$3\r\nget\r\n*4\r\n$7\r\nsummary\r\n$34\r\nReturns the string value of a key.\r\n$5\r\ngroup\r\n$6\r\nstring\r\n"},
+		{[]string{"COMMAND", "NOSUCH"}, "-ERR unknown subcommand 'NOSUCH'. Try COMMAND HELP.\r\n"},
+		{[]string{"CONFIG", "nosuch"}, "-ERR unknown subcommand 'nosuch'. Try CONFIG HELP.\r\n"},
+		{[]string{"CONFIG", "GET"}, "-ERR wrong number of arguments for 'CONFIG|GET' command\r\n"},
+		{[]string{"CONFIG"}, "-ERR wrong number of arguments for 'CONFIG' command\r\n"},
+		{[]string{"MULTI"}, "+OK\r\n"},
+		{[]string{"XGROUP", "NOSUCH"}, "-ERR unknown subcommand 'NOSUCH'. Try XGROUP HELP.\r\n"},
+		{[]string{"EXEC"}, "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+	})
+
+	for _, spec := range commandTable {
+		specs := append([]*commandSpec{spec}, spec.subcommands...)
+		for _, s := range specs {
+			if s.handler == nil && len(s.subcommands) == 0 {
+				t.Errorf("Expected %s to have a handler", s.fullName())
+			}
+			if s.summary == "" || s.group == "" {
+				t.Errorf("Expected %s to be documented", s.fullName())
+			}
+			if s.flags&flagWrite != 0 && s.flags&flagReadonly != 0 {
+				t.Errorf("Expected %s not to be both write and readonly", s.fullName())
+			}
+		}
+	}
+}
+
+// TestReplay tests that the write commands logged to the AOF are replayed
+func TestReplay(t *testing.T) {
+	ds := datastore.GetDataStore()
+	client := NewReplayClient()
+	commands := [][]string{
+		{"SET", "replay-str", "v"},
+		{"SET", "replay-gone", "v", "PXAT", "1"},
+		{"RPUSH", "replay-list", "a", "b", "c"},
+		{"LPOP", "replay-list"},
+		{"LSET", "replay-list", "0", "B"},
+		{"SET", "replay-keepttl", "v", "PXAT", "99999999999999"},
+		{"SET", "replay-keepttl", "w", "KEEPTTL"},
+		{"SET", "replay-del", "v"},
+		{"DEL", "replay-del", "replay-nosuch"},
+		{"INCR", "replay-counter"},
+		{"INCRBY", "replay-counter", "41"},
+		{"DECRBY", "replay-counter", "2"},
+		{"DECR", "replay-counter"},
+		{"APPEND", "replay-append", "Hello"},
+		{"SETRANGE", "replay-append", "7", "!"},
+		{"MSET", "replay-m1", "a", "replay-m2", "b"},
+		{"MSETNX", "replay-m2", "x", "replay-m3", "y"},
+		{"PEXPIREAT", "replay-str", "1"},
+		{"HSET", "replay-hash", "a", "1", "b", "2"},
+		{"HINCRBY", "replay-hash", "a", "41"},
+		{"HDEL", "replay-hash", "b"},
+		{"ZADD", "replay-zset", "1", "a", "2", "b", "3", "c"},
+		{"ZREM", "replay-zset", "b"},
+		{"ZUNIONSTORE", "replay-zdst", "1", "replay-zset", "WEIGHTS", "2"},
+		{"XADD", "replay-stream", "1-1", "f", "v"},
+		{"XADD", "replay-stream", "1-2", "f", "v"},
+		{"XADD", "replay-stream", "2-0", "f", "v"},
+		{"XDEL", "replay-stream", "2-0"},
+		{"XTRIM", "replay-stream", "MINID", "1-2"},
+		{"XGROUP", "CREATE", "replay-stream", "g", "0-0", "ENTRIESREAD", "0"},
+		{"XGROUP", "CREATECONSUMER", "replay-stream", "g", "c"},
+		{"XCLAIM", "replay-stream", "g", "c", "0", "1-2", "TIME", "1000", "RETRYCOUNT", "2", "FORCE", "JUSTID"},
+		{"XGROUP", "SETID", "replay-stream", "g", "1-2", "ENTRIESREAD", "2"},
+	}
+	for _, args := range commands {
+		if err := client.Replay(args); err != nil {
+			t.Fatalf("Failed to replay %q: %v", args, err)
+		}
+	}
+
+	if _, found, _ := ds.Get("replay-str"); found {
+		t.Errorf("Expected key with a past PEXPIREAT deadline to be gone")
+	}
+	if _, found, _ := ds.Get("replay-gone"); found {
+		t.Errorf("Expected key with a past PXAT deadline to be gone")
+	}
+	if value, _, _ := ds.Get("replay-keepttl"); value != "w" || ds.ExpireTime("replay-keepttl") != 99999999999999 {
+		t.Errorf("Expected SET KEEPTTL to keep the deadline, got %q expiring at %d", value, ds.ExpireTime("replay-keepttl"))
+	}
+	if _, found, _ := ds.Get("replay-del"); found {
+		t.Errorf("Expected DEL to delete the key")
+	}
+	if value, _, _ := ds.Get("replay-counter"); value != "39" {
+		t.Errorf("Expected counter 39 after replay, got %q", value)
+	}
+	if value, _, _ := ds.Get("replay-append"); value != "Hello\x00\x00!" {
+		t.Errorf("Unexpected string after replay: %q", value)
+	}
+	if value, _, _ := ds.Get("replay-m2"); value != "b" {
+		t.Errorf("Expected MSETNX to leave existing keys alone, got %q", value)
+	}
+	if _, found, _ := ds.Get("replay-m3"); found {
+		t.Errorf("Expected MSETNX not to set keys when one exists")
+	}
+	values, _ := ds.LRange("replay-list", 0, -1)
+	if len(values) != 2 || values[0] != "B" || values[1] != "c" {
+		t.Errorf("Unexpected list after replay: %v", values)
+	}
+	pairs, _ := ds.HGetAll("replay-hash")
+	if len(pairs) != 2 || pairs[0] != "a" || pairs[1] != "42" {
+		t.Errorf("Unexpected hash after replay: %v", pairs)
+	}
+	members, _ := ds.ZRange("replay-zdst", datastore.ZRangeSpec{Start: 0, Stop: -1, Count: -1})
+	if len(members) != 2 || members[0] != (datastore.ZMember{Member: "a", Score: 2}) || members[1].Score != 6 {
+		t.Errorf("Unexpected sorted set after replay: %v", members)
+	}
+	entries, _ := ds.XRange("replay-stream", datastore.StreamID{}, datastore.MaxStreamID, -1, false)
+	if len(entries) != 1 || entries[0].ID != (datastore.StreamID{Ms: 1, Seq: 2}) {
+		t.Errorf("Unexpected stream after replay: %v", entries)
+	}
+	pending, _ := ds.XPending("replay-stream", "g", datastore.StreamID{}, datastore.MaxStreamID, 10, "", 0)
+	if len(pending) != 1 || pending[0].Consumer != "c" || pending[0].DeliveryTime != 1000 || pending[0].DeliveryCount != 2 {
+		t.Errorf("Unexpected pending entries after replay: %+v", pending)
+	}
+}
+
+// TestRestartExpired tests that the keys and hash fields that expired before
+// a restart stay expired, rather than being recreated without a deadline by
+// the commands logged while they were live
+func TestRestartExpired(t *testing.T) {
+	handler, err := aof.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open the AOF: %v", err)
+	}
+	client, mockConn := createMockClient()
+	client.aof = handler
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SET", "restart:str", "5", "PX", "100"}, "+OK\r\n"},
+		{[]string{"INCR", "restart:str"}, ":6\r\n"},
+		{[]string{"RPUSH", "restart:list", "a"}, ":1\r\n"},
+		{[]string{"PEXPIRE", "restart:list", "100"}, ":1\r\n"},
+		{[]string{"RPUSH", "restart:list", "b"}, ":2\r\n"},
+		{[]string{"HSET", "restart:hash", "f", "1", "g", "1"}, ":2\r\n"},
+		{[]string{"HPEXPIRE", "restart:hash", "100", "FIELDS", "1", "f"}, "*1\r\n:1\r\n"},
+		{[]string{"HINCRBY", "restart:hash", "f", "1"}, ":2\r\n"},
+	})
+	time.Sleep(150 * time.Millisecond)
+	datastore.FlushAll(false)
+	defer datastore.OnExpired(nil)
+	if err := LoadAOF(handler); err != nil {
+		t.Fatalf("Failed to replay the AOF: %v", err)
+	}
+	runSteps(t, client, mockConn, []step{
+		{[]string{"GET", "restart:str"}, "$-1\r\n"},
+		{[]string{"TTL", "restart:str"}, ":-2\r\n"},
+		{[]string{"EXISTS", "restart:list"}, ":0\r\n"},
+		{[]string{"HMGET", "restart:hash", "f", "g"}, "*2\r\n$-1\r\n$1\r\n1\r\n"},
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+	})
+}
+
+// TestRestartAfterExpiry tests that the keys and hash fields that expired
+// are logged as deleted, so that the commands run after they did replay
+// against the same keys
+func TestRestartAfterExpiry(t *testing.T) {
+	handler, err := aof.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open the AOF: %v", err)
+	}
+	defer datastore.OnExpired(nil)
+	if err := LoadAOF(handler); err != nil {
+		t.Fatalf("Failed to replay the AOF: %v", err)
+	}
+	client, mockConn := createMockClient()
+	client.aof = handler
+	runSteps(t, client, mockConn, []step{
+		{[]string{"SET", "expired:str", "v", "PX", "50"}, "+OK\r\n"},
+		{[]string{"SET", "expired:int", "5", "PX", "50"}, "+OK\r\n"},
+		{[]string{"HSET", "expired:hash", "f", "1", "g", "1"}, ":2\r\n"},
+		{[]string{"HPEXPIRE", "expired:hash", "50", "FIELDS", "1", "f"}, "*1\r\n:1\r\n"},
+		{[]string{"SET", "expired:past", "v", "PXAT", "1"}, "+OK\r\n"},
+		{[]string{"RPUSH", "expired:past", "a"}, ":1\r\n"},
+		{[]string{"SET", "expired:getex", "v"}, "+OK\r\n"},
+		{[]string{"GETEX", "expired:getex", "PXAT", "1"}, "$1\r\nv\r\n"},
+		{[]string{"SADD", "expired:getex", "a"}, ":1\r\n"},
+		{[]string{"SET", "expired:expire", "v"}, "+OK\r\n"},
+		{[]string{"PEXPIREAT", "expired:expire", "1"}, ":1\r\n"},
+		{[]string{"INCR", "expired:expire"}, ":1\r\n"},
+	})
+	time.Sleep(100 * time.Millisecond)
+	runSteps(t, client, mockConn, []step{
+		{[]string{"RPUSH", "expired:str", "a"}, ":1\r\n"},
+		{[]string{"INCR", "expired:int"}, ":1\r\n"},
+		{[]string{"HINCRBY", "expired:hash", "f", "5"}, ":5\r\n"},
+	})
+
+	datastore.FlushAll(false)
+	if err := LoadAOF(handler); err != nil {
+		t.Fatalf("Failed to replay the AOF: %v", err)
+	}
+	runSteps(t, client, mockConn, []step{
+		{[]string{"LRANGE", "expired:str", "0", "-1"}, "*1\r\n$1\r\na\r\n"},
+		{[]string{"GET", "expired:int"}, "$1\r\n1\r\n"},
+		{[]string{"HMGET", "expired:hash", "f", "g"}, "*2\r\n$1\r\n5\r\n$1\r\n1\r\n"},
+		{[]string{"HTTL", "expired:hash", "FIELDS", "1", "f"}, "*1\r\n:-1\r\n"},
+		{[]string{"LRANGE", "expired:past", "0", "-1"}, "*1\r\n$1\r\na\r\n"},
+		{[]string{"SMEMBERS", "expired:getex"}, "*1\r\n$1\r\na\r\n"},
+		{[]string{"GET", "expired:expire"}, "$1\r\n1\r\n"},
+		{[]string{"FLUSHALL"}, "+OK\r\n"},
+	})
+}
+
+// TestRestartConcurrentWrites tests that the AOF logs the writes of
+// concurrent clients in the order they applied in
+func TestRestartConcurrentWrites(t *testing.T) {
+	handler, err := aof.Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to open the AOF: %v", err)
+	}
+	defer datastore.OnExpired(nil)
+	if err := LoadAOF(handler); err != nil {
+		t.Fatalf("Failed to replay the AOF: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		client, _ := createMockClient()
+		client.aof = handler
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 300; j++ {
+				client.process([]string{"RPUSH", "concurrent:list", strconv.Itoa(i)})
+			}
+		}()
+	}
+	wg.Wait()
+
+	client, mockConn := createMockClient()
+	client.aof = handler
+	lrange := func() string {
+		mockConn.writeBuffer.Reset()
+		client.process([]string{"LRANGE", "concurrent:list", "0", "-1"})
+		return mockConn.GetOutput()
+	}
+	before := lrange()
+	datastore.FlushAll(false)
+	if err := LoadAOF(handler); err != nil {
+		t.Fatalf("Failed to replay the AOF: %v", err)
+	}
+	if lrange() != before {
+		t.Error("Expected the replayed list to match the list written")
+	}
+	runSteps(t, client, mockConn, []step{{[]string{"FLUSHALL"}, "+OK\r\n"}})
+}
+
+// TestReplayKeyspace tests that the key-space writes logged to the AOF are
+// replayed
+func TestReplayKeyspace(t *testing.T) {
+	ds := datastore.GetDataStore()
+	client := NewReplayClient()
+	commands := [][]string{
+		{"SET", "replay-ks-flushed", "v"},
+		{"FLUSHALL"},
+		{"RPUSH", "replay-ks-list", "a", "b"},
+		{"COPY", "replay-ks-list", "replay-ks-copy"},
+		{"RPUSH", "replay-ks-copy", "c"},
+		{"SET", "replay-ks-a", "1"},
+		{"SET", "replay-ks-b", "2"},
+		{"COPY", "replay-ks-a", "replay-ks-b", "REPLACE"},
+		{"RENAME", "replay-ks-a", "replay-ks-renamed"},
+		{"SET", "replay-ks-del", "v"},
+		{"UNLINK", "replay-ks-del"},
+	}
+	for _, args := range commands {
+		if err := client.Replay(args); err != nil {
+			t.Fatalf("Failed to replay %q: %v", args, err)
+		}
+	}
+
+	if ds.Exists("replay-ks-flushed", "replay-ks-a", "replay-ks-del") != 0 {
+		t.Errorf("Expected the flushed, renamed and unlinked keys to be gone")
+	}
+	if n, _ := ds.LLen("replay-ks-list"); n != 2 {
+		t.Errorf("Expected the source list to keep 2 elements, got %d", n)
+	}
+	if n, _ := ds.LLen("replay-ks-copy"); n != 3 {
+		t.Errorf("Expected the copied list to have 3 elements, got %d", n)
+	}
+	if value, _, _ := ds.Get("replay-ks-b"); value != "1" {
+		t.Errorf("Expected COPY REPLACE to overwrite, got %q", value)
+	}
+	if value, _, _ := ds.Get("replay-ks-renamed"); value != "1" {
+		t.Errorf("Expected RENAME to move the value, got %q", value)
+	}
+}
+
+// TestReplayClient tests that the replay client follows SELECT, applies
+// MULTI/EXEC blocks at EXEC, does not block or log the commands again, and
+// fails on unknown commands and error replies
+func TestReplayClient(t *testing.T) {
+	datastore.FlushAll(false)
+	defer datastore.FlushAll(false)
+	client := NewReplayClient()
+	for _, args := range [][]string{
+		{"SET", "replay-db-a", "0"},
+		{"SELECT", "1"},
+		{"SET", "replay-db-a", "1"},
+		{"COPY", "replay-db-a", "replay-db-b", "DB", "2"},
+		{"SELECT", "2"},
+		{"MULTI"},
+		{"MOVE", "replay-db-b", "3"},
+		{"RPUSH", "replay-db-list", "x"},
+		{"EXEC"},
+		{"BLMOVE", "replay-db-list", "replay-db-dst", "LEFT", "LEFT", "0"},
+		{"BLPOP", "replay-db-list", "0"},
+		{"SWAPDB", "0", "1"},
+	} {
+		if err := client.Replay(args); err != nil {
+			t.Fatalf("Failed to replay %q: %v", args, err)
+		}
+	}
+	for db, want := range map[int]string{0: "1", 1: "0"} {
+		if value, _, _ := datastore.GetDatabase(db).Get("replay-db-a"); value != want {
+			t.Errorf("Expected %q in database %d, got %q", want, db, value)
+		}
+	}
+	if datastore.GetDatabase(2).Exists("replay-db-b") != 0 || datastore.GetDatabase(3).Exists("replay-db-b") != 1 {
+		t.Errorf("Expected the copy to be moved from database 2 to 3")
+	}
+	if datastore.GetDatabase(2).Exists("replay-db-dst") != 1 {
+		t.Errorf("Expected BLMOVE to be replayed in database 2")
+	}
+
+	for _, args := range [][]string{
+		{"NOSUCH", "a"},
+		{"SET", "a"},
+		{"SELECT", "16"},
+		{"INCR", "replay-db-dst"},
+		{"EXEC"},
+	} {
+		if err := client.Replay(args); err == nil {
+			t.Errorf("Expected an error replaying %q", args)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// deadline converts an expiry given in unit into an absolute Unix time in
+// milliseconds. If absolute is false, ttl is relative to the current time.
+// It returns false if the result does not fit in an int64.
+func deadline(ttl int64, unit time.Duration, absolute bool) (int64, bool) {
+	scale := int64(unit / time.Millisecond)
+	if ttl > math.MaxInt64/scale || ttl < math.MinInt64/scale {
+		return 0, false
+	}
+	at := ttl * scale
+	if absolute {
+		return at, true
+	}
+	now := datastore.Now()
+	if at > 0 && at > math.MaxInt64-now {
+		return 0, false
+	}
+	return at + now, true
+}
+
+// expire handles the EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT commands.
+// It takes an array of arguments with the following format:
+// [cmd, key, time, [NX|XX|GT|LT]...].
+// The deadline is always logged to the AOF as PEXPIREAT with an absolute
+// timestamp, so replaying the file neither resurrects nor extends the key,
+// or as DEL if it passed already.
+// It responds with 1 if the expiry was set and 0 if the key does not exist
+// or the options prevented it.
+func (c *Client) expire(args []string, unit time.Duration, absolute bool) {
+	key := args[1]
+	cond, ok := c.parseExpireOptions(args[3:])
+	if !ok {
+		return
+	}
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	at, ok := deadline(ttl, unit, absolute)
+	if !ok {
+		protocol.WriteError(c.conn, "ERR invalid expire time in '"+strings.ToUpper(args[0])+"' command")
+		return
+	}
+	switch c.datastore.ExpireAt(key, at, cond) {
+	case 0:
+		protocol.WriteInteger(c.conn, 0)
+		return
+	case 2:
+		// Replay keeps keys whose deadline passed until it is done, so
+		// the deletion is logged instead.
+		c.propagate([]string{"DEL", key})
+	default:
+		c.propagate([]string{"PEXPIREAT", key, strconv.FormatInt(at, 10)})
+	}
+	protocol.WriteInteger(c.conn, 1)
+}
+
+// parseExpireOptions parses the NX, XX, GT and LT options of the EXPIRE
+// commands, which may be combined as long as they do not contradict each
+// other. It writes an error to the client and returns false if they do, or
+// if an option is unknown.
+func (c *Client) parseExpireOptions(args []string) (datastore.ExpireCondition, bool) {
+	cond := datastore.ExpireAlways
+	for _, arg := range args {
+		parsed, ok := parseExpireCondition(arg)
+		if !ok {
+			protocol.WriteError(c.conn, "ERR Unsupported option "+arg)
+			return cond, false
+		}
+		cond |= parsed
+	}
+	switch {
+	case cond&datastore.ExpireNX != 0 && cond != datastore.ExpireNX:
+		protocol.WriteError(c.conn, "ERR NX and XX, GT or LT options at the same time are not compatible")
+		return cond, false
+	case cond&datastore.ExpireGT != 0 && cond&datastore.ExpireLT != 0:
+		protocol.WriteError(c.conn, "ERR GT and LT options at the same time are not compatible")
+		return cond, false
+	}
+	return cond, true
+}
+
+// ttl handles the TTL and PTTL commands.
+// It takes an array of arguments with the following format: [cmd, key].
+// It responds with the remaining time to live in unit, -1 if the key has no
+// expiry and -2 if the key does not exist.
+func (c *Client) ttl(args []string, unit time.Duration) {
+	at := c.datastore.ExpireTime(args[1])
+	if at < 0 {
+		protocol.WriteInteger(c.conn, at)
+		return
+	}
+	remaining := at - datastore.Now()
+	if remaining < 0 {
+		remaining = 0
+	}
+	scale := int64(unit / time.Millisecond)
+	// Round to the nearest unit, as Redis does for TTL.
+	protocol.WriteInteger(c.conn, (remaining+scale/2)/scale)
+}
+
+// expireTime handles the EXPIRETIME and PEXPIRETIME commands.
+// It takes an array of arguments with the following format: [cmd, key].
+// It responds with the absolute Unix deadline in unit, -1 if the key has no
+// expiry and -2 if the key does not exist.
+func (c *Client) expireTime(args []string, unit time.Duration) {
+	at := c.datastore.ExpireTime(args[1])
+	if at < 0 {
+		protocol.WriteInteger(c.conn, at)
+		return
+	}
+	protocol.WriteInteger(c.conn, at/int64(unit/time.Millisecond))
+}
+
+// persist handles the PERSIST command for the client.
+// It takes an array of arguments with the following format: ["PERSIST", key].
+// It responds with 1 if an expiry was removed and 0 otherwise.
+func (c *Client) persist(args []string) {
+	if !c.datastore.Persist(args[1]) {
+		protocol.WriteInteger(c.conn, 0)
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, 1)
+}
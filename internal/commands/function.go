@@ -0,0 +1,549 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/glob"
+	"github.com/manimovassagh/Godis/internal/lua"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// Functions are named scripts grouped in libraries. A library is Lua code
+// starting with a "#!lua name=<library>" line, which registers its functions
+// with redis.register_function when it is loaded. The FUNCTION commands
+// changing the libraries are logged to the AOF, so they survive restarts.
+
+const (
+	// functionLoadTimeout bounds the time the code of a library may run
+	// when it is loaded.
+	functionLoadTimeout = 500 * time.Millisecond
+	// functionDumpHeader starts the payloads of FUNCTION DUMP, followed by
+	// their version byte.
+	functionDumpHeader  = "GODISFN"
+	functionDumpVersion = 1
+)
+
+// functionFlags are the flags a function may be registered with, in the
+// order FUNCTION LIST reports them.
+var functionFlags = []string{"no-writes", "allow-oom", "allow-stale", "no-cluster", "allow-cross-slot-keys"}
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// luaFunction is a function registered by a library.
+type luaFunction struct {
+	name        string
+	description lua.Value
+	flags       []string
+	callback    *lua.Function
+	library     *library
+}
+
+// library is a loaded function library. Its functions run on the state the
+// library was loaded in, whose redis table is bound to the calling client
+// before each call.
+type library struct {
+	name      string
+	code      string
+	state     *lua.State
+	redis     *lua.Table
+	functions map[string]*luaFunction
+}
+
+// functionRegistry holds the loaded libraries and their functions by name.
+var functionRegistry = struct {
+	sync.Mutex
+	libraries map[string]*library
+	functions map[string]*luaFunction
+}{
+	libraries: make(map[string]*library),
+	functions: make(map[string]*luaFunction),
+}
+
+// validFunctionName reports whether name is a valid library or function
+// name.
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLibraryMetadata parses the "#!<engine> name=<library>" first line of
+// the code of a library and returns the library name.
+func parseLibraryMetadata(code string) (string, error) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", errors.New("ERR Missing library metadata")
+	}
+	line, _, _ := strings.Cut(code[2:], "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "lua") {
+		engine := ""
+		if len(fields) > 0 {
+			engine = fields[0]
+		}
+		return "", fmt.Errorf("ERR Engine '%s' not found", engine)
+	}
+	name, found := "", false
+	for _, field := range fields[1:] {
+		value, ok := strings.CutPrefix(field, "name=")
+		if !ok {
+			return "", fmt.Errorf("ERR Invalid metadata value given: %s", field)
+		}
+		name, found = value, true
+	}
+	if !found {
+		return "", errors.New("ERR Library name was not given")
+	}
+	if !validFunctionName(name) {
+		return "", errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+// loadLibrary compiles and runs the code of a library, and returns the
+// library with the functions it registered. The library is not installed.
+func loadLibrary(code string) (*library, error) {
+	name, err := parseLibraryMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := lua.Compile(code, "user_function")
+	if err != nil {
+		return nil, errors.New("ERR Error compiling function: " + err.Error())
+	}
+	lib := &library{
+		name:      name,
+		code:      code,
+		state:     lua.NewState(),
+		redis:     newRedisLib(),
+		functions: make(map[string]*luaFunction),
+	}
+	lib.redis.SetString("register_function", lua.NewFunction("register_function", lib.registerFunction))
+	lib.state.SetGlobal("redis", lib.redis)
+	lib.state.SetStrictGlobals(true)
+	deadline := time.Now().Add(functionLoadTimeout)
+	lib.state.SetInterrupt(func() error {
+		if time.Now().After(deadline) {
+			return errors.New("FUNCTION LOAD timeout")
+		}
+		return nil
+	})
+	_, err = lib.state.Run(chunk)
+	// Functions can only be registered while the library loads.
+	lib.redis.SetString("register_function", nil)
+	if err != nil {
+		return nil, errors.New("ERR Error registering functions: " + err.Error())
+	}
+	if len(lib.functions) == 0 {
+		return nil, errors.New("ERR No functions registered")
+	}
+	return lib, nil
+}
+
+// registerFunction implements redis.register_function, which takes either a
+// name and a callback, or a table with the function_name, callback, and
+// optional description and flags fields.
+func (lib *library) registerFunction(s *lua.State, args []lua.Value) []lua.Value {
+	f := &luaFunction{library: lib}
+	var name, callback lua.Value
+	switch len(args) {
+	case 2:
+		name, callback = args[0], args[1]
+	case 1:
+		t, ok := args[0].(*lua.Table)
+		if !ok {
+			s.Raise("calling redis.register_function with a single argument is only applicable to Lua table (representing named arguments).")
+		}
+		t.ForEach(func(k, v lua.Value) {
+			switch k {
+			case "function_name":
+				name = v
+			case "callback":
+				callback = v
+			case "description":
+				if _, ok := v.(string); !ok {
+					s.Raise("description argument given to redis.register_function must be a string")
+				}
+				f.description = v
+			case "flags":
+				f.flags = parseFunctionFlags(s, v)
+			default:
+				s.Raise("unknown argument given to redis.register_function")
+			}
+		})
+	default:
+		s.Raise("wrong number of arguments to redis.register_function")
+	}
+	var ok bool
+	if f.name, ok = name.(string); !ok {
+		s.Raise("function_name argument given to redis.register_function must be a string")
+	}
+	if f.callback, ok = callback.(*lua.Function); !ok {
+		s.Raise("callback argument given to redis.register_function must be a function")
+	}
+	if !validFunctionName(f.name) {
+		s.Raise("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	if _, found := lib.functions[f.name]; found {
+		s.Raise("Function already exists in the library")
+	}
+	lib.functions[f.name] = f
+	return nil
+}
+
+// parseFunctionFlags checks the flags table given to
+// redis.register_function and returns the flags in their canonical order.
+func parseFunctionFlags(s *lua.State, v lua.Value) []string {
+	t, ok := v.(*lua.Table)
+	if !ok {
+		s.Raise("flags argument to redis.register_function must be a table representing function flags")
+	}
+	given := make(map[string]bool)
+	t.ForEach(func(_, flag lua.Value) {
+		name, ok := flag.(string)
+		if !ok || !slices.Contains(functionFlags, name) {
+			s.Raise("unknown flag given")
+		}
+		given[name] = true
+	})
+	var flags []string
+	for _, flag := range functionFlags {
+		if given[flag] {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// noWrites reports whether the function is flagged as not modifying the
+// data set.
+func (f *luaFunction) noWrites() bool {
+	return slices.Contains(f.flags, "no-writes")
+}
+
+// installLibraries adds loaded libraries to the registry according to a
+// FUNCTION RESTORE policy: APPEND fails if a library already exists, REPLACE
+// replaces it, and FLUSH removes all the libraries first. It also fails if
+// two libraries register the same function, in which case the registry is
+// left unchanged.
+func installLibraries(libs []*library, policy string) error {
+	functionRegistry.Lock()
+	defer functionRegistry.Unlock()
+	libraries := make(map[string]*library)
+	if policy != "FLUSH" {
+		maps.Copy(libraries, functionRegistry.libraries)
+	}
+	for _, lib := range libs {
+		if _, found := libraries[lib.name]; found && policy == "APPEND" {
+			return fmt.Errorf("ERR Library '%s' already exists", lib.name)
+		}
+		libraries[lib.name] = lib
+	}
+	functions := make(map[string]*luaFunction)
+	for _, name := range slices.Sorted(maps.Keys(libraries)) {
+		for fname, f := range libraries[name].functions {
+			if _, found := functions[fname]; found {
+				return fmt.Errorf("ERR Function %s already exists", fname)
+			}
+			functions[fname] = f
+		}
+	}
+	functionRegistry.libraries, functionRegistry.functions = libraries, functions
+	return nil
+}
+
+// deleteLibrary removes a library and its functions from the registry.
+func deleteLibrary(name string) error {
+	functionRegistry.Lock()
+	defer functionRegistry.Unlock()
+	lib, found := functionRegistry.libraries[name]
+	if !found {
+		return errors.New("ERR Library not found")
+	}
+	delete(functionRegistry.libraries, name)
+	for fname := range lib.functions {
+		delete(functionRegistry.functions, fname)
+	}
+	return nil
+}
+
+// flushLibraries removes every library from the registry.
+func flushLibraries() {
+	functionRegistry.Lock()
+	defer functionRegistry.Unlock()
+	functionRegistry.libraries = make(map[string]*library)
+	functionRegistry.functions = make(map[string]*luaFunction)
+}
+
+// libraryCodes returns the code of every library, sorted by library name.
+func libraryCodes() []string {
+	functionRegistry.Lock()
+	defer functionRegistry.Unlock()
+	codes := make([]string, 0, len(functionRegistry.libraries))
+	for _, name := range slices.Sorted(maps.Keys(functionRegistry.libraries)) {
+		codes = append(codes, functionRegistry.libraries[name].code)
+	}
+	return codes
+}
+
+// dumpLibraries serializes the code of every library: the header and version
+// byte, the codes as a RESP array, and a little-endian CRC64 of all that.
+func dumpLibraries() string {
+	payload := []byte(functionDumpHeader)
+	payload = append(payload, functionDumpVersion)
+	payload = append(payload, protocol.FormatCommand(libraryCodes())...)
+	return string(binary.LittleEndian.AppendUint64(payload, crc64.Checksum(payload, crcTable)))
+}
+
+// restoreLibraries loads the libraries of a FUNCTION DUMP payload and
+// installs them according to policy.
+func restoreLibraries(payload, policy string) error {
+	errPayload := errors.New("ERR payload version or checksum are wrong")
+	if len(payload) < len(functionDumpHeader)+1+8 || !strings.HasPrefix(payload, functionDumpHeader) {
+		return errPayload
+	}
+	body, sum := payload[:len(payload)-8], payload[len(payload)-8:]
+	if body[len(functionDumpHeader)] != functionDumpVersion ||
+		binary.LittleEndian.Uint64([]byte(sum)) != crc64.Checksum([]byte(body), crcTable) {
+		return errPayload
+	}
+	codes, err := protocol.ParseRequest(bufio.NewReader(strings.NewReader(body[len(functionDumpHeader)+1:])))
+	if err != nil {
+		return errPayload
+	}
+	libs := make([]*library, 0, len(codes))
+	for _, code := range codes {
+		lib, err := loadLibrary(code)
+		if err != nil {
+			return err
+		}
+		libs = append(libs, lib)
+	}
+	return installLibraries(libs, policy)
+}
+
+// fcall handles the FCALL and FCALL_RO commands for the client.
+// It takes an array of arguments with the following format:
+// ["FCALL", function, numkeys, key ..., arg ...]. FCALL_RO only calls
+// functions flagged no-writes. The caller must hold commandLock for writing.
+func (c *Client) fcall(args []string, readOnly bool) {
+	keys, argv, ok := c.scriptKeys(args)
+	if !ok {
+		return
+	}
+	functionRegistry.Lock()
+	f := functionRegistry.functions[args[1]]
+	functionRegistry.Unlock()
+	if f == nil {
+		protocol.WriteError(c.conn, "ERR Function not found")
+		return
+	}
+	if readOnly && !f.noWrites() {
+		protocol.WriteError(c.conn, "ERR Can not execute a script with write flag using *_ro command.")
+		return
+	}
+	lib := f.library
+	c.bindRedisCalls(lib.redis, f.noWrites())
+	c.runScript(lib.state, args, f.name, func() ([]lua.Value, error) {
+		return lib.state.Call(f.callback, stringsToTable(keys), stringsToTable(argv))
+	})
+}
+
+// functionLoad handles the FUNCTION LOAD command for the client.
+// It takes an array of arguments with the following format: ["FUNCTION", "LOAD", [REPLACE], code].
+// It loads a library and responds with its name.
+func (c *Client) functionLoad(args []string) {
+	replace := len(args) == 4 && strings.EqualFold(args[2], "REPLACE")
+	if len(args) != 3 && !replace {
+		protocol.WriteError(c.conn, errWrongArgs("FUNCTION|LOAD"))
+		return
+	}
+	code := args[len(args)-1]
+	lib, err := loadLibrary(code)
+	if err == nil {
+		policy := "APPEND"
+		if replace {
+			policy = "REPLACE"
+		}
+		err = installLibraries([]*library{lib}, policy)
+	}
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.propagate([]string{"FUNCTION", "LOAD", "REPLACE", code})
+	protocol.WriteBulkString(c.conn, lib.name)
+}
+
+// functionDelete handles the FUNCTION DELETE command for the client.
+// It takes an array of arguments with the following format: ["FUNCTION", "DELETE", library].
+func (c *Client) functionDelete(args []string) {
+	if err := deleteLibrary(args[2]); err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.propagate([]string{"FUNCTION", "DELETE", args[2]})
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// functionFlush handles the FUNCTION FLUSH command for the client.
+// It takes an array of arguments with the following format: ["FUNCTION", "FLUSH", [ASYNC|SYNC]].
+func (c *Client) functionFlush(args []string) {
+	if len(args) > 3 || (len(args) == 3 && !strings.EqualFold(args[2], "ASYNC") && !strings.EqualFold(args[2], "SYNC")) {
+		protocol.WriteError(c.conn, "ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+		return
+	}
+	flushLibraries()
+	c.propagate([]string{"FUNCTION", "FLUSH"})
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// functionDump handles the FUNCTION DUMP command for the client.
+// It takes an array of arguments with the following format: ["FUNCTION", "DUMP"].
+// It responds with a serialized payload of the libraries.
+func (c *Client) functionDump(args []string) {
+	protocol.WriteBulkString(c.conn, dumpLibraries())
+}
+
+// functionRestore handles the FUNCTION RESTORE command for the client.
+// It takes an array of arguments with the following format:
+// ["FUNCTION", "RESTORE", payload, [FLUSH|APPEND|REPLACE]].
+func (c *Client) functionRestore(args []string) {
+	if len(args) > 4 {
+		protocol.WriteError(c.conn, errWrongArgs("FUNCTION|RESTORE"))
+		return
+	}
+	policy := "APPEND"
+	if len(args) == 4 {
+		policy = strings.ToUpper(args[3])
+		if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+			protocol.WriteError(c.conn, "ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+			return
+		}
+	}
+	if err := restoreLibraries(args[2], policy); err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.propagate([]string{"FUNCTION", "RESTORE", args[2], policy})
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// functionList handles the FUNCTION LIST command for the client.
+// It takes an array of arguments with the following format:
+// ["FUNCTION", "LIST", [LIBRARYNAME pattern], [WITHCODE]].
+// It responds with an array describing the libraries, sorted by name.
+func (c *Client) functionList(args []string) {
+	pattern, withCode := "", false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 >= len(args) {
+				protocol.WriteError(c.conn, "ERR library name argument was not given")
+				return
+			}
+			i++
+			pattern = args[i]
+		default:
+			protocol.WriteError(c.conn, "ERR Unknown argument "+args[i])
+			return
+		}
+	}
+
+	functionRegistry.Lock()
+	defer functionRegistry.Unlock()
+	var libs []*library
+	for _, name := range slices.Sorted(maps.Keys(functionRegistry.libraries)) {
+		if pattern == "" || glob.Match(pattern, name) {
+			libs = append(libs, functionRegistry.libraries[name])
+		}
+	}
+	protocol.WriteArrayHeader(c.conn, len(libs))
+	for _, lib := range libs {
+		if withCode {
+			protocol.WriteArrayHeader(c.conn, 8)
+		} else {
+			protocol.WriteArrayHeader(c.conn, 6)
+		}
+		protocol.WriteBulkString(c.conn, "library_name")
+		protocol.WriteBulkString(c.conn, lib.name)
+		protocol.WriteBulkString(c.conn, "engine")
+		protocol.WriteBulkString(c.conn, "LUA")
+		protocol.WriteBulkString(c.conn, "functions")
+		protocol.WriteArrayHeader(c.conn, len(lib.functions))
+		for _, name := range slices.Sorted(maps.Keys(lib.functions)) {
+			f := lib.functions[name]
+			protocol.WriteArrayHeader(c.conn, 6)
+			protocol.WriteBulkString(c.conn, "name")
+			protocol.WriteBulkString(c.conn, f.name)
+			protocol.WriteBulkString(c.conn, "description")
+			if description, ok := f.description.(string); ok {
+				protocol.WriteBulkString(c.conn, description)
+			} else {
+				protocol.WriteNullBulkString(c.conn)
+			}
+			protocol.WriteBulkString(c.conn, "flags")
+			protocol.WriteArray(c.conn, f.flags)
+		}
+		if withCode {
+			protocol.WriteBulkString(c.conn, "library_code")
+			protocol.WriteBulkString(c.conn, lib.code)
+		}
+	}
+}
+
+// functionStats handles the FUNCTION STATS command for the client.
+// It takes an array of arguments with the following format: ["FUNCTION", "STATS"].
+// It responds with the running function, if any, and the number of libraries
+// and functions. It fails with a BUSY error while an EVAL script runs.
+func (c *Client) functionStats(args []string) {
+	runningScript.Lock()
+	active, function, command, started := runningScript.active, runningScript.function, runningScript.command, runningScript.started
+	runningScript.Unlock()
+	if active && function == "" {
+		protocol.WriteError(c.conn, errBusy)
+		return
+	}
+	functionRegistry.Lock()
+	numLibraries, numFunctions := len(functionRegistry.libraries), len(functionRegistry.functions)
+	functionRegistry.Unlock()
+
+	protocol.WriteArrayHeader(c.conn, 4)
+	protocol.WriteBulkString(c.conn, "running_script")
+	if active {
+		protocol.WriteArrayHeader(c.conn, 6)
+		protocol.WriteBulkString(c.conn, "name")
+		protocol.WriteBulkString(c.conn, function)
+		protocol.WriteBulkString(c.conn, "command")
+		protocol.WriteArray(c.conn, command)
+		protocol.WriteBulkString(c.conn, "duration_ms")
+		protocol.WriteInteger(c.conn, time.Since(started).Milliseconds())
+	} else {
+		protocol.WriteNullBulkString(c.conn)
+	}
+	protocol.WriteBulkString(c.conn, "engines")
+	protocol.WriteArrayHeader(c.conn, 2)
+	protocol.WriteBulkString(c.conn, "LUA")
+	protocol.WriteArrayHeader(c.conn, 4)
+	protocol.WriteBulkString(c.conn, "libraries_count")
+	protocol.WriteInteger(c.conn, int64(numLibraries))
+	protocol.WriteBulkString(c.conn, "functions_count")
+	protocol.WriteInteger(c.conn, int64(numFunctions))
+}
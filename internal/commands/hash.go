@@ -0,0 +1,391 @@
+package commands
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// hset handles the HSET and HMSET commands.
+// It takes an array of arguments with the following format: [cmd, key, field, value, ...].
+// HSET responds with the number of added fields, HMSET with "OK".
+func (c *Client) hset(args []string) {
+	if len(args)%2 != 0 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	added, err := c.datastore.HSet(args[1], args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.propagate(append([]string{"HSET"}, args[1:]...))
+	if strings.ToUpper(args[0]) == "HMSET" {
+		protocol.WriteSimpleString(c.conn, "OK")
+		return
+	}
+	protocol.WriteInteger(c.conn, int64(added))
+}
+
+// hget handles the HGET command for the client.
+// It takes an array of arguments with the following format: ["HGET", key, field].
+func (c *Client) hget(args []string) {
+	value, found, err := c.datastore.HGet(args[1], args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+	} else if !found {
+		protocol.WriteNullBulkString(c.conn)
+	} else {
+		protocol.WriteBulkString(c.conn, value)
+	}
+}
+
+// hmget handles the HMGET command for the client.
+// It takes an array of arguments with the following format: ["HMGET", key, field, ...].
+// It responds with an array holding the value of each field, or nil for missing fields.
+func (c *Client) hmget(args []string) {
+	values, err := c.datastore.HMGet(args[1], args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteArrayHeader(c.conn, len(values))
+	for _, value := range values {
+		if value == nil {
+			protocol.WriteNullBulkString(c.conn)
+		} else {
+			protocol.WriteBulkString(c.conn, *value)
+		}
+	}
+}
+
+// hgetall handles the HGETALL, HKEYS and HVALS commands.
+// It takes an array of arguments with the following format: [cmd, key].
+// HGETALL responds with alternating fields and values, HKEYS with the fields
+// only and HVALS with the values only.
+func (c *Client) hgetall(args []string) {
+	pairs, err := c.datastore.HGetAll(args[1])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	switch strings.ToUpper(args[0]) {
+	case "HKEYS":
+		protocol.WriteArray(c.conn, everyOther(pairs, 0))
+	case "HVALS":
+		protocol.WriteArray(c.conn, everyOther(pairs, 1))
+	default:
+		protocol.WriteArray(c.conn, pairs)
+	}
+}
+
+// everyOther returns every second element of pairs, starting at offset.
+func everyOther(pairs []string, offset int) []string {
+	values := make([]string, 0, len(pairs)/2)
+	for i := offset; i < len(pairs); i += 2 {
+		values = append(values, pairs[i])
+	}
+	return values
+}
+
+// hdel handles the HDEL command for the client.
+// It takes an array of arguments with the following format: ["HDEL", key, field, ...].
+func (c *Client) hdel(args []string) {
+	removed, err := c.datastore.HDel(args[1], args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(removed))
+}
+
+// hexists handles the HEXISTS command for the client.
+// It takes an array of arguments with the following format: ["HEXISTS", key, field].
+func (c *Client) hexists(args []string) {
+	_, found, err := c.datastore.HGet(args[1], args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if found {
+		protocol.WriteInteger(c.conn, 1)
+	} else {
+		protocol.WriteInteger(c.conn, 0)
+	}
+}
+
+// hlen handles the HLEN command for the client.
+// It takes an array of arguments with the following format: ["HLEN", key].
+func (c *Client) hlen(args []string) {
+	length, err := c.datastore.HLen(args[1])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteInteger(c.conn, int64(length))
+}
+
+// hincrby handles the HINCRBY command for the client.
+// It takes an array of arguments with the following format: ["HINCRBY", key, field, increment].
+func (c *Client) hincrby(args []string) {
+	delta, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	value, err := c.datastore.HIncrBy(args[1], args[2], delta)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, value)
+}
+
+// hincrbyfloat handles the HINCRBYFLOAT command for the client.
+// It takes an array of arguments with the following format: ["HINCRBYFLOAT", key, field, increment].
+// The result is logged to the AOF as an HSET so that replay does not depend on
+// floating point rounding.
+func (c *Client) hincrbyfloat(args []string) {
+	delta, err := strconv.ParseFloat(args[3], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		protocol.WriteError(c.conn, errNotFloat)
+		return
+	}
+	value, err := c.datastore.HIncrByFloat(args[1], args[2], delta)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.propagate([]string{"HSET", args[1], args[2], value})
+	protocol.WriteBulkString(c.conn, value)
+}
+
+// hscan handles the HSCAN command for the client.
+// It takes an array of arguments with the following format:
+// ["HSCAN", key, cursor, [MATCH pattern], [COUNT count], [NOVALUES]].
+// It responds with the next cursor and the matching fields and values.
+func (c *Client) hscan(args []string) {
+	opts, ok := c.parseScanArgs(args, 2, true, false)
+	if !ok {
+		return
+	}
+	next, pairs, err := c.datastore.HScan(args[1], opts.cursor, opts.count, opts.pattern)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if opts.noValues {
+		pairs = everyOther(pairs, 0)
+	}
+	c.writeScanReply(next, pairs)
+}
+
+// hrandfield handles the HRANDFIELD command for the client.
+// It takes an array of arguments with the following format: ["HRANDFIELD", key, [count, [WITHVALUES]]].
+func (c *Client) hrandfield(args []string) {
+	if len(args) > 4 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	if len(args) == 2 {
+		pairs, err := c.datastore.HRandField(args[1], 1)
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+		} else if len(pairs) == 0 {
+			protocol.WriteNullBulkString(c.conn)
+		} else {
+			protocol.WriteBulkString(c.conn, pairs[0])
+		}
+		return
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	withValues := false
+	if len(args) == 4 {
+		if strings.ToUpper(args[3]) != "WITHVALUES" {
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+		withValues = true
+	}
+	pairs, err := c.datastore.HRandField(args[1], count)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !withValues {
+		pairs = everyOther(pairs, 0)
+	}
+	protocol.WriteArray(c.conn, pairs)
+}
+
+// parseFields parses the "FIELDS numfields field ..." block that ends the
+// hash field expiry commands, starting at args[i]. On failure it writes the
+// error to the client and returns false.
+func (c *Client) parseFields(args []string, i int) ([]string, bool) {
+	if i+1 >= len(args) || strings.ToUpper(args[i]) != "FIELDS" {
+		protocol.WriteError(c.conn, "ERR Mandatory argument FIELDS is missing or not at the right position")
+		return nil, false
+	}
+	n, err := strconv.Atoi(args[i+1])
+	if err != nil || n <= 0 {
+		protocol.WriteError(c.conn, "ERR Parameter `numFields` should be greater than 0")
+		return nil, false
+	}
+	fields := args[i+2:]
+	if len(fields) != n {
+		protocol.WriteError(c.conn, "ERR The `numfields` parameter must match the number of arguments")
+		return nil, false
+	}
+	return fields, true
+}
+
+// hexpire handles the HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT commands.
+// It takes an array of arguments with the following format:
+// [cmd, key, time, [NX|XX|GT|LT], FIELDS, numfields, field, ...].
+// Fields whose deadline changed are logged to the AOF as HPEXPIREAT with an
+// absolute timestamp, and fields it deleted as HDEL. It responds with one
+// status code per field.
+func (c *Client) hexpire(args []string, unit time.Duration, absolute bool) {
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	at, ok := deadline(ttl, unit, absolute)
+	if !ok || ttl < 0 {
+		protocol.WriteError(c.conn, "ERR invalid expire time in '"+strings.ToUpper(args[0])+"' command")
+		return
+	}
+	i, cond := 3, datastore.ExpireAlways
+	if parsed, ok := parseExpireCondition(args[3]); ok {
+		i, cond = 4, parsed
+	}
+	fields, ok := c.parseFields(args, i)
+	if !ok {
+		return
+	}
+	results, err := c.datastore.HExpireAt(args[1], at, cond, fields...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	var changed, deleted []string
+	for j, result := range results {
+		switch result {
+		case 1:
+			changed = append(changed, fields[j])
+		case 2:
+			deleted = append(deleted, fields[j])
+		}
+	}
+	if len(changed) > 0 {
+		c.propagate(append([]string{"HPEXPIREAT", args[1], strconv.FormatInt(at, 10), "FIELDS", strconv.Itoa(len(changed))}, changed...))
+	}
+	if len(deleted) > 0 {
+		c.propagate(append([]string{"HDEL", args[1]}, deleted...))
+	}
+	c.writeIntegers(results)
+}
+
+// httl handles the HTTL and HPTTL commands.
+// It takes an array of arguments with the following format: [cmd, key, FIELDS, numfields, field, ...].
+// It responds with the remaining time to live of each field in unit.
+func (c *Client) httl(args []string, unit time.Duration) {
+	fields, ok := c.parseFields(args, 2)
+	if !ok {
+		return
+	}
+	results, err := c.datastore.HExpireTime(args[1], fields...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	current := datastore.Now()
+	scale := int64(unit / time.Millisecond)
+	for i, at := range results {
+		if at >= 0 {
+			results[i] = (max(at-current, 0) + scale/2) / scale
+		}
+	}
+	c.writeIntegers(results)
+}
+
+// hexpiretime handles the HEXPIRETIME and HPEXPIRETIME commands.
+// It takes an array of arguments with the following format: [cmd, key, FIELDS, numfields, field, ...].
+// It responds with the absolute Unix deadline of each field in unit.
+func (c *Client) hexpiretime(args []string, unit time.Duration) {
+	fields, ok := c.parseFields(args, 2)
+	if !ok {
+		return
+	}
+	results, err := c.datastore.HExpireTime(args[1], fields...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	for i, at := range results {
+		if at >= 0 {
+			results[i] = at / int64(unit/time.Millisecond)
+		}
+	}
+	c.writeIntegers(results)
+}
+
+// hpersist handles the HPERSIST command for the client.
+// It takes an array of arguments with the following format: ["HPERSIST", key, FIELDS, numfields, field, ...].
+func (c *Client) hpersist(args []string) {
+	fields, ok := c.parseFields(args, 2)
+	if !ok {
+		return
+	}
+	results, err := c.datastore.HPersist(args[1], fields...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	var changed []string
+	for i, result := range results {
+		if result == 1 {
+			changed = append(changed, fields[i])
+		}
+	}
+	if len(changed) > 0 {
+		c.propagate(append([]string{"HPERSIST", args[1], "FIELDS", strconv.Itoa(len(changed))}, changed...))
+	}
+	c.writeIntegers(results)
+}
+
+// parseExpireCondition parses an NX, XX, GT or LT expiry option.
+func parseExpireCondition(arg string) (datastore.ExpireCondition, bool) {
+	switch strings.ToUpper(arg) {
+	case "NX":
+		return datastore.ExpireNX, true
+	case "XX":
+		return datastore.ExpireXX, true
+	case "GT":
+		return datastore.ExpireGT, true
+	case "LT":
+		return datastore.ExpireLT, true
+	}
+	return datastore.ExpireAlways, false
+}
+
+// writeIntegers writes an array of integers to the client.
+func (c *Client) writeIntegers(values []int64) {
+	protocol.WriteArrayHeader(c.conn, len(values))
+	for _, value := range values {
+		protocol.WriteInteger(c.conn, value)
+	}
+}
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// del handles the DEL and UNLINK commands.
+// It takes an array of arguments with the following format: [cmd, key, ...].
+// UNLINK releases large values in the background. It responds with the
+// number of keys that were deleted.
+func (c *Client) del(args []string, lazy bool) {
+	var deleted int
+	if lazy {
+		deleted = c.datastore.Unlink(args[1:]...)
+	} else {
+		deleted = c.datastore.Del(args[1:]...)
+	}
+	if deleted > 0 {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(deleted))
+}
+
+// exists handles the EXISTS and TOUCH commands.
+// It takes an array of arguments with the following format: [cmd, key, ...].
+// It responds with the number of given keys that exist, counting a key given
+// several times as many times.
+func (c *Client) exists(args []string) {
+	protocol.WriteInteger(c.conn, int64(c.datastore.Exists(args[1:]...)))
+}
+
+// typeCmd handles the TYPE command for the client.
+// It takes an array of arguments with the following format: ["TYPE", key].
+func (c *Client) typeCmd(args []string) {
+	protocol.WriteSimpleString(c.conn, c.datastore.Type(args[1]))
+}
+
+// rename handles the RENAME and RENAMENX commands.
+// It takes an array of arguments with the following format: [cmd, key, newkey].
+// RENAME responds with "OK", RENAMENX with 1 if the key was renamed and 0 if
+// newkey already existed.
+func (c *Client) rename(args []string, nx bool) {
+	renamed, err := c.datastore.Rename(args[1], args[2], nx)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if renamed {
+		c.dirty = true
+	}
+	switch {
+	case !nx:
+		protocol.WriteSimpleString(c.conn, "OK")
+	case renamed:
+		protocol.WriteInteger(c.conn, 1)
+	default:
+		protocol.WriteInteger(c.conn, 0)
+	}
+}
+
+// copyCmd handles the COPY command for the client.
+// It takes an array of arguments with the following format: ["COPY", source, destination, [DB db], [REPLACE]].
+// It responds with 1 if the value was copied and 0 otherwise.
+func (c *Client) copyCmd(args []string) {
+	dst, replace := c.datastore, false
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "REPLACE":
+			replace = true
+		case option == "DB" && i+1 < len(args):
+			if dst = c.parseDB(args[i+1]); dst == nil {
+				return
+			}
+			i++
+		default:
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+	}
+	copied, err := c.datastore.Copy(args[1], dst, args[2], replace)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !copied {
+		protocol.WriteInteger(c.conn, 0)
+		return
+	}
+	entry := []string{"COPY", args[1], args[2]}
+	if dst != c.datastore {
+		entry = append(entry, "DB", strconv.Itoa(dst.ID()))
+	}
+	if replace {
+		entry = append(entry, "REPLACE")
+	}
+	c.propagate(entry)
+	protocol.WriteInteger(c.conn, 1)
+}
+
+// move handles the MOVE command for the client.
+// It takes an array of arguments with the following format: ["MOVE", key, db].
+// It responds with 1 if the key was moved, and 0 if it did not exist or the
+// database db already held it.
+func (c *Client) move(args []string) {
+	dst := c.parseDB(args[2])
+	if dst == nil {
+		return
+	}
+	moved, err := c.datastore.Move(args[1], dst)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !moved {
+		protocol.WriteInteger(c.conn, 0)
+		return
+	}
+	c.propagate([]string{"MOVE", args[1], strconv.Itoa(dst.ID())})
+	protocol.WriteInteger(c.conn, 1)
+}
+
+// selectCmd handles the SELECT command for the client.
+// It takes an array of arguments with the following format: ["SELECT", db].
+// The following commands of the client apply to the database db.
+func (c *Client) selectCmd(args []string) {
+	db := c.parseDB(args[1])
+	if db == nil {
+		return
+	}
+	c.datastore = db
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// swapDB handles the SWAPDB command for the client.
+// It takes an array of arguments with the following format: ["SWAPDB", index1, index2].
+// The clients connected to either database see the other one's keys. It
+// responds with "OK".
+func (c *Client) swapDB(args []string) {
+	var dbs [2]*datastore.DataStore
+	for i, name := range []string{"first", "second"} {
+		id, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			protocol.WriteError(c.conn, "ERR invalid "+name+" DB index")
+			return
+		}
+		if dbs[i] = datastore.GetDatabase(id); dbs[i] == nil {
+			protocol.WriteError(c.conn, "ERR DB index is out of range")
+			return
+		}
+	}
+	datastore.SwapDatabases(dbs[0].ID(), dbs[1].ID())
+	c.propagate([]string{"SWAPDB", strconv.Itoa(dbs[0].ID()), strconv.Itoa(dbs[1].ID())})
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// parseDB returns the database numbered arg. If there is no such database it
+// writes an error reply and returns nil.
+func (c *Client) parseDB(arg string) *datastore.DataStore {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return nil
+	}
+	db := datastore.GetDatabase(id)
+	if db == nil {
+		protocol.WriteError(c.conn, "ERR DB index is out of range")
+	}
+	return db
+}
+
+// randomKey handles the RANDOMKEY command for the client.
+// It responds with a random key, or nil if there are none.
+func (c *Client) randomKey(args []string) {
+	key, found := c.datastore.RandomKey()
+	if !found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	protocol.WriteBulkString(c.conn, key)
+}
+
+// keys handles the KEYS command for the client.
+// It takes an array of arguments with the following format: ["KEYS", pattern].
+// It responds with every key that matches pattern.
+func (c *Client) keys(args []string) {
+	protocol.WriteArray(c.conn, c.datastore.Keys(args[1]))
+}
+
+// dbSize handles the DBSIZE command for the client.
+// It responds with the number of keys.
+func (c *Client) dbSize(args []string) {
+	protocol.WriteInteger(c.conn, int64(c.datastore.DBSize()))
+}
+
+// flush handles the FLUSHDB and FLUSHALL commands.
+// It takes an array of arguments with the following format: [cmd, [ASYNC|SYNC]].
+// FLUSHDB deletes the keys of the selected database and FLUSHALL those of
+// every database. With ASYNC the old keys are released in the background. It
+// responds with "OK".
+func (c *Client) flush(args []string) {
+	async := false
+	if len(args) > 2 {
+		protocol.WriteError(c.conn, "ERR syntax error")
+		return
+	}
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case "ASYNC":
+			async = true
+		case "SYNC":
+		default:
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+	}
+	cmd := strings.ToUpper(args[0])
+	if cmd == "FLUSHALL" {
+		datastore.FlushAll(async)
+	} else {
+		c.datastore.FlushDB(async)
+	}
+	c.propagate([]string{cmd})
+	protocol.WriteSimpleString(c.conn, "OK")
+}
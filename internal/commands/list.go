@@ -0,0 +1,216 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// push handles the LPUSH and RPUSH commands.
+// It takes an array of arguments with the following format: [cmd, key, value, ...].
+// It responds with the length of the list after the push.
+func (c *Client) push(args []string, left bool) {
+	length, err := c.datastore.Push(args[1], left, args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, int64(length))
+}
+
+// pop handles the LPOP and RPOP commands.
+// It takes an array of arguments with the following format: [cmd, key, [count]].
+// Without a count it responds with a single element, with a count it responds
+// with an array of at most count elements.
+func (c *Client) pop(args []string, left bool) {
+	if len(args) > 3 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			protocol.WriteError(c.conn, "ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	values, err := c.datastore.Pop(args[1], left, count)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if len(values) > 0 {
+		c.dirty = true
+	}
+	switch {
+	case len(args) == 3 && values == nil:
+		protocol.WriteNullArray(c.conn)
+	case len(args) == 3:
+		protocol.WriteArray(c.conn, values)
+	case len(values) == 0:
+		protocol.WriteNullBulkString(c.conn)
+	default:
+		protocol.WriteBulkString(c.conn, values[0])
+	}
+}
+
+// llen handles the LLEN command for the client.
+// It takes an array of arguments with the following format: ["LLEN", key].
+func (c *Client) llen(args []string) {
+	length, err := c.datastore.LLen(args[1])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteInteger(c.conn, int64(length))
+}
+
+// lrange handles the LRANGE command for the client.
+// It takes an array of arguments with the following format: ["LRANGE", key, start, stop].
+func (c *Client) lrange(args []string) {
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	values, err := c.datastore.LRange(args[1], start, stop)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteArray(c.conn, values)
+}
+
+// lindex handles the LINDEX command for the client.
+// It takes an array of arguments with the following format: ["LINDEX", key, index].
+func (c *Client) lindex(args []string) {
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	value, found, err := c.datastore.LIndex(args[1], index)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	protocol.WriteBulkString(c.conn, value)
+}
+
+// lset handles the LSET command for the client.
+// It takes an array of arguments with the following format: ["LSET", key, index, value].
+func (c *Client) lset(args []string) {
+	index, err := strconv.Atoi(args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	if err := c.datastore.LSet(args[1], index, args[3]); err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// lrem handles the LREM command for the client.
+// It takes an array of arguments with the following format: ["LREM", key, count, value].
+func (c *Client) lrem(args []string) {
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	removed, err := c.datastore.LRem(args[1], count, args[3])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(removed))
+}
+
+// ltrim handles the LTRIM command for the client.
+// It takes an array of arguments with the following format: ["LTRIM", key, start, stop].
+func (c *Client) ltrim(args []string) {
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	if err := c.datastore.LTrim(args[1], start, stop); err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// linsert handles the LINSERT command for the client.
+// It takes an array of arguments with the following format: ["LINSERT", key, BEFORE|AFTER, pivot, value].
+func (c *Client) linsert(args []string) {
+	var before bool
+	switch strings.ToUpper(args[2]) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		protocol.WriteError(c.conn, "ERR syntax error")
+		return
+	}
+	length, err := c.datastore.LInsert(args[1], before, args[3], args[4])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if length > 0 {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(length))
+}
+
+// lmove handles the LMOVE command for the client.
+// It takes an array of arguments with the following format: ["LMOVE", source, destination, LEFT|RIGHT, LEFT|RIGHT].
+func (c *Client) lmove(args []string) {
+	fromLeft, ok1 := parseListEnd(args[3])
+	toLeft, ok2 := parseListEnd(args[4])
+	if !ok1 || !ok2 {
+		protocol.WriteError(c.conn, "ERR syntax error")
+		return
+	}
+	value, found, err := c.datastore.LMove(args[1], args[2], fromLeft, toLeft)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	c.dirty = true
+	protocol.WriteBulkString(c.conn, value)
+}
+
+// parseListEnd parses a LEFT or RIGHT argument, returning true for LEFT.
+func parseListEnd(arg string) (bool, bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,169 @@
+package commands
+
+import (
+	"strings"
+
+	"github.com/manimovassagh/Godis/internal/protocol"
+	"github.com/manimovassagh/Godis/internal/pubsub"
+)
+
+// subscriberCommands are the commands a client may run while it has
+// subscriptions.
+var subscriberCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+}
+
+// subscriber returns the subscriber of the client, registering it with the
+// broker and starting the delivery of its messages on first use. A client
+// that falls too far behind is disconnected.
+func (c *Client) subscriber() *pubsub.Subscriber {
+	if c.sub == nil {
+		conn := c.conn
+		c.sub = pubsub.GetBroker().NewSubscriber(func() { conn.Close() })
+		go c.deliverMessages(c.sub)
+	}
+	return c.sub
+}
+
+// deliverMessages writes the messages received by sub to the client until
+// sub is closed.
+func (c *Client) deliverMessages(sub *pubsub.Subscriber) {
+	for {
+		messages, ok := sub.Next()
+		if !ok {
+			return
+		}
+		c.writeMu.Lock()
+		for _, m := range messages {
+			if m.Pattern == "" {
+				protocol.WriteArray(c.conn, []string{"message", m.Channel, m.Payload})
+			} else {
+				protocol.WriteArray(c.conn, []string{"pmessage", m.Pattern, m.Channel, m.Payload})
+			}
+		}
+		c.writeMu.Unlock()
+	}
+}
+
+// closePubSub removes the subscriptions of a disconnecting client.
+func (c *Client) closePubSub() {
+	if c.sub != nil {
+		pubsub.GetBroker().Close(c.sub)
+	}
+}
+
+// subscribe handles the SUBSCRIBE and PSUBSCRIBE commands.
+// It takes an array of arguments with the following format: [cmd, channel|pattern, ...].
+// It responds with a [subscribe|psubscribe, name, count] message per name,
+// where count is the number of subscriptions the client has afterwards.
+func (c *Client) subscribe(args []string, pattern bool) {
+	broker, sub := pubsub.GetBroker(), c.subscriber()
+	kind := strings.ToLower(args[0])
+	for _, name := range args[1:] {
+		if pattern {
+			c.subscriptions = broker.PSubscribe(sub, name)
+		} else {
+			c.subscriptions = broker.Subscribe(sub, name)
+		}
+		c.writeSubscription(kind, name, true)
+	}
+}
+
+// unsubscribe handles the UNSUBSCRIBE and PUNSUBSCRIBE commands.
+// It takes an array of arguments with the following format: [cmd, [channel|pattern, ...]].
+// Without names, the client is unsubscribed from all its channels or
+// patterns. It responds with an [unsubscribe|punsubscribe, name, count]
+// message per name, or a single message with a null name if there was
+// nothing to unsubscribe from.
+func (c *Client) unsubscribe(args []string, pattern bool) {
+	kind := strings.ToLower(args[0])
+	if c.sub == nil {
+		if len(args) == 1 {
+			c.writeSubscription(kind, "", false)
+		}
+		for _, name := range args[1:] {
+			c.writeSubscription(kind, name, true)
+		}
+		return
+	}
+	broker := pubsub.GetBroker()
+	names := args[1:]
+	if len(names) == 0 {
+		if pattern {
+			names = broker.Patterns(c.sub)
+		} else {
+			names = broker.Channels(c.sub)
+		}
+		if len(names) == 0 {
+			c.writeSubscription(kind, "", false)
+			return
+		}
+	}
+	for _, name := range names {
+		if pattern {
+			c.subscriptions = broker.PUnsubscribe(c.sub, name)
+		} else {
+			c.subscriptions = broker.Unsubscribe(c.sub, name)
+		}
+		c.writeSubscription(kind, name, true)
+	}
+}
+
+// writeSubscription writes a subscription change message, with a null name
+// unless hasName is set.
+func (c *Client) writeSubscription(kind, name string, hasName bool) {
+	protocol.WriteArrayHeader(c.conn, 3)
+	protocol.WriteBulkString(c.conn, kind)
+	if hasName {
+		protocol.WriteBulkString(c.conn, name)
+	} else {
+		protocol.WriteNullBulkString(c.conn)
+	}
+	protocol.WriteInteger(c.conn, int64(c.subscriptions))
+}
+
+// publish handles the PUBLISH command for the client.
+// It takes an array of arguments with the following format: ["PUBLISH", channel, message].
+// It responds with the number of clients that received the message.
+func (c *Client) publish(args []string) {
+	protocol.WriteInteger(c.conn, int64(pubsub.GetBroker().Publish(args[1], args[2])))
+}
+
+// pubsubChannels handles the PUBSUB CHANNELS command for the client.
+// It takes an array of arguments with the following format: ["PUBSUB", "CHANNELS", [pattern]].
+// It responds with the channels that have subscribers, matching the pattern
+// if one is given.
+func (c *Client) pubsubChannels(args []string) {
+	if len(args) > 3 {
+		protocol.WriteError(c.conn, errWrongArgs("PUBSUB|CHANNELS"))
+		return
+	}
+	pattern := ""
+	if len(args) == 3 {
+		pattern = args[2]
+	}
+	protocol.WriteArray(c.conn, pubsub.GetBroker().ActiveChannels(pattern))
+}
+
+// pubsubNumSub handles the PUBSUB NUMSUB command for the client.
+// It takes an array of arguments with the following format: ["PUBSUB", "NUMSUB", [channel, ...]].
+// It responds with each channel followed by its number of subscribers.
+func (c *Client) pubsubNumSub(args []string) {
+	broker := pubsub.GetBroker()
+	protocol.WriteArrayHeader(c.conn, 2*(len(args)-2))
+	for _, channel := range args[2:] {
+		protocol.WriteBulkString(c.conn, channel)
+		protocol.WriteInteger(c.conn, int64(broker.NumSub(channel)))
+	}
+}
+
+// pubsubNumPat handles the PUBSUB NUMPAT command for the client.
+// It takes an array of arguments with the following format: ["PUBSUB", "NUMPAT"].
+// It responds with the number of pattern subscriptions.
+func (c *Client) pubsubNumPat(args []string) {
+	protocol.WriteInteger(c.conn, int64(pubsub.GetBroker().NumPat()))
+}
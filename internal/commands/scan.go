@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// scanOptions holds the parsed arguments shared by the SCAN command family.
+type scanOptions struct {
+	cursor   uint64
+	pattern  string
+	count    int
+	noValues bool
+	typeName string
+}
+
+// scanTypes holds the type names accepted by the TYPE option of SCAN.
+var scanTypes = map[string]bool{
+	"string": true, "list": true, "set": true, "zset": true, "hash": true, "stream": true,
+}
+
+// parseScanArgs parses the cursor found at args[i] and the MATCH and COUNT
+// options following it, plus NOVALUES when allowNoValues is set and TYPE when
+// allowType is set. On failure it writes the error to the client and returns
+// false.
+func (c *Client) parseScanArgs(args []string, i int, allowNoValues, allowType bool) (scanOptions, bool) {
+	opts := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(args[i], 10, 64)
+	if err != nil {
+		protocol.WriteError(c.conn, "ERR invalid cursor")
+		return opts, false
+	}
+	opts.cursor = cursor
+	for i++; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "MATCH" && i+1 < len(args):
+			opts.pattern = args[i+1]
+			i++
+		case opt == "COUNT" && i+1 < len(args):
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				protocol.WriteError(c.conn, errNotInteger)
+				return opts, false
+			}
+			if count < 1 {
+				protocol.WriteError(c.conn, "ERR syntax error")
+				return opts, false
+			}
+			opts.count = count
+			i++
+		case opt == "NOVALUES" && allowNoValues:
+			opts.noValues = true
+		case opt == "TYPE" && allowType && i+1 < len(args):
+			opts.typeName = strings.ToLower(args[i+1])
+			if !scanTypes[opts.typeName] {
+				protocol.WriteError(c.conn, "ERR unknown type name '"+args[i+1]+"'")
+				return opts, false
+			}
+			i++
+		default:
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return opts, false
+		}
+	}
+	return opts, true
+}
+
+// writeScanReply writes the two element reply of the SCAN command family: the
+// cursor to continue from and the elements returned by this call.
+func (c *Client) writeScanReply(cursor uint64, elements []string) {
+	protocol.WriteArrayHeader(c.conn, 2)
+	protocol.WriteBulkString(c.conn, strconv.FormatUint(cursor, 10))
+	protocol.WriteArray(c.conn, elements)
+}
+
+// scan handles the SCAN command for the client.
+// It takes an array of arguments with the following format:
+// ["SCAN", cursor, [MATCH pattern], [COUNT count], [TYPE type]].
+// It responds with the next cursor and the matching keys. Every key present
+// for the whole iteration is returned at least once, but a key may be
+// returned several times.
+func (c *Client) scan(args []string) {
+	opts, ok := c.parseScanArgs(args, 1, false, true)
+	if !ok {
+		return
+	}
+	next, keys := c.datastore.Scan(opts.cursor, opts.count, opts.pattern, opts.typeName)
+	c.writeScanReply(next, keys)
+}
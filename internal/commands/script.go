@@ -0,0 +1,500 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/config"
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/lua"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+const (
+	errBusy         = "BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE."
+	errBusyFunction = "BUSY Redis is busy running a script. You can only call FUNCTION KILL or SHUTDOWN NOSAVE."
+	// errScriptKilled is the error a script killed by SCRIPT KILL fails with.
+	errScriptKilled = "Script killed by user with SCRIPT KILL..."
+	// errFunctionKilled is the error a function killed by FUNCTION KILL
+	// fails with.
+	errFunctionKilled = "Script killed by user with FUNCTION KILL..."
+)
+
+// scriptTimeLimit is the time in milliseconds a script may run before the
+// other clients are answered with a BUSY error instead of waiting for it.
+var scriptTimeLimit atomic.Int64
+
+func init() {
+	scriptTimeLimit.Store(5000)
+	config.Register(config.IntParam("busy-reply-threshold", &scriptTimeLimit, 0, math.MaxInt64))
+	// lua-time-limit is the former name of busy-reply-threshold.
+	config.Register(config.IntParam("lua-time-limit", &scriptTimeLimit, 0, math.MaxInt64))
+}
+
+// scripts caches the compiled scripts by the SHA1 digest of their source.
+var scripts = struct {
+	sync.Mutex
+	bySHA map[string]*lua.Chunk
+}{bySHA: make(map[string]*lua.Chunk)}
+
+// runningScript describes the script being executed, if any. Scripts hold
+// commandLock for writing, so at most one runs at a time. function is the
+// name of the function being called, empty for EVAL scripts, and command the
+// command that runs it. killed is set by SCRIPT KILL or FUNCTION KILL and
+// checked by the interrupt hook of the script.
+var runningScript struct {
+	sync.Mutex
+	active   bool
+	started  time.Time
+	killed   bool
+	function string
+	command  []string
+}
+
+// sha1hex returns the hexadecimal SHA1 digest of s.
+func sha1hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadScript compiles a script and caches it, returning its SHA1 digest.
+func loadScript(src string) (string, *lua.Chunk, error) {
+	sha := sha1hex(src)
+	scripts.Lock()
+	defer scripts.Unlock()
+	if chunk, found := scripts.bySHA[sha]; found {
+		return sha, chunk, nil
+	}
+	chunk, err := lua.Compile(src, "user_script")
+	if err != nil {
+		return "", nil, err
+	}
+	scripts.bySHA[sha] = chunk
+	return sha, chunk, nil
+}
+
+// busyError returns the error replied to other commands while a script has
+// been running for longer than the time limit, or "" if none has.
+func busyError() string {
+	runningScript.Lock()
+	defer runningScript.Unlock()
+	limit := time.Duration(scriptTimeLimit.Load()) * time.Millisecond
+	switch {
+	case !runningScript.active || time.Since(runningScript.started) < limit:
+		return ""
+	case runningScript.function != "":
+		return errBusyFunction
+	}
+	return errBusy
+}
+
+// isScriptControl reports whether args is a command flagged allow-busy, such
+// as SCRIPT KILL, FUNCTION KILL or FUNCTION STATS, which runs without
+// commandLock so that it can inspect or interrupt the script holding it.
+func isScriptControl(args []string) bool {
+	spec, err := lookupCommand(args)
+	return err == nil && spec.flags&flagAllowBusy != 0
+}
+
+// eval handles the EVAL and EVALSHA commands for the client.
+// It takes an array of arguments with the following format:
+// ["EVAL", script, numkeys, key ..., arg ...] or
+// ["EVALSHA", sha1, numkeys, key ..., arg ...].
+// The caller must hold commandLock for writing.
+func (c *Client) eval(args []string, bySHA bool) {
+	keys, argv, ok := c.scriptKeys(args)
+	if !ok {
+		return
+	}
+	var chunk *lua.Chunk
+	var err error
+	if bySHA {
+		scripts.Lock()
+		chunk = scripts.bySHA[strings.ToLower(args[1])]
+		scripts.Unlock()
+		if chunk == nil {
+			protocol.WriteError(c.conn, "NOSCRIPT No matching script. Please use EVAL.")
+			return
+		}
+	} else if _, chunk, err = loadScript(args[1]); err != nil {
+		protocol.WriteError(c.conn, "ERR Error compiling script (new function): "+err.Error())
+		return
+	}
+
+	s := lua.NewState()
+	s.SetGlobal("KEYS", stringsToTable(keys))
+	s.SetGlobal("ARGV", stringsToTable(argv))
+	s.SetGlobal("redis", c.bindRedisCalls(newRedisLib(), false))
+	s.SetStrictGlobals(true)
+	c.runScript(s, args, "", func() ([]lua.Value, error) {
+		return s.Run(chunk)
+	})
+}
+
+// scriptKeys splits the keys and the arguments of an EVAL, EVALSHA, FCALL or
+// FCALL_RO command, whose third argument is the number of keys. It replies
+// with an error and returns false if the arguments are invalid.
+func (c *Client) scriptKeys(args []string) (keys, argv []string, ok bool) {
+	numKeys, err := strconv.Atoi(args[2])
+	switch {
+	case err != nil:
+		protocol.WriteError(c.conn, errNotInteger)
+		return nil, nil, false
+	case numKeys < 0:
+		protocol.WriteError(c.conn, "ERR Number of keys can't be negative")
+		return nil, nil, false
+	case numKeys > len(args)-3:
+		protocol.WriteError(c.conn, "ERR Number of keys can't be greater than number of args")
+		return nil, nil, false
+	}
+	return args[3 : 3+numKeys], args[3+numKeys:], true
+}
+
+// runScript runs a script or a function on state s by calling run, and
+// replies with its result. function is the name of the function, or "" for
+// an EVAL script, and args the command running it. The write commands it
+// calls are logged to the AOF as a MULTI/EXEC block, unless the script runs
+// inside a transaction which already is one.
+func (c *Client) runScript(s *lua.State, args []string, function string, run func() ([]lua.Value, error)) {
+	killedErr := errScriptKilled
+	if function != "" {
+		killedErr = errFunctionKilled
+	}
+	s.SetInterrupt(func() error {
+		runningScript.Lock()
+		defer runningScript.Unlock()
+		if runningScript.killed {
+			return errors.New(killedErr)
+		}
+		return nil
+	})
+
+	runningScript.Lock()
+	runningScript.active, runningScript.started, runningScript.killed = true, time.Now(), false
+	runningScript.function, runningScript.command = function, args
+	runningScript.Unlock()
+	defer func() {
+		runningScript.Lock()
+		runningScript.active = false
+		runningScript.Unlock()
+	}()
+	if !c.inExec {
+		c.aof.BeginTransaction()
+		defer c.aof.EndTransaction()
+	}
+
+	results, err := run()
+	if err != nil {
+		protocol.WriteError(c.conn, scriptErrorReply(err))
+		return
+	}
+	var result lua.Value
+	if len(results) > 0 {
+		result = results[0]
+	}
+	writeLuaValue(c.conn, result)
+}
+
+// scriptErrorReply returns the error reply of a failed script. Errors
+// raised with an error table, such as failed redis.call commands, are
+// replied as is.
+func scriptErrorReply(err error) string {
+	if e, ok := err.(*lua.Error); ok {
+		if t, ok := e.Value.(*lua.Table); ok {
+			if msg, ok := t.GetString("err").(string); ok {
+				return msg
+			}
+		}
+	}
+	return "ERR " + err.Error()
+}
+
+// stringsToTable returns a Lua array of strings.
+func stringsToTable(values []string) *lua.Table {
+	t := lua.NewTable()
+	for _, v := range values {
+		t.Append(v)
+	}
+	return t
+}
+
+// errorTable returns a Lua error reply table.
+func errorTable(msg string) *lua.Table {
+	t := lua.NewTable()
+	t.SetString("err", msg)
+	return t
+}
+
+// bindRedisCalls sets the call and pcall functions of a redis table to run
+// commands on behalf of the client, and returns the table. With noWrites,
+// the commands which may modify the data set are rejected.
+func (c *Client) bindRedisCalls(t *lua.Table, noWrites bool) *lua.Table {
+	conn := &scriptConn{}
+	// The commands called by the script run on a client of their own, whose
+	// replies are parsed back into Lua values. Like the commands of a
+	// transaction they can not block.
+	sc := &Client{id: c.id, conn: conn, datastore: c.datastore, aof: c.aof, inExec: true, oom: c.oom}
+	call := func(raise bool) lua.GoFunction {
+		return func(s *lua.State, args []lua.Value) []lua.Value {
+			reply := sc.scriptCall(conn, args, noWrites)
+			if e, ok := reply.(*lua.Table); ok && e.GetString("err") != nil && raise {
+				s.RaiseValue(e)
+			}
+			return []lua.Value{reply}
+		}
+	}
+	t.SetString("call", lua.NewFunction("call", call(true)))
+	t.SetString("pcall", lua.NewFunction("pcall", call(false)))
+	return t
+}
+
+// newRedisLib returns a redis table with the library functions which do not
+// depend on the client running the script.
+func newRedisLib() *lua.Table {
+	t := lua.NewTable()
+	t.SetString("error_reply", lua.NewFunction("error_reply", func(s *lua.State, args []lua.Value) []lua.Value {
+		msg, ok := luaStringArg(args)
+		if !ok {
+			s.Raise("wrong number or type of arguments")
+		}
+		return []lua.Value{errorTable(msg)}
+	}))
+	t.SetString("status_reply", lua.NewFunction("status_reply", func(s *lua.State, args []lua.Value) []lua.Value {
+		msg, ok := luaStringArg(args)
+		if !ok {
+			s.Raise("wrong number or type of arguments")
+		}
+		status := lua.NewTable()
+		status.SetString("ok", msg)
+		return []lua.Value{status}
+	}))
+	t.SetString("sha1hex", lua.NewFunction("sha1hex", func(s *lua.State, args []lua.Value) []lua.Value {
+		str, ok := luaStringArg(args)
+		if !ok {
+			s.Raise("wrong number of arguments")
+		}
+		return []lua.Value{sha1hex(str)}
+	}))
+	t.SetString("log", lua.NewFunction("log", func(s *lua.State, args []lua.Value) []lua.Value {
+		if len(args) < 2 {
+			s.Raise("redis.log() requires two arguments or more.")
+		}
+		if _, ok := args[0].(float64); !ok {
+			s.Raise("First argument must be a number (log level).")
+		}
+		parts := make([]string, 0, len(args)-1)
+		for _, v := range args[1:] {
+			str, _ := lua.ToString(v)
+			parts = append(parts, str)
+		}
+		log.Printf("Script log: %s", strings.Join(parts, " "))
+		return nil
+	}))
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		t.SetString(level, float64(i))
+	}
+	return t
+}
+
+// luaStringArg returns the single string argument of a redis library
+// function.
+func luaStringArg(args []lua.Value) (string, bool) {
+	if len(args) != 1 {
+		return "", false
+	}
+	return lua.ToString(args[0])
+}
+
+// scriptCall runs a command called by a script with redis.call or
+// redis.pcall and returns its reply as a Lua value. Errors are returned as
+// error tables. With noWrites, the commands which may modify the data set
+// are rejected.
+func (c *Client) scriptCall(conn *scriptConn, luaArgs []lua.Value, noWrites bool) lua.Value {
+	if len(luaArgs) == 0 {
+		return errorTable("ERR Please specify at least one argument for this redis lib call")
+	}
+	args := make([]string, len(luaArgs))
+	for i, v := range luaArgs {
+		str, ok := lua.ToString(v)
+		if !ok {
+			return errorTable("ERR Lua redis lib command arguments must be strings or integers")
+		}
+		args[i] = str
+	}
+	spec, err := lookupCommand(args)
+	switch {
+	case errors.Is(err, errUnknownCommand) || errors.Is(err, errUnknownSubcommand):
+		return errorTable("ERR Unknown Redis command called from script")
+	case spec.flags&flagNoScript != 0:
+		return errorTable("ERR This Redis command is not allowed from script")
+	case noWrites && spec.flags&flagWrite != 0:
+		return errorTable("ERR Write commands are not allowed from read-only scripts.")
+	case err != nil:
+		return errorTable("ERR Wrong number of args calling Redis command from script")
+	case c.oom && spec.flags&flagDenyOOM != 0:
+		return errorTable(datastore.ErrOOM.Error())
+	}
+	if msg := c.diskError(spec); msg != "" {
+		return errorTable(msg)
+	}
+
+	conn.buf.Reset()
+	c.call(spec, args)
+	reply, err := protocol.ReadReply(bufio.NewReader(&conn.buf))
+	if err != nil {
+		return errorTable("ERR " + err.Error())
+	}
+	return replyToLua(reply)
+}
+
+// replyToLua converts a command reply to a Lua value: status and error
+// replies become tables with an ok or err field, nulls become false.
+func replyToLua(r protocol.Reply) lua.Value {
+	switch {
+	case r.Null:
+		return false
+	case r.Type == '+':
+		t := lua.NewTable()
+		t.SetString("ok", r.Str)
+		return t
+	case r.Type == '-':
+		return errorTable(r.Str)
+	case r.Type == ':':
+		return float64(r.Int)
+	case r.Type == '*':
+		t := lua.NewTable()
+		for _, e := range r.Elements {
+			t.Append(replyToLua(e))
+		}
+		return t
+	}
+	return r.Str
+}
+
+// writeLuaValue writes a value returned by a script as a reply. Numbers are
+// truncated to integers, except those too large for one, which are written
+// as bulk strings, true becomes 1 and false a null reply, and arrays stop at
+// their first nil.
+func writeLuaValue(conn net.Conn, v lua.Value) {
+	switch v := v.(type) {
+	case string:
+		protocol.WriteBulkString(conn, v)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			protocol.WriteInteger(conn, math.MinInt64)
+		case v >= 1<<63 || v < -1<<63:
+			protocol.WriteBulkString(conn, lua.FormatNumber(v))
+		default:
+			protocol.WriteInteger(conn, int64(v))
+		}
+	case bool:
+		if v {
+			protocol.WriteInteger(conn, 1)
+		} else {
+			protocol.WriteNullBulkString(conn)
+		}
+	case *lua.Table:
+		if msg, ok := v.GetString("err").(string); ok {
+			protocol.WriteError(conn, msg)
+			return
+		}
+		if status, ok := v.GetString("ok").(string); ok {
+			protocol.WriteSimpleString(conn, status)
+			return
+		}
+		n := 0
+		for v.Get(float64(n+1)) != nil {
+			n++
+		}
+		protocol.WriteArrayHeader(conn, n)
+		for i := 1; i <= n; i++ {
+			writeLuaValue(conn, v.Get(float64(i)))
+		}
+	default:
+		protocol.WriteNullBulkString(conn)
+	}
+}
+
+// scriptConn collects the replies of the commands called by a script.
+type scriptConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (sc *scriptConn) Write(b []byte) (int, error) {
+	return sc.buf.Write(b)
+}
+
+// scriptLoad handles the SCRIPT LOAD command for the client.
+// It takes an array of arguments with the following format: ["SCRIPT", "LOAD", script].
+// It caches the script and responds with its SHA1 digest.
+func (c *Client) scriptLoad(args []string) {
+	sha, _, err := loadScript(args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, "ERR Error compiling script (new function): "+err.Error())
+		return
+	}
+	protocol.WriteBulkString(c.conn, sha)
+}
+
+// scriptExists handles the SCRIPT EXISTS command for the client.
+// It takes an array of arguments with the following format: ["SCRIPT", "EXISTS", sha1, ...].
+// It responds with 1 for every cached script and 0 for the others.
+func (c *Client) scriptExists(args []string) {
+	scripts.Lock()
+	defer scripts.Unlock()
+	protocol.WriteArrayHeader(c.conn, len(args)-2)
+	for _, sha := range args[2:] {
+		if _, found := scripts.bySHA[strings.ToLower(sha)]; found {
+			protocol.WriteInteger(c.conn, 1)
+		} else {
+			protocol.WriteInteger(c.conn, 0)
+		}
+	}
+}
+
+// scriptFlush handles the SCRIPT FLUSH command for the client.
+// It takes an array of arguments with the following format: ["SCRIPT", "FLUSH", [ASYNC|SYNC]].
+// It empties the script cache.
+func (c *Client) scriptFlush(args []string) {
+	if len(args) > 3 || (len(args) == 3 && !strings.EqualFold(args[2], "ASYNC") && !strings.EqualFold(args[2], "SYNC")) {
+		protocol.WriteError(c.conn, "ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+		return
+	}
+	scripts.Lock()
+	clear(scripts.bySHA)
+	scripts.Unlock()
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// killScript stops the running EVAL script, or with function the running
+// function, if it has not written yet.
+func (c *Client) killScript(function bool) {
+	runningScript.Lock()
+	defer runningScript.Unlock()
+	switch {
+	case !runningScript.active:
+		protocol.WriteError(c.conn, "NOTBUSY No scripts in execution right now.")
+	case function && runningScript.function == "":
+		protocol.WriteError(c.conn, errBusy)
+	case !function && runningScript.function != "":
+		protocol.WriteError(c.conn, errBusyFunction)
+	case c.aof.TransactionWritten():
+		protocol.WriteError(c.conn, "UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	default:
+		runningScript.killed = true
+		protocol.WriteSimpleString(c.conn, "OK")
+	}
+}
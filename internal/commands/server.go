@@ -0,0 +1,441 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/aof"
+	"github.com/manimovassagh/Godis/internal/config"
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// configGet handles the CONFIG GET command for the client.
+// It takes an array of arguments with the following format: ["CONFIG", "GET", pattern, ...].
+// It responds with the matching parameters and their values.
+func (c *Client) configGet(args []string) {
+	seen := make(map[string]bool)
+	var pairs []string
+	for _, pattern := range args[2:] {
+		for _, p := range config.Match(pattern) {
+			if !seen[p.Name] {
+				seen[p.Name] = true
+				pairs = append(pairs, p.Name, p.Get())
+			}
+		}
+	}
+	protocol.WriteArray(c.conn, pairs)
+}
+
+// configSet handles the CONFIG SET command for the client.
+// It takes an array of arguments with the following format: ["CONFIG", "SET", name, value, ...].
+func (c *Client) configSet(args []string) {
+	if len(args)%2 != 0 {
+		protocol.WriteError(c.conn, errWrongArgs("CONFIG|SET"))
+		return
+	}
+	for i := 2; i < len(args); i += 2 {
+		if err := config.Set(args[i], args[i+1]); err != nil {
+			protocol.WriteError(c.conn, err.Error())
+			return
+		}
+	}
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// objectEncoding handles the OBJECT ENCODING command for the client.
+// It takes an array of arguments with the following format: ["OBJECT", "ENCODING", key].
+// It responds with the internal encoding of the value stored at key.
+func (c *Client) objectEncoding(args []string) {
+	encoding, found := c.datastore.Encoding(args[2])
+	if !found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	protocol.WriteBulkString(c.conn, encoding)
+}
+
+// objectIdleTime handles the OBJECT IDLETIME command for the client.
+// It takes an array of arguments with the following format: ["OBJECT", "IDLETIME", key].
+// It responds with the number of seconds since the key was last accessed.
+func (c *Client) objectIdleTime(args []string) {
+	if datastore.LFUPolicy() {
+		protocol.WriteError(c.conn, "ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		return
+	}
+	idle, found := c.datastore.IdleTime(args[2])
+	if !found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	protocol.WriteInteger(c.conn, idle)
+}
+
+// objectFreq handles the OBJECT FREQ command for the client.
+// It takes an array of arguments with the following format: ["OBJECT", "FREQ", key].
+// It responds with the logarithmic access frequency counter of the key.
+func (c *Client) objectFreq(args []string) {
+	if !datastore.LFUPolicy() {
+		protocol.WriteError(c.conn, "ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		return
+	}
+	freq, found := c.datastore.Frequency(args[2])
+	if !found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	protocol.WriteInteger(c.conn, freq)
+}
+
+// memoryUsage handles the MEMORY USAGE command for the client.
+// It takes an array of arguments with the following format: ["MEMORY", "USAGE", key, [SAMPLES count]].
+// It responds with the estimated number of bytes taken by the key and its
+// value, sizing aggregate values from count elements (5 by default, all of
+// them if 0).
+func (c *Client) memoryUsage(args []string) {
+	samples := 5
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToUpper(args[3]) != "SAMPLES" {
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+		n, err := strconv.Atoi(args[4])
+		if err != nil || n < 0 {
+			protocol.WriteError(c.conn, "ERR value is out of range, must be positive")
+			return
+		}
+		samples = n
+	}
+	size, found := c.datastore.MemoryUsage(args[2], samples)
+	if !found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	protocol.WriteInteger(c.conn, size)
+}
+
+// infoSections lists the sections of INFO in the order they are reported.
+var infoSections = []string{"memory", "persistence", "stats", "keyspace"}
+
+// info handles the INFO command for the client.
+// It takes an array of arguments with the following format: ["INFO", [section ...]].
+// It responds with the requested sections, or all of them if none is given
+// or one is "all", "default" or "everything", as lines of field:value pairs.
+func (c *Client) info(args []string) {
+	requested := make(map[string]bool)
+	for _, section := range args[1:] {
+		requested[strings.ToLower(section)] = true
+	}
+	all := len(args) == 1 || requested["all"] || requested["default"] || requested["everything"]
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !requested[section] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		switch section {
+		case "memory":
+			used, limit := datastore.UsedMemory(), datastore.MaxMemory()
+			b.WriteString("# Memory\r\n")
+			fmt.Fprintf(&b, "used_memory:%d\r\nused_memory_human:%s\r\n", used, bytesToHuman(used))
+			fmt.Fprintf(&b, "maxmemory:%d\r\nmaxmemory_human:%s\r\n", limit, bytesToHuman(limit))
+			fmt.Fprintf(&b, "maxmemory_policy:%s\r\n", datastore.MaxMemoryPolicy())
+		case "persistence":
+			stats := c.aof.Stats()
+			b.WriteString("# Persistence\r\n")
+			fmt.Fprintf(&b, "aof_enabled:1\r\naof_rewrite_in_progress:%d\r\naof_rewrite_scheduled:%d\r\n",
+				boolToInt(stats.RewriteInProgress), boolToInt(stats.RewriteScheduled))
+			fmt.Fprintf(&b, "aof_last_rewrite_time_sec:%d\r\naof_current_rewrite_time_sec:%d\r\n",
+				durationToSeconds(stats.LastRewriteTime), durationToSeconds(stats.CurrentRewriteTime))
+			fmt.Fprintf(&b, "aof_last_bgrewrite_status:%s\r\naof_rewrites:%d\r\n", errorStatus(stats.LastRewriteErr), stats.Rewrites)
+			fmt.Fprintf(&b, "aof_last_write_status:%s\r\n", errorStatus(c.aof.WriteError()))
+			fmt.Fprintf(&b, "aof_current_size:%d\r\naof_base_size:%d\r\n", stats.CurrentSize, stats.BaseSize)
+			fmt.Fprintf(&b, "aof_delayed_fsync:%d\r\n", stats.DelayedFsyncs)
+		case "stats":
+			b.WriteString("# Stats\r\n")
+			fmt.Fprintf(&b, "evicted_keys:%d\r\n", datastore.EvictedKeys())
+			fmt.Fprintf(&b, "lazyfreed_objects:%d\r\n", datastore.LazyfreedObjects())
+		case "keyspace":
+			b.WriteString("# Keyspace\r\n")
+			for id := range datastore.Databases() {
+				if keys, expires := datastore.GetDatabase(id).KeyCounts(); keys > 0 {
+					fmt.Fprintf(&b, "db%d:keys=%d,expires=%d\r\n", id, keys, expires)
+				}
+			}
+		}
+	}
+	protocol.WriteBulkString(c.conn, b.String())
+}
+
+// boolToInt returns 1 if b is set and 0 otherwise, as INFO reports flags.
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// durationToSeconds returns d in whole seconds, or -1 if d is negative, as
+// INFO reports durations that do not apply.
+func durationToSeconds(d time.Duration) int64 {
+	if d < 0 {
+		return -1
+	}
+	return int64(d / time.Second)
+}
+
+// errorStatus returns the status INFO reports for the outcome of an
+// operation: ok, or err if it failed.
+func errorStatus(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
+
+// bgrewriteaof handles the BGREWRITEAOF command for the client.
+// It takes an array of arguments with the following format: ["BGREWRITEAOF"].
+// It starts rewriting the AOF in the background. It runs holding
+// commandLock for writing, as rewriteAOF requires.
+func (c *Client) bgrewriteaof(args []string) {
+	if err := rewriteAOF(c.aof); err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteSimpleString(c.conn, "Background append only file rewriting started")
+}
+
+// rewriteAOF starts rewriting the AOF of handler from a snapshot of the
+// function libraries and of every database. The caller must hold
+// commandLock for writing, so that the snapshot reflects exactly the
+// commands logged so far.
+func rewriteAOF(handler *aof.AOFHandler) error {
+	return handler.Rewrite(func() aof.Snapshot {
+		codes := libraryCodes()
+		snapshots := make([]*datastore.Snapshot, 0, datastore.Databases())
+		for id := range datastore.Databases() {
+			snapshots = append(snapshots, datastore.GetDatabase(id).Snapshot())
+		}
+		return func(emit func(args []string)) {
+			for _, code := range codes {
+				emit([]string{"FUNCTION", "LOAD", code})
+			}
+			for _, s := range snapshots {
+				s.Rewrite(emit)
+			}
+		}
+	})
+}
+
+// bytesToHuman formats a number of bytes the way INFO does, such as 1.50M.
+func bytesToHuman(n int64) string {
+	units := []string{"B", "K", "M", "G", "T", "P"}
+	if n < 1024 {
+		return strconv.FormatInt(n, 10) + "B"
+	}
+	size, unit := float64(n), 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(size, 'f', 2, 64) + units[unit]
+}
+
+// clientID handles the CLIENT ID command for the client.
+// It takes an array of arguments with the following format: ["CLIENT", "ID"].
+// It responds with the ID of the connection.
+func (c *Client) clientID(args []string) {
+	protocol.WriteInteger(c.conn, c.id)
+}
+
+// clientUnblock handles the CLIENT UNBLOCK command for the client.
+// It takes an array of arguments with the following format: ["CLIENT", "UNBLOCK", id, [TIMEOUT|ERROR]].
+// It cancels the blocking command of another connection, which then replies
+// as if it timed out, or with an UNBLOCKED error.
+func (c *Client) clientUnblock(args []string) {
+	if len(args) > 4 {
+		protocol.WriteError(c.conn, errWrongArgs("CLIENT|UNBLOCK"))
+		return
+	}
+	id, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	withError := false
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3]) {
+		case "TIMEOUT":
+		case "ERROR":
+			withError = true
+		default:
+			protocol.WriteError(c.conn, "ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+			return
+		}
+	}
+	if unblockClient(id, withError) {
+		protocol.WriteInteger(c.conn, 1)
+	} else {
+		protocol.WriteInteger(c.conn, 0)
+	}
+}
+
+// commandCmd handles the COMMAND command for the client.
+// It takes an array of arguments with the following format: ["COMMAND"].
+// It responds with the description of every command, as COMMAND INFO does.
+func (c *Client) commandCmd(args []string) {
+	specs := sortedCommands()
+	protocol.WriteArrayHeader(c.conn, len(specs))
+	for _, spec := range specs {
+		c.writeCommandInfo(spec)
+	}
+}
+
+// commandCount handles the COMMAND COUNT command for the client.
+// It takes an array of arguments with the following format: ["COMMAND", "COUNT"].
+func (c *Client) commandCount(args []string) {
+	protocol.WriteInteger(c.conn, int64(len(commandTable)))
+}
+
+// commandInfo handles the COMMAND INFO command for the client.
+// It takes an array of arguments with the following format: ["COMMAND", "INFO", [name, ...]].
+// It responds with the description of the named commands, or of every
+// command if none is named, and a null for unknown names. Subcommands are
+// named container|subcommand.
+func (c *Client) commandInfo(args []string) {
+	if len(args) == 2 {
+		c.commandCmd(args)
+		return
+	}
+	protocol.WriteArrayHeader(c.conn, len(args)-2)
+	for _, name := range args[2:] {
+		if spec := commandByName(name); spec != nil {
+			c.writeCommandInfo(spec)
+		} else {
+			protocol.WriteNullBulkString(c.conn)
+		}
+	}
+}
+
+// commandByName returns the spec of the command with the given name, which
+// names a subcommand as container|subcommand, or nil if there is none.
+func commandByName(name string) *commandSpec {
+	container, sub, found := strings.Cut(name, "|")
+	spec := commandTable[strings.ToUpper(container)]
+	if spec == nil || !found {
+		return spec
+	}
+	return spec.subcommand(sub)
+}
+
+// writeCommandInfo writes the description of a command: its name, arity,
+// flags, first key, last key, key step, ACL categories, tips, key
+// specifications and subcommands.
+func (c *Client) writeCommandInfo(spec *commandSpec) {
+	protocol.WriteArrayHeader(c.conn, 10)
+	protocol.WriteBulkString(c.conn, strings.ToLower(spec.fullName()))
+	protocol.WriteInteger(c.conn, int64(spec.arity))
+	var flags []string
+	for _, f := range flagNames {
+		if spec.flags&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	if spec.keys != nil {
+		flags = append(flags, "movablekeys")
+	}
+	c.writeSimpleStrings(flags)
+	protocol.WriteInteger(c.conn, int64(spec.firstKey))
+	protocol.WriteInteger(c.conn, int64(spec.lastKey))
+	protocol.WriteInteger(c.conn, int64(spec.keyStep))
+	c.writeSimpleStrings(spec.categories())
+	protocol.WriteArrayHeader(c.conn, 0)
+	protocol.WriteArrayHeader(c.conn, 0)
+	protocol.WriteArrayHeader(c.conn, len(spec.subcommands))
+	for _, sub := range spec.subcommands {
+		c.writeCommandInfo(sub)
+	}
+}
+
+// writeSimpleStrings writes an array of simple strings to the client.
+func (c *Client) writeSimpleStrings(values []string) {
+	protocol.WriteArrayHeader(c.conn, len(values))
+	for _, value := range values {
+		protocol.WriteSimpleString(c.conn, value)
+	}
+}
+
+// commandGetKeys handles the COMMAND GETKEYS command for the client.
+// It takes an array of arguments with the following format: ["COMMAND", "GETKEYS", command, arg, ...].
+// It responds with the keys of the given command.
+func (c *Client) commandGetKeys(args []string) {
+	spec, err := lookupCommand(args[2:])
+	switch {
+	case errors.Is(err, errUnknownCommand) || errors.Is(err, errUnknownSubcommand):
+		protocol.WriteError(c.conn, "ERR Invalid command specified")
+		return
+	case err != nil:
+		protocol.WriteError(c.conn, "ERR Invalid number of arguments specified for command")
+		return
+	case spec.keys == nil && spec.firstKey == 0:
+		protocol.WriteError(c.conn, "ERR The command has no key arguments")
+		return
+	}
+	positions, ok := spec.keyPositions(args[2:])
+	if !ok {
+		protocol.WriteError(c.conn, "ERR Invalid arguments specified for command")
+		return
+	}
+	protocol.WriteArrayHeader(c.conn, len(positions))
+	for _, i := range positions {
+		protocol.WriteBulkString(c.conn, args[2+i])
+	}
+}
+
+// commandDocs handles the COMMAND DOCS command for the client.
+// It takes an array of arguments with the following format: ["COMMAND", "DOCS", [name, ...]].
+// It responds with alternating command names and documentation, for the
+// named commands or for every command if none is named. Unknown names are
+// left out.
+func (c *Client) commandDocs(args []string) {
+	var specs []*commandSpec
+	if len(args) == 2 {
+		specs = sortedCommands()
+	}
+	for _, name := range args[2:] {
+		if spec := commandByName(name); spec != nil {
+			specs = append(specs, spec)
+		}
+	}
+	protocol.WriteArrayHeader(c.conn, 2*len(specs))
+	for _, spec := range specs {
+		c.writeCommandDocs(spec)
+	}
+}
+
+// writeCommandDocs writes the name of a command followed by its summary,
+// group and, for container commands, the documentation of its subcommands.
+func (c *Client) writeCommandDocs(spec *commandSpec) {
+	protocol.WriteBulkString(c.conn, strings.ToLower(spec.fullName()))
+	if len(spec.subcommands) == 0 {
+		protocol.WriteArrayHeader(c.conn, 4)
+	} else {
+		protocol.WriteArrayHeader(c.conn, 6)
+	}
+	protocol.WriteBulkString(c.conn, "summary")
+	protocol.WriteBulkString(c.conn, spec.summary)
+	protocol.WriteBulkString(c.conn, "group")
+	protocol.WriteBulkString(c.conn, spec.group)
+	if len(spec.subcommands) > 0 {
+		protocol.WriteBulkString(c.conn, "subcommands")
+		protocol.WriteArrayHeader(c.conn, 2*len(spec.subcommands))
+		for _, sub := range spec.subcommands {
+			c.writeCommandDocs(sub)
+		}
+	}
+}
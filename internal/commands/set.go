@@ -0,0 +1,240 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// sadd handles the SADD command for the client.
+// It takes an array of arguments with the following format: ["SADD", key, member, ...].
+// It responds with the number of members that were added.
+func (c *Client) sadd(args []string) {
+	added, err := c.datastore.SAdd(args[1], args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if added > 0 {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(added))
+}
+
+// srem handles the SREM command for the client.
+// It takes an array of arguments with the following format: ["SREM", key, member, ...].
+// It responds with the number of members that were removed.
+func (c *Client) srem(args []string) {
+	removed, err := c.datastore.SRem(args[1], args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(removed))
+}
+
+// smembers handles the SMEMBERS command for the client.
+// It takes an array of arguments with the following format: ["SMEMBERS", key].
+func (c *Client) smembers(args []string) {
+	members, err := c.datastore.SMembers(args[1])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteArray(c.conn, members)
+}
+
+// sismember handles the SISMEMBER and SMISMEMBER commands.
+// It takes an array of arguments with the following format: [cmd, key, member, ...].
+// SISMEMBER responds with 1 or 0, SMISMEMBER with an array of them.
+func (c *Client) sismember(args []string) {
+	multi := strings.ToUpper(args[0]) == "SMISMEMBER"
+	found, err := c.datastore.SMIsMember(args[1], args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	results := make([]int64, len(found))
+	for i, ok := range found {
+		if ok {
+			results[i] = 1
+		}
+	}
+	if multi {
+		c.writeIntegers(results)
+		return
+	}
+	protocol.WriteInteger(c.conn, results[0])
+}
+
+// scard handles the SCARD command for the client.
+// It takes an array of arguments with the following format: ["SCARD", key].
+func (c *Client) scard(args []string) {
+	n, err := c.datastore.SCard(args[1])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteInteger(c.conn, int64(n))
+}
+
+// spop handles the SPOP command for the client.
+// It takes an array of arguments with the following format: ["SPOP", key, [count]].
+// Since the popped members are random, the removal is logged to the AOF as an
+// SREM of the members that were actually popped.
+func (c *Client) spop(args []string) {
+	if len(args) > 3 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			protocol.WriteError(c.conn, "ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	members, err := c.datastore.SPop(args[1], count)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if len(members) > 0 {
+		c.propagate(append([]string{"SREM", args[1]}, members...))
+	}
+	switch {
+	case len(args) == 3:
+		protocol.WriteArray(c.conn, members)
+	case len(members) == 0:
+		protocol.WriteNullBulkString(c.conn)
+	default:
+		protocol.WriteBulkString(c.conn, members[0])
+	}
+}
+
+// srandmember handles the SRANDMEMBER command for the client.
+// It takes an array of arguments with the following format: ["SRANDMEMBER", key, [count]].
+func (c *Client) srandmember(args []string) {
+	if len(args) > 3 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			protocol.WriteError(c.conn, errNotInteger)
+			return
+		}
+		count = n
+	}
+	members, err := c.datastore.SRandMember(args[1], count)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	switch {
+	case len(args) == 3:
+		protocol.WriteArray(c.conn, members)
+	case len(members) == 0:
+		protocol.WriteNullBulkString(c.conn)
+	default:
+		protocol.WriteBulkString(c.conn, members[0])
+	}
+}
+
+// smove handles the SMOVE command for the client.
+// It takes an array of arguments with the following format: ["SMOVE", source, destination, member].
+func (c *Client) smove(args []string) {
+	moved, err := c.datastore.SMove(args[1], args[2], args[3])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !moved {
+		protocol.WriteInteger(c.conn, 0)
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, 1)
+}
+
+// setAlgebra handles the SINTER, SUNION and SDIFF commands.
+// It takes an array of arguments with the following format: [cmd, key, ...].
+func (c *Client) setAlgebra(args []string, op datastore.SetOp) {
+	members, err := c.datastore.SetAlgebra(op, args[1:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteArray(c.conn, members)
+}
+
+// setAlgebraStore handles the SINTERSTORE, SUNIONSTORE and SDIFFSTORE commands.
+// It takes an array of arguments with the following format: [cmd, destination, key, ...].
+// It responds with the cardinality of the stored set.
+func (c *Client) setAlgebraStore(args []string, op datastore.SetOp) {
+	n, err := c.datastore.SetAlgebraStore(op, args[1], args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, int64(n))
+}
+
+// sintercard handles the SINTERCARD command for the client.
+// It takes an array of arguments with the following format: ["SINTERCARD", numkeys, key, ..., [LIMIT limit]].
+func (c *Client) sintercard(args []string) {
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys <= 0 {
+		protocol.WriteError(c.conn, "ERR numkeys should be greater than 0")
+		return
+	}
+	if numKeys > len(args)-2 {
+		protocol.WriteError(c.conn, "ERR Number of keys can't be greater than number of args")
+		return
+	}
+	keys, rest := args[2:2+numKeys], args[2+numKeys:]
+	limit := 0
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(rest[0]) != "LIMIT" {
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+		limit, err = strconv.Atoi(rest[1])
+		if err != nil || limit < 0 {
+			protocol.WriteError(c.conn, "ERR LIMIT can't be negative")
+			return
+		}
+	}
+	n, err := c.datastore.SInterCard(limit, keys...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteInteger(c.conn, int64(n))
+}
+
+// sscan handles the SSCAN command for the client.
+// It takes an array of arguments with the following format:
+// ["SSCAN", key, cursor, [MATCH pattern], [COUNT count]].
+func (c *Client) sscan(args []string) {
+	opts, ok := c.parseScanArgs(args, 2, false, false)
+	if !ok {
+		return
+	}
+	next, members, err := c.datastore.SScan(args[1], opts.cursor, opts.count, opts.pattern)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.writeScanReply(next, members)
+}
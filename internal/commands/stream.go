@@ -0,0 +1,380 @@
+package commands
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// xadd handles the XADD command for the client.
+// It takes an array of arguments with the following format:
+// ["XADD", key, [NOMKSTREAM], [MAXLEN|MINID [=|~] threshold [LIMIT count]], *|id, field, value, ...].
+// The entry is logged to the AOF with its final ID, followed by an exact
+// XTRIM if entries were trimmed, so replay does not depend on the clock.
+func (c *Client) xadd(args []string) {
+	xargs, err := datastore.ParseXAddArgs(args[2:])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if len(xargs.Fields) == 0 || len(xargs.Fields)%2 != 0 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	result, err := c.datastore.XAdd(args[1], xargs)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !result.Added {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	c.propagate(append([]string{"XADD", args[1], result.ID.String()}, xargs.Fields...))
+	if result.Trimmed != nil {
+		c.propagate(append([]string{"XTRIM", args[1]}, result.Trimmed.Args()...))
+	}
+	protocol.WriteBulkString(c.conn, result.ID.String())
+}
+
+// xtrim handles the XTRIM command for the client.
+// It takes an array of arguments with the following format:
+// ["XTRIM", key, MAXLEN|MINID, [=|~], threshold, [LIMIT count]].
+func (c *Client) xtrim(args []string) {
+	trim, next, err := datastore.ParseStreamTrim(args, 2)
+	if err == nil && next != len(args) {
+		err = datastore.ErrSyntax
+	}
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	removed, exact, err := c.datastore.XTrim(args[1], trim)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if exact != nil {
+		c.propagate(append([]string{"XTRIM", args[1]}, exact.Args()...))
+	}
+	protocol.WriteInteger(c.conn, int64(removed))
+}
+
+// xdel handles the XDEL command for the client.
+// It takes an array of arguments with the following format: ["XDEL", key, id, ...].
+func (c *Client) xdel(args []string) {
+	ids, ok := c.parseStreamIDs(args[2:])
+	if !ok {
+		return
+	}
+	removed, err := c.datastore.XDel(args[1], ids...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(removed))
+}
+
+// xsetid handles the XSETID command for the client.
+// It takes an array of arguments with the following format:
+// ["XSETID", key, last-id, [ENTRIESADDED entries-added], [MAXDELETEDID max-deleted-id]].
+func (c *Client) xsetid(args []string) {
+	xargs, err := datastore.ParseXSetIDArgs(args[2:])
+	if err == nil {
+		err = c.datastore.XSetID(args[1], xargs)
+	}
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// parseStreamIDs parses complete or incomplete stream IDs, writing an error
+// reply and returning false if one is invalid.
+func (c *Client) parseStreamIDs(args []string) ([]datastore.StreamID, bool) {
+	ids := make([]datastore.StreamID, len(args))
+	for i, arg := range args {
+		id, err := datastore.ParseStreamID(arg, 0)
+		if err != nil || strings.HasSuffix(arg, "-*") {
+			protocol.WriteError(c.conn, datastore.ErrInvalidStreamID.Error())
+			return nil, false
+		}
+		ids[i] = id
+	}
+	return ids, true
+}
+
+// xlen handles the XLEN command for the client.
+// It takes an array of arguments with the following format: ["XLEN", key].
+func (c *Client) xlen(args []string) {
+	n, err := c.datastore.XLen(args[1])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteInteger(c.conn, int64(n))
+}
+
+// xrange handles the XRANGE and XREVRANGE commands.
+// It takes an array of arguments with the following format:
+// ["XRANGE", key, start, end, [COUNT count]], or ["XREVRANGE", key, end, start, [COUNT count]].
+func (c *Client) xrange(args []string, rev bool) {
+	if len(args) != 4 && len(args) != 6 {
+		protocol.WriteError(c.conn, "ERR syntax error")
+		return
+	}
+	startArg, endArg := args[2], args[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, err := datastore.ParseStreamRangeBound(startArg, true)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	end, err := datastore.ParseStreamRangeBound(endArg, false)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	count := -1
+	if len(args) == 6 {
+		if strings.ToUpper(args[4]) != "COUNT" {
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+		n, err := strconv.Atoi(args[5])
+		if err != nil {
+			protocol.WriteError(c.conn, errNotInteger)
+			return
+		}
+		if n <= 0 {
+			protocol.WriteNullArray(c.conn)
+			return
+		}
+		count = n
+	}
+	entries, err := c.datastore.XRange(args[1], start, end, count, rev)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.writeStreamEntries(entries)
+}
+
+// xinfoStream handles the XINFO STREAM command for the client.
+// It takes an array of arguments with the following format: ["XINFO", "STREAM", key, [FULL [COUNT count]]].
+// It responds with a flat array of field names and values.
+func (c *Client) xinfoStream(args []string) {
+	full, count := false, 10
+	switch {
+	case len(args) == 3:
+	case len(args) == 4 && strings.ToUpper(args[3]) == "FULL":
+		full = true
+	case len(args) == 6 && strings.ToUpper(args[3]) == "FULL" && strings.ToUpper(args[4]) == "COUNT":
+		n, err := strconv.Atoi(args[5])
+		if err != nil {
+			protocol.WriteError(c.conn, errNotInteger)
+			return
+		}
+		full, count = true, max(n, 0)
+	default:
+		protocol.WriteError(c.conn, "ERR syntax error")
+		return
+	}
+	info, err := c.datastore.XInfoStream(args[2], full, count)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+
+	if full {
+		protocol.WriteArrayHeader(c.conn, 18)
+	} else {
+		protocol.WriteArrayHeader(c.conn, 20)
+	}
+	protocol.WriteBulkString(c.conn, "length")
+	protocol.WriteInteger(c.conn, int64(info.Length))
+	protocol.WriteBulkString(c.conn, "radix-tree-keys")
+	protocol.WriteInteger(c.conn, int64(info.Chunks))
+	protocol.WriteBulkString(c.conn, "radix-tree-nodes")
+	protocol.WriteInteger(c.conn, int64(info.Chunks))
+	protocol.WriteBulkString(c.conn, "last-generated-id")
+	protocol.WriteBulkString(c.conn, info.LastID.String())
+	protocol.WriteBulkString(c.conn, "max-deleted-entry-id")
+	protocol.WriteBulkString(c.conn, info.MaxDeletedID.String())
+	protocol.WriteBulkString(c.conn, "entries-added")
+	protocol.WriteInteger(c.conn, int64(info.EntriesAdded))
+	protocol.WriteBulkString(c.conn, "recorded-first-entry-id")
+	protocol.WriteBulkString(c.conn, info.RecordedFirstID.String())
+	if full {
+		protocol.WriteBulkString(c.conn, "entries")
+		c.writeStreamEntries(info.Entries)
+		protocol.WriteBulkString(c.conn, "groups")
+		c.writeGroupsFull(info.Groups)
+		return
+	}
+	protocol.WriteBulkString(c.conn, "groups")
+	protocol.WriteInteger(c.conn, int64(len(info.Groups)))
+	protocol.WriteBulkString(c.conn, "first-entry")
+	c.writeStreamEntry(info.First)
+	protocol.WriteBulkString(c.conn, "last-entry")
+	c.writeStreamEntry(info.Last)
+}
+
+// writeStreamEntries writes entries as an array of [id, [field, value, ...]]
+// pairs.
+func (c *Client) writeStreamEntries(entries []datastore.StreamEntry) {
+	protocol.WriteArrayHeader(c.conn, len(entries))
+	for i := range entries {
+		c.writeStreamEntry(&entries[i])
+	}
+}
+
+// writeStreamEntry writes a single entry, or a null array if entry is nil.
+// An entry without fields, which XREADGROUP returns for pending entries that
+// were deleted, is written with null fields.
+func (c *Client) writeStreamEntry(entry *datastore.StreamEntry) {
+	if entry == nil {
+		protocol.WriteNullArray(c.conn)
+		return
+	}
+	protocol.WriteArrayHeader(c.conn, 2)
+	protocol.WriteBulkString(c.conn, entry.ID.String())
+	if entry.Fields == nil {
+		protocol.WriteNullArray(c.conn)
+	} else {
+		protocol.WriteArray(c.conn, entry.Fields)
+	}
+}
+
+// xread handles the XREAD command for the client.
+// It takes an array of arguments with the following format:
+// ["XREAD", [COUNT count], [BLOCK milliseconds], STREAMS, key, ..., id, ...].
+// With BLOCK, the client waits for an entry past the given ID when none of
+// the streams has one; "$" stands for the last ID of the stream at the time
+// of the call.
+func (c *Client) xread(args []string) {
+	count := -1
+	var timeout time.Duration
+	block := false
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "STREAMS" {
+			i++
+			break
+		}
+		switch {
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				protocol.WriteError(c.conn, errNotInteger)
+				return
+			}
+			if n > 0 {
+				count = n
+			}
+			i++
+		case opt == "BLOCK" && i+1 < len(args):
+			var ok bool
+			if timeout, ok = c.parseBlockTimeout(args[i+1]); !ok {
+				return
+			}
+			block = true
+			i++
+		default:
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+	}
+	streams := args[i:]
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		protocol.WriteError(c.conn, "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+		return
+	}
+	keys, ids := streams[:len(streams)/2], streams[len(streams)/2:]
+	after := make([]datastore.StreamID, len(keys))
+	for j, arg := range ids {
+		var id datastore.StreamID
+		var err error
+		if arg == "$" {
+			id, err = c.datastore.XLastID(keys[j])
+		} else {
+			id, err = datastore.ParseStreamID(arg, 0)
+		}
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+			return
+		}
+		after[j] = id
+	}
+
+	var replies []streamReply
+	for j, key := range keys {
+		entries, err := c.datastore.XRead(key, after[j], count)
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+			return
+		}
+		if len(entries) > 0 {
+			replies = append(replies, streamReply{key, entries})
+		}
+	}
+	if len(replies) == 0 && block {
+		var opErr error
+		served, err := c.block(keys, timeout, func(key string) bool {
+			entries, err := c.datastore.XRead(key, after[slices.Index(keys, key)], count)
+			if err != nil {
+				opErr = err
+				return true
+			}
+			if len(entries) == 0 {
+				return false
+			}
+			replies = []streamReply{{key, entries}}
+			return true
+		})
+		if err == nil {
+			err = opErr
+		}
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+			return
+		}
+		if !served {
+			replies = nil
+		}
+	}
+	c.writeStreamReplies(replies)
+}
+
+// streamReply holds the entries XREAD and XREADGROUP read from one stream.
+type streamReply struct {
+	key     string
+	entries []datastore.StreamEntry
+}
+
+// writeStreamReplies writes replies as an array of [key, entries] pairs, or
+// a null array if there are none.
+func (c *Client) writeStreamReplies(replies []streamReply) {
+	if len(replies) == 0 {
+		protocol.WriteNullArray(c.conn)
+		return
+	}
+	protocol.WriteArrayHeader(c.conn, len(replies))
+	for _, r := range replies {
+		protocol.WriteArrayHeader(c.conn, 2)
+		protocol.WriteBulkString(c.conn, r.key)
+		c.writeStreamEntries(r.entries)
+	}
+}
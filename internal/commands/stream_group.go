@@ -0,0 +1,538 @@
+package commands
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// xgroupCreate handles the XGROUP CREATE and XGROUP SETID commands for the
+// client. They take an array of arguments with the following format:
+// ["XGROUP", "CREATE", key, group, id|$, [MKSTREAM], [ENTRIESREAD n]] or
+// ["XGROUP", "SETID", key, group, id|$, [ENTRIESREAD n]].
+// They are logged to the AOF with the resolved ID and entries read, so "$" is
+// not re-evaluated on replay.
+func (c *Client) xgroupCreate(args []string) {
+	sub := strings.ToUpper(args[1])
+	key, group := args[2], args[3]
+	start, mkStream, err := datastore.ParseGroupStart(args[4:], sub == "CREATE")
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if sub == "CREATE" {
+		start, err = c.datastore.XGroupCreate(key, group, start, mkStream)
+	} else {
+		start, err = c.datastore.XGroupSetID(key, group, start)
+	}
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	entry := []string{"XGROUP", sub, key, group, start.ID.String(), "ENTRIESREAD", strconv.FormatInt(start.EntriesRead, 10)}
+	if mkStream {
+		entry = append(entry, "MKSTREAM")
+	}
+	c.propagate(entry)
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// xgroupDestroy handles the XGROUP DESTROY command for the client.
+// It takes an array of arguments with the following format: ["XGROUP", "DESTROY", key, group].
+func (c *Client) xgroupDestroy(args []string) {
+	destroyed, err := c.datastore.XGroupDestroy(args[2], args[3])
+	c.writeGroupChange(destroyed, err)
+}
+
+// xgroupCreateConsumer handles the XGROUP CREATECONSUMER command for the client.
+// It takes an array of arguments with the following format: ["XGROUP", "CREATECONSUMER", key, group, consumer].
+func (c *Client) xgroupCreateConsumer(args []string) {
+	created, err := c.datastore.XGroupCreateConsumer(args[2], args[3], args[4])
+	c.writeGroupChange(created, err)
+}
+
+// xgroupDelConsumer handles the XGROUP DELCONSUMER command for the client.
+// It takes an array of arguments with the following format: ["XGROUP", "DELCONSUMER", key, group, consumer].
+// It responds with the number of pending entries the consumer had.
+func (c *Client) xgroupDelConsumer(args []string) {
+	pending, err := c.datastore.XGroupDelConsumer(args[2], args[3], args[4])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, int64(pending))
+}
+
+// writeGroupChange replies to the XGROUP commands that answer 1 or 0,
+// logging the command if it changed anything.
+func (c *Client) writeGroupChange(changed bool, err error) {
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !changed {
+		protocol.WriteInteger(c.conn, 0)
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, 1)
+}
+
+// xreadgroup handles the XREADGROUP command for the client.
+// It takes an array of arguments with the following format:
+// ["XREADGROUP", "GROUP", group, consumer, [COUNT count], [BLOCK ms], [NOACK], "STREAMS", key, ..., id, ...].
+// The ID ">" reads entries never delivered to the group, any other ID reads
+// the consumer's pending entries after it. Deliveries are logged to the AOF
+// as XCLAIM and XGROUP SETID commands carrying their resulting state.
+func (c *Client) xreadgroup(args []string) {
+	var group, consumer string
+	count, noAck := -1, false
+	var timeout time.Duration
+	block := false
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		if opt == "STREAMS" {
+			i++
+			break
+		}
+		switch {
+		case opt == "GROUP" && i+2 < len(args):
+			group, consumer = args[i+1], args[i+2]
+			i += 2
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				protocol.WriteError(c.conn, errNotInteger)
+				return
+			}
+			if n > 0 {
+				count = n
+			}
+			i++
+		case opt == "BLOCK" && i+1 < len(args):
+			var ok bool
+			if timeout, ok = c.parseBlockTimeout(args[i+1]); !ok {
+				return
+			}
+			block = true
+			i++
+		case opt == "NOACK":
+			noAck = true
+		default:
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+	}
+	if group == "" {
+		protocol.WriteError(c.conn, "ERR Missing GROUP option for XREADGROUP")
+		return
+	}
+	streams := args[i:]
+	if len(streams) == 0 || len(streams)%2 != 0 {
+		protocol.WriteError(c.conn, "ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.")
+		return
+	}
+	keys, ids := streams[:len(streams)/2], streams[len(streams)/2:]
+	after := make([]datastore.StreamID, len(keys))
+	for j, arg := range ids {
+		if arg == ">" {
+			continue
+		}
+		if arg == "$" {
+			protocol.WriteError(c.conn, "ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+			return
+		}
+		id, err := datastore.ParseStreamID(arg, 0)
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+			return
+		}
+		after[j] = id
+	}
+
+	// read reads the j-th stream for the consumer and logs the deliveries.
+	read := func(j int) ([]datastore.StreamEntry, error) {
+		key, newOnly := keys[j], ids[j] == ">"
+		result, err := c.datastore.XReadGroup(key, group, consumer, after[j], newOnly, count, noAck)
+		var noGroup *datastore.NoGroupError
+		if errors.As(err, &noGroup) {
+			return nil, errors.New(err.Error() + " in XREADGROUP with GROUP option")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if result.ConsumerCreated {
+			c.appendAOF([]string{"XGROUP", "CREATECONSUMER", key, group, consumer})
+		}
+		c.logClaims(key, group, result.Delivered, c.appendAOF)
+		if newOnly && len(result.Entries) > 0 {
+			c.appendAOF([]string{"XGROUP", "SETID", key, group, result.Group.ID.String(),
+				"ENTRIESREAD", strconv.FormatInt(result.Group.EntriesRead, 10)})
+		}
+		return result.Entries, nil
+	}
+
+	var replies []streamReply
+	for j, key := range keys {
+		entries, err := read(j)
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+			return
+		}
+		if ids[j] != ">" || len(entries) > 0 {
+			replies = append(replies, streamReply{key, entries})
+		}
+	}
+	if len(replies) == 0 && block {
+		// Only new entries are waited for: a history read always replies
+		// right away.
+		var opErr error
+		served, err := c.block(keys, timeout, func(key string) bool {
+			j := slices.Index(keys, key)
+			entries, err := read(j)
+			if err != nil {
+				opErr = err
+				return true
+			}
+			if len(entries) == 0 {
+				return false
+			}
+			replies = []streamReply{{key, entries}}
+			return true
+		})
+		if err == nil {
+			err = opErr
+		}
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+			return
+		}
+		if !served {
+			replies = nil
+		}
+	}
+	c.writeStreamReplies(replies)
+}
+
+// logClaims logs the state of pending entries as XCLAIM commands that
+// recreate them exactly, passing each to log.
+func (c *Client) logClaims(key, group string, pending []datastore.PendingEntry, log func([]string)) {
+	for _, p := range pending {
+		log([]string{"XCLAIM", key, group, p.Consumer, "0", p.ID.String(),
+			"TIME", strconv.FormatInt(p.DeliveryTime, 10),
+			"RETRYCOUNT", strconv.FormatInt(p.DeliveryCount, 10), "FORCE", "JUSTID"})
+	}
+}
+
+// logClaimResult logs the outcome of XCLAIM or XAUTOCLAIM to the AOF.
+func (c *Client) logClaimResult(key, group string, result datastore.XClaimResult) {
+	c.logClaims(key, group, result.Claimed, c.propagate)
+	if len(result.Deleted) > 0 {
+		entry := []string{"XACK", key, group}
+		for _, id := range result.Deleted {
+			entry = append(entry, id.String())
+		}
+		c.propagate(entry)
+	}
+	if result.Group != nil {
+		c.propagate([]string{"XGROUP", "SETID", key, group, result.Group.ID.String(),
+			"ENTRIESREAD", strconv.FormatInt(result.Group.EntriesRead, 10)})
+	}
+}
+
+// xack handles the XACK command for the client.
+// It takes an array of arguments with the following format: ["XACK", key, group, id, ...].
+func (c *Client) xack(args []string) {
+	ids, ok := c.parseStreamIDs(args[3:])
+	if !ok {
+		return
+	}
+	acked, err := c.datastore.XAck(args[1], args[2], ids...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if acked > 0 {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(acked))
+}
+
+// xpending handles the XPENDING command for the client.
+// It takes an array of arguments with the following format:
+// ["XPENDING", key, group, [[IDLE min-idle-time], start, end, count, [consumer]]].
+// Without a range it responds with a summary of the pending entries.
+func (c *Client) xpending(args []string) {
+	if len(args) == 3 {
+		summary, err := c.datastore.XPendingSummary(args[1], args[2])
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+			return
+		}
+		protocol.WriteArrayHeader(c.conn, 4)
+		protocol.WriteInteger(c.conn, int64(summary.Count))
+		if summary.Count == 0 {
+			protocol.WriteNullBulkString(c.conn)
+			protocol.WriteNullBulkString(c.conn)
+			protocol.WriteNullArray(c.conn)
+			return
+		}
+		protocol.WriteBulkString(c.conn, summary.Min.String())
+		protocol.WriteBulkString(c.conn, summary.Max.String())
+		protocol.WriteArrayHeader(c.conn, len(summary.Consumers))
+		for _, cp := range summary.Consumers {
+			protocol.WriteArray(c.conn, []string{cp.Name, strconv.Itoa(cp.Pending)})
+		}
+		return
+	}
+
+	rest := args[3:]
+	var minIdle int64
+	if strings.ToUpper(rest[0]) == "IDLE" && len(rest) >= 2 {
+		n, err := strconv.ParseInt(rest[1], 10, 64)
+		if err != nil {
+			protocol.WriteError(c.conn, errNotInteger)
+			return
+		}
+		minIdle, rest = n, rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		protocol.WriteError(c.conn, "ERR syntax error")
+		return
+	}
+	start, err := datastore.ParseStreamRangeBound(rest[0], true)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	end, err := datastore.ParseStreamRangeBound(rest[1], false)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	count, err := strconv.Atoi(rest[2])
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	consumer := ""
+	if len(rest) == 4 {
+		consumer = rest[3]
+	}
+	entries, err := c.datastore.XPending(args[1], args[2], start, end, max(count, 0), consumer, minIdle)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteArrayHeader(c.conn, len(entries))
+	for _, p := range entries {
+		protocol.WriteArrayHeader(c.conn, 4)
+		protocol.WriteBulkString(c.conn, p.ID.String())
+		protocol.WriteBulkString(c.conn, p.Consumer)
+		protocol.WriteInteger(c.conn, p.Idle)
+		protocol.WriteInteger(c.conn, p.DeliveryCount)
+	}
+}
+
+// xclaim handles the XCLAIM command for the client.
+// It takes an array of arguments with the following format:
+// ["XCLAIM", key, group, consumer, min-idle-time, id, ..., [IDLE ms], [TIME ms],
+// [RETRYCOUNT count], [FORCE], [JUSTID], [LASTID id]].
+func (c *Client) xclaim(args []string) {
+	minIdle, ids, opts, err := datastore.ParseXClaimArgs(args[4:])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	result, err := c.datastore.XClaim(args[1], args[2], args[3], minIdle, ids, opts)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.logClaimResult(args[1], args[2], result)
+	c.writeClaimedEntries(result.Entries, opts.JustID)
+}
+
+// xautoclaim handles the XAUTOCLAIM command for the client.
+// It takes an array of arguments with the following format:
+// ["XAUTOCLAIM", key, group, consumer, min-idle-time, start, [COUNT count], [JUSTID]].
+// It responds with the cursor to continue from, the claimed entries and the
+// IDs of pending entries that no longer exist in the stream.
+func (c *Client) xautoclaim(args []string) {
+	minIdle, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		protocol.WriteError(c.conn, "ERR Invalid min-idle-time argument for XAUTOCLAIM")
+		return
+	}
+	start, err := datastore.ParseStreamRangeBound(args[5], true)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	count, justID := 100, false
+	for i := 6; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				protocol.WriteError(c.conn, errNotInteger)
+				return
+			}
+			if n < 1 || n > 1<<20 {
+				protocol.WriteError(c.conn, "ERR COUNT must be > 0")
+				return
+			}
+			count = n
+			i++
+		case opt == "JUSTID":
+			justID = true
+		default:
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+	}
+	result, err := c.datastore.XAutoClaim(args[1], args[2], args[3], max(minIdle, 0), start, count, justID)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.logClaimResult(args[1], args[2], result)
+	protocol.WriteArrayHeader(c.conn, 3)
+	protocol.WriteBulkString(c.conn, result.Next.String())
+	c.writeClaimedEntries(result.Entries, justID)
+	deleted := make([]string, len(result.Deleted))
+	for i, id := range result.Deleted {
+		deleted[i] = id.String()
+	}
+	protocol.WriteArray(c.conn, deleted)
+}
+
+// writeClaimedEntries writes the entries claimed by XCLAIM or XAUTOCLAIM,
+// or only their IDs when justID is set.
+func (c *Client) writeClaimedEntries(entries []datastore.StreamEntry, justID bool) {
+	if !justID {
+		c.writeStreamEntries(entries)
+		return
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID.String()
+	}
+	protocol.WriteArray(c.conn, ids)
+}
+
+// xinfoGroups handles the XINFO GROUPS command for the client.
+// It takes an array of arguments with the following format: ["XINFO", "GROUPS", key].
+// It responds with one flat array of field names and values per group.
+func (c *Client) xinfoGroups(args []string) {
+	groups, err := c.datastore.XInfoGroups(args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteArrayHeader(c.conn, len(groups))
+	for _, g := range groups {
+		protocol.WriteArrayHeader(c.conn, 12)
+		protocol.WriteBulkString(c.conn, "name")
+		protocol.WriteBulkString(c.conn, g.Name)
+		protocol.WriteBulkString(c.conn, "consumers")
+		protocol.WriteInteger(c.conn, int64(len(g.Consumers)))
+		protocol.WriteBulkString(c.conn, "pending")
+		protocol.WriteInteger(c.conn, int64(len(g.Pending)))
+		protocol.WriteBulkString(c.conn, "last-delivered-id")
+		protocol.WriteBulkString(c.conn, g.LastID.String())
+		c.writeGroupCounters(g)
+	}
+}
+
+// writeGroupCounters writes the entries-read and lag fields of a group, which
+// are null when unknown.
+func (c *Client) writeGroupCounters(g datastore.GroupInfo) {
+	protocol.WriteBulkString(c.conn, "entries-read")
+	if g.EntriesRead < 0 {
+		protocol.WriteNullBulkString(c.conn)
+	} else {
+		protocol.WriteInteger(c.conn, g.EntriesRead)
+	}
+	protocol.WriteBulkString(c.conn, "lag")
+	if g.Lag < 0 {
+		protocol.WriteNullBulkString(c.conn)
+	} else {
+		protocol.WriteInteger(c.conn, g.Lag)
+	}
+}
+
+// xinfoConsumers handles the XINFO CONSUMERS command for the client.
+// It takes an array of arguments with the following format: ["XINFO", "CONSUMERS", key, group].
+// It responds with one flat array of field names and values per consumer.
+func (c *Client) xinfoConsumers(args []string) {
+	consumers, err := c.datastore.XInfoConsumers(args[2], args[3])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteArrayHeader(c.conn, len(consumers))
+	for _, cons := range consumers {
+		protocol.WriteArrayHeader(c.conn, 8)
+		protocol.WriteBulkString(c.conn, "name")
+		protocol.WriteBulkString(c.conn, cons.Name)
+		protocol.WriteBulkString(c.conn, "pending")
+		protocol.WriteInteger(c.conn, int64(len(cons.Pending)))
+		protocol.WriteBulkString(c.conn, "idle")
+		protocol.WriteInteger(c.conn, cons.Idle)
+		protocol.WriteBulkString(c.conn, "inactive")
+		protocol.WriteInteger(c.conn, cons.Inactive)
+	}
+}
+
+// writeGroupsFull writes the groups section of XINFO STREAM FULL.
+func (c *Client) writeGroupsFull(groups []datastore.GroupInfo) {
+	protocol.WriteArrayHeader(c.conn, len(groups))
+	for _, g := range groups {
+		protocol.WriteArrayHeader(c.conn, 14)
+		protocol.WriteBulkString(c.conn, "name")
+		protocol.WriteBulkString(c.conn, g.Name)
+		protocol.WriteBulkString(c.conn, "last-delivered-id")
+		protocol.WriteBulkString(c.conn, g.LastID.String())
+		c.writeGroupCounters(g)
+		protocol.WriteBulkString(c.conn, "pel-count")
+		protocol.WriteInteger(c.conn, int64(len(g.Pending)))
+		protocol.WriteBulkString(c.conn, "pending")
+		protocol.WriteArrayHeader(c.conn, len(g.Pending))
+		for _, p := range g.Pending {
+			protocol.WriteArrayHeader(c.conn, 4)
+			protocol.WriteBulkString(c.conn, p.ID.String())
+			protocol.WriteBulkString(c.conn, p.Consumer)
+			protocol.WriteInteger(c.conn, p.DeliveryTime)
+			protocol.WriteInteger(c.conn, p.DeliveryCount)
+		}
+		protocol.WriteBulkString(c.conn, "consumers")
+		protocol.WriteArrayHeader(c.conn, len(g.Consumers))
+		for _, cons := range g.Consumers {
+			protocol.WriteArrayHeader(c.conn, 10)
+			protocol.WriteBulkString(c.conn, "name")
+			protocol.WriteBulkString(c.conn, cons.Name)
+			protocol.WriteBulkString(c.conn, "seen-time")
+			protocol.WriteInteger(c.conn, cons.SeenTime)
+			protocol.WriteBulkString(c.conn, "active-time")
+			protocol.WriteInteger(c.conn, cons.ActiveTime)
+			protocol.WriteBulkString(c.conn, "pel-count")
+			protocol.WriteInteger(c.conn, int64(len(cons.Pending)))
+			protocol.WriteBulkString(c.conn, "pending")
+			protocol.WriteArrayHeader(c.conn, len(cons.Pending))
+			for _, p := range cons.Pending {
+				protocol.WriteArrayHeader(c.conn, 3)
+				protocol.WriteBulkString(c.conn, p.ID.String())
+				protocol.WriteInteger(c.conn, p.DeliveryTime)
+				protocol.WriteInteger(c.conn, p.DeliveryCount)
+			}
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package commands
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// isExpireOption reports whether option is one of the expiry options of SET
+// and GETEX, which take a time argument.
+func isExpireOption(option string) bool {
+	return option == "EX" || option == "PX" || option == "EXAT" || option == "PXAT"
+}
+
+// parseExpireOption converts the time given to the EX, PX, EXAT or PXAT
+// option of cmd into an absolute deadline in Unix milliseconds. On failure it
+// returns the error to reply with.
+func parseExpireOption(cmd, option, value string) (int64, string) {
+	ttl, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	unit := time.Second
+	if option == "PX" || option == "PXAT" {
+		unit = time.Millisecond
+	}
+	at, ok := deadline(ttl, unit, strings.HasSuffix(option, "AT"))
+	if !ok || ttl <= 0 {
+		return 0, "ERR invalid expire time in '" + strings.ToUpper(cmd) + "' command"
+	}
+	return at, ""
+}
+
+// setEntry returns the normalized SET logged to the AOF for a write made with
+// opts: the conditions are dropped and the expiry becomes a PXAT deadline.
+func setEntry(key, value string, opts datastore.SetArgs) []string {
+	entry := []string{"SET", key, value}
+	if opts.ExpireAt != 0 {
+		entry = append(entry, "PXAT", strconv.FormatInt(opts.ExpireAt, 10))
+	} else if opts.KeepTTL {
+		entry = append(entry, "KEEPTTL")
+	}
+	return entry
+}
+
+// setnx handles the SETNX command for the client.
+// It takes an array of arguments with the following format: ["SETNX", key, value].
+// It responds with 1 if the key was set and 0 if it already existed.
+func (c *Client) setnx(args []string) {
+	opts := datastore.SetArgs{NX: true}
+	result, err := c.datastore.SetWithArgs(args[1], args[2], opts)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !result.Done {
+		protocol.WriteInteger(c.conn, 0)
+		return
+	}
+	c.propagate(setEntry(args[1], args[2], opts))
+	protocol.WriteInteger(c.conn, 1)
+}
+
+// setex handles the SETEX and PSETEX commands.
+// It takes an array of arguments with the following format: [cmd, key, ttl, value].
+// option is "EX" for SETEX and "PX" for PSETEX. It responds with "OK".
+func (c *Client) setex(args []string, option string) {
+	at, msg := parseExpireOption(args[0], option, args[2])
+	if msg != "" {
+		protocol.WriteError(c.conn, msg)
+		return
+	}
+	opts := datastore.SetArgs{ExpireAt: at}
+	if _, err := c.datastore.SetWithArgs(args[1], args[3], opts); err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.propagate(setEntry(args[1], args[3], opts))
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// getset handles the GETSET command for the client.
+// It takes an array of arguments with the following format: ["GETSET", key, value].
+// It responds with the old value of the key, or nil if it did not exist.
+func (c *Client) getset(args []string) {
+	opts := datastore.SetArgs{Get: true}
+	result, err := c.datastore.SetWithArgs(args[1], args[2], opts)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.propagate(setEntry(args[1], args[2], opts))
+	if !result.Found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	protocol.WriteBulkString(c.conn, result.Old)
+}
+
+// getdel handles the GETDEL command for the client.
+// It takes an array of arguments with the following format: ["GETDEL", key].
+// It responds with the value of the key, or nil if it did not exist, and logs
+// the deletion to the AOF as a DEL.
+func (c *Client) getdel(args []string) {
+	value, found, err := c.datastore.GetDel(args[1])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	c.propagate([]string{"DEL", args[1]})
+	protocol.WriteBulkString(c.conn, value)
+}
+
+// getex handles the GETEX command for the client.
+// It takes an array of arguments with the following format:
+// ["GETEX", key, [EX seconds | PX milliseconds | EXAT timestamp | PXAT ms-timestamp | PERSIST]].
+// It responds with the value of the key, or nil if it did not exist. A new
+// expiry is logged to the AOF as PEXPIREAT with an absolute deadline.
+func (c *Client) getex(args []string) {
+	var expiry, ttl string
+	persist := false
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "PERSIST" && expiry == "":
+			persist = true
+		case isExpireOption(option) && (expiry == "" || expiry == option) && !persist && i+1 < len(args):
+			expiry, ttl = option, args[i+1]
+			i++
+		default:
+			protocol.WriteError(c.conn, "ERR syntax error")
+			return
+		}
+	}
+	var at int64
+	if expiry != "" {
+		var msg string
+		if at, msg = parseExpireOption(args[0], expiry, ttl); msg != "" {
+			protocol.WriteError(c.conn, msg)
+			return
+		}
+	}
+
+	value, found, expired, err := c.datastore.GetEx(args[1], at, persist)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if !found {
+		protocol.WriteNullBulkString(c.conn)
+		return
+	}
+	switch {
+	case persist:
+		c.propagate([]string{"PERSIST", args[1]})
+	case expired:
+		c.propagate([]string{"DEL", args[1]})
+	case at != 0:
+		c.propagate([]string{"PEXPIREAT", args[1], strconv.FormatInt(at, 10)})
+	}
+	protocol.WriteBulkString(c.conn, value)
+}
+
+// incrBy handles the INCR, DECR, INCRBY and DECRBY commands.
+// It takes an array of arguments with the following format: [cmd, key, [increment]].
+// INCR and DECR add 1 and -1, INCRBY adds the increment and DECRBY subtracts
+// it. It responds with the value of the key afterwards.
+func (c *Client) incrBy(args []string, sign int64) {
+	delta := sign
+	if len(args) == 3 {
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			protocol.WriteError(c.conn, errNotInteger)
+			return
+		}
+		if sign < 0 && n == math.MinInt64 {
+			protocol.WriteError(c.conn, "ERR decrement would overflow")
+			return
+		}
+		delta = sign * n
+	}
+	value, err := c.datastore.IncrBy(args[1], delta)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, value)
+}
+
+// incrByFloat handles the INCRBYFLOAT command for the client.
+// It takes an array of arguments with the following format: ["INCRBYFLOAT", key, increment].
+// The result is logged to the AOF as a SET with KEEPTTL, so that replay does
+// not depend on floating point rounding.
+func (c *Client) incrByFloat(args []string) {
+	delta, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		protocol.WriteError(c.conn, errNotFloat)
+		return
+	}
+	value, err := c.datastore.IncrByFloat(args[1], delta)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.propagate(setEntry(args[1], value, datastore.SetArgs{KeepTTL: true}))
+	protocol.WriteBulkString(c.conn, value)
+}
+
+// appendCmd handles the APPEND command for the client.
+// It takes an array of arguments with the following format: ["APPEND", key, value].
+// It responds with the length of the string afterwards.
+func (c *Client) appendCmd(args []string) {
+	length, err := c.datastore.Append(args[1], args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, int64(length))
+}
+
+// strlen handles the STRLEN command for the client.
+// It takes an array of arguments with the following format: ["STRLEN", key].
+func (c *Client) strlen(args []string) {
+	length, err := c.datastore.StrLen(args[1])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteInteger(c.conn, int64(length))
+}
+
+// getrange handles the GETRANGE command for the client.
+// It takes an array of arguments with the following format: ["GETRANGE", key, start, end].
+func (c *Client) getrange(args []string) {
+	start, err1 := strconv.Atoi(args[2])
+	end, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	value, err := c.datastore.GetRange(args[1], start, end)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteBulkString(c.conn, value)
+}
+
+// setrange handles the SETRANGE command for the client.
+// It takes an array of arguments with the following format: ["SETRANGE", key, offset, value].
+// It responds with the length of the string afterwards.
+func (c *Client) setrange(args []string) {
+	offset, err := strconv.Atoi(args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	if offset < 0 {
+		protocol.WriteError(c.conn, "ERR offset is out of range")
+		return
+	}
+	length, err := c.datastore.SetRange(args[1], offset, args[3])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if args[3] != "" {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(length))
+}
+
+// mget handles the MGET command for the client.
+// It takes an array of arguments with the following format: ["MGET", key, ...].
+// It responds with the value of each key, or nil for keys that do not exist
+// or do not hold a string.
+func (c *Client) mget(args []string) {
+	values := c.datastore.MGet(args[1:]...)
+	protocol.WriteArrayHeader(c.conn, len(values))
+	for _, value := range values {
+		if value == nil {
+			protocol.WriteNullBulkString(c.conn)
+		} else {
+			protocol.WriteBulkString(c.conn, *value)
+		}
+	}
+}
+
+// mset handles the MSET and MSETNX commands.
+// It takes an array of arguments with the following format: [cmd, key, value, ...].
+// MSET responds with "OK", MSETNX with 1 if the keys were set and 0 if one of
+// them already existed.
+func (c *Client) mset(args []string, nx bool) {
+	if len(args)%2 != 1 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	if !nx {
+		c.datastore.MSet(args[1:]...)
+		c.dirty = true
+		protocol.WriteSimpleString(c.conn, "OK")
+		return
+	}
+	if !c.datastore.MSetNX(args[1:]...) {
+		protocol.WriteInteger(c.conn, 0)
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, 1)
+}
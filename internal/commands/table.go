@@ -0,0 +1,984 @@
+package commands
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/aof"
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// commandFlags describe how a command behaves. They decide which commands
+// are logged to the AOF, which scripts may call, and which run while a
+// script is busy, and are reported by COMMAND INFO.
+type commandFlags uint
+
+const (
+	// flagWrite marks commands that may modify the data set. Only they are
+	// logged to the AOF, and functions flagged no-writes can not call them.
+	flagWrite commandFlags = 1 << iota
+	// flagReadonly marks commands that only read the data set.
+	flagReadonly
+	// flagDenyOOM marks commands that may grow the memory in use.
+	flagDenyOOM
+	// flagAdmin marks administrative commands.
+	flagAdmin
+	// flagPubSub marks the pub/sub commands.
+	flagPubSub
+	// flagNoScript marks commands that scripts can not call.
+	flagNoScript
+	// flagBlocking marks commands that may block the client.
+	flagBlocking
+	// flagFast marks commands that run in constant or logarithmic time.
+	flagFast
+	// flagAllowBusy marks commands that run while a script is busy.
+	flagAllowBusy
+)
+
+// flagNames holds the names of the flags, as reported by COMMAND INFO.
+var flagNames = []struct {
+	flag commandFlags
+	name string
+}{
+	{flagWrite, "write"},
+	{flagReadonly, "readonly"},
+	{flagDenyOOM, "denyoom"},
+	{flagAdmin, "admin"},
+	{flagPubSub, "pubsub"},
+	{flagNoScript, "noscript"},
+	{flagBlocking, "blocking"},
+	{flagFast, "fast"},
+	{flagAllowBusy, "allow_busy"},
+}
+
+// commandSpec describes a command: how many arguments it takes, how it
+// behaves, where its keys are and which function handles it. Container
+// commands such as CONFIG dispatch on their first argument to subcommands,
+// which have specs of their own.
+type commandSpec struct {
+	// name is the upper case name of the command, or of the subcommand for
+	// subcommands.
+	name string
+	// arity is the number of arguments, including the command name and the
+	// subcommand name, following the Redis convention: a negative arity -n
+	// means at least n arguments.
+	arity int
+	flags commandFlags
+	// firstKey, lastKey and keyStep locate the keys among the arguments:
+	// every keyStep-th argument from firstKey to lastKey, where a negative
+	// lastKey counts from the end. firstKey is 0 for commands without keys.
+	firstKey, lastKey, keyStep int
+	// keys returns the positions of the keys of commands whose keys depend
+	// on their arguments, or false if the arguments are invalid.
+	keys func(args []string) ([]int, bool)
+	// acl lists the ACL categories of the command besides its group and
+	// the ones implied by its flags.
+	acl []string
+	// group and summary document the command for COMMAND DOCS.
+	group   string
+	summary string
+
+	handler     func(c *Client, args []string)
+	subcommands []*commandSpec
+	parent      *commandSpec
+}
+
+// fullName returns the name of the command as used in error messages, with
+// the container name for subcommands, such as "CONFIG|GET".
+func (spec *commandSpec) fullName() string {
+	if spec.parent != nil {
+		return spec.parent.name + "|" + spec.name
+	}
+	return spec.name
+}
+
+// arityOK reports whether n arguments satisfy the arity of the command.
+func (spec *commandSpec) arityOK(n int) bool {
+	if spec.arity < 0 {
+		return n >= -spec.arity
+	}
+	return n == spec.arity
+}
+
+// subcommand returns the spec of the named subcommand, or nil.
+func (spec *commandSpec) subcommand(name string) *commandSpec {
+	for _, sub := range spec.subcommands {
+		if strings.EqualFold(sub.name, name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// keyPositions returns the positions of the keys in args, or false if the
+// arguments are invalid. args must satisfy the arity of the command.
+func (spec *commandSpec) keyPositions(args []string) ([]int, bool) {
+	if spec.keys != nil {
+		return spec.keys(args)
+	}
+	if spec.firstKey == 0 {
+		return nil, true
+	}
+	last := spec.lastKey
+	if last < 0 {
+		last += len(args)
+	}
+	var positions []int
+	for i := spec.firstKey; i <= last && i < len(args); i += spec.keyStep {
+		positions = append(positions, i)
+	}
+	return positions, true
+}
+
+// categories returns the ACL categories of the command: its group, the ones
+// it declares and the ones implied by its flags.
+func (spec *commandSpec) categories() []string {
+	var categories []string
+	if category := groupCategories[spec.group]; category != "" {
+		categories = append(categories, category)
+	}
+	categories = append(categories, spec.acl...)
+	if spec.flags&flagWrite != 0 {
+		categories = append(categories, "@write")
+	}
+	if spec.flags&flagReadonly != 0 && spec.group != "scripting" {
+		categories = append(categories, "@read")
+	}
+	if spec.flags&flagAdmin != 0 {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if spec.flags&flagPubSub != 0 && spec.group != "pubsub" {
+		categories = append(categories, "@pubsub")
+	}
+	if spec.flags&flagFast != 0 {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	if spec.flags&flagBlocking != 0 {
+		categories = append(categories, "@blocking")
+	}
+	return categories
+}
+
+// groupCategories maps the command groups to their ACL category.
+var groupCategories = map[string]string{
+	"connection":   "@connection",
+	"string":       "@string",
+	"generic":      "@keyspace",
+	"list":         "@list",
+	"hash":         "@hash",
+	"set":          "@set",
+	"sorted-set":   "@sortedset",
+	"stream":       "@stream",
+	"pubsub":       "@pubsub",
+	"transactions": "@transaction",
+	"scripting":    "@scripting",
+}
+
+// numKeysAt returns a keys function for commands whose number of keys is at
+// args[i], followed by the keys themselves. With dest, args[1] is a
+// destination key as well.
+func numKeysAt(i int, dest bool) func(args []string) ([]int, bool) {
+	return func(args []string) ([]int, bool) {
+		n, err := strconv.Atoi(args[i])
+		if err != nil || n < 0 || n > len(args)-i-1 {
+			return nil, false
+		}
+		var positions []int
+		if dest {
+			positions = append(positions, 1)
+		}
+		for j := range n {
+			positions = append(positions, i+1+j)
+		}
+		return positions, true
+	}
+}
+
+// streamKeys is the keys function of XREAD and XREADGROUP, whose keys make
+// up the first half of the arguments following STREAMS.
+func streamKeys(args []string) ([]int, bool) {
+	for i, arg := range args {
+		if strings.EqualFold(arg, "STREAMS") {
+			n := len(args) - i - 1
+			if n == 0 || n%2 != 0 {
+				return nil, false
+			}
+			var positions []int
+			for j := range n / 2 {
+				positions = append(positions, i+1+j)
+			}
+			return positions, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	errUnknownCommand    = errors.New("unknown command")
+	errUnknownSubcommand = errors.New("unknown subcommand")
+	errWrongArity        = errors.New("wrong number of arguments")
+)
+
+// lookupCommand returns the spec of the command run by args, descending into
+// the subcommands of container commands. It fails with errUnknownCommand,
+// errUnknownSubcommand or errWrongArity; except for errUnknownCommand, the
+// spec of the command is returned along with the error.
+func lookupCommand(args []string) (*commandSpec, error) {
+	spec := commandTable[strings.ToUpper(args[0])]
+	if spec == nil {
+		return nil, errUnknownCommand
+	}
+	if len(spec.subcommands) > 0 && len(args) > 1 {
+		sub := spec.subcommand(args[1])
+		if sub == nil {
+			return spec, errUnknownSubcommand
+		}
+		spec = sub
+	}
+	if !spec.arityOK(len(args)) {
+		return spec, errWrongArity
+	}
+	return spec, nil
+}
+
+// commandError returns the error replied for a command that lookupCommand
+// rejected with err.
+func commandError(spec *commandSpec, args []string, err error) string {
+	switch {
+	case errors.Is(err, errUnknownCommand):
+		return "ERR unknown command '" + strings.ToUpper(args[0]) + "'"
+	case errors.Is(err, errUnknownSubcommand):
+		return "ERR unknown subcommand '" + args[1] + "'. Try " + spec.name + " HELP."
+	}
+	return errWrongArgs(spec.fullName())
+}
+
+// call runs a command whose arguments satisfy its spec. Write commands are
+// logged to the AOF afterwards: as the entries the handler passed to
+// propagate if it did, or as they were called if the handler marked the
+// client dirty. A rewrite of the AOF is started once it grew enough, as soon
+// as no command runs.
+func (c *Client) call(spec *commandSpec, args []string) {
+	c.dirty, c.propagated = false, nil
+	spec.handler(c, args)
+	if spec.flags&flagWrite == 0 {
+		return
+	}
+	switch {
+	case c.propagated != nil:
+		for _, entry := range c.propagated {
+			c.appendAOF(entry)
+		}
+	case c.dirty:
+		c.appendAOF(args)
+	}
+	c.dirty, c.propagated = false, nil
+	if c.aof.RewriteDue() {
+		go func(handler *aof.AOFHandler) {
+			commandLock.Lock()
+			defer commandLock.Unlock()
+			rewriteAOF(handler)
+		}(c.aof)
+	}
+}
+
+// propagate records an entry to log to the AOF in place of the command
+// being run, for commands whose effect must be logged in a deterministic
+// form, such as a relative expiry as an absolute deadline.
+func (c *Client) propagate(entry []string) {
+	c.propagated = append(c.propagated, entry)
+}
+
+// appendAOF logs entry to the AOF as applying to the database selected by
+// the client.
+func (c *Client) appendAOF(entry []string) {
+	c.aof.AppendCommand(c.datastore.ID(), entry)
+}
+
+// commandTable holds the spec of every command by upper case name.
+var commandTable map[string]*commandSpec
+
+func init() {
+	commandTable = make(map[string]*commandSpec)
+	for _, spec := range commandSpecs() {
+		for _, sub := range spec.subcommands {
+			sub.parent = spec
+			if sub.group == "" {
+				sub.group = spec.group
+			}
+		}
+		commandTable[spec.name] = spec
+	}
+}
+
+// commandSpecs returns the specs of the commands. It is a function rather
+// than a variable because the handlers refer back to the table.
+func commandSpecs() []*commandSpec {
+	return []*commandSpec{
+		// Connection.
+		{name: "PING", arity: -1, flags: flagFast, group: "connection", summary: "Returns the server's liveliness response.",
+			handler: (*Client).ping},
+		{name: "ECHO", arity: 2, flags: flagFast, group: "connection", summary: "Returns the given string.",
+			handler: (*Client).echo},
+		{name: "SELECT", arity: 2, flags: flagFast, group: "connection", summary: "Changes the selected database.",
+			handler: (*Client).selectCmd},
+		{name: "CLIENT", arity: -2, group: "connection", summary: "A container for client connection commands.",
+			subcommands: []*commandSpec{
+				{name: "ID", arity: 2, flags: flagNoScript, summary: "Returns the unique client ID of the connection.",
+					handler: (*Client).clientID},
+				{name: "UNBLOCK", arity: -3, flags: flagAdmin | flagNoScript, summary: "Unblocks a client blocked by a blocking command from a different connection.",
+					handler: (*Client).clientUnblock},
+			}},
+
+		// Strings.
+		{name: "SET", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+			handler: (*Client).set},
+		{name: "GET", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Returns the string value of a key.",
+			handler: (*Client).get},
+		{name: "SETNX", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Set the string value of a key only when the key doesn't exist.",
+			handler: (*Client).setnx},
+		{name: "SETEX", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Sets the string value and expiration time of a key. Creates the key if it doesn't exist.",
+			handler: func(c *Client, args []string) { c.setex(args, "EX") }},
+		{name: "PSETEX", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Sets both string value and expiration time in milliseconds of a key. The key is created if it doesn't exist.",
+			handler: func(c *Client, args []string) { c.setex(args, "PX") }},
+		{name: "GETSET", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Returns the previous string value of a key after setting it to a new value.",
+			handler: (*Client).getset},
+		{name: "GETDEL", arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Returns the string value of a key after deleting the key.",
+			handler: (*Client).getdel},
+		{name: "GETEX", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Returns the string value of a key after setting its expiration time.",
+			handler: (*Client).getex},
+		{name: "INCR", arity: 2, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Increments the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+			handler: func(c *Client, args []string) { c.incrBy(args, 1) }},
+		{name: "DECR", arity: 2, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Decrements the integer value of a key by one. Uses 0 as initial value if the key doesn't exist.",
+			handler: func(c *Client, args []string) { c.incrBy(args, -1) }},
+		{name: "INCRBY", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Increments the integer value of a key by a number. Uses 0 as initial value if the key doesn't exist.",
+			handler: func(c *Client, args []string) { c.incrBy(args, 1) }},
+		{name: "DECRBY", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Decrements a number from the integer value of a key. Uses 0 as initial value if the key doesn't exist.",
+			handler: func(c *Client, args []string) { c.incrBy(args, -1) }},
+		{name: "INCRBYFLOAT", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Increment the floating point value of a key by a number. Uses 0 as initial value if the key doesn't exist.",
+			handler: (*Client).incrByFloat},
+		{name: "APPEND", arity: 3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Appends a string to the value of a key. Creates the key if it doesn't exist.",
+			handler: (*Client).appendCmd},
+		{name: "STRLEN", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Returns the length of a string value.",
+			handler: (*Client).strlen},
+		{name: "GETRANGE", arity: 4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Returns a substring of the string stored at a key.",
+			handler: (*Client).getrange},
+		{name: "SETRANGE", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1, group: "string",
+			summary: "Overwrites a part of a string value with another by an offset. Creates the key if it doesn't exist.",
+			handler: (*Client).setrange},
+		{name: "MGET", arity: -2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: -1, keyStep: 1, group: "string",
+			summary: "Atomically returns the string values of one or more keys.",
+			handler: (*Client).mget},
+		{name: "MSET", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, keyStep: 2, group: "string",
+			summary: "Atomically creates or modifies the string values of one or more keys.",
+			handler: func(c *Client, args []string) { c.mset(args, false) }},
+		{name: "MSETNX", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, keyStep: 2, group: "string",
+			summary: "Atomically modifies the string values of one or more keys only when all keys don't exist.",
+			handler: func(c *Client, args []string) { c.mset(args, true) }},
+
+		// Keys.
+		{name: "DEL", arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, keyStep: 1, group: "generic",
+			summary: "Deletes one or more keys.",
+			handler: func(c *Client, args []string) { c.del(args, false) }},
+		{name: "UNLINK", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: -1, keyStep: 1, group: "generic",
+			summary: "Asynchronously deletes one or more keys.",
+			handler: func(c *Client, args []string) { c.del(args, true) }},
+		{name: "EXISTS", arity: -2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: -1, keyStep: 1, group: "generic",
+			summary: "Determines whether one or more keys exist.",
+			handler: (*Client).exists},
+		{name: "TOUCH", arity: -2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: -1, keyStep: 1, group: "generic",
+			summary: "Returns the number of existing keys out of those specified after updating the time they were last accessed.",
+			handler: (*Client).exists},
+		{name: "TYPE", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Determines the type of value stored at a key.",
+			handler: (*Client).typeCmd},
+		{name: "RENAME", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 2, keyStep: 1, group: "generic",
+			summary: "Renames a key and overwrites the destination.",
+			handler: func(c *Client, args []string) { c.rename(args, false) }},
+		{name: "RENAMENX", arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 2, keyStep: 1, group: "generic",
+			summary: "Renames a key only when the target key name doesn't exist.",
+			handler: func(c *Client, args []string) { c.rename(args, true) }},
+		{name: "COPY", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, keyStep: 1, group: "generic",
+			summary: "Copies the value of a key to a new key.",
+			handler: (*Client).copyCmd},
+		{name: "MOVE", arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Moves a key to another database.",
+			handler: (*Client).move},
+		{name: "RANDOMKEY", arity: 1, flags: flagReadonly, group: "generic",
+			summary: "Returns a random key name from the database.",
+			handler: (*Client).randomKey},
+		{name: "KEYS", arity: 2, flags: flagReadonly, acl: []string{"@dangerous"}, group: "generic",
+			summary: "Returns all key names that match a pattern.",
+			handler: (*Client).keys},
+		{name: "SCAN", arity: -2, flags: flagReadonly, group: "generic",
+			summary: "Iterates over the key names in the database.",
+			handler: (*Client).scan},
+		{name: "DBSIZE", arity: 1, flags: flagReadonly | flagFast, acl: []string{"@keyspace"}, group: "server",
+			summary: "Returns the number of keys in the database.",
+			handler: (*Client).dbSize},
+		{name: "BGREWRITEAOF", arity: 1, flags: flagAdmin | flagNoScript, group: "server",
+			summary: "Asynchronously rewrites the append-only file to disk.",
+			handler: (*Client).bgrewriteaof},
+		{name: "INFO", arity: -1, acl: []string{"@dangerous"}, group: "server",
+			summary: "Returns information and statistics about the server.",
+			handler: (*Client).info},
+		{name: "MEMORY", arity: -2, group: "server", summary: "A container for memory diagnostics commands.",
+			subcommands: []*commandSpec{
+				{name: "USAGE", arity: -3, flags: flagReadonly, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Estimates the memory usage of a key.",
+					handler: (*Client).memoryUsage},
+			}},
+		{name: "SWAPDB", arity: 3, flags: flagWrite | flagFast, acl: []string{"@keyspace", "@dangerous"}, group: "server",
+			summary: "Swaps two Redis databases.",
+			handler: (*Client).swapDB},
+		{name: "FLUSHDB", arity: -1, flags: flagWrite, acl: []string{"@keyspace", "@dangerous"}, group: "server",
+			summary: "Remove all keys from the current database.",
+			handler: (*Client).flush},
+		{name: "FLUSHALL", arity: -1, flags: flagWrite, acl: []string{"@keyspace", "@dangerous"}, group: "server",
+			summary: "Removes all keys from all databases.",
+			handler: (*Client).flush},
+		{name: "EXPIRE", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Sets the expiration time of a key in seconds.",
+			handler: func(c *Client, args []string) { c.expire(args, time.Second, false) }},
+		{name: "PEXPIRE", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Sets the expiration time of a key in milliseconds.",
+			handler: func(c *Client, args []string) { c.expire(args, time.Millisecond, false) }},
+		{name: "EXPIREAT", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Sets the expiration time of a key to a Unix timestamp.",
+			handler: func(c *Client, args []string) { c.expire(args, time.Second, true) }},
+		{name: "PEXPIREAT", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+			handler: func(c *Client, args []string) { c.expire(args, time.Millisecond, true) }},
+		{name: "TTL", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Returns the expiration time in seconds of a key.",
+			handler: func(c *Client, args []string) { c.ttl(args, time.Second) }},
+		{name: "PTTL", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Returns the expiration time in milliseconds of a key.",
+			handler: func(c *Client, args []string) { c.ttl(args, time.Millisecond) }},
+		{name: "EXPIRETIME", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Returns the expiration time of a key as a Unix timestamp.",
+			handler: func(c *Client, args []string) { c.expireTime(args, time.Second) }},
+		{name: "PEXPIRETIME", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Returns the expiration time of a key as a Unix milliseconds timestamp.",
+			handler: func(c *Client, args []string) { c.expireTime(args, time.Millisecond) }},
+		{name: "PERSIST", arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "generic",
+			summary: "Removes the expiration time of a key.",
+			handler: (*Client).persist},
+		{name: "OBJECT", arity: -2, group: "generic", summary: "A container for object introspection commands.",
+			subcommands: []*commandSpec{
+				{name: "ENCODING", arity: 3, flags: flagReadonly, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns the internal encoding of a Redis object.",
+					handler: (*Client).objectEncoding},
+				{name: "IDLETIME", arity: 3, flags: flagReadonly, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns the time since the last access to a Redis object.",
+					handler: (*Client).objectIdleTime},
+				{name: "FREQ", arity: 3, flags: flagReadonly, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns the logarithmic access frequency counter of a Redis object.",
+					handler: (*Client).objectFreq},
+			}},
+
+		// Lists.
+		{name: "LPUSH", arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.",
+			handler: func(c *Client, args []string) { c.push(args, true) }},
+		{name: "RPUSH", arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.",
+			handler: func(c *Client, args []string) { c.push(args, false) }},
+		{name: "LPOP", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.",
+			handler: func(c *Client, args []string) { c.pop(args, true) }},
+		{name: "RPOP", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Returns and removes the last elements of a list. Deletes the list if the last element was popped.",
+			handler: func(c *Client, args []string) { c.pop(args, false) }},
+		{name: "LLEN", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Returns the length of a list.",
+			handler: (*Client).llen},
+		{name: "LRANGE", arity: 4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Returns a range of elements from a list.",
+			handler: (*Client).lrange},
+		{name: "LINDEX", arity: 3, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Returns an element from a list by its index.",
+			handler: (*Client).lindex},
+		{name: "LSET", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Sets the value of an element in a list by its index.",
+			handler: (*Client).lset},
+		{name: "LREM", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Removes elements from a list. Deletes the list if the last element was removed.",
+			handler: (*Client).lrem},
+		{name: "LTRIM", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Removes elements from both ends a list. Deletes the list if all elements were trimmed.",
+			handler: (*Client).ltrim},
+		{name: "LINSERT", arity: 5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1, group: "list",
+			summary: "Inserts an element before or after another element in a list.",
+			handler: (*Client).linsert},
+		{name: "LMOVE", arity: 5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, keyStep: 1, group: "list",
+			summary: "Returns an element after popping it from one list and pushing it to another. Deletes the list if the last element was moved.",
+			handler: (*Client).lmove},
+		{name: "BLPOP", arity: -3, flags: flagWrite | flagBlocking, firstKey: 1, lastKey: -2, keyStep: 1, group: "list",
+			summary: "Removes and returns the first element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.",
+			handler: func(c *Client, args []string) { c.bpop(args, true) }},
+		{name: "BRPOP", arity: -3, flags: flagWrite | flagBlocking, firstKey: 1, lastKey: -2, keyStep: 1, group: "list",
+			summary: "Removes and returns the last element in a list. Blocks until an element is available otherwise. Deletes the list if the last element was popped.",
+			handler: func(c *Client, args []string) { c.bpop(args, false) }},
+		{name: "BLMOVE", arity: 6, flags: flagWrite | flagDenyOOM | flagBlocking, firstKey: 1, lastKey: 2, keyStep: 1, group: "list",
+			summary: "Pops an element from a list, pushes it to another list and returns it. Blocks until an element is available otherwise. Deletes the list if the last element was moved.",
+			handler: (*Client).blmove},
+		{name: "BRPOPLPUSH", arity: 4, flags: flagWrite | flagDenyOOM | flagBlocking, firstKey: 1, lastKey: 2, keyStep: 1, group: "list",
+			summary: "Pops an element from a list, pushes it to another list and returns it. Block until an element is available otherwise. Deletes the list if the last element was popped.",
+			handler: (*Client).blmove},
+
+		// Hashes.
+		{name: "HSET", arity: -4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Creates or modifies the value of a field in a hash.",
+			handler: (*Client).hset},
+		{name: "HMSET", arity: -4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Sets the values of multiple fields.",
+			handler: (*Client).hset},
+		{name: "HGET", arity: 3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns the value of a field in a hash.",
+			handler: (*Client).hget},
+		{name: "HMGET", arity: -3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns the values of all fields in a hash.",
+			handler: (*Client).hmget},
+		{name: "HGETALL", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns all fields and values in a hash.",
+			handler: (*Client).hgetall},
+		{name: "HKEYS", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns all fields in a hash.",
+			handler: (*Client).hgetall},
+		{name: "HVALS", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns all values in a hash.",
+			handler: (*Client).hgetall},
+		{name: "HDEL", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Deletes one or more fields and their values from a hash. Deletes the hash if no fields remain.",
+			handler: (*Client).hdel},
+		{name: "HEXISTS", arity: 3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Determines whether a field exists in a hash.",
+			handler: (*Client).hexists},
+		{name: "HLEN", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns the number of fields in a hash.",
+			handler: (*Client).hlen},
+		{name: "HINCRBY", arity: 4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Increments the integer value of a field in a hash by a number. Uses 0 as initial value if the field doesn't exist.",
+			handler: (*Client).hincrby},
+		{name: "HINCRBYFLOAT", arity: 4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Increments the floating point value of a field by a number. Uses 0 as initial value if the field doesn't exist.",
+			handler: (*Client).hincrbyfloat},
+		{name: "HSCAN", arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Iterates over fields and values of a hash.",
+			handler: (*Client).hscan},
+		{name: "HRANDFIELD", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns one or more random fields from a hash.",
+			handler: (*Client).hrandfield},
+		{name: "HEXPIRE", arity: -6, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Set expiry for hash field using relative time to expire (seconds).",
+			handler: func(c *Client, args []string) { c.hexpire(args, time.Second, false) }},
+		{name: "HPEXPIRE", arity: -6, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Set expiry for hash field using relative time to expire (milliseconds).",
+			handler: func(c *Client, args []string) { c.hexpire(args, time.Millisecond, false) }},
+		{name: "HEXPIREAT", arity: -6, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Set expiry for hash field using an absolute Unix timestamp (seconds).",
+			handler: func(c *Client, args []string) { c.hexpire(args, time.Second, true) }},
+		{name: "HPEXPIREAT", arity: -6, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Set expiry for hash field using an absolute Unix timestamp (milliseconds).",
+			handler: func(c *Client, args []string) { c.hexpire(args, time.Millisecond, true) }},
+		{name: "HTTL", arity: -5, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns the TTL in seconds of a hash field.",
+			handler: func(c *Client, args []string) { c.httl(args, time.Second) }},
+		{name: "HPTTL", arity: -5, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns the TTL in milliseconds of a hash field.",
+			handler: func(c *Client, args []string) { c.httl(args, time.Millisecond) }},
+		{name: "HEXPIRETIME", arity: -5, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns the expiration time of a hash field as a Unix timestamp, in seconds.",
+			handler: func(c *Client, args []string) { c.hexpiretime(args, time.Second) }},
+		{name: "HPEXPIRETIME", arity: -5, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Returns the expiration time of a hash field as a Unix timestamp, in msec.",
+			handler: func(c *Client, args []string) { c.hexpiretime(args, time.Millisecond) }},
+		{name: "HPERSIST", arity: -5, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "hash",
+			summary: "Removes the expiration time for each specified field.",
+			handler: (*Client).hpersist},
+
+		// Sets.
+		{name: "SADD", arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "set",
+			summary: "Adds one or more members to a set. Creates the key if it doesn't exist.",
+			handler: (*Client).sadd},
+		{name: "SREM", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "set",
+			summary: "Removes one or more members from a set. Deletes the set if the last member was removed.",
+			handler: (*Client).srem},
+		{name: "SMEMBERS", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "set",
+			summary: "Returns all members of a set.",
+			handler: (*Client).smembers},
+		{name: "SISMEMBER", arity: 3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "set",
+			summary: "Determines whether a member belongs to a set.",
+			handler: (*Client).sismember},
+		{name: "SMISMEMBER", arity: -3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "set",
+			summary: "Determines whether multiple members belong to a set.",
+			handler: (*Client).sismember},
+		{name: "SCARD", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "set",
+			summary: "Returns the number of members in a set.",
+			handler: (*Client).scard},
+		{name: "SPOP", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "set",
+			summary: "Returns one or more random members from a set after removing them. Deletes the set if the last member was popped.",
+			handler: (*Client).spop},
+		{name: "SRANDMEMBER", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "set",
+			summary: "Get one or multiple random members from a set.",
+			handler: (*Client).srandmember},
+		{name: "SMOVE", arity: 4, flags: flagWrite | flagFast, firstKey: 1, lastKey: 2, keyStep: 1, group: "set",
+			summary: "Moves a member from one set to another.",
+			handler: (*Client).smove},
+		{name: "SINTER", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, keyStep: 1, group: "set",
+			summary: "Returns the intersect of multiple sets.",
+			handler: func(c *Client, args []string) { c.setAlgebra(args, datastore.SetInter) }},
+		{name: "SUNION", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, keyStep: 1, group: "set",
+			summary: "Returns the union of multiple sets.",
+			handler: func(c *Client, args []string) { c.setAlgebra(args, datastore.SetUnion) }},
+		{name: "SDIFF", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, keyStep: 1, group: "set",
+			summary: "Returns the difference of multiple sets.",
+			handler: func(c *Client, args []string) { c.setAlgebra(args, datastore.SetDiff) }},
+		{name: "SINTERSTORE", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, keyStep: 1, group: "set",
+			summary: "Stores the intersect of multiple sets in a key.",
+			handler: func(c *Client, args []string) { c.setAlgebraStore(args, datastore.SetInter) }},
+		{name: "SUNIONSTORE", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, keyStep: 1, group: "set",
+			summary: "Stores the union of multiple sets in a key.",
+			handler: func(c *Client, args []string) { c.setAlgebraStore(args, datastore.SetUnion) }},
+		{name: "SDIFFSTORE", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, keyStep: 1, group: "set",
+			summary: "Stores the difference of multiple sets in a key.",
+			handler: func(c *Client, args []string) { c.setAlgebraStore(args, datastore.SetDiff) }},
+		{name: "SINTERCARD", arity: -3, flags: flagReadonly, keys: numKeysAt(1, false), group: "set",
+			summary: "Returns the number of members of the intersect of multiple sets.",
+			handler: (*Client).sintercard},
+		{name: "SSCAN", arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "set",
+			summary: "Iterates over members of a set.",
+			handler: (*Client).sscan},
+
+		// Sorted sets.
+		{name: "ZADD", arity: -4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Adds one or more members to a sorted set, or updates their scores. Creates the key if it doesn't exist.",
+			handler: (*Client).zadd},
+		{name: "ZINCRBY", arity: 4, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Increments the score of a member in a sorted set.",
+			handler: (*Client).zincrby},
+		{name: "ZREM", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Removes one or more members from a sorted set. Deletes the sorted set if all members were removed.",
+			handler: (*Client).zrem},
+		{name: "ZSCORE", arity: 3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns the score of a member in a sorted set.",
+			handler: (*Client).zscore},
+		{name: "ZMSCORE", arity: -3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns the score of one or more members in a sorted set.",
+			handler: (*Client).zscore},
+		{name: "ZCARD", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns the number of members in a sorted set.",
+			handler: (*Client).zcard},
+		{name: "ZRANK", arity: -3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns the index of a member in a sorted set ordered by ascending scores.",
+			handler: func(c *Client, args []string) { c.zrank(args, false) }},
+		{name: "ZREVRANK", arity: -3, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns the index of a member in a sorted set ordered by descending scores.",
+			handler: func(c *Client, args []string) { c.zrank(args, true) }},
+		{name: "ZRANGE", arity: -4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns members in a sorted set within a range of indexes.",
+			handler: func(c *Client, args []string) { c.zrange(args) }},
+		{name: "ZREVRANGE", arity: -4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns members in a sorted set within a range of indexes in reverse order.",
+			handler: func(c *Client, args []string) { c.zrange(args, "REV") }},
+		{name: "ZRANGEBYSCORE", arity: -4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns members in a sorted set within a range of scores.",
+			handler: func(c *Client, args []string) { c.zrange(args, "BYSCORE") }},
+		{name: "ZREVRANGEBYSCORE", arity: -4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns members in a sorted set within a range of scores in reverse order.",
+			handler: func(c *Client, args []string) { c.zrange(args, "BYSCORE", "REV") }},
+		{name: "ZRANGEBYLEX", arity: -4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns members in a sorted set within a lexicographical range.",
+			handler: func(c *Client, args []string) { c.zrange(args, "BYLEX") }},
+		{name: "ZREVRANGEBYLEX", arity: -4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns members in a sorted set within a lexicographical range in reverse order.",
+			handler: func(c *Client, args []string) { c.zrange(args, "BYLEX", "REV") }},
+		{name: "ZRANGESTORE", arity: -5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, keyStep: 1, group: "sorted-set",
+			summary: "Stores a range of members from sorted set in a key.",
+			handler: (*Client).zrangestore},
+		{name: "ZCOUNT", arity: 4, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns the count of members in a sorted set that have scores within a range.",
+			handler: func(c *Client, args []string) { c.zcount(args, datastore.ZRangeByScore) }},
+		{name: "ZLEXCOUNT", arity: 4, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns the number of members in a sorted set within a lexicographical range.",
+			handler: func(c *Client, args []string) { c.zcount(args, datastore.ZRangeByLex) }},
+		{name: "ZREMRANGEBYRANK", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Removes members in a sorted set within a range of indexes. Deletes the sorted set if all members were removed.",
+			handler: func(c *Client, args []string) { c.zremrange(args, datastore.ZRangeByRank) }},
+		{name: "ZREMRANGEBYSCORE", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Removes members in a sorted set within a range of scores. Deletes the sorted set if all members were removed.",
+			handler: func(c *Client, args []string) { c.zremrange(args, datastore.ZRangeByScore) }},
+		{name: "ZREMRANGEBYLEX", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Removes members in a sorted set within a lexicographical range. Deletes the sorted set if all members were removed.",
+			handler: func(c *Client, args []string) { c.zremrange(args, datastore.ZRangeByLex) }},
+		{name: "ZPOPMIN", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns the lowest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+			handler: func(c *Client, args []string) { c.zpop(args, false) }},
+		{name: "ZPOPMAX", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns the highest-scoring members from a sorted set after removing them. Deletes the sorted set if the last member was popped.",
+			handler: func(c *Client, args []string) { c.zpop(args, true) }},
+		{name: "ZUNION", arity: -3, flags: flagReadonly, keys: numKeysAt(1, false), group: "sorted-set",
+			summary: "Returns the union of multiple sorted sets.",
+			handler: func(c *Client, args []string) { c.zcombine(args, datastore.SetUnion) }},
+		{name: "ZINTER", arity: -3, flags: flagReadonly, keys: numKeysAt(1, false), group: "sorted-set",
+			summary: "Returns the intersect of multiple sorted sets.",
+			handler: func(c *Client, args []string) { c.zcombine(args, datastore.SetInter) }},
+		{name: "ZDIFF", arity: -3, flags: flagReadonly, keys: numKeysAt(1, false), group: "sorted-set",
+			summary: "Returns the difference between multiple sorted sets.",
+			handler: func(c *Client, args []string) { c.zcombine(args, datastore.SetDiff) }},
+		{name: "ZUNIONSTORE", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1, keys: numKeysAt(2, true), group: "sorted-set",
+			summary: "Stores the union of multiple sorted sets in a key.",
+			handler: func(c *Client, args []string) { c.zcombineStore(args, datastore.SetUnion) }},
+		{name: "ZINTERSTORE", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1, keys: numKeysAt(2, true), group: "sorted-set",
+			summary: "Stores the intersect of multiple sorted sets in a key.",
+			handler: func(c *Client, args []string) { c.zcombineStore(args, datastore.SetInter) }},
+		{name: "ZDIFFSTORE", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, keyStep: 1, keys: numKeysAt(2, true), group: "sorted-set",
+			summary: "Stores the difference of multiple sorted sets in a key.",
+			handler: func(c *Client, args []string) { c.zcombineStore(args, datastore.SetDiff) }},
+		{name: "ZRANDMEMBER", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Returns one or more random members from a sorted set.",
+			handler: (*Client).zrandmember},
+		{name: "ZSCAN", arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "sorted-set",
+			summary: "Iterates over members and scores of a sorted set.",
+			handler: (*Client).zscan},
+		{name: "BZPOPMIN", arity: -3, flags: flagWrite | flagBlocking | flagFast, firstKey: 1, lastKey: -2, keyStep: 1, group: "sorted-set",
+			summary: "Removes and returns the member with the lowest score from one or more sorted sets. Blocks until a member is available otherwise. Deletes the sorted set if the last element was popped.",
+			handler: func(c *Client, args []string) { c.bzpop(args, false) }},
+		{name: "BZPOPMAX", arity: -3, flags: flagWrite | flagBlocking | flagFast, firstKey: 1, lastKey: -2, keyStep: 1, group: "sorted-set",
+			summary: "Removes and returns the member with the highest score from one or more sorted sets. Blocks until a member available otherwise. Deletes the sorted set if the last element was popped.",
+			handler: func(c *Client, args []string) { c.bzpop(args, true) }},
+
+		// Streams.
+		{name: "XADD", arity: -5, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Appends a new message to a stream. Creates the key if it doesn't exist.",
+			handler: (*Client).xadd},
+		{name: "XTRIM", arity: -4, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Deletes messages from the beginning of a stream.",
+			handler: (*Client).xtrim},
+		{name: "XDEL", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Returns the number of messages after removing them from a stream.",
+			handler: (*Client).xdel},
+		{name: "XSETID", arity: -3, flags: flagWrite | flagDenyOOM | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "An internal command for replicating stream values.",
+			handler: (*Client).xsetid},
+		{name: "XLEN", arity: 2, flags: flagReadonly | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Return the number of messages in a stream.",
+			handler: (*Client).xlen},
+		{name: "XRANGE", arity: -4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Returns the messages from a stream within a range of IDs.",
+			handler: func(c *Client, args []string) { c.xrange(args, false) }},
+		{name: "XREVRANGE", arity: -4, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Returns the messages from a stream within a range of IDs in reverse order.",
+			handler: func(c *Client, args []string) { c.xrange(args, true) }},
+		{name: "XREAD", arity: -4, flags: flagReadonly | flagBlocking, keys: streamKeys, group: "stream",
+			summary: "Returns messages from multiple streams with IDs greater than the ones requested. Blocks until a message is available otherwise.",
+			handler: (*Client).xread},
+		{name: "XREADGROUP", arity: -7, flags: flagWrite | flagBlocking, keys: streamKeys, group: "stream",
+			summary: "Returns new or historical messages from a stream for a consumer in a group. Blocks until a message is available otherwise.",
+			handler: (*Client).xreadgroup},
+		{name: "XACK", arity: -4, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream.",
+			handler: (*Client).xack},
+		{name: "XPENDING", arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Returns the information and entries from a stream consumer group's pending entries list.",
+			handler: (*Client).xpending},
+		{name: "XCLAIM", arity: -6, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Changes, or acquires, ownership of a message in a consumer group, as if the message was delivered a consumer group member.",
+			handler: (*Client).xclaim},
+		{name: "XAUTOCLAIM", arity: -6, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, keyStep: 1, group: "stream",
+			summary: "Changes, or acquires, ownership of messages in a consumer group, as if the messages were delivered to as consumer group member.",
+			handler: (*Client).xautoclaim},
+		{name: "XINFO", arity: -2, group: "stream", summary: "A container for stream introspection commands.",
+			subcommands: []*commandSpec{
+				{name: "STREAM", arity: -3, flags: flagReadonly, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns information about a stream.",
+					handler: (*Client).xinfoStream},
+				{name: "GROUPS", arity: 3, flags: flagReadonly, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns a list of the consumer groups of a stream.",
+					handler: (*Client).xinfoGroups},
+				{name: "CONSUMERS", arity: 4, flags: flagReadonly, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Returns a list of the consumers in a consumer group.",
+					handler: (*Client).xinfoConsumers},
+			}},
+		{name: "XGROUP", arity: -2, group: "stream", summary: "A container for consumer groups commands.",
+			subcommands: []*commandSpec{
+				{name: "CREATE", arity: -5, flags: flagWrite | flagDenyOOM, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Creates a consumer group.",
+					handler: (*Client).xgroupCreate},
+				{name: "SETID", arity: -5, flags: flagWrite, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Sets the last-delivered ID of a consumer group.",
+					handler: (*Client).xgroupCreate},
+				{name: "DESTROY", arity: 4, flags: flagWrite, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Destroys a consumer group.",
+					handler: (*Client).xgroupDestroy},
+				{name: "CREATECONSUMER", arity: 5, flags: flagWrite | flagDenyOOM, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Creates a consumer in a consumer group.",
+					handler: (*Client).xgroupCreateConsumer},
+				{name: "DELCONSUMER", arity: 5, flags: flagWrite, firstKey: 2, lastKey: 2, keyStep: 1,
+					summary: "Deletes a consumer from a consumer group.",
+					handler: (*Client).xgroupDelConsumer},
+			}},
+
+		// Pub/sub.
+		{name: "SUBSCRIBE", arity: -2, flags: flagPubSub | flagNoScript, group: "pubsub",
+			summary: "Listens for messages published to channels.",
+			handler: func(c *Client, args []string) { c.subscribe(args, false) }},
+		{name: "PSUBSCRIBE", arity: -2, flags: flagPubSub | flagNoScript, group: "pubsub",
+			summary: "Listens for messages published to channels that match one or more patterns.",
+			handler: func(c *Client, args []string) { c.subscribe(args, true) }},
+		{name: "UNSUBSCRIBE", arity: -1, flags: flagPubSub | flagNoScript, group: "pubsub",
+			summary: "Stops listening to messages posted to channels.",
+			handler: func(c *Client, args []string) { c.unsubscribe(args, false) }},
+		{name: "PUNSUBSCRIBE", arity: -1, flags: flagPubSub | flagNoScript, group: "pubsub",
+			summary: "Stops listening to messages published to channels that match one or more patterns.",
+			handler: func(c *Client, args []string) { c.unsubscribe(args, true) }},
+		{name: "PUBLISH", arity: 3, flags: flagPubSub | flagFast, group: "pubsub",
+			summary: "Posts a message to a channel.",
+			handler: (*Client).publish},
+		{name: "PUBSUB", arity: -2, group: "pubsub", summary: "A container for Pub/Sub commands.",
+			subcommands: []*commandSpec{
+				{name: "CHANNELS", arity: -2, flags: flagPubSub,
+					summary: "Returns the active channels.",
+					handler: (*Client).pubsubChannels},
+				{name: "NUMSUB", arity: -2, flags: flagPubSub,
+					summary: "Returns a count of subscribers to channels.",
+					handler: (*Client).pubsubNumSub},
+				{name: "NUMPAT", arity: 2, flags: flagPubSub,
+					summary: "Returns a count of unique pattern subscriptions.",
+					handler: (*Client).pubsubNumPat},
+			}},
+
+		// Transactions.
+		{name: "MULTI", arity: 1, flags: flagNoScript | flagFast, group: "transactions",
+			summary: "Starts a transaction.",
+			handler: (*Client).multiCmd},
+		{name: "EXEC", arity: 1, flags: flagNoScript, group: "transactions",
+			summary: "Executes all commands in a transaction.",
+			handler: func(c *Client, args []string) { protocol.WriteError(c.conn, "ERR EXEC without MULTI") }},
+		{name: "DISCARD", arity: 1, flags: flagNoScript | flagFast, group: "transactions",
+			summary: "Discards a transaction.",
+			handler: (*Client).discard},
+		{name: "WATCH", arity: -2, flags: flagNoScript | flagFast, firstKey: 1, lastKey: -1, keyStep: 1, group: "transactions",
+			summary: "Monitors changes to keys to determine the execution of a transaction.",
+			handler: (*Client).watch},
+		{name: "UNWATCH", arity: 1, flags: flagNoScript | flagFast, group: "transactions",
+			summary: "Forgets about watched keys of a transaction.",
+			handler: (*Client).unwatch},
+
+		// Scripting and functions.
+		{name: "EVAL", arity: -3, flags: flagNoScript, keys: numKeysAt(2, false), group: "scripting",
+			summary: "Executes a server-side Lua script.",
+			handler: func(c *Client, args []string) { c.eval(args, false) }},
+		{name: "EVALSHA", arity: -3, flags: flagNoScript, keys: numKeysAt(2, false), group: "scripting",
+			summary: "Executes a server-side Lua script by SHA1 digest.",
+			handler: func(c *Client, args []string) { c.eval(args, true) }},
+		{name: "FCALL", arity: -3, flags: flagNoScript, keys: numKeysAt(2, false), group: "scripting",
+			summary: "Invokes a function.",
+			handler: func(c *Client, args []string) { c.fcall(args, false) }},
+		{name: "FCALL_RO", arity: -3, flags: flagReadonly | flagNoScript, keys: numKeysAt(2, false), group: "scripting",
+			summary: "Invokes a read-only function.",
+			handler: func(c *Client, args []string) { c.fcall(args, true) }},
+		{name: "SCRIPT", arity: -2, group: "scripting", summary: "A container for Lua scripts management commands.",
+			subcommands: []*commandSpec{
+				{name: "LOAD", arity: 3, flags: flagNoScript,
+					summary: "Loads a server-side Lua script to the script cache.",
+					handler: (*Client).scriptLoad},
+				{name: "EXISTS", arity: -3, flags: flagNoScript,
+					summary: "Determines whether server-side Lua scripts exist in the script cache.",
+					handler: (*Client).scriptExists},
+				{name: "FLUSH", arity: -2, flags: flagNoScript,
+					summary: "Removes all server-side Lua scripts from the script cache.",
+					handler: (*Client).scriptFlush},
+				{name: "KILL", arity: 2, flags: flagNoScript | flagAllowBusy,
+					summary: "Terminates a server-side Lua script during execution.",
+					handler: func(c *Client, args []string) { c.killScript(false) }},
+			}},
+		{name: "FUNCTION", arity: -2, group: "scripting", summary: "A container for function commands.",
+			subcommands: []*commandSpec{
+				{name: "LOAD", arity: -3, flags: flagWrite | flagDenyOOM | flagNoScript,
+					summary: "Creates a library.",
+					handler: (*Client).functionLoad},
+				{name: "DELETE", arity: 3, flags: flagWrite | flagNoScript,
+					summary: "Deletes a library and its functions.",
+					handler: (*Client).functionDelete},
+				{name: "FLUSH", arity: -2, flags: flagWrite | flagNoScript,
+					summary: "Deletes all libraries and functions.",
+					handler: (*Client).functionFlush},
+				{name: "LIST", arity: -2, flags: flagNoScript,
+					summary: "Returns information about all libraries.",
+					handler: (*Client).functionList},
+				{name: "DUMP", arity: 2, flags: flagNoScript,
+					summary: "Dumps all libraries into a serialized binary payload.",
+					handler: (*Client).functionDump},
+				{name: "RESTORE", arity: -3, flags: flagWrite | flagDenyOOM | flagNoScript,
+					summary: "Restores all libraries from a payload.",
+					handler: (*Client).functionRestore},
+				{name: "STATS", arity: 2, flags: flagNoScript | flagAllowBusy,
+					summary: "Returns information about a function during execution.",
+					handler: (*Client).functionStats},
+				{name: "KILL", arity: 2, flags: flagNoScript | flagAllowBusy,
+					summary: "Terminates a function during execution.",
+					handler: func(c *Client, args []string) { c.killScript(true) }},
+			}},
+
+		// Server.
+		{name: "CONFIG", arity: -2, group: "server", summary: "A container for server configuration commands.",
+			subcommands: []*commandSpec{
+				{name: "GET", arity: -3, flags: flagAdmin | flagNoScript,
+					summary: "Returns the effective values of configuration parameters.",
+					handler: (*Client).configGet},
+				{name: "SET", arity: -4, flags: flagAdmin | flagNoScript,
+					summary: "Sets configuration parameters in-flight.",
+					handler: (*Client).configSet},
+			}},
+		{name: "COMMAND", arity: -1, acl: []string{"@connection"}, group: "server", summary: "Returns detailed information about all commands.",
+			handler: (*Client).commandCmd,
+			subcommands: []*commandSpec{
+				{name: "COUNT", arity: 2,
+					summary: "Returns a count of commands.",
+					handler: (*Client).commandCount},
+				{name: "INFO", arity: -2,
+					summary: "Returns information about one, multiple or all commands.",
+					handler: (*Client).commandInfo},
+				{name: "GETKEYS", arity: -3,
+					summary: "Extracts the key names from an arbitrary command.",
+					handler: (*Client).commandGetKeys},
+				{name: "DOCS", arity: -2,
+					summary: "Returns documentary information about one, multiple or all commands.",
+					handler: (*Client).commandDocs},
+			}},
+	}
+}
+
+// sortedCommands returns the specs of every command, sorted by name.
+func sortedCommands() []*commandSpec {
+	specs := make([]*commandSpec, 0, len(commandTable))
+	for _, spec := range commandTable {
+		specs = append(specs, spec)
+	}
+	slices.SortFunc(specs, func(a, b *commandSpec) int { return strings.Compare(a.name, b.name) })
+	return specs
+}
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// commandLock makes transactions atomic. Read-only commands run holding it
+// for reading, while write commands, EXEC and scripts hold it for writing so
+// no other client runs between the commands of a transaction, and the AOF
+// logs writes in the order they apply in. The active expiry cycle holds it
+// for reading. Blocked clients release it while they wait.
+var commandLock sync.RWMutex
+
+func init() {
+	datastore.SetCycleLock(commandLock.RLocker())
+}
+
+// lockCommands takes commandLock for the command c runs, for writing if
+// exclusive is set and for reading otherwise.
+func (c *Client) lockCommands(exclusive bool) {
+	if exclusive {
+		commandLock.Lock()
+	} else {
+		commandLock.RLock()
+	}
+	c.exclusive = exclusive
+}
+
+// unlockCommands releases commandLock taken by lockCommands.
+func (c *Client) unlockCommands() {
+	if c.exclusive {
+		commandLock.Unlock()
+	} else {
+		commandLock.RUnlock()
+	}
+}
+
+// process runs a command read from the connection. Inside MULTI, commands
+// other than EXEC, DISCARD, MULTI and WATCH are queued instead, and EXEC is
+// rejected if it can't run the queued commands, see execAbortError. While a
+// script runs past its time limit, commands other than SCRIPT KILL,
+// FUNCTION KILL and FUNCTION STATS are rejected with a BUSY error rather than
+// waiting for it. Under appendfsync always, the replies to writes are held
+// until the AOF logging them is fsynced, see holdReplies.
+func (c *Client) process(args []string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	cmd := strings.ToUpper(args[0])
+	if isScriptControl(args) && !c.multi {
+		c.execute(args)
+		return
+	}
+	if msg := busyError(); msg != "" {
+		protocol.WriteError(c.conn, msg)
+		return
+	}
+	if c.multi {
+		switch cmd {
+		case "EXEC":
+			c.lockCommands(true)
+			defer c.unlockCommands()
+			defer c.holdReplies()()
+			if msg := c.execAbortError(); msg != "" {
+				c.resetTransaction()
+				protocol.WriteError(c.conn, "EXECABORT Transaction discarded because of: "+msg)
+				return
+			}
+			c.exec()
+			c.serveReadyKeys()
+			return
+		case "DISCARD", "MULTI", "WATCH":
+		default:
+			c.queue(args)
+			return
+		}
+	}
+	// Scripts run atomically, like transactions, the AOF is rewritten from a
+	// snapshot taken while no command runs, and writes run one at a time so
+	// that the AOF logs them in the order they apply in.
+	spec, _ := lookupCommand(args)
+	exclusive := cmd == "EVAL" || cmd == "EVALSHA" || cmd == "FCALL" || cmd == "FCALL_RO" || cmd == "BGREWRITEAOF" ||
+		spec != nil && spec.flags&flagWrite != 0
+	c.lockCommands(exclusive)
+	defer c.unlockCommands()
+	if exclusive {
+		defer c.holdReplies()()
+	}
+	c.execute(args)
+	c.serveReadyKeys()
+}
+
+// queue adds a command to the transaction of the client. Unknown commands
+// and subcommands, wrong argument counts, commands that may grow the memory
+// in use past maxmemory and writes while the AOF can't be written are
+// reported right away and make EXEC fail.
+func (c *Client) queue(args []string) {
+	spec, err := lookupCommand(args)
+	if err != nil {
+		c.multiErr = true
+		protocol.WriteError(c.conn, commandError(spec, args, err))
+		return
+	}
+	if c.rejectOOM(spec) || c.rejectDiskError(spec) {
+		c.multiErr = true
+		return
+	}
+	c.queued = append(c.queued, args)
+	protocol.WriteSimpleString(c.conn, "QUEUED")
+}
+
+// multiCmd handles the MULTI command for the client.
+// It takes an array of arguments with the following format: ["MULTI"].
+func (c *Client) multiCmd(args []string) {
+	if c.multi {
+		protocol.WriteError(c.conn, "ERR MULTI calls can not be nested")
+		return
+	}
+	c.multi = true
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// exec runs the queued commands of the transaction and replies with an array
+// of their replies. The caller must hold commandLock for writing. The
+// transaction is aborted with EXECABORT if a command was rejected when it was
+// queued, and replies with a null array without running anything if a
+// watched key was modified since WATCH.
+func (c *Client) exec() {
+	defer c.resetTransaction()
+	if c.multiErr {
+		protocol.WriteError(c.conn, "EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+	for wk, version := range c.watched {
+		if wk.db.KeyVersion(wk.key) != version {
+			protocol.WriteNullArray(c.conn)
+			return
+		}
+	}
+	protocol.WriteArrayHeader(c.conn, len(c.queued))
+	c.aof.BeginTransaction()
+	c.inExec = true
+	for _, args := range c.queued {
+		c.execute(args)
+	}
+	c.inExec = false
+	c.aof.EndTransaction()
+}
+
+// execAbortError returns why EXEC must discard the transaction without
+// running it, or an empty string: a queued command may grow the memory in
+// use past maxmemory, or modify the data set while the AOF can't be
+// written.
+func (c *Client) execAbortError() string {
+	if err := c.performEvictions(); err != nil && c.queuedFlag(flagDenyOOM) {
+		return err.Error()
+	}
+	if err := c.aof.WriteError(); err != nil && c.queuedFlag(flagWrite) {
+		return misconfError(err)
+	}
+	return ""
+}
+
+// queuedFlag reports whether a queued command has flag.
+func (c *Client) queuedFlag(flag commandFlags) bool {
+	for _, args := range c.queued {
+		if spec, _ := lookupCommand(args); spec.flags&flag != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// discard handles the DISCARD command for the client.
+// It takes an array of arguments with the following format: ["DISCARD"].
+func (c *Client) discard(args []string) {
+	if !c.multi {
+		protocol.WriteError(c.conn, "ERR DISCARD without MULTI")
+		return
+	}
+	c.resetTransaction()
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// resetTransaction leaves MULTI, dropping the queued commands, and unwatches
+// every key.
+func (c *Client) resetTransaction() {
+	c.multi, c.multiErr, c.queued = false, false, nil
+	c.unwatchAll()
+}
+
+// watch handles the WATCH command for the client.
+// It takes an array of arguments with the following format: ["WATCH", key, ...].
+// EXEC fails if one of the keys is modified before it runs.
+func (c *Client) watch(args []string) {
+	if c.multi {
+		protocol.WriteError(c.conn, "ERR WATCH inside MULTI is not allowed")
+		return
+	}
+	if c.watched == nil {
+		c.watched = make(map[dbKey]uint64)
+	}
+	for _, key := range args[1:] {
+		wk := dbKey{c.datastore, key}
+		if _, found := c.watched[wk]; !found {
+			c.watched[wk] = c.datastore.WatchKey(key)
+		}
+	}
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// unwatch handles the UNWATCH command for the client.
+// It takes an array of arguments with the following format: ["UNWATCH"].
+func (c *Client) unwatch(args []string) {
+	c.unwatchAll()
+	protocol.WriteSimpleString(c.conn, "OK")
+}
+
+// unwatchAll forgets every key watched by the client.
+func (c *Client) unwatchAll() {
+	for wk := range c.watched {
+		wk.db.UnwatchKey(wk.key)
+	}
+	c.watched = nil
+}
@@ -0,0 +1,422 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/manimovassagh/Godis/internal/datastore"
+	"github.com/manimovassagh/Godis/internal/protocol"
+)
+
+// zadd handles the ZADD command for the client.
+// It takes an array of arguments with the following format:
+// ["ZADD", key, [NX|XX], [GT|LT], [CH], [INCR], score, member, ...].
+// The members that changed are logged to the AOF as a plain ZADD with their
+// final scores, so replay does not depend on the flags.
+func (c *Client) zadd(args []string) {
+	var opts datastore.ZAddOptions
+	ch := false
+	i := 2
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			ch = true
+		case "INCR":
+			opts.Incr = true
+		default:
+			break flags
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		protocol.WriteError(c.conn, "ERR syntax error")
+		return
+	}
+	if opts.NX && opts.XX {
+		protocol.WriteError(c.conn, "ERR XX and NX options at the same time are not compatible")
+		return
+	}
+	if (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)) {
+		protocol.WriteError(c.conn, "ERR GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	if opts.Incr && len(pairs) > 2 {
+		protocol.WriteError(c.conn, "ERR INCR option supports a single increment-element pair")
+		return
+	}
+	members := make([]datastore.ZMember, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := datastore.ParseScore(pairs[j])
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+			return
+		}
+		members = append(members, datastore.ZMember{Member: pairs[j+1], Score: score})
+	}
+
+	result, err := c.datastore.ZAdd(args[1], opts, members...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.logZAdd(args[1], result.Changed)
+	switch {
+	case opts.Incr && !result.Applied:
+		protocol.WriteNullBulkString(c.conn)
+	case opts.Incr:
+		protocol.WriteBulkString(c.conn, datastore.FormatScore(result.Score))
+	case ch:
+		protocol.WriteInteger(c.conn, int64(result.Added+result.Updated))
+	default:
+		protocol.WriteInteger(c.conn, int64(result.Added))
+	}
+}
+
+// logZAdd logs a plain ZADD of members to the AOF, if there are any.
+func (c *Client) logZAdd(key string, members []datastore.ZMember) {
+	if len(members) == 0 {
+		return
+	}
+	entry := []string{"ZADD", key}
+	for _, m := range members {
+		entry = append(entry, datastore.FormatScore(m.Score), m.Member)
+	}
+	c.propagate(entry)
+}
+
+// zincrby handles the ZINCRBY command for the client.
+// It takes an array of arguments with the following format: ["ZINCRBY", key, increment, member].
+func (c *Client) zincrby(args []string) {
+	delta, err := datastore.ParseScore(args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	result, err := c.datastore.ZAdd(args[1], datastore.ZAddOptions{Incr: true}, datastore.ZMember{Member: args[3], Score: delta})
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.logZAdd(args[1], result.Changed)
+	protocol.WriteBulkString(c.conn, datastore.FormatScore(result.Score))
+}
+
+// zrem handles the ZREM command for the client.
+// It takes an array of arguments with the following format: ["ZREM", key, member, ...].
+func (c *Client) zrem(args []string) {
+	removed, err := c.datastore.ZRem(args[1], args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	protocol.WriteInteger(c.conn, int64(removed))
+}
+
+// zscore handles the ZSCORE and ZMSCORE commands.
+// It takes an array of arguments with the following format: [cmd, key, member, ...].
+// ZSCORE responds with a single score, ZMSCORE with an array of them.
+func (c *Client) zscore(args []string) {
+	multi := strings.ToUpper(args[0]) == "ZMSCORE"
+	scores, err := c.datastore.ZScore(args[1], args[2:]...)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if multi {
+		protocol.WriteArrayHeader(c.conn, len(scores))
+	}
+	for _, score := range scores {
+		if score == nil {
+			protocol.WriteNullBulkString(c.conn)
+		} else {
+			protocol.WriteBulkString(c.conn, datastore.FormatScore(*score))
+		}
+	}
+}
+
+// zcard handles the ZCARD command for the client.
+// It takes an array of arguments with the following format: ["ZCARD", key].
+func (c *Client) zcard(args []string) {
+	n, err := c.datastore.ZCard(args[1])
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteInteger(c.conn, int64(n))
+}
+
+// zrank handles the ZRANK and ZREVRANK commands.
+// It takes an array of arguments with the following format: [cmd, key, member, [WITHSCORE]].
+func (c *Client) zrank(args []string, rev bool) {
+	if len(args) > 4 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	withScore := len(args) == 4
+	if withScore && strings.ToUpper(args[3]) != "WITHSCORE" {
+		protocol.WriteError(c.conn, "ERR syntax error")
+		return
+	}
+	rank, score, found, err := c.datastore.ZRank(args[1], args[2], rev)
+	switch {
+	case err != nil:
+		protocol.WriteError(c.conn, err.Error())
+	case !found && withScore:
+		protocol.WriteNullArray(c.conn)
+	case !found:
+		protocol.WriteNullBulkString(c.conn)
+	case withScore:
+		protocol.WriteArrayHeader(c.conn, 2)
+		protocol.WriteInteger(c.conn, int64(rank))
+		protocol.WriteBulkString(c.conn, datastore.FormatScore(score))
+	default:
+		protocol.WriteInteger(c.conn, int64(rank))
+	}
+}
+
+// zrange handles ZRANGE and its legacy forms ZREVRANGE, ZRANGEBYSCORE,
+// ZREVRANGEBYSCORE, ZRANGEBYLEX and ZREVRANGEBYLEX, which are translated into
+// the unified syntax by appending the options in extra.
+// It takes an array of arguments with the following format:
+// [cmd, key, start, stop, [BYSCORE|BYLEX], [REV], [LIMIT offset count], [WITHSCORES]].
+func (c *Client) zrange(args []string, extra ...string) {
+	spec, withScores, err := datastore.ParseZRangeArgs(append(args[2:len(args):len(args)], extra...))
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	members, err := c.datastore.ZRange(args[1], spec)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.writeZMembers(members, withScores)
+}
+
+// zrangestore handles the ZRANGESTORE command for the client.
+// It takes an array of arguments with the following format:
+// ["ZRANGESTORE", destination, source, start, stop, [BYSCORE|BYLEX], [REV], [LIMIT offset count]].
+func (c *Client) zrangestore(args []string) {
+	spec, withScores, err := datastore.ParseZRangeArgs(args[3:])
+	if err == nil && withScores {
+		err = datastore.ErrSyntax
+	}
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	n, err := c.datastore.ZRangeStore(args[1], args[2], spec)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, int64(n))
+}
+
+// zcount handles the ZCOUNT and ZLEXCOUNT commands.
+// It takes an array of arguments with the following format: [cmd, key, min, max].
+func (c *Client) zcount(args []string, by datastore.ZRangeBy) {
+	spec := datastore.ZRangeSpec{By: by}
+	var err error
+	if by == datastore.ZRangeByLex {
+		spec.Lex, err = datastore.ParseLexRange(args[2], args[3])
+	} else {
+		spec.Score, err = datastore.ParseScoreRange(args[2], args[3])
+	}
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	n, err := c.datastore.ZCount(args[1], spec)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	protocol.WriteInteger(c.conn, int64(n))
+}
+
+// zremrange handles the ZREMRANGEBYRANK, ZREMRANGEBYSCORE and ZREMRANGEBYLEX
+// commands. The removed members are logged to the AOF as a ZREM.
+// It takes an array of arguments with the following format: [cmd, key, min, max].
+func (c *Client) zremrange(args []string, by datastore.ZRangeBy) {
+	spec := datastore.ZRangeSpec{By: by, Count: -1}
+	var err error
+	switch by {
+	case datastore.ZRangeByRank:
+		var err1, err2 error
+		spec.Start, err1 = strconv.Atoi(args[2])
+		spec.Stop, err2 = strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil {
+			protocol.WriteError(c.conn, errNotInteger)
+			return
+		}
+	case datastore.ZRangeByScore:
+		spec.Score, err = datastore.ParseScoreRange(args[2], args[3])
+	case datastore.ZRangeByLex:
+		spec.Lex, err = datastore.ParseLexRange(args[2], args[3])
+	}
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	removed, err := c.datastore.ZRemRange(args[1], spec)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if len(removed) > 0 {
+		c.propagate(append([]string{"ZREM", args[1]}, removed...))
+	}
+	protocol.WriteInteger(c.conn, int64(len(removed)))
+}
+
+// zpop handles the ZPOPMIN and ZPOPMAX commands.
+// It takes an array of arguments with the following format: [cmd, key, [count]].
+// The popped members are logged to the AOF as a ZREM.
+func (c *Client) zpop(args []string, highest bool) {
+	if len(args) > 3 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			protocol.WriteError(c.conn, "ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	members, err := c.datastore.ZPop(args[1], count, highest)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	if len(members) > 0 {
+		entry := []string{"ZREM", args[1]}
+		for _, m := range members {
+			entry = append(entry, m.Member)
+		}
+		c.propagate(entry)
+	}
+	c.writeZMembers(members, true)
+}
+
+// zcombine handles the ZUNION, ZINTER and ZDIFF commands.
+// It takes an array of arguments with the following format:
+// [cmd, numkeys, key, ..., [WEIGHTS weight ...], [AGGREGATE SUM|MIN|MAX], [WITHSCORES]].
+func (c *Client) zcombine(args []string, op datastore.SetOp) {
+	spec, err := datastore.ParseZCombineArgs(op, args[0], args[1:], true)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	members, err := c.datastore.ZCombine(spec)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.writeZMembers(members, spec.WithScores)
+}
+
+// zcombineStore handles the ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE commands.
+// It takes an array of arguments with the following format:
+// [cmd, destination, numkeys, key, ..., [WEIGHTS weight ...], [AGGREGATE SUM|MIN|MAX]].
+func (c *Client) zcombineStore(args []string, op datastore.SetOp) {
+	spec, err := datastore.ParseZCombineArgs(op, args[0], args[2:], false)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	n, err := c.datastore.ZCombineStore(args[1], spec)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.dirty = true
+	protocol.WriteInteger(c.conn, int64(n))
+}
+
+// zrandmember handles the ZRANDMEMBER command for the client.
+// It takes an array of arguments with the following format: ["ZRANDMEMBER", key, [count, [WITHSCORES]]].
+func (c *Client) zrandmember(args []string) {
+	if len(args) > 4 {
+		protocol.WriteError(c.conn, errWrongArgs(args[0]))
+		return
+	}
+	if len(args) == 2 {
+		members, err := c.datastore.ZRandMember(args[1], 1)
+		if err != nil {
+			protocol.WriteError(c.conn, err.Error())
+		} else if len(members) == 0 {
+			protocol.WriteNullBulkString(c.conn)
+		} else {
+			protocol.WriteBulkString(c.conn, members[0].Member)
+		}
+		return
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		protocol.WriteError(c.conn, errNotInteger)
+		return
+	}
+	withScores := len(args) == 4
+	if withScores && strings.ToUpper(args[3]) != "WITHSCORES" {
+		protocol.WriteError(c.conn, "ERR syntax error")
+		return
+	}
+	members, err := c.datastore.ZRandMember(args[1], count)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.writeZMembers(members, withScores)
+}
+
+// zscan handles the ZSCAN command for the client.
+// It takes an array of arguments with the following format:
+// ["ZSCAN", key, cursor, [MATCH pattern], [COUNT count]].
+func (c *Client) zscan(args []string) {
+	opts, ok := c.parseScanArgs(args, 2, false, false)
+	if !ok {
+		return
+	}
+	next, members, err := c.datastore.ZScan(args[1], opts.cursor, opts.count, opts.pattern)
+	if err != nil {
+		protocol.WriteError(c.conn, err.Error())
+		return
+	}
+	c.writeScanReply(next, flattenZMembers(members, true))
+}
+
+// writeZMembers writes members as an array, interleaving the scores when
+// withScores is set.
+func (c *Client) writeZMembers(members []datastore.ZMember, withScores bool) {
+	protocol.WriteArray(c.conn, flattenZMembers(members, withScores))
+}
+
+// flattenZMembers returns the member names, each followed by its score when
+// withScores is set.
+func flattenZMembers(members []datastore.ZMember, withScores bool) []string {
+	flat := make([]string, 0, 2*len(members))
+	for _, m := range members {
+		flat = append(flat, m.Member)
+		if withScores {
+			flat = append(flat, datastore.FormatScore(m.Score))
+		}
+	}
+	return flat
+}
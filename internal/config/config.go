@@ -0,0 +1,185 @@
+// Package config holds the registry of tunable server parameters. Packages
+// register their parameters at init time, and the registry backs both the
+// CONFIG GET/SET commands and the --name value command-line flags.
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/manimovassagh/Godis/internal/glob"
+)
+
+// Param describes a single tunable parameter.
+type Param struct {
+	Name string
+	Get  func() string
+	Set  func(value string) error
+}
+
+var (
+	mu     sync.RWMutex
+	params = make(map[string]Param)
+)
+
+// Register adds p to the registry. Names are case-insensitive.
+func Register(p Param) {
+	mu.Lock()
+	defer mu.Unlock()
+	p.Name = strings.ToLower(p.Name)
+	params[p.Name] = p
+}
+
+// Lookup returns the parameter with the given name.
+func Lookup(name string) (Param, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, found := params[strings.ToLower(name)]
+	return p, found
+}
+
+// Match returns the parameters whose name matches the glob pattern, sorted by
+// name.
+func Match(pattern string) []Param {
+	mu.RLock()
+	defer mu.RUnlock()
+	pattern = strings.ToLower(pattern)
+	var matched []Param
+	for name, p := range params {
+		if glob.Match(pattern, name) {
+			matched = append(matched, p)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+	return matched
+}
+
+// Set changes the value of the named parameter.
+func Set(name, value string) error {
+	p, found := Lookup(name)
+	if !found {
+		return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name)
+	}
+	if err := p.Set(value); err != nil {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", p.Name, err)
+	}
+	return nil
+}
+
+// IntParam returns a parameter backed by v that accepts integers between min
+// and max, both inclusive.
+func IntParam(name string, v *atomic.Int64, min, max int64) Param {
+	return Param{
+		Name: name,
+		Get:  func() string { return strconv.FormatInt(v.Load(), 10) },
+		Set: func(value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			v.Store(n)
+			return nil
+		},
+	}
+}
+
+// memoryUnits maps the units accepted by MemoryParam to their size in bytes.
+// As in redis.conf, k is a thousand bytes while kb is 1024.
+var memoryUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1000,
+	"kb": 1024,
+	"m":  1000 * 1000,
+	"mb": 1024 * 1024,
+	"g":  1000 * 1000 * 1000,
+	"gb": 1024 * 1024 * 1024,
+}
+
+// MemoryParam returns a parameter backed by v that accepts an amount of
+// memory, as a number of bytes optionally followed by a unit such as 100mb.
+// Its value reads back in bytes.
+func MemoryParam(name string, v *atomic.Int64) Param {
+	return Param{
+		Name: name,
+		Get:  func() string { return strconv.FormatInt(v.Load(), 10) },
+		Set: func(value string) error {
+			value = strings.ToLower(value)
+			digits := strings.TrimRight(value, "bkmg")
+			unit, found := memoryUnits[value[len(digits):]]
+			n, err := strconv.ParseInt(digits, 10, 64)
+			if !found || err != nil || n < 0 || n > math.MaxInt64/unit {
+				return fmt.Errorf("argument must be a memory value")
+			}
+			v.Store(n * unit)
+			return nil
+		},
+	}
+}
+
+// EnumParam returns a parameter backed by v that accepts one of names, case
+// insensitively, and stores its index.
+func EnumParam(name string, v *atomic.Int64, names ...string) Param {
+	return Param{
+		Name: name,
+		Get:  func() string { return names[v.Load()] },
+		Set: func(value string) error {
+			for i, n := range names {
+				if strings.EqualFold(value, n) {
+					v.Store(int64(i))
+					return nil
+				}
+			}
+			return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(names, ", "))
+		},
+	}
+}
+
+// BoolParam returns a parameter backed by v that accepts yes or no, case
+// insensitively, as redis.conf does.
+func BoolParam(name string, v *atomic.Bool) Param {
+	return Param{
+		Name: name,
+		Get: func() string {
+			if v.Load() {
+				return "yes"
+			}
+			return "no"
+		},
+		Set: func(value string) error {
+			switch strings.ToLower(value) {
+			case "yes":
+				v.Store(true)
+			case "no":
+				v.Store(false)
+			default:
+				return fmt.Errorf("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+	}
+}
+
+// ParseArgs applies command-line arguments of the form --name value, as
+// accepted by redis-server.
+func ParseArgs(args []string) error {
+	for i := 0; i < len(args); i++ {
+		name, found := strings.CutPrefix(args[i], "--")
+		if !found || i+1 >= len(args) {
+			return fmt.Errorf("invalid argument %q, expected --name value", args[i])
+		}
+		if err := Set(name, args[i+1]); err != nil {
+			return err
+		}
+		i++
+	}
+	return nil
+}
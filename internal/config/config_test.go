@@ -0,0 +1,79 @@
+package config
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// TestIntParam tests registering, reading and changing an integer parameter
+func TestIntParam(t *testing.T) {
+	var v atomic.Int64
+	v.Store(5)
+	Register(IntParam("test-int-param", &v, 0, 10))
+
+	matched := Match("test-int-*")
+	if len(matched) != 1 || matched[0].Get() != "5" {
+		t.Fatalf("Expected to match test-int-param with value 5, got %v", matched)
+	}
+	if err := Set("TEST-INT-PARAM", "7"); err != nil || v.Load() != 7 {
+		t.Errorf("Expected value 7 after Set, got %d (%v)", v.Load(), err)
+	}
+	if err := Set("test-int-param", "11"); err == nil {
+		t.Errorf("Expected out of range value to be rejected")
+	}
+	if err := Set("no-such-param", "1"); err == nil {
+		t.Errorf("Expected unknown parameter to be rejected")
+	}
+	if err := ParseArgs([]string{"--test-int-param", "3"}); err != nil || v.Load() != 3 {
+		t.Errorf("Expected value 3 after ParseArgs, got %d (%v)", v.Load(), err)
+	}
+}
+
+// TestMemoryParam tests memory values with and without units
+func TestMemoryParam(t *testing.T) {
+	var v atomic.Int64
+	Register(MemoryParam("test-memory-param", &v))
+	for value, expected := range map[string]int64{"100": 100, "1k": 1000, "1KB": 1024, "2mb": 2 << 20, "1gb": 1 << 30, "0": 0} {
+		if err := Set("test-memory-param", value); err != nil || v.Load() != expected {
+			t.Errorf("Expected %s to set %d, got %d (%v)", value, expected, v.Load(), err)
+		}
+	}
+	for _, value := range []string{"", "mb", "-1", "1tb", "1.5mb", "99999999999gb"} {
+		if err := Set("test-memory-param", value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+// TestEnumParam tests that only the listed names are accepted
+func TestEnumParam(t *testing.T) {
+	var v atomic.Int64
+	Register(EnumParam("test-enum-param", &v, "first", "second"))
+	if err := Set("test-enum-param", "SECOND"); err != nil || v.Load() != 1 {
+		t.Errorf("Expected index 1, got %d (%v)", v.Load(), err)
+	}
+	if p, _ := Lookup("test-enum-param"); p.Get() != "second" {
+		t.Errorf("Expected second, got %s", p.Get())
+	}
+	if err := Set("test-enum-param", "third"); err == nil {
+		t.Errorf("Expected an unknown name to be rejected")
+	}
+}
+
+// TestBoolParam tests that only yes and no are accepted
+func TestBoolParam(t *testing.T) {
+	var v atomic.Bool
+	Register(BoolParam("test-bool-param", &v))
+	if err := Set("test-bool-param", "YES"); err != nil || !v.Load() {
+		t.Errorf("Expected true, got %v (%v)", v.Load(), err)
+	}
+	if p, _ := Lookup("test-bool-param"); p.Get() != "yes" {
+		t.Errorf("Expected yes, got %s", p.Get())
+	}
+	if err := Set("test-bool-param", "no"); err != nil || v.Load() {
+		t.Errorf("Expected false, got %v (%v)", v.Load(), err)
+	}
+	if err := Set("test-bool-param", "1"); err == nil {
+		t.Errorf("Expected 1 to be rejected")
+	}
+}
@@ -0,0 +1,85 @@
+package datastore
+
+import "sync/atomic"
+
+// Clients blocked on a key (BLPOP, BZPOPMIN, XREAD BLOCK, ...) are parked by
+// the commands package. The data store only keeps track of which keys have
+// waiters and reports the ones that received new data, so the client that
+// made the write can serve the waiters right after its command.
+
+// BlockKey records one more client waiting on key.
+func (ds *DataStore) BlockKey(key string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.blocked[key]++
+}
+
+// UnblockKey records that a client stopped waiting on key.
+func (ds *DataStore) UnblockKey(key string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.blocked[key] <= 1 {
+		delete(ds.blocked, key)
+		delete(ds.readyKeys, key)
+		return
+	}
+	ds.blocked[key]--
+}
+
+// signalKeyAsReady records that key received data that may serve clients
+// blocked on it. The caller must hold the write lock.
+func (ds *DataStore) signalKeyAsReady(key string) {
+	if ds.blocked[key] > 0 {
+		ds.readyKeys[key] = struct{}{}
+		keysReady.Store(true)
+	}
+}
+
+// signalBlockedKeys records the keys with waiters that exist as ready, after
+// the contents of the database changed wholesale. The caller must hold the
+// write lock.
+func (ds *DataStore) signalBlockedKeys() {
+	for key := range ds.blocked {
+		if _, found := ds.data.Get(key); found {
+			ds.signalKeyAsReady(key)
+		}
+	}
+}
+
+// keysReady is set when a database may have ready keys, so that ReadyKeys
+// does not have to lock every database after each command.
+var keysReady atomic.Bool
+
+// ReadyKey is a key with waiters that received data, in database DB.
+type ReadyKey struct {
+	DB  *DataStore
+	Key string
+}
+
+// ReadyKeys returns the keys with waiters that received data since the last
+// call, in every database, and forgets them.
+func ReadyKeys() []ReadyKey {
+	if !keysReady.Swap(false) {
+		return nil
+	}
+	var ready []ReadyKey
+	for _, ds := range databases {
+		for _, key := range ds.takeReadyKeys() {
+			ready = append(ready, ReadyKey{ds, key})
+		}
+	}
+	return ready
+}
+
+// takeReadyKeys returns the ready keys of the database in sorted order and
+// forgets them.
+func (ds *DataStore) takeReadyKeys() []string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if len(ds.readyKeys) == 0 {
+		return nil
+	}
+	keys := sortedKeys(ds.readyKeys)
+	clear(ds.readyKeys)
+	return keys
+}
@@ -1,43 +1,469 @@
 package datastore
 
-import "sync"
+import (
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/manimovassagh/Godis/internal/config"
+)
+
+const (
+	// activeExpireInterval is how often the background expiry cycle runs.
+	activeExpireInterval = 100 * time.Millisecond
+	// activeExpireSampleSize is the number of keys with a TTL sampled per round.
+	activeExpireSampleSize = 20
+	// activeExpireBudget bounds how long a single expiry cycle may run.
+	activeExpireBudget = 25 * time.Millisecond
+	// activeRehashBudget bounds how long the keyspace is rehashed on each
+	// run of the background cycle.
+	activeRehashBudget = time.Millisecond
+)
+
+var (
+	// ErrWrongType is returned when a command is run against a key holding a
+	// value of a different type.
+	ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	// ErrNoSuchKey is returned when a command requires an existing key.
+	ErrNoSuchKey = errors.New("ERR no such key")
+)
+
+// DataStore is a numbered database mapping keys to values. A value is either
+// a string, stored as an int64 if it is an integer (see string.go), or one of
+// the aggregate types defined in this package, such as *List.
+//
+// The server holds a fixed number of databases, set by the databases
+// parameter. SWAPDB exchanges the contents of two databases, while the
+// clients, waiters and watchers attached to each keep their number.
 type DataStore struct {
-	data map[string]string
-	mu   sync.RWMutex
+	id      int
+	data    *dict[any]
+	expires map[string]int64 // absolute deadlines in Unix milliseconds
+	mu      sync.RWMutex
+
+	// blocked counts the clients waiting on each key, and readyKeys holds
+	// the keys among them that received data. See blocking.go.
+	blocked   map[string]int
+	readyKeys map[string]struct{}
+
+	// watched holds the version of every key watched by a client. See
+	// watch.go.
+	watched map[string]*watchedKey
+
+	// meta holds the bookkeeping of every key, and used the memory
+	// accounted to them. See memory.go.
+	meta map[string]*keyMeta
+	used int64
+
+	// lazyfree feeds the values released in the background by UNLINK and
+	// FLUSHALL ASYNC to the lazyfree goroutine, which every database
+	// shares. See keyspace.go.
+	lazyfree chan any
+
+	// snapshot is the snapshot being written out by an AOF rewrite, for
+	// which keys are saved before they change. See rewrite.go.
+	snapshot *Snapshot
+}
+
+// databaseCount is the number of databases. It can only be changed before
+// the databases are created, that is with a command-line flag.
+var databaseCount atomic.Int64
+
+func init() {
+	databaseCount.Store(16)
+	p := config.IntParam("databases", &databaseCount, 1, math.MaxInt32)
+	set := p.Set
+	p.Set = func(value string) error {
+		if created.Load() {
+			return errors.New("can't set immutable config")
+		}
+		return set(value)
+	}
+	config.Register(p)
 }
 
 var (
-	instance *DataStore
-	once     sync.Once
+	databases []*DataStore
+	once      sync.Once
+	created   atomic.Bool
 )
 
-// GetDataStore returns a singleton instance of the DataStore. It is safe to call
-// from multiple goroutines.
-func GetDataStore() *DataStore {
+// fakeNow, unless zero, is the time Now returns, so tests can control the
+// clock. It is atomic as the active expiry goroutine reads the clock while
+// tests move it.
+var fakeNow atomic.Int64
+
+// Now returns the current time in Unix milliseconds, as the databases see
+// it when deciding whether a deadline passed. Commands computing or
+// reporting deadlines use it too, so that they agree with the expiry checks.
+func Now() int64 {
+	if t := fakeNow.Load(); t != 0 {
+		return t
+	}
+	return time.Now().UnixMilli()
+}
+
+// SetNow freezes the clock Now reads at ms, or lets it follow the current
+// time again if ms is zero. It lets tests control when keys expire.
+func SetNow(ms int64) {
+	fakeNow.Store(ms)
+}
+
+// loading is set while the AOF is replayed. As in Redis, no key or hash
+// field is expired meanwhile, neither lazily nor actively: the logged
+// commands ran against live keys, and must be replayed against the same
+// keys however long ago they ran. The keys that expired while the server
+// ran were logged as deleted when they did, and the keys whose deadline
+// passed since are expired once loading is done.
+var loading atomic.Bool
+
+// expiredHook, if set, receives the deletions of the keys and hash fields
+// whose deadline passed, as DEL and HDEL commands applying to a database,
+// so that they can be logged to the AOF.
+var expiredHook atomic.Pointer[func(db int, args []string)]
+
+// OnExpired sets the function passed the deletions of expired keys and hash
+// fields, or unsets it if fn is nil. fn may be called holding the lock of
+// the database.
+func OnExpired(fn func(db int, args []string)) {
+	if fn == nil {
+		expiredHook.Store(nil)
+		return
+	}
+	expiredHook.Store(&fn)
+}
+
+// propagateExpired passes the deletion of an expired key or of expired hash
+// fields to the hook set by OnExpired.
+func (ds *DataStore) propagateExpired(args []string) {
+	if fn := expiredHook.Load(); fn != nil {
+		(*fn)(ds.id, args)
+	}
+}
+
+// cycleLock, if set, is held by the active expiry cycle while it deletes
+// keys, so that the deletions it logs are ordered with those of commands.
+var cycleLock atomic.Pointer[sync.Locker]
+
+// SetCycleLock sets the lock held by the active expiry cycle while it runs.
+func SetCycleLock(l sync.Locker) {
+	cycleLock.Store(&l)
+}
+
+// SetLoading turns the expiry of keys off while the AOF is replayed, and
+// back on.
+func SetLoading(on bool) {
+	loading.Store(on)
+}
+
+// deadlinePassed reports whether the deadline at, in Unix milliseconds, has
+// passed, which no deadline has while loading.
+func deadlinePassed(at int64) bool {
+	return at <= Now() && !loading.Load()
+}
+
+// createDatabases creates the databases on first use and starts the
+// background goroutines that actively expire keys and release lazily freed
+// values.
+func createDatabases() {
 	once.Do(func() {
-		instance = &DataStore{
-			data: make(map[string]string),
+		created.Store(true)
+		lazyfree := make(chan any, lazyfreeQueueSize)
+		databases = make([]*DataStore, databaseCount.Load())
+		for i := range databases {
+			databases[i] = &DataStore{
+				id:        i,
+				data:      newDict[any](),
+				expires:   make(map[string]int64),
+				blocked:   make(map[string]int),
+				readyKeys: make(map[string]struct{}),
+				watched:   make(map[string]*watchedKey),
+				meta:      make(map[string]*keyMeta),
+				lazyfree:  lazyfree,
+			}
 		}
+		go activeExpireLoop()
+		go lazyfreeLoop(lazyfree)
 	})
+}
+
+// GetDataStore returns database 0, which clients use until they SELECT
+// another one. It is safe to call from multiple goroutines.
+func GetDataStore() *DataStore {
+	return GetDatabase(0)
+}
+
+// GetDatabase returns the database numbered id, or nil if there is no such
+// database.
+func GetDatabase(id int) *DataStore {
+	createDatabases()
+	if id < 0 || id >= len(databases) {
+		return nil
+	}
+	return databases[id]
+}
+
+// Databases returns the number of databases.
+func Databases() int {
+	createDatabases()
+	return len(databases)
+}
 
-	return instance
+// ID returns the number of the database.
+func (ds *DataStore) ID() int {
+	return ds.id
 }
 
-// Set sets the given key-value pair in the in-memory data store. It is thread-safe
-// and can be safely called from multiple goroutines concurrently.
+// Set sets the given key-value pair in the in-memory data store, discarding any
+// expiry the key had. It is thread-safe and can be safely called from multiple
+// goroutines concurrently.
 func (ds *DataStore) Set(key, value string) {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
-	ds.data[key] = value
+	ds.preserve(key)
+	ds.data.Set(key, newString(value))
+	delete(ds.expires, key)
+	ds.signalModifiedKey(key)
+}
+
+// SetWithExpireAt sets the given key-value pair and gives it the absolute
+// deadline at, in Unix milliseconds. A deadline in the past leaves the key
+// deleted.
+func (ds *DataStore) SetWithExpireAt(key, value string, at int64) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if deadlinePassed(at) {
+		ds.deleteKey(key)
+		return
+	}
+	ds.preserve(key)
+	ds.data.Set(key, newString(value))
+	ds.expires[key] = at
+	ds.signalModifiedKey(key)
 }
 
 // Get looks up the given key in the in-memory data store and returns the associated
-// value, or ("", false) if the key is not found. It is thread-safe and can be
-// safely called from multiple goroutines concurrently.
-func (ds *DataStore) Get(key string) (string, bool) {
+// value, or ("", false) if the key is not found. Keys whose deadline has passed
+// are deleted on access. It returns ErrWrongType if the key holds a non-string
+// value. It is thread-safe and can be safely called from multiple goroutines
+// concurrently.
+func (ds *DataStore) Get(key string) (string, bool, error) {
 	ds.mu.RLock()
-	defer ds.mu.RUnlock()
-	value, found := ds.data[key]
+	value, found := ds.data.Get(key)
+	expired := found && ds.isExpired(key)
+	if found && !expired {
+		ds.touch(key)
+	}
+	ds.mu.RUnlock()
+	if expired {
+		ds.mu.Lock()
+		value, found = ds.lookup(key)
+		ds.mu.Unlock()
+	}
+	if !found {
+		return "", false, nil
+	}
+	str, ok := asString(value)
+	if !ok {
+		return "", false, ErrWrongType
+	}
+	return str, true, nil
+}
+
+// ExpireAt sets the deadline of the given key to the absolute Unix time at, in
+// milliseconds, if cond allows it. A deadline in the past deletes the key
+// immediately. It returns 0 if the key does not exist or cond does not hold,
+// 1 if the deadline was set and 2 if the key was deleted.
+func (ds *DataStore) ExpireAt(key string, at int64, cond ExpireCondition) int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.expireIfNeeded(key)
+	if _, found := ds.data.Get(key); !found {
+		return 0
+	}
+	if !cond.allows(ds.expires[key], at) {
+		return 0
+	}
+	if deadlinePassed(at) {
+		ds.deleteKey(key)
+		return 2
+	}
+	ds.preserve(key)
+	ds.expires[key] = at
+	ds.signalModifiedKey(key)
+	return 1
+}
+
+// ExpireTime returns the absolute deadline of the given key in Unix
+// milliseconds. Like Redis, it returns -2 if the key does not exist and -1 if
+// the key exists but has no associated expiry.
+func (ds *DataStore) ExpireTime(key string) int64 {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.expireIfNeeded(key)
+	if _, found := ds.data.Get(key); !found {
+		return -2
+	}
+	at, found := ds.expires[key]
+	if !found {
+		return -1
+	}
+	return at
+}
+
+// Persist removes the expiry of the given key. It returns true if an expiry was
+// removed and false if the key does not exist or has no expiry.
+func (ds *DataStore) Persist(key string) bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.expireIfNeeded(key)
+	if _, found := ds.expires[key]; !found {
+		return false
+	}
+	ds.preserve(key)
+	delete(ds.expires, key)
+	ds.signalModifiedKey(key)
+	return true
+}
+
+// Encoding returns the internal encoding of the value stored at key as
+// reported by OBJECT ENCODING.
+func (ds *DataStore) Encoding(key string) (string, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	value, found := ds.peek(key)
+	if !found {
+		return "", false
+	}
+	switch v := value.(type) {
+	case int64:
+		return "int", true
+	case string:
+		if len(v) <= 44 {
+			return "embstr", true
+		}
+		return "raw", true
+	case *List:
+		return "quicklist", true
+	case *Hash:
+		return "hashtable", true
+	case *Set:
+		return v.Encoding(), true
+	case *ZSet:
+		return "skiplist", true
+	case *Stream:
+		return "stream", true
+	}
+	return "unknown", true
+}
+
+// lookup returns the live value stored at key, deleting it first if its
+// deadline has passed, and records the access. The caller must hold the
+// write lock.
+func (ds *DataStore) lookup(key string) (any, bool) {
+	value, found := ds.peek(key)
+	if found {
+		ds.touch(key)
+	}
 	return value, found
 }
+
+// peek is like lookup, but does not count as an access. The caller must
+// hold the write lock.
+func (ds *DataStore) peek(key string) (any, bool) {
+	ds.preserve(key)
+	ds.expireIfNeeded(key)
+	return ds.data.Get(key)
+}
+
+// isExpired reports whether the key has a deadline that has already passed.
+// The caller must hold at least a read lock.
+func (ds *DataStore) isExpired(key string) bool {
+	at, found := ds.expires[key]
+	return found && deadlinePassed(at)
+}
+
+// expireIfNeeded deletes the key if its deadline has passed. The caller must
+// hold the write lock.
+func (ds *DataStore) expireIfNeeded(key string) {
+	if ds.isExpired(key) {
+		ds.expireKey(key)
+	}
+}
+
+// expireKey deletes a key whose deadline passed, and propagates its
+// deletion. The caller must hold the write lock.
+func (ds *DataStore) expireKey(key string) {
+	ds.deleteKey(key)
+	ds.propagateExpired([]string{"DEL", key})
+}
+
+// deleteKey removes the key and its expiry. The caller must hold the write lock.
+func (ds *DataStore) deleteKey(key string) {
+	ds.preserve(key)
+	ds.data.Delete(key)
+	delete(ds.expires, key)
+	ds.signalModifiedKey(key)
+}
+
+// activeExpireLoop periodically runs activeExpireCycle on every database so
+// that keys which are never accessed again still get reclaimed. It also moves
+// the keyspaces along when they are being resized, so that a table that
+// stopped receiving writes does not stay split between two tables.
+func activeExpireLoop() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, ds := range databases {
+			if l := cycleLock.Load(); l != nil {
+				(*l).Lock()
+				ds.activeExpireCycle()
+				(*l).Unlock()
+			} else {
+				ds.activeExpireCycle()
+			}
+			ds.mu.Lock()
+			ds.data.rehashFor(activeRehashBudget)
+			ds.mu.Unlock()
+		}
+	}
+}
+
+// activeExpireCycle implements the Redis active expiry algorithm: sample a small
+// number of keys with a TTL, delete the expired ones, and repeat while more than
+// a quarter of the sample was expired and the time budget allows.
+func (ds *DataStore) activeExpireCycle() {
+	if loading.Load() {
+		return
+	}
+	start := time.Now()
+	for {
+		ds.mu.Lock()
+		sampled := 0
+		var expired []string
+		current := Now()
+		// Map iteration order is randomized, which gives us the sampling for free.
+		for key, at := range ds.expires {
+			if sampled == activeExpireSampleSize {
+				break
+			}
+			sampled++
+			if at <= current {
+				ds.deleteKey(key)
+				expired = append(expired, key)
+			}
+		}
+		ds.mu.Unlock()
+		// The deletions are propagated without holding the lock, which an
+		// AOF rewrite takes while holding the lock of the AOF.
+		for _, key := range expired {
+			ds.propagateExpired([]string{"DEL", key})
+		}
+
+		if sampled == 0 || len(expired)*4 <= sampled || time.Since(start) > activeExpireBudget {
+			return
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package datastore
 
 import (
+	"fmt"
+	"strings"
 	"sync"
 	"testing"
+
+	"github.com/manimovassagh/Godis/internal/config"
 )
 
 // TestGetDataStore tests the singleton behavior of GetDataStore
@@ -15,6 +19,20 @@ func TestGetDataStore(t *testing.T) {
 	}
 }
 
+// TestDatabases tests that the databases are numbered and that their number
+// can not change once they exist
+func TestDatabases(t *testing.T) {
+	if Databases() != 16 || GetDatabase(15).ID() != 15 || GetDatabase(16) != nil || GetDatabase(-1) != nil {
+		t.Errorf("Expected 16 databases")
+	}
+	if GetDataStore() != GetDatabase(0) {
+		t.Errorf("Expected GetDataStore to return database 0")
+	}
+	if err := config.Set("databases", "32"); err == nil || !strings.Contains(err.Error(), "can't set immutable config") {
+		t.Errorf("Expected the databases parameter to be immutable, got %v", err)
+	}
+}
+
 // TestSetAndGet tests the Set and Get methods
 func TestSetAndGet(t *testing.T) {
 	ds := GetDataStore()
@@ -23,7 +41,7 @@ func TestSetAndGet(t *testing.T) {
 	ds.Set("foo", "bar")
 
 	// Test Get for existing key
-	value, found := ds.Get("foo")
+	value, found, _ := ds.Get("foo")
 	if !found {
 		t.Errorf("Expected to find key 'foo', but it was not found")
 	}
@@ -32,7 +50,7 @@ func TestSetAndGet(t *testing.T) {
 	}
 
 	// Test Get for non-existing key
-	_, found = ds.Get("nonexistent")
+	_, found, _ = ds.Get("nonexistent")
 	if found {
 		t.Errorf("Expected 'nonexistent' key not to be found, but it was found")
 	}
@@ -59,7 +77,7 @@ func TestConcurrentAccess(t *testing.T) {
 	// Test if the values were set correctly
 	for i := 0; i < numGoroutines; i++ {
 		key := string(rune('A' + i))
-		value, found := ds.Get(key)
+		value, found, _ := ds.Get(key)
 		if !found {
 			t.Errorf("Expected to find key '%s', but it was not found", key)
 		}
@@ -68,4 +86,193 @@ func TestConcurrentAccess(t *testing.T) {
 			t.Errorf("Expected value '%s', got '%s'", expectedValue, value)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// TestExpiry tests lazy expiry, ExpireTime and Persist
+func TestExpiry(t *testing.T) {
+	ds := GetDataStore()
+	current := int64(1_000_000)
+	fakeNow.Store(current)
+	defer fakeNow.Store(0)
+
+	ds.Set("ttl-key", "v")
+	if at := ds.ExpireTime("ttl-key"); at != -1 {
+		t.Errorf("Expected -1 for key without expiry, got %d", at)
+	}
+	if ds.ExpireAt("ttl-key", current+100, ExpireAlways) != 1 {
+		t.Fatalf("Expected ExpireAt to succeed on existing key")
+	}
+	if at := ds.ExpireTime("ttl-key"); at != current+100 {
+		t.Errorf("Expected deadline %d, got %d", current+100, at)
+	}
+
+	current = fakeNow.Add(100)
+	if _, found, _ := ds.Get("ttl-key"); found {
+		t.Errorf("Expected 'ttl-key' to be expired")
+	}
+	if at := ds.ExpireTime("ttl-key"); at != -2 {
+		t.Errorf("Expected -2 for expired key, got %d", at)
+	}
+
+	ds.SetWithExpireAt("ttl-key", "v", current+10)
+	if !ds.Persist("ttl-key") {
+		t.Errorf("Expected Persist to remove the expiry")
+	}
+	current = fakeNow.Add(1000)
+	if _, found, _ := ds.Get("ttl-key"); !found {
+		t.Errorf("Expected persisted key to survive")
+	}
+}
+
+// TestActiveExpireCycle tests that keys which are never accessed are reclaimed
+func TestActiveExpireCycle(t *testing.T) {
+	ds := GetDataStore()
+	current := int64(1_000_000)
+	fakeNow.Store(current)
+	defer fakeNow.Store(0)
+
+	for i := 0; i < 100; i++ {
+		ds.SetWithExpireAt(fmt.Sprintf("active-%d", i), "v", current+1)
+	}
+	current = fakeNow.Add(2)
+	ds.activeExpireCycle()
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	for i := 0; i < 100; i++ {
+		if _, found := ds.data.Get(fmt.Sprintf("active-%d", i)); found {
+			t.Errorf("Expected active-%d to be reclaimed by the active expiry cycle", i)
+		}
+	}
+}
+
+// TestWrongType tests that string and list operations reject each other's keys
+func TestWrongType(t *testing.T) {
+	ds := GetDataStore()
+	ds.Set("wrongtype-string", "v")
+	if _, err := ds.Push("wrongtype-string", true, "a"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType pushing onto a string, got %v", err)
+	}
+
+	if _, err := ds.Push("wrongtype-list", true, "a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := ds.Get("wrongtype-list"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType reading a list as a string, got %v", err)
+	}
+
+	if _, err := ds.Pop("wrongtype-list", true, 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := ds.Get("wrongtype-list"); err != nil {
+		t.Errorf("Expected emptied list to be deleted, got %v", err)
+	}
+}
+
+// TestHashFieldExpiry tests that expired hash fields disappear and take the key with them
+func TestHashFieldExpiry(t *testing.T) {
+	ds := GetDataStore()
+	current := int64(1_000_000)
+	fakeNow.Store(current)
+	defer fakeNow.Store(0)
+
+	ds.HSet("hash-ttl", "a", "1", "b", "2")
+	results, _ := ds.HExpireAt("hash-ttl", current+10, ExpireAlways, "a", "missing")
+	if results[0] != 1 || results[1] != -2 {
+		t.Errorf("Unexpected HExpireAt results %v", results)
+	}
+	if results, _ := ds.HExpireAt("hash-ttl", current+5, ExpireGT, "a"); results[0] != 0 {
+		t.Errorf("Expected GT to reject an earlier deadline, got %v", results)
+	}
+
+	current = fakeNow.Add(10)
+	if _, found, _ := ds.HGet("hash-ttl", "a"); found {
+		t.Errorf("Expected field 'a' to be expired")
+	}
+	if n, _ := ds.HLen("hash-ttl"); n != 1 {
+		t.Errorf("Expected 1 remaining field, got %d", n)
+	}
+
+	ds.HExpireAt("hash-ttl", current+1, ExpireAlways, "b")
+	current = fakeNow.Add(1)
+	ds.mu.Lock()
+	_, err := ds.lookupHash("hash-ttl", false)
+	_, exists := ds.data.Get("hash-ttl")
+	ds.mu.Unlock()
+	if err != nil || exists {
+		t.Errorf("Expected key to be deleted once all fields expired")
+	}
+}
+
+// TestReadyKeys tests that only keys with waiters are reported as ready, in
+// the database they belong to
+func TestReadyKeys(t *testing.T) {
+	ds, other := GetDataStore(), GetDatabase(1)
+	ready := func() []string {
+		var keys []string
+		for _, r := range ReadyKeys() {
+			keys = append(keys, fmt.Sprintf("%d/%s", r.DB.ID(), r.Key))
+		}
+		return keys
+	}
+	ds.BlockKey("ready:list")
+	ds.BlockKey("ready:zset")
+	other.BlockKey("ready:list")
+
+	if _, err := ds.Push("ready:list", false, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Push("ready:other", false, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.ZAdd("ready:zset", ZAddOptions{}, ZMember{Member: "m", Score: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ready(); fmt.Sprint(got) != "[0/ready:list 0/ready:zset]" {
+		t.Errorf("Expected the blocked keys to be ready, got %q", got)
+	}
+	if got := ready(); got != nil {
+		t.Errorf("Expected no ready keys after reading them, got %q", got)
+	}
+
+	SwapDatabases(0, 1)
+	if got := ready(); fmt.Sprint(got) != "[1/ready:list]" {
+		t.Errorf("Expected SWAPDB to make the blocked keys of the other database ready, got %q", got)
+	}
+	SwapDatabases(0, 1)
+	other.UnblockKey("ready:list")
+	ready()
+
+	ds.Push("ready:list", false, "b")
+	ds.UnblockKey("ready:list")
+	ds.UnblockKey("ready:zset")
+	if got := ready(); got != nil {
+		t.Errorf("Expected unblocked keys to be forgotten, got %q", got)
+	}
+}
+
+// TestKeyVersions tests that writes bump the version of watched keys only
+func TestKeyVersions(t *testing.T) {
+	ds := GetDataStore()
+	version := ds.WatchKey("watch:key")
+
+	ds.SAdd("watch:key", "a")
+	if ds.KeyVersion("watch:key") == version {
+		t.Errorf("Expected SADD to bump the version")
+	}
+	version = ds.KeyVersion("watch:key")
+	ds.SAdd("watch:key", "a")
+	ds.SMembers("watch:key")
+	if ds.KeyVersion("watch:key") != version {
+		t.Errorf("Expected reads and no-op writes to keep the version")
+	}
+	ds.SRem("watch:key", "a")
+	if ds.KeyVersion("watch:key") == version {
+		t.Errorf("Expected deleting the key to bump the version")
+	}
+
+	ds.UnwatchKey("watch:key")
+	if _, found := ds.watched["watch:key"]; found {
+		t.Errorf("Expected the key to be forgotten once unwatched")
+	}
+}
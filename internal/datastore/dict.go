@@ -0,0 +1,385 @@
+package datastore
+
+import (
+	"hash/maphash"
+	"iter"
+	"math/bits"
+	"math/rand/v2"
+	"time"
+)
+
+// A dict is a hash table with string keys modeled on the dict of Redis. It
+// backs the keyspace and the hash, set and sorted set types, so that SCAN
+// and its variants can iterate them with a stateless cursor.
+//
+// The table is resized by allocating a second table and moving the buckets
+// over one at a time on each write, rather than all at once, so that growing
+// a large table never stalls the server. The cursor of Scan is the index of
+// the next bucket with its bits reversed: incrementing the high bits first
+// visits a bucket before the buckets it splits into when the table grows,
+// and after the buckets merged into it when the table shrinks, so that every
+// entry present for the whole iteration is returned at least once.
+//
+// Lookups never move buckets, so concurrent readers holding the read lock
+// can share a dict like a Go map.
+
+const (
+	// dictInitialSize is the number of buckets of a new table.
+	dictInitialSize = 4
+	// dictMinFill is the inverse of the fill ratio below which a table
+	// shrinks.
+	dictMinFill = 8
+	// dictRehashEmptyVisits bounds the empty buckets a rehash step may skip
+	// for each bucket it is asked to move.
+	dictRehashEmptyVisits = 10
+)
+
+type dictEntry[V any] struct {
+	key   string
+	value V
+	next  *dictEntry[V]
+}
+
+type dictTable[V any] struct {
+	buckets []*dictEntry[V]
+	used    int
+}
+
+// mask returns the mask selecting the bucket of a hash. The table must not
+// be empty.
+func (t *dictTable[V]) mask() uint64 {
+	return uint64(len(t.buckets) - 1)
+}
+
+type dict[V any] struct {
+	// tables[1] is only used while rehashing from tables[0].
+	tables [2]dictTable[V]
+	// rehashIdx is the next bucket of tables[0] to move, or -1 when not
+	// rehashing.
+	rehashIdx int
+	// pauseRehash counts the iterations in progress, during which buckets
+	// must not move.
+	pauseRehash int
+	seed        maphash.Seed
+}
+
+func newDict[V any]() *dict[V] {
+	return &dict[V]{rehashIdx: -1, seed: maphash.MakeSeed()}
+}
+
+// Len returns the number of entries.
+func (d *dict[V]) Len() int {
+	return d.tables[0].used + d.tables[1].used
+}
+
+func (d *dict[V]) isRehashing() bool {
+	return d.rehashIdx != -1
+}
+
+func (d *dict[V]) hash(key string) uint64 {
+	return maphash.String(d.seed, key)
+}
+
+// find returns the entry of key, or nil.
+func (d *dict[V]) find(key string, h uint64) *dictEntry[V] {
+	for i := range d.tables {
+		t := &d.tables[i]
+		if t.used == 0 {
+			continue
+		}
+		for e := t.buckets[h&t.mask()]; e != nil; e = e.next {
+			if e.key == key {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// Get returns the value of key.
+func (d *dict[V]) Get(key string) (V, bool) {
+	if e := d.find(key, d.hash(key)); e != nil {
+		return e.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Set sets key to value. It returns true if the key is new.
+func (d *dict[V]) Set(key string, value V) bool {
+	d.rehashStep()
+	h := d.hash(key)
+	if e := d.find(key, h); e != nil {
+		e.value = value
+		return false
+	}
+	d.expandIfNeeded()
+	t := &d.tables[0]
+	if d.isRehashing() {
+		t = &d.tables[1]
+	}
+	i := h & t.mask()
+	t.buckets[i] = &dictEntry[V]{key: key, value: value, next: t.buckets[i]}
+	t.used++
+	return true
+}
+
+// Delete removes key and returns its value, or false if it did not exist.
+func (d *dict[V]) Delete(key string) (V, bool) {
+	var zero V
+	if d.Len() == 0 {
+		return zero, false
+	}
+	d.rehashStep()
+	h := d.hash(key)
+	for i := range d.tables {
+		t := &d.tables[i]
+		if t.used == 0 {
+			continue
+		}
+		for prev, e := (*dictEntry[V])(nil), t.buckets[h&t.mask()]; e != nil; prev, e = e, e.next {
+			if e.key != key {
+				continue
+			}
+			if prev == nil {
+				t.buckets[h&t.mask()] = e.next
+			} else {
+				prev.next = e.next
+			}
+			t.used--
+			d.shrinkIfNeeded()
+			return e.value, true
+		}
+	}
+	return zero, false
+}
+
+// All returns an iterator over the entries in bucket order. The entry being
+// visited may be deleted, and no buckets move until the iteration ends.
+func (d *dict[V]) All() iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		d.pauseRehash++
+		defer func() { d.pauseRehash-- }()
+		for i := range d.tables {
+			for _, e := range d.tables[i].buckets {
+				for e != nil {
+					next := e.next
+					if !yield(e.key, e.value) {
+						return
+					}
+					e = next
+				}
+			}
+		}
+	}
+}
+
+// Keys returns the keys in bucket order.
+func (d *dict[V]) Keys() []string {
+	keys := make([]string, 0, d.Len())
+	for key := range d.All() {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Scan calls fn for the entries of the bucket at cursor, and of the buckets
+// it maps to in the other table while rehashing, and returns the cursor to
+// continue from, which is 0 once every bucket was visited. fn must not
+// modify the dict.
+func (d *dict[V]) Scan(cursor uint64, fn func(key string, value V)) uint64 {
+	if d.Len() == 0 {
+		return 0
+	}
+	d.pauseRehash++
+	defer func() { d.pauseRehash-- }()
+	visit := func(e *dictEntry[V]) {
+		for ; e != nil; e = e.next {
+			fn(e.key, e.value)
+		}
+	}
+	if !d.isRehashing() {
+		t := &d.tables[0]
+		visit(t.buckets[cursor&t.mask()])
+		return nextCursor(cursor, t.mask())
+	}
+	small, large := &d.tables[0], &d.tables[1]
+	if len(small.buckets) > len(large.buckets) {
+		small, large = large, small
+	}
+	m0, m1 := small.mask(), large.mask()
+	visit(small.buckets[cursor&m0])
+	// Visit the buckets of the larger table that the bucket of the smaller
+	// one expands to.
+	for {
+		visit(large.buckets[cursor&m1])
+		cursor = nextCursor(cursor, m1)
+		if cursor&(m0^m1) == 0 {
+			return cursor
+		}
+	}
+}
+
+// scanCount calls Scan from cursor until about count entries were visited,
+// or count*10 buckets when most of them are empty, as each call of SCAN
+// does. It returns the cursor to continue from.
+func (d *dict[V]) scanCount(cursor uint64, count int, fn func(key string, value V)) uint64 {
+	visited := 0
+	visit := func(key string, value V) {
+		visited++
+		fn(key, value)
+	}
+	for steps := count * 10; ; steps-- {
+		cursor = d.Scan(cursor, visit)
+		if cursor == 0 || steps <= 1 || visited >= count {
+			return cursor
+		}
+	}
+}
+
+// nextCursor increments the reversed bits of cursor under mask.
+func nextCursor(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// RandomEntry returns a random entry, or false if the dict is empty.
+func (d *dict[V]) RandomEntry() (string, V, bool) {
+	if d.Len() == 0 {
+		var zero V
+		return "", zero, false
+	}
+	var e *dictEntry[V]
+	for e == nil {
+		if d.isRehashing() {
+			// The buckets of tables[0] below rehashIdx are empty.
+			size0 := len(d.tables[0].buckets)
+			i := d.rehashIdx + rand.IntN(size0+len(d.tables[1].buckets)-d.rehashIdx)
+			if i >= size0 {
+				e = d.tables[1].buckets[i-size0]
+			} else {
+				e = d.tables[0].buckets[i]
+			}
+		} else {
+			e = d.tables[0].buckets[rand.IntN(len(d.tables[0].buckets))]
+		}
+	}
+	n := 0
+	for x := e; x != nil; x = x.next {
+		n++
+	}
+	for i := rand.IntN(n); i > 0; i-- {
+		e = e.next
+	}
+	return e.key, e.value, true
+}
+
+// clone returns a copy of the dict. The values are copied as is.
+func (d *dict[V]) clone() *dict[V] {
+	c := newDict[V]()
+	c.resize(d.Len())
+	for key, value := range d.All() {
+		c.Set(key, value)
+	}
+	return c
+}
+
+// expandIfNeeded allocates the first table, or starts growing the table
+// once it holds as many entries as buckets.
+func (d *dict[V]) expandIfNeeded() {
+	if d.isRehashing() {
+		return
+	}
+	if len(d.tables[0].buckets) == 0 {
+		d.resize(dictInitialSize)
+	} else if d.tables[0].used >= len(d.tables[0].buckets) {
+		d.resize(d.tables[0].used + 1)
+	}
+}
+
+// shrinkIfNeeded starts shrinking the table once it is less than
+// 1/dictMinFill full.
+func (d *dict[V]) shrinkIfNeeded() {
+	if d.isRehashing() || d.pauseRehash > 0 {
+		return
+	}
+	if size := len(d.tables[0].buckets); size > dictInitialSize && d.tables[0].used*dictMinFill <= size {
+		d.resize(d.tables[0].used)
+	}
+}
+
+// resize makes the table the smallest power of two holding n entries,
+// starting to rehash into it unless the current table is empty.
+func (d *dict[V]) resize(n int) {
+	size := dictInitialSize
+	for size < n {
+		size *= 2
+	}
+	if size == len(d.tables[0].buckets) {
+		return
+	}
+	t := dictTable[V]{buckets: make([]*dictEntry[V], size)}
+	if d.tables[0].used == 0 && d.pauseRehash == 0 {
+		d.tables[0] = t
+		return
+	}
+	d.tables[1] = t
+	d.rehashIdx = 0
+}
+
+// rehashStep moves a bucket to the new table while rehashing, unless an
+// iteration is in progress.
+func (d *dict[V]) rehashStep() {
+	if d.isRehashing() && d.pauseRehash == 0 {
+		d.rehash(1)
+	}
+}
+
+// rehashFor moves buckets to the new table for about duration, and reports
+// whether rehashing is still in progress.
+func (d *dict[V]) rehashFor(duration time.Duration) bool {
+	if !d.isRehashing() || d.pauseRehash > 0 {
+		return false
+	}
+	start := time.Now()
+	for d.rehash(100) {
+		if time.Since(start) > duration {
+			return true
+		}
+	}
+	return false
+}
+
+// rehash moves up to n buckets of tables[0] to tables[1], and reports
+// whether there are more to move.
+func (d *dict[V]) rehash(n int) bool {
+	emptyVisits := n * dictRehashEmptyVisits
+	from, to := &d.tables[0], &d.tables[1]
+	for ; n > 0 && from.used > 0; n-- {
+		for from.buckets[d.rehashIdx] == nil {
+			d.rehashIdx++
+			if emptyVisits--; emptyVisits == 0 {
+				return true
+			}
+		}
+		for e := from.buckets[d.rehashIdx]; e != nil; {
+			next := e.next
+			i := d.hash(e.key) & to.mask()
+			e.next = to.buckets[i]
+			to.buckets[i] = e
+			from.used--
+			to.used++
+			e = next
+		}
+		from.buckets[d.rehashIdx] = nil
+		d.rehashIdx++
+	}
+	if from.used > 0 {
+		return true
+	}
+	d.tables[0], d.tables[1] = d.tables[1], dictTable[V]{}
+	d.rehashIdx = -1
+	return false
+}
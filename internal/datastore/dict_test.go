@@ -0,0 +1,92 @@
+package datastore
+
+import (
+	"strconv"
+	"testing"
+)
+
+// TestDict tests that entries survive growing and shrinking the table
+func TestDict(t *testing.T) {
+	d := newDict[int]()
+	for i := 0; i < 1000; i++ {
+		if !d.Set(strconv.Itoa(i), i) {
+			t.Fatalf("Expected %d to be new", i)
+		}
+	}
+	if d.Set("7", 70) || d.Len() != 1000 {
+		t.Fatalf("Expected an update to keep 1000 entries, got %d", d.Len())
+	}
+	for i := 0; i < 1000; i += 2 {
+		if _, found := d.Delete(strconv.Itoa(i)); !found {
+			t.Fatalf("Expected %d to be deleted", i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		value, found := d.Get(strconv.Itoa(i))
+		if found != (i%2 == 1) || (found && value != i && i != 7) {
+			t.Fatalf("Expected %d to be found: %v, got %d", i, i%2 == 1, value)
+		}
+	}
+	for key := range d.All() {
+		d.Delete(key)
+	}
+	if d.Len() != 0 {
+		t.Errorf("Expected deleting during All to empty the dict, got %d entries", d.Len())
+	}
+	if _, _, found := d.RandomEntry(); found {
+		t.Errorf("Expected no random entry in an empty dict")
+	}
+}
+
+// TestDictScan tests that Scan returns every entry present for the whole
+// iteration while the table grows, shrinks and rehashes between calls
+func TestDictScan(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		change func(d *dict[int], step int)
+	}{
+		{"grow", func(d *dict[int], step int) {
+			for i := 0; i < 20 && step < 500; i++ {
+				d.Set("new:"+strconv.Itoa(step*20+i), 0)
+			}
+		}},
+		{"shrink", func(d *dict[int], step int) {
+			for i := 0; i < 20; i++ {
+				d.Delete("tmp:" + strconv.Itoa(step*20+i))
+			}
+		}},
+		{"rehash", func(d *dict[int], step int) {
+			if !d.isRehashing() {
+				d.resize(len(d.tables[0].buckets) * 2)
+			}
+			d.rehashStep()
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			d := newDict[int]()
+			for i := 0; i < 100; i++ {
+				d.Set(strconv.Itoa(i), i)
+			}
+			for i := 0; i < 2000; i++ {
+				d.Set("tmp:"+strconv.Itoa(i), i)
+			}
+			seen := make(map[string]bool)
+			cursor, steps := uint64(0), 0
+			for {
+				cursor = d.Scan(cursor, func(key string, _ int) { seen[key] = true })
+				if cursor == 0 {
+					break
+				}
+				test.change(d, steps)
+				if steps++; steps > 100000 {
+					t.Fatalf("Expected the scan to complete")
+				}
+			}
+			for i := 0; i < 100; i++ {
+				if !seen[strconv.Itoa(i)] {
+					t.Errorf("Expected %d to be returned", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package datastore
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/manimovassagh/Godis/internal/config"
+)
+
+// Once the memory accounted to the keys exceeds maxmemory, keys are evicted
+// before each command according to maxmemory-policy, as in Redis. The LRU,
+// LFU and TTL policies don't keep the keys ordered: they sample
+// maxmemory-samples keys of every database, keep the best candidates seen
+// so far in a small pool, and evict the best of the pool. Under noeviction,
+// or when nothing is left to evict, commands that may grow the memory in use
+// are rejected with ErrOOM instead.
+
+// ErrOOM is returned when the memory in use exceeds maxmemory and no key can
+// be evicted.
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+// evictionPolicy is the value of maxmemory-policy.
+type evictionPolicy int64
+
+const (
+	noEviction evictionPolicy = iota
+	allKeysLRU
+	allKeysLFU
+	allKeysRandom
+	volatileLRU
+	volatileLFU
+	volatileRandom
+	volatileTTL
+)
+
+// evictionPolicies holds the names of the policies, by value.
+var evictionPolicies = []string{
+	"noeviction", "allkeys-lru", "allkeys-lfu", "allkeys-random",
+	"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+}
+
+// volatile reports whether the policy only evicts keys with a TTL.
+func (p evictionPolicy) volatile() bool {
+	return p >= volatileLRU
+}
+
+// lfu reports whether the policy ranks keys by access frequency.
+func (p evictionPolicy) lfu() bool {
+	return p == allKeysLFU || p == volatileLFU
+}
+
+// evictionPoolSize is the number of candidates kept between evictions.
+const evictionPoolSize = 16
+
+var (
+	maxMemory        atomic.Int64
+	maxMemoryPolicy  atomic.Int64
+	maxMemorySamples atomic.Int64
+	lfuLogFactor     atomic.Int64
+	lfuDecayTime     atomic.Int64
+
+	// evictedKeys counts the keys evicted since the server started.
+	evictedKeys atomic.Int64
+)
+
+func init() {
+	maxMemorySamples.Store(5)
+	lfuLogFactor.Store(10)
+	lfuDecayTime.Store(1)
+	config.Register(config.MemoryParam("maxmemory", &maxMemory))
+	config.Register(config.EnumParam("maxmemory-policy", &maxMemoryPolicy, evictionPolicies...))
+	config.Register(config.IntParam("maxmemory-samples", &maxMemorySamples, 1, 64))
+	config.Register(config.IntParam("lfu-log-factor", &lfuLogFactor, 0, math.MaxInt32))
+	config.Register(config.IntParam("lfu-decay-time", &lfuDecayTime, 0, math.MaxInt32))
+}
+
+// MaxMemory returns the value of maxmemory, in bytes, 0 meaning no limit.
+func MaxMemory() int64 {
+	return maxMemory.Load()
+}
+
+// MaxMemoryPolicy returns the name of the eviction policy.
+func MaxMemoryPolicy() string {
+	return evictionPolicies[maxMemoryPolicy.Load()]
+}
+
+// LFUPolicy reports whether the eviction policy ranks keys by access
+// frequency rather than by idle time.
+func LFUPolicy() bool {
+	return evictionPolicy(maxMemoryPolicy.Load()).lfu()
+}
+
+// EvictedKeys returns the number of keys evicted since the server started.
+func EvictedKeys() int64 {
+	return evictedKeys.Load()
+}
+
+// evictionCandidate is a key that may be evicted, ranked by score: the
+// higher, the better the candidate.
+type evictionCandidate struct {
+	score int64
+	db    *DataStore
+	key   string
+}
+
+var (
+	// evictionMu serializes evictions, and guards evictionPool and
+	// nextEvictionDB.
+	evictionMu sync.Mutex
+	// evictionPool holds the best candidates sampled so far, by increasing
+	// score.
+	evictionPool []evictionCandidate
+	// nextEvictionDB is the database the random policies evict from next,
+	// so that they evict from every database in turn.
+	nextEvictionDB int
+)
+
+// PerformEvictions evicts keys until the memory in use is within maxmemory,
+// calling evicted with the database number and name of every evicted key.
+// It returns ErrOOM if the memory in use still exceeds maxmemory, because
+// the policy is noeviction or no key is left to evict.
+func PerformEvictions(evicted func(db int, key string)) error {
+	limit := maxMemory.Load()
+	if limit == 0 || usedMemory.Load() <= limit {
+		return nil
+	}
+	policy := evictionPolicy(maxMemoryPolicy.Load())
+	if policy == noEviction {
+		return ErrOOM
+	}
+	evictionMu.Lock()
+	defer evictionMu.Unlock()
+	for usedMemory.Load() > limit {
+		var ds *DataStore
+		var key string
+		if policy == allKeysRandom || policy == volatileRandom {
+			ds, key = evictRandom(policy)
+		} else {
+			ds, key = evictFromPool(policy)
+		}
+		if ds == nil {
+			return ErrOOM
+		}
+		evictedKeys.Add(1)
+		evicted(ds.id, key)
+	}
+	return nil
+}
+
+// evictRandom evicts a random key from the next database that has one, and
+// returns it, or a nil database if there are no keys to evict.
+func evictRandom(policy evictionPolicy) (*DataStore, string) {
+	for range databases {
+		ds := databases[nextEvictionDB]
+		nextEvictionDB = (nextEvictionDB + 1) % len(databases)
+		ds.mu.Lock()
+		key, found := ds.randomEvictionKey(policy)
+		if found {
+			ds.evict(key)
+		}
+		ds.mu.Unlock()
+		if found {
+			return ds, key
+		}
+	}
+	return nil, ""
+}
+
+// randomEvictionKey returns a random key that policy may evict. The caller
+// must hold the write lock.
+func (ds *DataStore) randomEvictionKey(policy evictionPolicy) (string, bool) {
+	if policy.volatile() {
+		// Map iteration order is randomized.
+		for key := range ds.expires {
+			return key, true
+		}
+		return "", false
+	}
+	key, _, found := ds.data.RandomEntry()
+	return key, found
+}
+
+// evictFromPool refills the eviction pool with samples of every database
+// and evicts its best candidate that still exists, and returns it, or a nil
+// database if there are no keys to evict.
+func evictFromPool(policy evictionPolicy) (*DataStore, string) {
+	for _, ds := range databases {
+		ds.mu.Lock()
+		ds.sampleEvictionPool(policy)
+		ds.mu.Unlock()
+	}
+	for len(evictionPool) > 0 {
+		best := evictionPool[len(evictionPool)-1]
+		evictionPool = evictionPool[:len(evictionPool)-1]
+		ds := best.db
+		ds.mu.Lock()
+		_, found := ds.data.Get(best.key)
+		if _, hasExpiry := ds.expires[best.key]; policy.volatile() && !hasExpiry {
+			found = false
+		}
+		if found {
+			ds.evict(best.key)
+		}
+		ds.mu.Unlock()
+		if found {
+			return ds, best.key
+		}
+	}
+	return nil, ""
+}
+
+// sampleEvictionPool adds maxmemory-samples keys of the database that
+// policy may evict to the eviction pool, if they score better than its
+// worst candidate. The caller must hold the write lock and evictionMu.
+func (ds *DataStore) sampleEvictionPool(policy evictionPolicy) {
+	samples := int(maxMemorySamples.Load())
+	if policy.volatile() {
+		sampled := 0
+		for key, at := range ds.expires {
+			if sampled == samples {
+				break
+			}
+			sampled++
+			ds.addCandidate(policy, key, at)
+		}
+		return
+	}
+	for i := 0; i < samples && ds.data.Len() > 0; i++ {
+		key, _, _ := ds.data.RandomEntry()
+		ds.addCandidate(policy, key, ds.expires[key])
+	}
+}
+
+// addCandidate inserts key, whose deadline is at, in the eviction pool
+// according to its score, dropping the worst candidate if the pool is full.
+// The caller must hold the write lock and evictionMu.
+func (ds *DataStore) addCandidate(policy evictionPolicy, key string, at int64) {
+	var score int64
+	switch m := ds.meta[key]; {
+	case policy == volatileTTL:
+		// The sooner the deadline, the better.
+		score = math.MaxInt64 - at
+	case m == nil:
+		// Keys without bookkeeping were never accessed since they were
+		// loaded.
+		score = math.MaxInt64
+	case policy.lfu():
+		score = lfuMaxValue - m.decay()
+	default:
+		score = Now() - m.access.Load()
+	}
+	for i, c := range evictionPool {
+		if c.db == ds && c.key == key {
+			evictionPool = append(evictionPool[:i], evictionPool[i+1:]...)
+			break
+		}
+	}
+	if len(evictionPool) == evictionPoolSize {
+		if score <= evictionPool[0].score {
+			return
+		}
+		evictionPool = evictionPool[1:]
+	}
+	i := 0
+	for i < len(evictionPool) && evictionPool[i].score < score {
+		i++
+	}
+	evictionPool = append(evictionPool, evictionCandidate{})
+	copy(evictionPool[i+1:], evictionPool[i:])
+	evictionPool[i] = evictionCandidate{score, ds, key}
+}
+
+// evict deletes key to free memory. The caller must hold the write lock.
+func (ds *DataStore) evict(key string) {
+	value, _ := ds.data.Get(key)
+	ds.deleteKey(key)
+	ds.free(value, false)
+}
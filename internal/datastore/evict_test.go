@@ -0,0 +1,142 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/manimovassagh/Godis/internal/config"
+)
+
+// TestMemoryAccounting tests that the memory accounted to the keys follows
+// writes, deletions, SWAPDB and FLUSHDB
+func TestMemoryAccounting(t *testing.T) {
+	FlushAll(false)
+	if UsedMemory() != 0 {
+		t.Fatalf("Expected no memory in use after FLUSHALL, got %d", UsedMemory())
+	}
+	ds, other := GetDatabase(0), GetDatabase(1)
+	ds.Set("mem:str", "hello")
+	expected := int64(keyOverhead + len("mem:str") + stringOverhead + len("hello"))
+	if UsedMemory() != expected {
+		t.Fatalf("Expected %d bytes in use, got %d", expected, UsedMemory())
+	}
+	if size, _ := ds.MemoryUsage("mem:str", 0); size != expected {
+		t.Errorf("Expected MEMORY USAGE of %d, got %d", expected, size)
+	}
+	ds.Push("mem:list", false, "a", "b", "c")
+	listSize := int64(keyOverhead + len("mem:list") + 3*(stringOverhead+1))
+	if UsedMemory() != expected+listSize {
+		t.Fatalf("Expected %d bytes in use, got %d", expected+listSize, UsedMemory())
+	}
+	ds.Del("mem:list")
+	if UsedMemory() != expected {
+		t.Errorf("Expected %d bytes in use after DEL, got %d", expected, UsedMemory())
+	}
+	SwapDatabases(0, 1)
+	if UsedMemory() != expected || other.used != expected || ds.used != 0 {
+		t.Errorf("Expected SWAPDB to move %d bytes to db 1, got %d and %d", expected, other.used, ds.used)
+	}
+	SwapDatabases(0, 1)
+	ds.FlushDB(false)
+	if UsedMemory() != 0 {
+		t.Errorf("Expected no memory in use after FLUSHDB, got %d", UsedMemory())
+	}
+}
+
+// TestEvictionPolicies tests which key every policy evicts once maxmemory is
+// exceeded
+func TestEvictionPolicies(t *testing.T) {
+	current := time.Now().UnixMilli()
+	fakeNow.Store(current)
+	defer fakeNow.Store(0)
+	defer func() {
+		config.Set("maxmemory", "0")
+		config.Set("maxmemory-policy", "noeviction")
+		config.Set("maxmemory-samples", "5")
+	}()
+	config.Set("maxmemory-samples", "64")
+
+	for _, test := range []struct {
+		policy  string
+		evicted string // empty if any key may be evicted
+		err     error
+	}{
+		{"noeviction", "", ErrOOM},
+		{"allkeys-lru", "old", nil},
+		{"allkeys-lfu", "cold", nil},
+		{"allkeys-random", "", nil},
+		{"volatile-lru", "old-ttl", nil},
+		{"volatile-lfu", "cold-ttl", nil},
+		{"volatile-random", "", nil},
+		{"volatile-ttl", "soon", nil},
+	} {
+		t.Run(test.policy, func(t *testing.T) {
+			FlushAll(false)
+			evictionPool = nil
+			config.Set("maxmemory", "0")
+			ds := GetDatabase(2)
+			current = fakeNow.Add(-10000)
+			ds.Set("old", "v")
+			current = fakeNow.Add(1000)
+			ds.SetWithExpireAt("old-ttl", "v", current+60000)
+			current = fakeNow.Add(4000)
+			ds.SetWithExpireAt("soon", "v", current+10000)
+			ds.Set("cold", "v")
+			ds.SetWithExpireAt("cold-ttl", "v", current+60000)
+			ds.Set("hot", "v")
+			current = fakeNow.Add(5000)
+			for key, m := range ds.meta {
+				if key != "old" && key != "old-ttl" {
+					m.access.Store(current)
+				}
+				m.freq.Store(100)
+			}
+			ds.meta["cold"].freq.Store(1)
+			ds.meta["cold-ttl"].freq.Store(2)
+
+			config.Set("maxmemory-policy", test.policy)
+			// Evicting any key is enough.
+			maxMemory.Store(UsedMemory() - 1)
+			before := EvictedKeys()
+			var evicted []string
+			err := PerformEvictions(func(db int, key string) {
+				if db != 2 {
+					t.Errorf("Expected to evict from db 2, got db %d", db)
+				}
+				evicted = append(evicted, key)
+			})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Expected error %v, got %v", test.err, err)
+			}
+			if test.err != nil {
+				if len(evicted) != 0 {
+					t.Errorf("Expected no eviction, got %v", evicted)
+				}
+				return
+			}
+			if len(evicted) != 1 || EvictedKeys() != before+1 {
+				t.Fatalf("Expected a single eviction, got %v", evicted)
+			}
+			if test.evicted != "" && evicted[0] != test.evicted {
+				t.Errorf("Expected %s to be evicted, got %s", test.evicted, evicted[0])
+			}
+			if _, hasExpiry := ds.expires[evicted[0]]; hasExpiry || ds.Exists(evicted[0]) != 0 {
+				t.Errorf("Expected %s to be deleted", evicted[0])
+			}
+			if test.policy == "volatile-random" && evicted[0] != "old-ttl" && evicted[0] != "soon" && evicted[0] != "cold-ttl" {
+				t.Errorf("Expected a key with a TTL to be evicted, got %s", evicted[0])
+			}
+		})
+	}
+
+	// Volatile policies can't evict keys without a TTL.
+	FlushAll(false)
+	GetDatabase(0).Set("persistent", "v")
+	config.Set("maxmemory-policy", "volatile-lru")
+	maxMemory.Store(1)
+	if err := PerformEvictions(func(int, string) {}); !errors.Is(err, ErrOOM) {
+		t.Errorf("Expected ErrOOM without volatile keys, got %v", err)
+	}
+	FlushAll(false)
+}
@@ -0,0 +1,472 @@
+package datastore
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+
+	"github.com/manimovassagh/Godis/internal/glob"
+)
+
+var (
+	// ErrHashValueNotInteger is returned by HIncrBy when the field is not an integer.
+	ErrHashValueNotInteger = errors.New("ERR hash value is not an integer")
+	// ErrHashValueNotFloat is returned by HIncrByFloat when the field is not a float.
+	ErrHashValueNotFloat = errors.New("ERR hash value is not a float")
+	// ErrOverflow is returned when an integer increment would overflow.
+	ErrOverflow = errors.New("ERR increment or decrement would overflow")
+	// ErrNaNOrInfinity is returned when a float increment produces NaN or ±Inf.
+	ErrNaNOrInfinity = errors.New("ERR increment would produce NaN or Infinity")
+)
+
+// ExpireCondition restricts when a new deadline is applied, mirroring the
+// NX, XX, GT and LT options of the Redis expiry commands. Conditions combine
+// with |, and a deadline is applied when all of them hold.
+type ExpireCondition int
+
+const (
+	// ExpireAlways sets the deadline unconditionally.
+	ExpireAlways ExpireCondition = 0
+	// ExpireNX only sets the deadline if there is none.
+	ExpireNX ExpireCondition = 1 << (iota - 1)
+	// ExpireXX only sets the deadline if there already is one.
+	ExpireXX
+	// ExpireGT only sets the deadline if it is later than the current one.
+	ExpireGT
+	// ExpireLT only sets the deadline if it is earlier than the current one.
+	ExpireLT
+)
+
+// allows reports whether a new deadline at may replace the current one, where
+// current is zero when there is no deadline. A missing deadline counts as an
+// infinite one for GT and LT, as in Redis.
+func (cond ExpireCondition) allows(current, at int64) bool {
+	switch {
+	case cond&ExpireNX != 0 && current != 0,
+		cond&ExpireXX != 0 && current == 0,
+		cond&ExpireGT != 0 && (current == 0 || at <= current),
+		cond&ExpireLT != 0 && current != 0 && at >= current:
+		return false
+	}
+	return true
+}
+
+// Hash is a field-value map where every field may carry its own deadline.
+type Hash struct {
+	fields  *dict[string]
+	expires map[string]int64 // absolute field deadlines in Unix milliseconds
+}
+
+// NewHash returns an empty Hash.
+func NewHash() *Hash {
+	return &Hash{
+		fields:  newDict[string](),
+		expires: make(map[string]int64),
+	}
+}
+
+// Len returns the number of fields in the hash.
+func (h *Hash) Len() int {
+	return h.fields.Len()
+}
+
+// clone returns a copy of the hash, including the deadlines of its fields.
+func (h *Hash) clone() *Hash {
+	return &Hash{
+		fields:  h.fields.clone(),
+		expires: maps.Clone(h.expires),
+	}
+}
+
+// Get returns the value of field.
+func (h *Hash) Get(field string) (string, bool) {
+	return h.fields.Get(field)
+}
+
+// Set sets field to value and clears any deadline the field had. It returns
+// true if the field is new.
+func (h *Hash) Set(field, value string) bool {
+	delete(h.expires, field)
+	return h.fields.Set(field, value)
+}
+
+// Delete removes field. It returns false if the field did not exist.
+func (h *Hash) Delete(field string) bool {
+	if _, exists := h.fields.Delete(field); !exists {
+		return false
+	}
+	delete(h.expires, field)
+	return true
+}
+
+// Fields returns the field names in sorted order.
+func (h *Hash) Fields() []string {
+	fields := h.fields.Keys()
+	sort.Strings(fields)
+	return fields
+}
+
+// ExpireTimes returns a copy of the field deadlines.
+func (h *Hash) ExpireTimes() map[string]int64 {
+	expires := make(map[string]int64, len(h.expires))
+	for field, at := range h.expires {
+		expires[field] = at
+	}
+	return expires
+}
+
+// purgeExpired removes the fields whose deadline is at or before current,
+// and returns them.
+func (h *Hash) purgeExpired(current int64) []string {
+	var purged []string
+	for field, at := range h.expires {
+		if at <= current {
+			h.fields.Delete(field)
+			delete(h.expires, field)
+			purged = append(purged, field)
+		}
+	}
+	return purged
+}
+
+// lookupHash returns the hash stored at key with expired fields removed. If
+// the key does not exist it returns nil, or a new hash stored at key when
+// create is true. The caller must hold the write lock.
+func (ds *DataStore) lookupHash(key string, create bool) (*Hash, error) {
+	value, found := ds.lookup(key)
+	if !found {
+		if !create {
+			return nil, nil
+		}
+		hash := NewHash()
+		ds.data.Set(key, hash)
+		return hash, nil
+	}
+	hash, ok := value.(*Hash)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	if len(hash.expires) > 0 && !loading.Load() {
+		purged := hash.purgeExpired(Now())
+		if len(purged) == 0 {
+			return hash, nil
+		}
+		// Deleting the last fields deletes the hash on replay as well.
+		sort.Strings(purged)
+		ds.propagateExpired(append([]string{"HDEL", key}, purged...))
+		if hash.Len() == 0 {
+			ds.deleteKey(key)
+			if !create {
+				return nil, nil
+			}
+			hash = NewHash()
+			ds.data.Set(key, hash)
+		}
+	}
+	return hash, nil
+}
+
+// deleteIfEmptyHash removes the key once its hash has no fields left. The
+// caller must hold the write lock.
+func (ds *DataStore) deleteIfEmptyHash(key string, hash *Hash) {
+	if hash.Len() == 0 {
+		ds.deleteKey(key)
+	}
+}
+
+// HSet sets the given field-value pairs in the hash stored at key, creating
+// the hash if needed. pairs alternates fields and values. It returns the
+// number of fields that were added.
+func (ds *DataStore) HSet(key string, pairs ...string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	hash, err := ds.lookupHash(key, true)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if hash.Set(pairs[i], pairs[i+1]) {
+			added++
+		}
+	}
+	ds.signalModifiedKey(key)
+	return added, nil
+}
+
+// HGet returns the value of field in the hash stored at key.
+func (ds *DataStore) HGet(key, field string) (string, bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	hash, err := ds.lookupHash(key, false)
+	if hash == nil {
+		return "", false, err
+	}
+	value, found := hash.Get(field)
+	return value, found, nil
+}
+
+// HMGet returns the values of the given fields in the hash stored at key. A
+// nil entry means the field does not exist.
+func (ds *DataStore) HMGet(key string, fields ...string) ([]*string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	values := make([]*string, len(fields))
+	hash, err := ds.lookupHash(key, false)
+	if hash == nil {
+		return values, err
+	}
+	for i, field := range fields {
+		if value, found := hash.Get(field); found {
+			values[i] = &value
+		}
+	}
+	return values, nil
+}
+
+// HGetAll returns the fields and values of the hash stored at key as
+// alternating field-value pairs, ordered by field.
+func (ds *DataStore) HGetAll(key string) ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	hash, err := ds.lookupHash(key, false)
+	if hash == nil {
+		return []string{}, err
+	}
+	pairs := make([]string, 0, 2*hash.Len())
+	for _, field := range hash.Fields() {
+		value, _ := hash.Get(field)
+		pairs = append(pairs, field, value)
+	}
+	return pairs, nil
+}
+
+// HDel removes the given fields from the hash stored at key and returns how
+// many were removed.
+func (ds *DataStore) HDel(key string, fields ...string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	hash, err := ds.lookupHash(key, false)
+	if hash == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, field := range fields {
+		if hash.Delete(field) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		ds.signalModifiedKey(key)
+	}
+	ds.deleteIfEmptyHash(key, hash)
+	return removed, nil
+}
+
+// HLen returns the number of fields in the hash stored at key.
+func (ds *DataStore) HLen(key string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	hash, err := ds.lookupHash(key, false)
+	if hash == nil {
+		return 0, err
+	}
+	return hash.Len(), nil
+}
+
+// HIncrBy increments the integer stored in field by delta and returns the new
+// value. A missing field is treated as 0.
+func (ds *DataStore) HIncrBy(key, field string, delta int64) (int64, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	hash, err := ds.lookupHash(key, true)
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	if value, found := hash.Get(field); found {
+		current, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			ds.deleteIfEmptyHash(key, hash)
+			return 0, ErrHashValueNotInteger
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		ds.deleteIfEmptyHash(key, hash)
+		return 0, ErrOverflow
+	}
+	current += delta
+	hash.fields.Set(field, strconv.FormatInt(current, 10))
+	ds.signalModifiedKey(key)
+	return current, nil
+}
+
+// HIncrByFloat increments the float stored in field by delta and returns the
+// new value formatted the way it is stored.
+func (ds *DataStore) HIncrByFloat(key, field string, delta float64) (string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	hash, err := ds.lookupHash(key, true)
+	if err != nil {
+		return "", err
+	}
+	var current float64
+	if value, found := hash.Get(field); found {
+		current, err = strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+			ds.deleteIfEmptyHash(key, hash)
+			return "", ErrHashValueNotFloat
+		}
+	}
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		ds.deleteIfEmptyHash(key, hash)
+		return "", ErrNaNOrInfinity
+	}
+	value := strconv.FormatFloat(current, 'f', -1, 64)
+	hash.fields.Set(field, value)
+	ds.signalModifiedKey(key)
+	return value, nil
+}
+
+// HScan visits about count fields of the hash stored at key, starting at
+// cursor, and returns those whose names match pattern (an empty pattern
+// matches everything) as alternating field-value pairs, along with the cursor
+// to continue from, which is 0 once the iteration is complete. See dict.Scan
+// for the guarantees.
+func (ds *DataStore) HScan(key string, cursor uint64, count int, pattern string) (uint64, []string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	hash, err := ds.lookupHash(key, false)
+	if hash == nil {
+		return 0, []string{}, err
+	}
+	pairs := []string{}
+	cursor = hash.fields.scanCount(cursor, count, func(field, value string) {
+		if pattern == "" || glob.Match(pattern, field) {
+			pairs = append(pairs, field, value)
+		}
+	})
+	return cursor, pairs, nil
+}
+
+// HRandField returns random fields of the hash stored at key as alternating
+// field-value pairs. A non-negative count returns up to count distinct fields,
+// while a negative count returns exactly -count fields that may repeat.
+func (ds *DataStore) HRandField(key string, count int) ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	hash, err := ds.lookupHash(key, false)
+	if hash == nil {
+		return []string{}, err
+	}
+	fields := hash.Fields()
+	pairs := []string{}
+	if count < 0 {
+		for i := 0; i < -count; i++ {
+			field := fields[rand.IntN(len(fields))]
+			value, _ := hash.Get(field)
+			pairs = append(pairs, field, value)
+		}
+		return pairs, nil
+	}
+	rand.Shuffle(len(fields), func(i, j int) { fields[i], fields[j] = fields[j], fields[i] })
+	for _, field := range fields[:min(count, len(fields))] {
+		value, _ := hash.Get(field)
+		pairs = append(pairs, field, value)
+	}
+	return pairs, nil
+}
+
+// HExpireAt sets the deadline of the given fields to the absolute Unix time
+// at, in milliseconds, subject to cond. For each field it returns -2 if the
+// field does not exist, 0 if cond was not met, 1 if the deadline was set and
+// 2 if the field was deleted because at is in the past.
+func (ds *DataStore) HExpireAt(key string, at int64, cond ExpireCondition, fields ...string) ([]int64, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	results := make([]int64, len(fields))
+	hash, err := ds.lookupHash(key, false)
+	if hash == nil {
+		for i := range results {
+			results[i] = -2
+		}
+		return results, err
+	}
+	changed := false
+	for i, field := range fields {
+		if _, found := hash.Get(field); !found {
+			results[i] = -2
+			continue
+		}
+		if !cond.allows(hash.expires[field], at) {
+			results[i] = 0
+			continue
+		}
+		changed = true
+		if deadlinePassed(at) {
+			hash.Delete(field)
+			results[i] = 2
+			continue
+		}
+		hash.expires[field] = at
+		results[i] = 1
+	}
+	if changed {
+		ds.signalModifiedKey(key)
+	}
+	ds.deleteIfEmptyHash(key, hash)
+	return results, nil
+}
+
+// HExpireTime returns the absolute deadline of each given field in Unix
+// milliseconds, -1 if the field has no deadline and -2 if it does not exist.
+func (ds *DataStore) HExpireTime(key string, fields ...string) ([]int64, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	results := make([]int64, len(fields))
+	hash, err := ds.lookupHash(key, false)
+	for i, field := range fields {
+		results[i] = -2
+		if hash == nil {
+			continue
+		}
+		if _, found := hash.Get(field); !found {
+			continue
+		}
+		results[i] = -1
+		if at, found := hash.expires[field]; found {
+			results[i] = at
+		}
+	}
+	return results, err
+}
+
+// HPersist removes the deadline of each given field. For each field it returns
+// 1 if a deadline was removed, -1 if the field had none and -2 if it does not
+// exist.
+func (ds *DataStore) HPersist(key string, fields ...string) ([]int64, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	results := make([]int64, len(fields))
+	hash, err := ds.lookupHash(key, false)
+	for i, field := range fields {
+		results[i] = -2
+		if hash == nil {
+			continue
+		}
+		if _, found := hash.Get(field); !found {
+			continue
+		}
+		results[i] = -1
+		if _, found := hash.expires[field]; found {
+			delete(hash.expires, field)
+			results[i] = 1
+			ds.signalModifiedKey(key)
+		}
+	}
+	return results, err
+}
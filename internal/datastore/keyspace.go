@@ -0,0 +1,428 @@
+package datastore
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/manimovassagh/Godis/internal/glob"
+)
+
+// Values are released when they are deleted by taking them apart, so that a
+// large value does not keep the memory of its elements reachable through
+// references that outlive it. UNLINK and FLUSHALL ASYNC hand large values to
+// the lazyfree goroutine instead, so that releasing them does not hold the
+// lock, as with lazy freeing in Redis.
+
+const (
+	// lazyfreeThreshold is the number of elements above which a value is
+	// released in the background rather than inline.
+	lazyfreeThreshold = 64
+	// lazyfreeQueueSize bounds the values waiting to be released. Values
+	// are released inline when the queue is full.
+	lazyfreeQueueSize = 1024
+)
+
+// ErrSameObject is returned by COPY when the source and destination are the
+// same key.
+var ErrSameObject = errors.New("ERR source and destination objects are the same")
+
+// lazyfreedObjects counts the values released by the lazyfree goroutine.
+var lazyfreedObjects atomic.Int64
+
+// LazyfreedObjects returns the number of values released in the background.
+func LazyfreedObjects() int64 {
+	return lazyfreedObjects.Load()
+}
+
+// Type returns the name of the type of the value stored at key as reported
+// by TYPE, or "none" if the key does not exist.
+func (ds *DataStore) Type(key string) string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	value, found := ds.lookup(key)
+	if !found {
+		return "none"
+	}
+	return valueType(value)
+}
+
+// valueType returns the name of the type of a stored value as reported by
+// TYPE.
+func valueType(value any) string {
+	switch value.(type) {
+	case *List:
+		return "list"
+	case *Hash:
+		return "hash"
+	case *Set:
+		return "set"
+	case *ZSet:
+		return "zset"
+	case *Stream:
+		return "stream"
+	}
+	return "string"
+}
+
+// Exists returns how many of the given keys exist. A key given several times
+// is counted as many times.
+func (ds *DataStore) Exists(keys ...string) int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	count := 0
+	for _, key := range keys {
+		if _, found := ds.lookup(key); found {
+			count++
+		}
+	}
+	return count
+}
+
+// Del deletes the given keys and returns how many of them existed.
+func (ds *DataStore) Del(keys ...string) int {
+	return ds.del(keys, false)
+}
+
+// Unlink deletes the given keys like Del, but releases the values holding
+// more than lazyfreeThreshold elements in the background.
+func (ds *DataStore) Unlink(keys ...string) int {
+	return ds.del(keys, true)
+}
+
+func (ds *DataStore) del(keys []string, lazy bool) int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	deleted := 0
+	for _, key := range keys {
+		value, found := ds.lookup(key)
+		if !found {
+			continue
+		}
+		ds.deleteKey(key)
+		ds.free(value, lazy)
+		deleted++
+	}
+	return deleted
+}
+
+// Rename moves the value stored at source and its expiry to destination,
+// replacing whatever destination held. With nx it does nothing if
+// destination exists. It returns whether the key was renamed, or
+// ErrNoSuchKey if source does not exist.
+func (ds *DataStore) Rename(source, destination string, nx bool) (bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	value, found := ds.lookup(source)
+	if !found {
+		return false, ErrNoSuchKey
+	}
+	if _, exists := ds.lookup(destination); exists && nx {
+		return false, nil
+	}
+	if source == destination {
+		return true, nil
+	}
+	at, hasExpiry := ds.expires[source]
+	ds.deleteKey(source)
+	ds.store(destination, value, at, hasExpiry)
+	return true, nil
+}
+
+// Copy stores a copy of the value stored at source and its expiry in
+// destination in the database dst, which may be ds. It does nothing if
+// destination exists, unless replace is set. It returns whether the value was
+// copied, or ErrSameObject if source and destination are the same key.
+func (ds *DataStore) Copy(source string, dst *DataStore, destination string, replace bool) (bool, error) {
+	if ds == dst && source == destination {
+		return false, ErrSameObject
+	}
+	defer lockPair(ds, dst)()
+	value, found := ds.lookup(source)
+	if !found {
+		return false, nil
+	}
+	if _, exists := dst.lookup(destination); exists && !replace {
+		return false, nil
+	}
+	at, hasExpiry := ds.expires[source]
+	dst.store(destination, copyValue(value), at, hasExpiry)
+	return true, nil
+}
+
+// Move moves key and its expiry from ds to the database dst, unless dst
+// already holds key. It returns whether the key was moved, or ErrSameObject
+// if dst is ds.
+func (ds *DataStore) Move(key string, dst *DataStore) (bool, error) {
+	if ds == dst {
+		return false, ErrSameObject
+	}
+	defer lockPair(ds, dst)()
+	value, found := ds.lookup(key)
+	if !found {
+		return false, nil
+	}
+	if _, exists := dst.lookup(key); exists {
+		return false, nil
+	}
+	at, hasExpiry := ds.expires[key]
+	ds.deleteKey(key)
+	dst.store(key, value, at, hasExpiry)
+	return true, nil
+}
+
+// SwapDatabases exchanges the contents of the databases numbered a and b.
+// The clients attached to either database see the other one's keys from
+// then on, so the keys they watch are touched and the keys they are blocked
+// on are checked again.
+func SwapDatabases(a, b int) {
+	x, y := GetDatabase(a), GetDatabase(b)
+	if x == y {
+		return
+	}
+	defer lockPair(x, y)()
+	x.signalWatchedKeys()
+	y.signalWatchedKeys()
+	x.preserveAll()
+	y.preserveAll()
+	x.data, y.data = y.data, x.data
+	x.expires, y.expires = y.expires, x.expires
+	x.meta, y.meta = y.meta, x.meta
+	x.used, y.used = y.used, x.used
+	x.signalWatchedKeys()
+	y.signalWatchedKeys()
+	x.signalBlockedKeys()
+	y.signalBlockedKeys()
+}
+
+// lockPair takes the write locks of a and b, which may be the same
+// database, in the order of their numbers so that two calls locking the same
+// pair cannot deadlock. It returns the function releasing them.
+func lockPair(a, b *DataStore) func() {
+	if a == b {
+		a.mu.Lock()
+		return a.mu.Unlock
+	}
+	if a.id > b.id {
+		a, b = b, a
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+	return func() {
+		b.mu.Unlock()
+		a.mu.Unlock()
+	}
+}
+
+// store sets key to value, with the deadline at if hasExpiry is set, and
+// wakes up the clients blocked on it. The caller must hold the write lock.
+func (ds *DataStore) store(key string, value any, at int64, hasExpiry bool) {
+	ds.preserve(key)
+	ds.data.Set(key, value)
+	if hasExpiry {
+		ds.expires[key] = at
+	} else {
+		delete(ds.expires, key)
+	}
+	ds.signalModifiedKey(key)
+	ds.signalKeyAsReady(key)
+}
+
+// RandomKey returns a random key, or false if there are none. Expired keys
+// met on the way are deleted.
+func (ds *DataStore) RandomKey() (string, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for {
+		key, _, found := ds.data.RandomEntry()
+		if !found {
+			return "", false
+		}
+		if !ds.isExpired(key) {
+			return key, true
+		}
+		ds.expireKey(key)
+	}
+}
+
+// Keys returns the keys that match pattern.
+func (ds *DataStore) Keys(pattern string) []string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	keys := []string{}
+	for key := range ds.data.All() {
+		if glob.Match(pattern, key) && !ds.isExpired(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Scan visits about count keys starting at cursor, and returns those that
+// match pattern (an empty pattern matches everything) and hold a value of
+// type typeName, unless it is empty, along with the cursor to continue from,
+// which is 0 once the iteration is complete. Expired keys met on the way are
+// deleted. See dict.Scan for the guarantees.
+func (ds *DataStore) Scan(cursor uint64, count int, pattern, typeName string) (uint64, []string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var visited []string
+	cursor = ds.data.scanCount(cursor, count, func(key string, value any) {
+		if (pattern == "" || glob.Match(pattern, key)) && (typeName == "" || valueType(value) == typeName) {
+			visited = append(visited, key)
+		}
+	})
+	keys := []string{}
+	for _, key := range visited {
+		if ds.isExpired(key) {
+			ds.expireKey(key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return cursor, keys
+}
+
+// DBSize returns the number of keys, including the expired keys that were
+// not reclaimed yet.
+func (ds *DataStore) DBSize() int {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.data.Len()
+}
+
+// KeyCounts returns the number of keys and the number of keys with a TTL,
+// including the expired keys that were not reclaimed yet.
+func (ds *DataStore) KeyCounts() (keys, expires int) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	return ds.data.Len(), len(ds.expires)
+}
+
+// FlushDB deletes every key of the database. With async the keyspace is
+// swapped for an empty one and the old one is released in the background, so
+// that the call does not take time proportional to the number of keys.
+func (ds *DataStore) FlushDB(async bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.signalWatchedKeys()
+	ds.preserveAll()
+	data := ds.data
+	ds.data = newDict[any]()
+	ds.expires = make(map[string]int64)
+	ds.meta = make(map[string]*keyMeta)
+	usedMemory.Add(-ds.used)
+	ds.used = 0
+	if !async {
+		release(data)
+		return
+	}
+	select {
+	case ds.lazyfree <- data:
+	default:
+		go release(data)
+	}
+}
+
+// FlushAll deletes every key of every database, like FlushDB.
+func FlushAll(async bool) {
+	createDatabases()
+	for _, ds := range databases {
+		ds.FlushDB(async)
+	}
+}
+
+// signalWatchedKeys bumps the version of the watched keys that exist. The
+// caller must hold the write lock.
+func (ds *DataStore) signalWatchedKeys() {
+	for key := range ds.watched {
+		if _, found := ds.data.Get(key); found {
+			ds.signalModifiedKey(key)
+		}
+	}
+}
+
+// free releases a deleted value, in the background if lazy is set and the
+// value is large. The caller must hold the write lock.
+func (ds *DataStore) free(value any, lazy bool) {
+	if lazy && freeEffort(value) > lazyfreeThreshold {
+		select {
+		case ds.lazyfree <- value:
+			return
+		default:
+		}
+	}
+	release(value)
+}
+
+// lazyfreeLoop releases the values handed to the lazyfree goroutine.
+func lazyfreeLoop(lazyfree <-chan any) {
+	for value := range lazyfree {
+		release(value)
+		lazyfreedObjects.Add(1)
+	}
+}
+
+// release takes apart a value that is no longer stored, dropping the
+// references it holds. A keyspace swapped out by FlushDB has each of its
+// values released.
+func release(value any) {
+	switch v := value.(type) {
+	case *dict[any]:
+		for key, item := range v.All() {
+			release(item)
+			v.Delete(key)
+		}
+	case *List:
+		for node := v.head; node != nil; {
+			next := node.next
+			node.prev, node.next, node.items = nil, nil, nil
+			node = next
+		}
+		v.head, v.tail, v.length = nil, nil, 0
+	case *Hash:
+		v.fields = newDict[string]()
+		clear(v.expires)
+	case *Set:
+		v.intset, v.members = nil, nil
+	case *ZSet:
+		v.dict, v.zsl = newDict[float64](), newSkiplist()
+	case *Stream:
+		v.chunks, v.groups, v.length = nil, nil, 0
+	}
+}
+
+// freeEffort returns the number of elements that releasing value has to go
+// through.
+func freeEffort(value any) int {
+	switch v := value.(type) {
+	case *List:
+		return v.Len()
+	case *Hash:
+		return v.Len()
+	case *Set:
+		return v.Len()
+	case *ZSet:
+		return v.Len()
+	case *Stream:
+		return v.Len()
+	}
+	return 1
+}
+
+// copyValue returns a deep copy of a stored value.
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case *List:
+		return v.clone()
+	case *Hash:
+		return v.clone()
+	case *Set:
+		return v.clone()
+	case *ZSet:
+		return v.clone()
+	case *Stream:
+		return v.clone()
+	}
+	// Strings are immutable.
+	return value
+}
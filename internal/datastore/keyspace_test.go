@@ -0,0 +1,156 @@
+package datastore
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestCopyIsDeep tests that COPY duplicates every type, so that changing the
+// copy leaves the source untouched
+func TestCopyIsDeep(t *testing.T) {
+	ds := GetDataStore()
+	ds.Push("copy:list", false, "a", "b")
+	ds.HSet("copy:hash", "f", "v")
+	ds.HExpireAt("copy:hash", 99999999999999, ExpireAlways, "f")
+	ds.SAdd("copy:intset", "1", "2")
+	ds.SAdd("copy:set", "a", "b")
+	ds.ZAdd("copy:zset", ZAddOptions{}, ZMember{Member: "a", Score: 1})
+	ds.XAdd("copy:stream", XAddArgs{ID: StreamID{1, 0}, Fields: []string{"f", "v"}})
+	ds.XGroupCreate("copy:stream", "g", GroupStart{}, false)
+	ds.XReadGroup("copy:stream", "g", "alice", StreamID{}, true, -1, false)
+	ds.SetWithExpireAt("copy:string", "v", 99999999999999)
+
+	for _, key := range []string{"copy:list", "copy:hash", "copy:intset", "copy:set", "copy:zset", "copy:stream", "copy:string"} {
+		if copied, err := ds.Copy(key, ds, key+":dst", false); !copied || err != nil {
+			t.Fatalf("Failed to copy %s: %v", key, err)
+		}
+		if ds.Type(key+":dst") != ds.Type(key) {
+			t.Errorf("Expected the copy of %s to be a %s", key, ds.Type(key))
+		}
+	}
+
+	ds.Push("copy:list:dst", false, "c")
+	ds.HSet("copy:hash:dst", "g", "w")
+	ds.SAdd("copy:intset:dst", "3")
+	ds.SAdd("copy:set:dst", "c")
+	ds.ZAdd("copy:zset:dst", ZAddOptions{}, ZMember{Member: "b", Score: 2})
+	ds.XAck("copy:stream:dst", "g", StreamID{1, 0})
+	ds.XAdd("copy:stream:dst", XAddArgs{ID: StreamID{2, 0}, Fields: []string{"f", "v"}})
+
+	if n, _ := ds.LLen("copy:list"); n != 2 {
+		t.Errorf("Expected the source list to keep 2 elements, got %d", n)
+	}
+	if pairs, _ := ds.HGetAll("copy:hash"); len(pairs) != 2 {
+		t.Errorf("Expected the source hash to keep 1 field, got %v", pairs)
+	}
+	if ttls, _ := ds.HExpireTime("copy:hash:dst", "f"); len(ttls) != 1 || ttls[0] != 99999999999999 {
+		t.Errorf("Expected the field deadline to be copied, got %v", ttls)
+	}
+	if n, _ := ds.SCard("copy:intset"); n != 2 {
+		t.Errorf("Expected the source intset to keep 2 members, got %d", n)
+	}
+	if n, _ := ds.SCard("copy:set"); n != 2 {
+		t.Errorf("Expected the source set to keep 2 members, got %d", n)
+	}
+	if n, _ := ds.ZCard("copy:zset"); n != 1 {
+		t.Errorf("Expected the source sorted set to keep 1 member, got %d", n)
+	}
+	if n, _ := ds.XLen("copy:stream"); n != 1 {
+		t.Errorf("Expected the source stream to keep 1 entry, got %d", n)
+	}
+	if summary, _ := ds.XPendingSummary("copy:stream", "g"); summary.Count != 1 {
+		t.Errorf("Expected the source group to keep its pending entry, got %+v", summary)
+	}
+	if summary, _ := ds.XPendingSummary("copy:stream:dst", "g"); summary.Count != 0 {
+		t.Errorf("Expected the copied group to have no pending entries, got %+v", summary)
+	}
+	if ds.ExpireTime("copy:string:dst") != 99999999999999 {
+		t.Errorf("Expected the deadline to be copied")
+	}
+
+	if copied, _ := ds.Copy("copy:list", ds, "copy:set", false); copied {
+		t.Errorf("Expected COPY not to overwrite without REPLACE")
+	}
+	if copied, _ := ds.Copy("copy:missing", ds, "copy:dst", false); copied {
+		t.Errorf("Expected COPY of a missing key to do nothing")
+	}
+	if _, err := ds.Copy("copy:list", ds, "copy:list", true); err != ErrSameObject {
+		t.Errorf("Expected ErrSameObject, got %v", err)
+	}
+}
+
+// TestRename tests that RENAME moves the value and its expiry
+func TestRename(t *testing.T) {
+	ds := GetDataStore()
+	ds.SetWithExpireAt("rename:src", "v", 99999999999999)
+	ds.Set("rename:dst", "old")
+
+	if renamed, _ := ds.Rename("rename:src", "rename:dst", true); renamed {
+		t.Errorf("Expected RENAMENX not to overwrite an existing key")
+	}
+	if renamed, err := ds.Rename("rename:src", "rename:dst", false); !renamed || err != nil {
+		t.Fatalf("Expected RENAME to succeed, got %v", err)
+	}
+	if value, _, _ := ds.Get("rename:dst"); value != "v" || ds.ExpireTime("rename:dst") != 99999999999999 {
+		t.Errorf("Expected the value and deadline to move, got %q expiring at %d", value, ds.ExpireTime("rename:dst"))
+	}
+	if ds.Exists("rename:src") != 0 {
+		t.Errorf("Expected the source key to be gone")
+	}
+	if _, err := ds.Rename("rename:src", "rename:dst", false); err != ErrNoSuchKey {
+		t.Errorf("Expected ErrNoSuchKey, got %v", err)
+	}
+}
+
+// TestLazyfree tests that UNLINK and FLUSHALL ASYNC release large values in
+// the background and small ones inline
+func TestLazyfree(t *testing.T) {
+	ds := GetDataStore()
+	waitLazyfreed := func(expected int64) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for lazyfreedObjects.Load() < expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d values released in the background, got %d", expected, lazyfreedObjects.Load())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	before := lazyfreedObjects.Load()
+	ds.SAdd("lazyfree:small", "a")
+	if ds.Unlink("lazyfree:small") != 1 {
+		t.Fatalf("Expected UNLINK to delete the key")
+	}
+	for i := 0; i <= lazyfreeThreshold; i++ {
+		ds.HSet("lazyfree:large", strconv.Itoa(i), "v")
+	}
+	ds.mu.RLock()
+	value, _ := ds.data.Get("lazyfree:large")
+	hash := value.(*Hash)
+	ds.mu.RUnlock()
+	if ds.Unlink("lazyfree:large", "lazyfree:missing") != 1 {
+		t.Fatalf("Expected UNLINK to delete 1 key")
+	}
+	waitLazyfreed(before + 1)
+	if lazyfreedObjects.Load() != before+1 {
+		t.Errorf("Expected only the large value to be released in the background")
+	}
+	if hash.Len() != 0 {
+		t.Errorf("Expected the unlinked hash to be released, got %d fields", hash.Len())
+	}
+
+	ds.WatchKey("lazyfree:watched")
+	defer ds.UnwatchKey("lazyfree:watched")
+	ds.Set("lazyfree:watched", "v")
+	version := ds.KeyVersion("lazyfree:watched")
+	ds.FlushDB(true)
+	waitLazyfreed(before + 2)
+	if ds.DBSize() != 0 {
+		t.Errorf("Expected FLUSHALL ASYNC to empty the keyspace, got %d keys", ds.DBSize())
+	}
+	if ds.KeyVersion("lazyfree:watched") == version {
+		t.Errorf("Expected FLUSHALL to touch the watched keys")
+	}
+}
@@ -0,0 +1,227 @@
+package datastore
+
+import "errors"
+
+// ErrIndexOutOfRange is returned by LSet when the index is outside the list.
+var ErrIndexOutOfRange = errors.New("ERR index out of range")
+
+// lookupList returns the list stored at key. If the key does not exist it
+// returns nil, or a new list stored at key when create is true. The caller
+// must hold the write lock.
+func (ds *DataStore) lookupList(key string, create bool) (*List, error) {
+	value, found := ds.lookup(key)
+	if !found {
+		if !create {
+			return nil, nil
+		}
+		list := NewList()
+		ds.data.Set(key, list)
+		return list, nil
+	}
+	list, ok := value.(*List)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return list, nil
+}
+
+// deleteIfEmptyList removes the key once its list has no elements left, as Redis
+// never keeps empty aggregates around. The caller must hold the write lock.
+func (ds *DataStore) deleteIfEmptyList(key string, list *List) {
+	if list.Len() == 0 {
+		ds.deleteKey(key)
+	}
+}
+
+// Push inserts values at the head of the list stored at key when left is
+// true, or at its tail otherwise, creating the list if needed. It returns the
+// length of the list after the operation.
+func (ds *DataStore) Push(key string, left bool, values ...string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list, err := ds.lookupList(key, true)
+	if err != nil {
+		return 0, err
+	}
+	for _, value := range values {
+		if left {
+			list.PushFront(value)
+		} else {
+			list.PushBack(value)
+		}
+	}
+	ds.signalModifiedKey(key)
+	ds.signalKeyAsReady(key)
+	return list.Len(), nil
+}
+
+// Pop removes and returns up to count elements from the head of the list
+// stored at key when left is true, or from its tail otherwise. It returns nil
+// if the key does not exist.
+func (ds *DataStore) Pop(key string, left bool, count int) ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list, err := ds.lookupList(key, false)
+	if list == nil {
+		return nil, err
+	}
+	values := make([]string, 0, min(count, list.Len()))
+	for len(values) < count {
+		var value string
+		var ok bool
+		if left {
+			value, ok = list.PopFront()
+		} else {
+			value, ok = list.PopBack()
+		}
+		if !ok {
+			break
+		}
+		values = append(values, value)
+	}
+	if len(values) > 0 {
+		ds.signalModifiedKey(key)
+	}
+	ds.deleteIfEmptyList(key, list)
+	return values, nil
+}
+
+// LLen returns the length of the list stored at key, or 0 if it does not exist.
+func (ds *DataStore) LLen(key string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list, err := ds.lookupList(key, false)
+	if list == nil {
+		return 0, err
+	}
+	return list.Len(), nil
+}
+
+// LRange returns the elements of the list stored at key between start and
+// stop, both inclusive. Negative indexes count from the tail.
+func (ds *DataStore) LRange(key string, start, stop int) ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list, err := ds.lookupList(key, false)
+	if list == nil {
+		return []string{}, err
+	}
+	return list.Range(start, stop), nil
+}
+
+// LIndex returns the element at index of the list stored at key.
+func (ds *DataStore) LIndex(key string, index int) (string, bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list, err := ds.lookupList(key, false)
+	if list == nil {
+		return "", false, err
+	}
+	value, found := list.Index(index)
+	return value, found, nil
+}
+
+// LSet replaces the element at index of the list stored at key.
+func (ds *DataStore) LSet(key string, index int, value string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list, err := ds.lookupList(key, false)
+	if err != nil {
+		return err
+	}
+	if list == nil {
+		return ErrNoSuchKey
+	}
+	if !list.Set(index, value) {
+		return ErrIndexOutOfRange
+	}
+	ds.signalModifiedKey(key)
+	return nil
+}
+
+// LRem removes up to count occurrences of value from the list stored at key,
+// following the LREM count semantics. It returns the number of removed elements.
+func (ds *DataStore) LRem(key string, count int, value string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list, err := ds.lookupList(key, false)
+	if list == nil {
+		return 0, err
+	}
+	removed := list.Remove(count, value)
+	if removed > 0 {
+		ds.signalModifiedKey(key)
+	}
+	ds.deleteIfEmptyList(key, list)
+	return removed, nil
+}
+
+// LTrim trims the list stored at key so it only contains the elements between
+// start and stop, both inclusive.
+func (ds *DataStore) LTrim(key string, start, stop int) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list, err := ds.lookupList(key, false)
+	if list == nil {
+		return err
+	}
+	list.Trim(start, stop)
+	ds.signalModifiedKey(key)
+	ds.deleteIfEmptyList(key, list)
+	return nil
+}
+
+// LInsert inserts value before or after pivot in the list stored at key. It
+// returns the new length, -1 if pivot was not found and 0 if the key does not
+// exist.
+func (ds *DataStore) LInsert(key string, before bool, pivot, value string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	list, err := ds.lookupList(key, false)
+	if list == nil {
+		return 0, err
+	}
+	if !list.Insert(before, pivot, value) {
+		return -1, nil
+	}
+	ds.signalModifiedKey(key)
+	return list.Len(), nil
+}
+
+// LMove atomically pops an element from one end of the source list and pushes
+// it to one end of the destination list. It returns false if the source list
+// does not exist.
+func (ds *DataStore) LMove(source, destination string, fromLeft, toLeft bool) (string, bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	src, err := ds.lookupList(source, false)
+	if src == nil {
+		return "", false, err
+	}
+	// Check the destination type before popping so a WRONGTYPE error leaves
+	// the source untouched.
+	if value, found := ds.lookup(destination); found {
+		if _, ok := value.(*List); !ok {
+			return "", false, ErrWrongType
+		}
+	}
+	var value string
+	if fromLeft {
+		value, _ = src.PopFront()
+	} else {
+		value, _ = src.PopBack()
+	}
+	if source != destination {
+		ds.deleteIfEmptyList(source, src)
+	}
+	dst, _ := ds.lookupList(destination, true)
+	if toLeft {
+		dst.PushFront(value)
+	} else {
+		dst.PushBack(value)
+	}
+	ds.signalModifiedKey(source)
+	ds.signalModifiedKey(destination)
+	ds.signalKeyAsReady(destination)
+	return value, true, nil
+}
@@ -0,0 +1,278 @@
+package datastore
+
+import (
+	"iter"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// Memory is accounted per key, as an estimate of the bytes its name, value
+// and bookkeeping take, rather than measured from the Go heap: the runtime
+// can't tell how much memory a value holds, and keys must be compared with
+// each other anyway to pick the ones to evict. Aggregate values are sized
+// from a few sampled elements, as MEMORY USAGE does in Redis, so that the
+// estimate is updated in constant time after every write.
+//
+// Every key also records when it was last accessed and how often, which the
+// LRU and LFU eviction policies rank keys by. See evict.go.
+
+const (
+	// keyOverhead approximates what a key takes besides its name and value:
+	// its entry in the keyspace and its keyMeta.
+	keyOverhead = 96
+	// stringOverhead is the size of a string header.
+	stringOverhead = 16
+	// entryOverhead approximates the size of an entry in the dict of a
+	// hash, set or sorted set.
+	entryOverhead = 40
+	// skiplistNodeOverhead approximates the size of a skiplist node of a
+	// sorted set, besides its member.
+	skiplistNodeOverhead = 48
+	// streamEntryOverhead is the size of a stream entry besides its fields.
+	streamEntryOverhead = 40
+	// fieldExpiryOverhead approximates the size of a hash field deadline.
+	fieldExpiryOverhead = 40
+	// memorySamples is the number of elements aggregate values are sized
+	// from when a key is written.
+	memorySamples = 5
+
+	// lfuInitValue is the counter of a key that was just created, so that
+	// new keys get a chance to be accessed before they are evicted.
+	lfuInitValue = 5
+	// lfuMaxValue is the largest value of the logarithmic counter.
+	lfuMaxValue = 255
+)
+
+// usedMemory is the memory accounted to the keys of every database.
+var usedMemory atomic.Int64
+
+// keyMeta is the bookkeeping of a key. size is only accessed with the write
+// lock held, while the access fields are atomic so that reads holding the
+// read lock can update them.
+type keyMeta struct {
+	size int64 // bytes accounted to the key in usedMemory
+
+	access  atomic.Int64  // Unix milliseconds of the last access
+	freq    atomic.Uint32 // logarithmic access counter
+	decayed atomic.Int64  // Unix milliseconds freq was last decremented
+}
+
+// UsedMemory returns the memory accounted to the keys of every database, in
+// bytes.
+func UsedMemory() int64 {
+	return usedMemory.Load()
+}
+
+// updateMemory accounts the current size of the value stored at key, or
+// drops the bookkeeping of key if it was deleted. The caller must hold the
+// write lock.
+func (ds *DataStore) updateMemory(key string) {
+	value, found := ds.data.Get(key)
+	m := ds.meta[key]
+	if !found {
+		if m != nil {
+			ds.used -= m.size
+			usedMemory.Add(-m.size)
+			delete(ds.meta, key)
+		}
+		return
+	}
+	if m == nil {
+		m = &keyMeta{}
+		m.freq.Store(lfuInitValue)
+		m.decayed.Store(Now())
+		ds.meta[key] = m
+	}
+	m.access.Store(Now())
+	size := keySize(key, value, memorySamples)
+	ds.used += size - m.size
+	usedMemory.Add(size - m.size)
+	m.size = size
+}
+
+// touch records an access to key. The caller must hold at least a read
+// lock.
+func (ds *DataStore) touch(key string) {
+	m := ds.meta[key]
+	if m == nil {
+		return
+	}
+	m.access.Store(Now())
+	m.freq.Store(uint32(lfuLogIncr(m.decay())))
+}
+
+// decay decrements the access counter by one for every lfu-decay-time
+// minutes since it was last decremented, and returns it.
+func (m *keyMeta) decay() int64 {
+	counter := int64(m.freq.Load())
+	period := lfuDecayTime.Load() * 60 * 1000
+	if period == 0 {
+		return counter
+	}
+	elapsed := Now() - m.decayed.Load()
+	if periods := elapsed / period; periods > 0 {
+		counter = max(counter-periods, 0)
+		m.freq.Store(uint32(counter))
+		m.decayed.Add(periods * period)
+	}
+	return counter
+}
+
+// lfuLogIncr increments the counter with a probability that decreases as it
+// grows, so that the 8 bits of the counter cover millions of accesses. The
+// higher lfu-log-factor, the more accesses it takes.
+func lfuLogIncr(counter int64) int64 {
+	if counter == lfuMaxValue {
+		return counter
+	}
+	base := float64(max(counter-lfuInitValue, 0))
+	if rand.Float64() < 1/(base*float64(lfuLogFactor.Load())+1) {
+		counter++
+	}
+	return counter
+}
+
+// MemoryUsage returns the memory taken by key and its value, in bytes, with
+// aggregate values sized from samples of their elements, or all of them if
+// samples is 0. It returns false if the key does not exist.
+func (ds *DataStore) MemoryUsage(key string, samples int) (int64, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	value, found := ds.peek(key)
+	if !found {
+		return 0, false
+	}
+	return keySize(key, value, samples), true
+}
+
+// IdleTime returns the number of seconds since key was last accessed, or
+// false if the key does not exist. It does not count as an access.
+func (ds *DataStore) IdleTime(key string) (int64, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	m, found := ds.peekMeta(key)
+	if !found {
+		return 0, false
+	}
+	return (Now() - m.access.Load()) / 1000, true
+}
+
+// Frequency returns the logarithmic access counter of key, or false if the
+// key does not exist. It does not count as an access.
+func (ds *DataStore) Frequency(key string) (int64, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	m, found := ds.peekMeta(key)
+	if !found {
+		return 0, false
+	}
+	return m.decay(), true
+}
+
+// peekMeta returns the bookkeeping of key without counting as an access, or
+// false if the key does not exist. The caller must hold the write lock.
+func (ds *DataStore) peekMeta(key string) (*keyMeta, bool) {
+	if _, found := ds.peek(key); !found {
+		return nil, false
+	}
+	if ds.meta[key] == nil {
+		ds.updateMemory(key)
+	}
+	return ds.meta[key], true
+}
+
+// keySize estimates the memory taken by key and value.
+func keySize(key string, value any, samples int) int64 {
+	return keyOverhead + int64(len(key)) + valueSize(value, samples)
+}
+
+// valueSize estimates the memory taken by a stored value, sizing aggregate
+// values from samples of their elements, or all of them if samples is 0.
+func valueSize(value any, samples int) int64 {
+	switch v := value.(type) {
+	case int64:
+		return 8
+	case string:
+		return stringOverhead + int64(len(v))
+	case *List:
+		return extrapolate(v.Len(), samples, func(yield func(int64) bool) {
+			for node := v.head; node != nil; node = node.next {
+				for _, item := range node.items {
+					if !yield(stringOverhead + int64(len(item))) {
+						return
+					}
+				}
+			}
+		})
+	case *Hash:
+		return int64(len(v.expires))*fieldExpiryOverhead +
+			extrapolate(v.Len(), samples, dictSizes(v.fields, samples, func(field, value string) int64 {
+				return entryOverhead + 2*stringOverhead + int64(len(field)+len(value))
+			}))
+	case *Set:
+		if v.members == nil {
+			return 8 * int64(len(v.intset))
+		}
+		return extrapolate(v.Len(), samples, dictSizes(v.members, samples, func(member string, _ struct{}) int64 {
+			return entryOverhead + stringOverhead + int64(len(member))
+		}))
+	case *ZSet:
+		return extrapolate(v.Len(), samples, dictSizes(v.dict, samples, func(member string, _ float64) int64 {
+			return entryOverhead + skiplistNodeOverhead + stringOverhead + int64(len(member))
+		}))
+	case *Stream:
+		return extrapolate(v.Len(), samples, func(yield func(int64) bool) {
+			for _, chunk := range v.chunks {
+				for _, entry := range chunk.entries {
+					size := int64(streamEntryOverhead)
+					for _, field := range entry.Fields {
+						size += stringOverhead + int64(len(field))
+					}
+					if !yield(size) {
+						return
+					}
+				}
+			}
+		})
+	}
+	return 0
+}
+
+// extrapolate estimates the total size of n elements from the first samples
+// sizes yielded by sizes, or all of them if samples is 0.
+func extrapolate(n, samples int, sizes iter.Seq[int64]) int64 {
+	var total int64
+	counted := 0
+	for size := range sizes {
+		if samples > 0 && counted == samples {
+			break
+		}
+		total += size
+		counted++
+	}
+	if counted == 0 {
+		return 0
+	}
+	return total * int64(n) / int64(counted)
+}
+
+// dictSizes yields the sizes of random entries of d, or of every entry if
+// samples is 0 or covers the whole dict.
+func dictSizes[V any](d *dict[V], samples int, size func(string, V) int64) iter.Seq[int64] {
+	return func(yield func(int64) bool) {
+		if samples == 0 || samples >= d.Len() {
+			for key, value := range d.All() {
+				if !yield(size(key, value)) {
+					return
+				}
+			}
+			return
+		}
+		for {
+			key, value, found := d.RandomEntry()
+			if !found || !yield(size(key, value)) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package datastore
+
+import "slices"
+
+// listChunkSize is the maximum number of elements stored in a single quicklist
+// node. Keeping nodes small bounds the cost of inserting at either end of a
+// node while keeping per-element overhead far below a plain linked list.
+const listChunkSize = 128
+
+// listNode is a chunk of consecutive list elements.
+type listNode struct {
+	prev, next *listNode
+	items      []string
+}
+
+// List is a quicklist: a doubly linked list of small slices. Pushing and
+// popping at either end is O(1), and index based access only walks nodes,
+// not individual elements.
+type List struct {
+	head, tail *listNode
+	length     int
+}
+
+// NewList returns an empty List.
+func NewList() *List {
+	return &List{}
+}
+
+// Len returns the number of elements in the list.
+func (l *List) Len() int {
+	return l.length
+}
+
+// clone returns a copy of the list that shares no nodes with it.
+func (l *List) clone() *List {
+	c := NewList()
+	for node := l.head; node != nil; node = node.next {
+		c.insertNodeAfter(nil, &listNode{items: slices.Clone(node.items)})
+	}
+	c.length = l.length
+	return c
+}
+
+// PushFront inserts value at the head of the list.
+func (l *List) PushFront(value string) {
+	if l.head == nil || len(l.head.items) >= listChunkSize {
+		l.insertNodeBefore(l.head, &listNode{})
+	}
+	l.head.items = append(l.head.items, "")
+	copy(l.head.items[1:], l.head.items)
+	l.head.items[0] = value
+	l.length++
+}
+
+// PushBack inserts value at the tail of the list.
+func (l *List) PushBack(value string) {
+	if l.tail == nil || len(l.tail.items) >= listChunkSize {
+		l.insertNodeAfter(l.tail, &listNode{})
+	}
+	l.tail.items = append(l.tail.items, value)
+	l.length++
+}
+
+// PopFront removes and returns the head of the list.
+func (l *List) PopFront() (string, bool) {
+	if l.length == 0 {
+		return "", false
+	}
+	node := l.head
+	value := node.items[0]
+	node.items[0] = ""
+	node.items = node.items[1:]
+	l.length--
+	if len(node.items) == 0 {
+		l.unlinkNode(node)
+	}
+	return value, true
+}
+
+// PopBack removes and returns the tail of the list.
+func (l *List) PopBack() (string, bool) {
+	if l.length == 0 {
+		return "", false
+	}
+	node := l.tail
+	last := len(node.items) - 1
+	value := node.items[last]
+	node.items[last] = ""
+	node.items = node.items[:last]
+	l.length--
+	if len(node.items) == 0 {
+		l.unlinkNode(node)
+	}
+	return value, true
+}
+
+// Index returns the element at the zero-based index i. Negative indexes count
+// from the tail, so -1 is the last element.
+func (l *List) Index(i int) (string, bool) {
+	node, offset, ok := l.locate(i)
+	if !ok {
+		return "", false
+	}
+	return node.items[offset], true
+}
+
+// Set replaces the element at index i. It returns false if i is out of range.
+func (l *List) Set(i int, value string) bool {
+	node, offset, ok := l.locate(i)
+	if !ok {
+		return false
+	}
+	node.items[offset] = value
+	return true
+}
+
+// Range returns the elements between start and stop, both inclusive, using
+// the same index semantics as LRANGE.
+func (l *List) Range(start, stop int) []string {
+	start, stop, ok := normalizeRange(start, stop, l.length)
+	if !ok {
+		return []string{}
+	}
+	result := make([]string, 0, stop-start+1)
+	node, offset, _ := l.locate(start)
+	for node != nil && len(result) < stop-start+1 {
+		result = append(result, node.items[offset])
+		offset++
+		if offset == len(node.items) {
+			node, offset = node.next, 0
+		}
+	}
+	return result
+}
+
+// Values returns every element of the list from head to tail.
+func (l *List) Values() []string {
+	return l.Range(0, -1)
+}
+
+// Remove deletes up to count occurrences of value. A positive count removes
+// from head to tail, a negative count from tail to head and zero removes every
+// occurrence. It returns the number of removed elements.
+func (l *List) Remove(count int, value string) int {
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	removed := 0
+	if count >= 0 {
+		for node := l.head; node != nil && (limit == 0 || removed < limit); {
+			next := node.next
+			kept := node.items[:0]
+			for _, item := range node.items {
+				if item == value && (limit == 0 || removed < limit) {
+					removed++
+					continue
+				}
+				kept = append(kept, item)
+			}
+			l.replaceItems(node, kept)
+			node = next
+		}
+	} else {
+		for node := l.tail; node != nil && removed < limit; {
+			prev := node.prev
+			items := node.items
+			for j := len(items) - 1; j >= 0 && removed < limit; j-- {
+				if items[j] == value {
+					items = append(items[:j], items[j+1:]...)
+					removed++
+				}
+			}
+			l.replaceItems(node, items)
+			node = prev
+		}
+	}
+	l.length -= removed
+	return removed
+}
+
+// Trim keeps only the elements between start and stop, both inclusive, using
+// the same index semantics as LTRIM.
+func (l *List) Trim(start, stop int) {
+	start, stop, ok := normalizeRange(start, stop, l.length)
+	if !ok {
+		*l = List{}
+		return
+	}
+	dropTail := l.length - 1 - stop
+	for i := 0; i < start; i++ {
+		l.PopFront()
+	}
+	for i := 0; i < dropTail; i++ {
+		l.PopBack()
+	}
+}
+
+// Insert inserts value before or after the first occurrence of pivot. It
+// returns false if pivot is not in the list.
+func (l *List) Insert(before bool, pivot, value string) bool {
+	for node := l.head; node != nil; node = node.next {
+		for j, item := range node.items {
+			if item != pivot {
+				continue
+			}
+			if !before {
+				j++
+			}
+			node.items = append(node.items, "")
+			copy(node.items[j+1:], node.items[j:])
+			node.items[j] = value
+			l.length++
+			if len(node.items) > listChunkSize {
+				l.splitNode(node)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// locate returns the node and the offset within it holding index i.
+func (l *List) locate(i int) (*listNode, int, bool) {
+	if i < 0 {
+		i += l.length
+	}
+	if i < 0 || i >= l.length {
+		return nil, 0, false
+	}
+	if i < l.length/2 {
+		for node := l.head; node != nil; node = node.next {
+			if i < len(node.items) {
+				return node, i, true
+			}
+			i -= len(node.items)
+		}
+	} else {
+		i = l.length - 1 - i
+		for node := l.tail; node != nil; node = node.prev {
+			if i < len(node.items) {
+				return node, len(node.items) - 1 - i, true
+			}
+			i -= len(node.items)
+		}
+	}
+	return nil, 0, false
+}
+
+// replaceItems stores items in node, unlinking the node if it became empty.
+func (l *List) replaceItems(node *listNode, items []string) {
+	if len(items) == 0 {
+		l.unlinkNode(node)
+		return
+	}
+	node.items = items
+}
+
+// splitNode moves the second half of an oversized node into a new node.
+func (l *List) splitNode(node *listNode) {
+	half := len(node.items) / 2
+	tail := &listNode{items: append([]string(nil), node.items[half:]...)}
+	node.items = node.items[:half:half]
+	l.insertNodeAfter(node, tail)
+}
+
+// insertNodeBefore links node in front of mark, or at the head if mark is nil.
+func (l *List) insertNodeBefore(mark, node *listNode) {
+	if mark == nil {
+		mark = l.head
+	}
+	node.next = mark
+	if mark == nil {
+		l.head, l.tail = node, node
+		return
+	}
+	node.prev = mark.prev
+	if mark.prev != nil {
+		mark.prev.next = node
+	} else {
+		l.head = node
+	}
+	mark.prev = node
+}
+
+// insertNodeAfter links node behind mark, or at the tail if mark is nil.
+func (l *List) insertNodeAfter(mark, node *listNode) {
+	if mark == nil {
+		mark = l.tail
+	}
+	node.prev = mark
+	if mark == nil {
+		l.head, l.tail = node, node
+		return
+	}
+	node.next = mark.next
+	if mark.next != nil {
+		mark.next.prev = node
+	} else {
+		l.tail = node
+	}
+	mark.next = node
+}
+
+// unlinkNode removes node from the list of nodes.
+func (l *List) unlinkNode(node *listNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		l.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		l.tail = node.prev
+	}
+	node.prev, node.next = nil, nil
+}
+
+// normalizeRange converts Redis style start/stop indexes, where negative values
+// count from the end, into a valid inclusive range over length elements. It
+// returns false if the range is empty.
+func normalizeRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return 0, 0, false
+	}
+	return start, stop, true
+}
@@ -0,0 +1,93 @@
+package datastore
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestListPushPop tests pushing and popping at both ends across node boundaries
+func TestListPushPop(t *testing.T) {
+	l := NewList()
+	for i := 0; i < 3*listChunkSize; i++ {
+		l.PushBack(fmt.Sprint(i))
+		l.PushFront(fmt.Sprint(-i - 1))
+	}
+	if l.Len() != 6*listChunkSize {
+		t.Fatalf("Expected length %d, got %d", 6*listChunkSize, l.Len())
+	}
+	if v, _ := l.Index(0); v != fmt.Sprint(-3*listChunkSize) {
+		t.Errorf("Unexpected head %q", v)
+	}
+	if v, _ := l.Index(-1); v != fmt.Sprint(3*listChunkSize-1) {
+		t.Errorf("Unexpected tail %q", v)
+	}
+	for i := 3*listChunkSize - 1; i >= 0; i-- {
+		if v, _ := l.PopBack(); v != fmt.Sprint(i) {
+			t.Fatalf("Expected %d from PopBack, got %q", i, v)
+		}
+	}
+	for i := 3 * listChunkSize; i > 0; i-- {
+		if v, _ := l.PopFront(); v != fmt.Sprint(-i) {
+			t.Fatalf("Expected %d from PopFront, got %q", -i, v)
+		}
+	}
+	if _, ok := l.PopFront(); ok || l.head != nil || l.tail != nil {
+		t.Errorf("Expected list to be empty")
+	}
+}
+
+// TestListOperations tests Range, Set, Insert, Remove and Trim
+func TestListOperations(t *testing.T) {
+	l := NewList()
+	for _, v := range []string{"a", "b", "a", "c", "a"} {
+		l.PushBack(v)
+	}
+	if got := l.Range(1, -2); !reflect.DeepEqual(got, []string{"b", "a", "c"}) {
+		t.Errorf("Unexpected range %v", got)
+	}
+	if got := l.Range(5, 10); len(got) != 0 {
+		t.Errorf("Expected empty range, got %v", got)
+	}
+	if !l.Set(-1, "z") || l.Set(5, "x") {
+		t.Errorf("Unexpected Set results")
+	}
+	if !l.Insert(true, "c", "x") || l.Insert(true, "missing", "x") {
+		t.Errorf("Unexpected Insert results")
+	}
+	if got := l.Values(); !reflect.DeepEqual(got, []string{"a", "b", "a", "x", "c", "z"}) {
+		t.Errorf("Unexpected values %v", got)
+	}
+	if n := l.Remove(-1, "a"); n != 1 {
+		t.Errorf("Expected 1 removal, got %d", n)
+	}
+	if got := l.Values(); !reflect.DeepEqual(got, []string{"a", "b", "x", "c", "z"}) {
+		t.Errorf("Unexpected values after Remove %v", got)
+	}
+	l.Trim(1, 2)
+	if got := l.Values(); !reflect.DeepEqual(got, []string{"b", "x"}) {
+		t.Errorf("Unexpected values after Trim %v", got)
+	}
+	l.Trim(5, 10)
+	if l.Len() != 0 {
+		t.Errorf("Expected empty list after out of range Trim")
+	}
+}
+
+// TestListInsertSplitsNodes tests that inserting into a full node splits it
+func TestListInsertSplitsNodes(t *testing.T) {
+	l := NewList()
+	for i := 0; i < listChunkSize; i++ {
+		l.PushBack(fmt.Sprint(i))
+	}
+	l.Insert(false, "10", "new")
+	if l.head == l.tail {
+		t.Errorf("Expected full node to be split")
+	}
+	if v, _ := l.Index(11); v != "new" {
+		t.Errorf("Expected inserted value at index 11, got %q", v)
+	}
+	if l.Len() != listChunkSize+1 || len(l.Values()) != listChunkSize+1 {
+		t.Errorf("Unexpected length after split")
+	}
+}
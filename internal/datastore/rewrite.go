@@ -0,0 +1,231 @@
+package datastore
+
+import (
+	"strconv"
+)
+
+// AOF rewriting turns the keys of every database into the shortest commands
+// recreating them. Taking a Snapshot copies nothing: Rewrite visits the keys
+// a few at a time with the cursor of SCAN, in the background while commands
+// keep running, and a key about to change before it was visited is copied
+// first, so that the snapshot sees the keys as they were when it was taken.
+// The copies must not share anything the databases may still modify.
+
+const (
+	// rewriteItemsPerCommand is the largest number of elements added by a
+	// single command of a rewritten AOF, as in Redis.
+	rewriteItemsPerCommand = 64
+	// rewriteScanCount is the number of keys Rewrite copies at a time.
+	rewriteScanCount = 100
+)
+
+// Snapshot is the data set of a database as it was when the snapshot was
+// taken, for AOF rewriting. Its fields are guarded by the lock of the
+// database.
+type Snapshot struct {
+	ds *DataStore
+	// cursor is where Rewrite resumes the scan of the keys, and complete is
+	// set once every key was visited or saved.
+	cursor   uint64
+	complete bool
+	// seen holds the keys that were visited or saved, or did not exist
+	// when they were first about to change. saved holds the copies of the
+	// keys saved that Rewrite did not write out yet.
+	seen  map[string]struct{}
+	saved []snapshotKey
+}
+
+// snapshotKey is a key of a Snapshot with its value and deadline.
+type snapshotKey struct {
+	key       string
+	value     any
+	at        int64
+	hasExpiry bool
+}
+
+// Snapshot starts a snapshot of the keys of the database, their values and
+// their deadlines, which Rewrite must then write out. Keys whose deadline
+// has passed are left out.
+func (ds *DataStore) Snapshot() *Snapshot {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.snapshot = &Snapshot{ds: ds, seen: make(map[string]struct{})}
+	return ds.snapshot
+}
+
+// preserve saves key for the snapshot being written out, if any, before it
+// changes. The caller must hold the write lock.
+func (ds *DataStore) preserve(key string) {
+	s := ds.snapshot
+	if s == nil || s.complete {
+		return
+	}
+	if _, found := s.seen[key]; found {
+		return
+	}
+	s.seen[key] = struct{}{}
+	if value, found := ds.data.Get(key); found && !ds.isExpired(key) {
+		at, hasExpiry := ds.expires[key]
+		s.saved = append(s.saved, snapshotKey{key, copyValue(value), at, hasExpiry})
+	}
+}
+
+// preserveAll saves every key not visited yet for the snapshot being written
+// out, if any, before the keyspace is replaced. The caller must hold the
+// write lock.
+func (ds *DataStore) preserveAll() {
+	if ds.snapshot == nil {
+		return
+	}
+	for key := range ds.data.All() {
+		ds.preserve(key)
+	}
+	ds.snapshot.complete = true
+}
+
+// next returns the keys to write out next, and whether there may be more.
+// Once there are none, the snapshot is over and changes are no longer
+// preserved for it.
+func (s *Snapshot) next() ([]snapshotKey, bool) {
+	ds := s.ds
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var keys []snapshotKey
+	if !s.complete {
+		s.cursor = ds.data.scanCount(s.cursor, rewriteScanCount, func(key string, value any) {
+			if _, found := s.seen[key]; found {
+				return
+			}
+			s.seen[key] = struct{}{}
+			if ds.isExpired(key) {
+				return
+			}
+			at, hasExpiry := ds.expires[key]
+			keys = append(keys, snapshotKey{key, copyValue(value), at, hasExpiry})
+		})
+		s.complete = s.cursor == 0
+	}
+	keys, s.saved = append(keys, s.saved...), nil
+	if s.complete {
+		ds.snapshot = nil
+	}
+	return keys, !s.complete
+}
+
+// Rewrite passes to emit the commands recreating the keys of the snapshot,
+// starting with a SELECT of their database if there are any. Hash fields
+// whose deadline has passed are left out.
+func (s *Snapshot) Rewrite(emit func(args []string)) {
+	selected := false
+	for more := true; more; {
+		var keys []snapshotKey
+		keys, more = s.next()
+		for _, k := range keys {
+			if !selected {
+				emit([]string{"SELECT", strconv.Itoa(s.ds.id)})
+				selected = true
+			}
+			if !rewriteValue(k.key, k.value, emit) {
+				continue
+			}
+			if k.hasExpiry {
+				emit([]string{"PEXPIREAT", k.key, strconv.FormatInt(k.at, 10)})
+			}
+		}
+	}
+}
+
+// rewriteValue passes to emit the commands storing value at key. It reports
+// whether there were any, as a hash whose fields all expired has none.
+func rewriteValue(key string, value any, emit func(args []string)) bool {
+	switch v := value.(type) {
+	case *List:
+		emitBatches(emit, []string{"RPUSH", key}, v.Values(), 1)
+	case *Set:
+		emitBatches(emit, []string{"SADD", key}, v.Members(), 1)
+	case *ZSet:
+		var items []string
+		for _, m := range v.Members() {
+			items = append(items, FormatScore(m.Score), m.Member)
+		}
+		emitBatches(emit, []string{"ZADD", key}, items, 2)
+	case *Hash:
+		return rewriteHash(key, v, emit)
+	case *Stream:
+		rewriteStream(key, v, emit)
+	default:
+		str, _ := asString(value)
+		emit([]string{"SET", key, str})
+	}
+	return true
+}
+
+// rewriteHash passes to emit the commands storing h at key, along with the
+// deadlines of its fields. It reports whether any field was left.
+func rewriteHash(key string, h *Hash, emit func(args []string)) bool {
+	current := Now()
+	var items []string
+	for _, field := range h.Fields() {
+		if at, found := h.expires[field]; found && at <= current {
+			continue
+		}
+		value, _ := h.Get(field)
+		items = append(items, field, value)
+	}
+	if len(items) == 0 {
+		return false
+	}
+	emitBatches(emit, []string{"HSET", key}, items, 2)
+	for i := 0; i < len(items); i += 2 {
+		if at, found := h.expires[items[i]]; found {
+			emit([]string{"HPEXPIREAT", key, strconv.FormatInt(at, 10), "FIELDS", "1", items[i]})
+		}
+	}
+	return true
+}
+
+// rewriteStream passes to emit the commands storing s at key: its entries,
+// its last ID and counters, and its consumer groups with their consumers
+// and pending entries.
+func rewriteStream(key string, s *Stream, emit func(args []string)) {
+	if s.length == 0 {
+		// Adding an entry and trimming it away is the only way to create
+		// an empty stream.
+		emit([]string{"XADD", key, "MAXLEN", "0", "0-1", "x", "y"})
+	}
+	for _, chunk := range s.chunks {
+		for _, entry := range chunk.entries {
+			emit(append([]string{"XADD", key, entry.ID.String()}, entry.Fields...))
+		}
+	}
+	emit([]string{"XSETID", key, s.lastID.String(),
+		"ENTRIESADDED", strconv.FormatUint(s.entriesAdded, 10),
+		"MAXDELETEDID", s.maxDeletedID.String()})
+	for _, name := range sortedKeys(s.groups) {
+		g := s.groups[name]
+		emit([]string{"XGROUP", "CREATE", key, name, g.lastID.String(),
+			"ENTRIESREAD", strconv.FormatInt(g.entriesRead, 10)})
+		for _, id := range g.pelIDs {
+			nack := g.pel[id]
+			emit([]string{"XCLAIM", key, name, nack.consumer.name, "0", id.String(),
+				"TIME", strconv.FormatInt(nack.deliveryTime, 10),
+				"RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10), "FORCE", "JUSTID"})
+		}
+		for _, consumer := range sortedKeys(g.consumers) {
+			if len(g.consumers[consumer].pending) == 0 {
+				emit([]string{"XGROUP", "CREATECONSUMER", key, name, consumer})
+			}
+		}
+	}
+}
+
+// emitBatches passes to emit the command made of prefix followed by items,
+// split into several commands of up to rewriteItemsPerCommand elements of
+// size items each.
+func emitBatches(emit func(args []string), prefix []string, items []string, size int) {
+	for len(items) > 0 {
+		n := min(len(items), rewriteItemsPerCommand*size)
+		emit(append(append([]string(nil), prefix...), items[:n]...))
+		items = items[n:]
+	}
+}
@@ -0,0 +1,166 @@
+package datastore
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSnapshotRewrite tests the commands a snapshot is rewritten as for
+// every type, and that the snapshot is not affected by later writes
+func TestSnapshotRewrite(t *testing.T) {
+	ds := GetDatabase(3)
+	ds.FlushDB(false)
+	defer ds.FlushDB(false)
+	future := time.Now().UnixMilli() + 60000
+	ds.Set("rw:str", "hello")
+	ds.SetWithExpireAt("rw:int", "42", future)
+	ds.SetWithExpireAt("rw:expired", "v", 1)
+	ds.Push("rw:list", false, "a", "b", "c")
+	ds.SAdd("rw:set", "3", "1", "2")
+	ds.ZAdd("rw:zset", ZAddOptions{}, ZMember{Member: "m", Score: 1.5}, ZMember{Member: "n", Score: -2})
+	ds.HSet("rw:hash", "f1", "v1", "f2", "v2", "f3", "v3")
+	ds.HExpireAt("rw:hash", future, ExpireAlways, "f2")
+	ds.HExpireAt("rw:hash", future, ExpireAlways, "f3")
+	ds.HSet("rw:gone", "f", "v")
+	// Expire fields without them being purged.
+	for key, field := range map[string]string{"rw:hash": "f3", "rw:gone": "f"} {
+		value, _ := ds.data.Get(key)
+		value.(*Hash).expires[field] = 1
+	}
+	for i := uint64(1); i <= 3; i++ {
+		ds.XAdd("rw:stream", XAddArgs{ID: StreamID{i, 0}, Fields: []string{"f", strconv.FormatUint(i, 10)}})
+	}
+	ds.XDel("rw:stream", StreamID{3, 0})
+	ds.XGroupCreate("rw:stream", "g", GroupStart{}, false)
+	ds.XReadGroup("rw:stream", "g", "alice", StreamID{}, true, 1, false)
+	ds.XGroupCreateConsumer("rw:stream", "g", "bob")
+	ds.XGroupCreate("rw:empty", "g", GroupStart{}, true)
+
+	snapshot := ds.Snapshot()
+	ds.Push("rw:list", false, "d")
+	ds.Del("rw:str")
+
+	byKey := make(map[string][]string)
+	var first []string
+	snapshot.Rewrite(func(args []string) {
+		if first == nil {
+			first = args
+		}
+		switch args[0] {
+		case "SELECT":
+		case "XGROUP":
+			byKey[args[2]] = append(byKey[args[2]], strings.Join(args, " "))
+		default:
+			byKey[args[1]] = append(byKey[args[1]], strings.Join(args, " "))
+		}
+	})
+	if !slices.Equal(first, []string{"SELECT", "3"}) {
+		t.Errorf("Expected the rewrite to start with SELECT 3, got %v", first)
+	}
+	at := strconv.FormatInt(future, 10)
+	delivery, _ := ds.XPending("rw:stream", "g", StreamID{}, MaxStreamID, 10, "", 0)
+	expected := map[string][]string{
+		"rw:str":  {"SET rw:str hello"},
+		"rw:int":  {"SET rw:int 42", "PEXPIREAT rw:int " + at},
+		"rw:list": {"RPUSH rw:list a b c"},
+		"rw:set":  {"SADD rw:set 1 2 3"},
+		"rw:zset": {"ZADD rw:zset -2 n 1.5 m"},
+		"rw:hash": {"HSET rw:hash f1 v1 f2 v2", "HPEXPIREAT rw:hash " + at + " FIELDS 1 f2"},
+		"rw:stream": {
+			"XADD rw:stream 1-0 f 1",
+			"XADD rw:stream 2-0 f 2",
+			"XSETID rw:stream 3-0 ENTRIESADDED 3 MAXDELETEDID 3-0",
+			"XGROUP CREATE rw:stream g 1-0 ENTRIESREAD -1",
+			"XCLAIM rw:stream g alice 0 1-0 TIME " + strconv.FormatInt(delivery[0].DeliveryTime, 10) + " RETRYCOUNT 1 FORCE JUSTID",
+			"XGROUP CREATECONSUMER rw:stream g bob",
+		},
+		"rw:empty": {
+			"XADD rw:empty MAXLEN 0 0-1 x y",
+			"XSETID rw:empty 0-0 ENTRIESADDED 0 MAXDELETEDID 0-0",
+			"XGROUP CREATE rw:empty g 0-0 ENTRIESREAD 0",
+		},
+	}
+	for key, commands := range expected {
+		if !slices.Equal(byKey[key], commands) {
+			t.Errorf("Expected %s to be rewritten as %q, got %q", key, commands, byKey[key])
+		}
+	}
+	for _, key := range []string{"rw:expired", "rw:gone"} {
+		if commands, found := byKey[key]; found {
+			t.Errorf("Expected %s to be left out, got %q", key, commands)
+		}
+	}
+}
+
+// TestSnapshotRewriteBatches tests that large values are split into
+// commands of rewriteItemsPerCommand elements
+func TestSnapshotRewriteBatches(t *testing.T) {
+	ds := GetDatabase(3)
+	ds.FlushDB(false)
+	defer ds.FlushDB(false)
+	var fields []string
+	for i := range 2*rewriteItemsPerCommand + 1 {
+		fields = append(fields, "f"+strconv.Itoa(i), "v")
+	}
+	ds.HSet("rw:big", fields...)
+	var sizes []int
+	ds.Snapshot().Rewrite(func(args []string) {
+		if args[0] == "HSET" {
+			sizes = append(sizes, (len(args)-2)/2)
+		}
+	})
+	if !slices.Equal(sizes, []int{rewriteItemsPerCommand, rewriteItemsPerCommand, 1}) {
+		t.Errorf("Expected batches of %d fields, got %v", rewriteItemsPerCommand, sizes)
+	}
+}
+
+// TestSnapshotCopyOnWrite tests that keys changed while a snapshot is
+// written out are rewritten as they were when it was taken
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	ds := GetDatabase(3)
+	ds.FlushDB(false)
+	defer ds.FlushDB(false)
+	for i := range 1000 {
+		ds.Push("cow:"+strconv.Itoa(i), false, "a")
+	}
+
+	for _, flush := range []bool{false, true} {
+		snapshot := ds.Snapshot()
+		keys, _ := snapshot.next()
+		rewritten := make(map[string][]string)
+		for _, k := range keys {
+			rewritten[k.key] = k.value.(*List).Values()
+		}
+		for i := range 1000 {
+			ds.Push("cow:"+strconv.Itoa(i), false, "b")
+		}
+		ds.Del("cow:0", "cow:999")
+		ds.Set("cow:new", "v")
+		if flush {
+			ds.FlushDB(false)
+		}
+		snapshot.Rewrite(func(args []string) {
+			if args[0] == "RPUSH" || args[0] == "SET" {
+				rewritten[args[1]] = append(rewritten[args[1]], args[2:]...)
+			}
+		})
+		if len(rewritten) != 1000 {
+			t.Errorf("Expected 1000 keys to be rewritten, got %d", len(rewritten))
+		}
+		for key, values := range rewritten {
+			if !slices.Equal(values, []string{"a"}) {
+				t.Errorf("Expected %s to be rewritten as it was, got %v", key, values)
+			}
+		}
+		if ds.snapshot != nil {
+			t.Error("Expected the snapshot to be over")
+		}
+		ds.FlushDB(false)
+		for i := range 1000 {
+			ds.Push("cow:"+strconv.Itoa(i), false, "a")
+		}
+	}
+}
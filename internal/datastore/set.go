@@ -0,0 +1,471 @@
+package datastore
+
+import (
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/manimovassagh/Godis/internal/config"
+	"github.com/manimovassagh/Godis/internal/glob"
+)
+
+// setMaxIntsetEntries is the largest number of members a set may hold while
+// still using the compact intset encoding.
+var setMaxIntsetEntries atomic.Int64
+
+func init() {
+	setMaxIntsetEntries.Store(512)
+	config.Register(config.IntParam("set-max-intset-entries", &setMaxIntsetEntries, 0, 1<<30))
+}
+
+// Set is an unordered collection of unique strings. Small sets made only of
+// integers are stored as a sorted slice of int64 (the intset encoding) and
+// converted to a hash table once they receive a non-integer member or grow
+// past set-max-intset-entries.
+type Set struct {
+	intset  []int64
+	members *dict[struct{}]
+}
+
+// NewSet returns an empty Set using the intset encoding.
+func NewSet() *Set {
+	return &Set{}
+}
+
+// Encoding returns the name of the set's current encoding as reported by
+// OBJECT ENCODING.
+func (s *Set) Encoding() string {
+	if s.members == nil {
+		return "intset"
+	}
+	return "hashtable"
+}
+
+// Len returns the number of members in the set.
+func (s *Set) Len() int {
+	if s.members == nil {
+		return len(s.intset)
+	}
+	return s.members.Len()
+}
+
+// clone returns a copy of the set with the same encoding.
+func (s *Set) clone() *Set {
+	if s.members != nil {
+		return &Set{members: s.members.clone()}
+	}
+	return &Set{intset: slices.Clone(s.intset)}
+}
+
+// Contains reports whether member is in the set.
+func (s *Set) Contains(member string) bool {
+	if s.members != nil {
+		_, found := s.members.Get(member)
+		return found
+	}
+	n, ok := parseSetInt(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(s.intset, n)
+	return found
+}
+
+// Add inserts member into the set. It returns false if it was already present.
+func (s *Set) Add(member string) bool {
+	if s.members == nil {
+		if n, ok := parseSetInt(member); ok {
+			i, found := slices.BinarySearch(s.intset, n)
+			if found {
+				return false
+			}
+			if int64(len(s.intset)) < setMaxIntsetEntries.Load() {
+				s.intset = slices.Insert(s.intset, i, n)
+				return true
+			}
+		}
+		s.convertToHashTable()
+	}
+	return s.members.Set(member, struct{}{})
+}
+
+// Remove deletes member from the set. It returns false if it was not present.
+func (s *Set) Remove(member string) bool {
+	if s.members != nil {
+		_, found := s.members.Delete(member)
+		return found
+	}
+	n, ok := parseSetInt(member)
+	if !ok {
+		return false
+	}
+	i, found := slices.BinarySearch(s.intset, n)
+	if !found {
+		return false
+	}
+	s.intset = slices.Delete(s.intset, i, i+1)
+	return true
+}
+
+// Members returns every member of the set. Intset members come out in
+// numeric order; hash table members in no particular order.
+func (s *Set) Members() []string {
+	members := make([]string, 0, s.Len())
+	if s.members == nil {
+		for _, n := range s.intset {
+			members = append(members, strconv.FormatInt(n, 10))
+		}
+		return members
+	}
+	return append(members, s.members.Keys()...)
+}
+
+// convertToHashTable switches the set to the hash table encoding.
+func (s *Set) convertToHashTable() {
+	s.members = newDict[struct{}]()
+	s.members.resize(len(s.intset) + 1)
+	for _, n := range s.intset {
+		s.members.Set(strconv.FormatInt(n, 10), struct{}{})
+	}
+	s.intset = nil
+}
+
+// parseSetInt parses member as an intset integer. Only the canonical decimal
+// form qualifies, so that "007" stays distinct from "7".
+func parseSetInt(member string) (int64, bool) {
+	n, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != member {
+		return 0, false
+	}
+	return n, true
+}
+
+// newSetFrom builds a set holding members, choosing the encoding as if they
+// had been added one by one.
+func newSetFrom(members []string) *Set {
+	s := NewSet()
+	for _, member := range members {
+		s.Add(member)
+	}
+	return s
+}
+
+// lookupSet returns the set stored at key. If the key does not exist it
+// returns nil, or a new set stored at key when create is true. The caller
+// must hold the write lock.
+func (ds *DataStore) lookupSet(key string, create bool) (*Set, error) {
+	value, found := ds.lookup(key)
+	if !found {
+		if !create {
+			return nil, nil
+		}
+		set := NewSet()
+		ds.data.Set(key, set)
+		return set, nil
+	}
+	set, ok := value.(*Set)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return set, nil
+}
+
+// deleteIfEmptySet removes the key once its set has no members left. The
+// caller must hold the write lock.
+func (ds *DataStore) deleteIfEmptySet(key string, set *Set) {
+	if set.Len() == 0 {
+		ds.deleteKey(key)
+	}
+}
+
+// SAdd adds members to the set stored at key, creating it if needed, and
+// returns the number of members that were added.
+func (ds *DataStore) SAdd(key string, members ...string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	set, err := ds.lookupSet(key, true)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, member := range members {
+		if set.Add(member) {
+			added++
+		}
+	}
+	if added > 0 {
+		ds.signalModifiedKey(key)
+	}
+	return added, nil
+}
+
+// SRem removes members from the set stored at key and returns the number of
+// members that were removed.
+func (ds *DataStore) SRem(key string, members ...string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	set, err := ds.lookupSet(key, false)
+	if set == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if set.Remove(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		ds.signalModifiedKey(key)
+	}
+	ds.deleteIfEmptySet(key, set)
+	return removed, nil
+}
+
+// SMembers returns the members of the set stored at key.
+func (ds *DataStore) SMembers(key string) ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	set, err := ds.lookupSet(key, false)
+	if set == nil {
+		return []string{}, err
+	}
+	return set.Members(), nil
+}
+
+// SMIsMember reports for each member whether it belongs to the set stored at key.
+func (ds *DataStore) SMIsMember(key string, members ...string) ([]bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	results := make([]bool, len(members))
+	set, err := ds.lookupSet(key, false)
+	if set == nil {
+		return results, err
+	}
+	for i, member := range members {
+		results[i] = set.Contains(member)
+	}
+	return results, nil
+}
+
+// SCard returns the number of members of the set stored at key.
+func (ds *DataStore) SCard(key string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	set, err := ds.lookupSet(key, false)
+	if set == nil {
+		return 0, err
+	}
+	return set.Len(), nil
+}
+
+// SPop removes and returns up to count random members of the set stored at key.
+func (ds *DataStore) SPop(key string, count int) ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	set, err := ds.lookupSet(key, false)
+	if set == nil {
+		return []string{}, err
+	}
+	members := set.Members()
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	members = members[:min(count, len(members))]
+	for _, member := range members {
+		set.Remove(member)
+	}
+	if len(members) > 0 {
+		ds.signalModifiedKey(key)
+	}
+	ds.deleteIfEmptySet(key, set)
+	return members, nil
+}
+
+// SRandMember returns random members of the set stored at key. A non-negative
+// count returns up to count distinct members, while a negative count returns
+// exactly -count members that may repeat.
+func (ds *DataStore) SRandMember(key string, count int) ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	set, err := ds.lookupSet(key, false)
+	if set == nil {
+		return []string{}, err
+	}
+	members := set.Members()
+	if count < 0 {
+		result := make([]string, -count)
+		for i := range result {
+			result[i] = members[rand.IntN(len(members))]
+		}
+		return result, nil
+	}
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	return members[:min(count, len(members))], nil
+}
+
+// SMove moves member from the source set to the destination set. It returns
+// false if the member was not in the source set.
+func (ds *DataStore) SMove(source, destination, member string) (bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	src, err := ds.lookupSet(source, false)
+	if err != nil {
+		return false, err
+	}
+	if value, found := ds.lookup(destination); found {
+		if _, ok := value.(*Set); !ok {
+			return false, ErrWrongType
+		}
+	}
+	if src == nil || !src.Contains(member) {
+		return false, nil
+	}
+	if source == destination {
+		return true, nil
+	}
+	src.Remove(member)
+	ds.deleteIfEmptySet(source, src)
+	dst, _ := ds.lookupSet(destination, true)
+	dst.Add(member)
+	ds.signalModifiedKey(source)
+	ds.signalModifiedKey(destination)
+	return true, nil
+}
+
+// SetOp identifies a set algebra operation.
+type SetOp int
+
+const (
+	// SetInter intersects the sets.
+	SetInter SetOp = iota
+	// SetUnion unites the sets.
+	SetUnion
+	// SetDiff subtracts every following set from the first one.
+	SetDiff
+)
+
+// setAlgebra applies op to the sets stored at keys. Missing keys count as
+// empty sets. The caller must hold the write lock.
+func (ds *DataStore) setAlgebra(op SetOp, keys []string) ([]string, error) {
+	sets := make([]*Set, len(keys))
+	for i, key := range keys {
+		set, err := ds.lookupSet(key, false)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	result := []string{}
+	switch op {
+	case SetInter:
+		for _, set := range sets {
+			if set == nil {
+				return result, nil
+			}
+		}
+		// Iterate the smallest set and probe the others.
+		sort.Slice(sets, func(i, j int) bool { return sets[i].Len() < sets[j].Len() })
+	next:
+		for _, member := range sets[0].Members() {
+			for _, other := range sets[1:] {
+				if !other.Contains(member) {
+					continue next
+				}
+			}
+			result = append(result, member)
+		}
+	case SetUnion:
+		seen := make(map[string]struct{})
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+			for _, member := range set.Members() {
+				if _, found := seen[member]; !found {
+					seen[member] = struct{}{}
+					result = append(result, member)
+				}
+			}
+		}
+	case SetDiff:
+		if sets[0] == nil {
+			return result, nil
+		}
+	outer:
+		for _, member := range sets[0].Members() {
+			for _, other := range sets[1:] {
+				if other != nil && other.Contains(member) {
+					continue outer
+				}
+			}
+			result = append(result, member)
+		}
+	}
+	return result, nil
+}
+
+// SetAlgebra returns the result of applying op to the sets stored at keys.
+func (ds *DataStore) SetAlgebra(op SetOp, keys ...string) ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.setAlgebra(op, keys)
+}
+
+// SetAlgebraStore stores the result of applying op to the sets stored at keys
+// in destination, replacing whatever it held, and returns its cardinality. An
+// empty result deletes destination.
+func (ds *DataStore) SetAlgebraStore(op SetOp, destination string, keys ...string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	members, err := ds.setAlgebra(op, keys)
+	if err != nil {
+		return 0, err
+	}
+	ds.deleteKey(destination)
+	if len(members) > 0 {
+		ds.data.Set(destination, newSetFrom(members))
+	}
+	return len(members), nil
+}
+
+// SInterCard returns the cardinality of the intersection of the sets stored
+// at keys, capped at limit if limit is positive.
+func (ds *DataStore) SInterCard(limit int, keys ...string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	members, err := ds.setAlgebra(SetInter, keys)
+	if err != nil {
+		return 0, err
+	}
+	if limit > 0 && len(members) > limit {
+		return limit, nil
+	}
+	return len(members), nil
+}
+
+// SScan visits about count members of the set stored at key, starting at
+// cursor, and returns those that match pattern (an empty pattern matches
+// everything), along with the cursor to continue from, which is 0 once the
+// iteration is complete. An intset is returned whole in a single call, as in
+// Redis. See dict.Scan for the guarantees.
+func (ds *DataStore) SScan(key string, cursor uint64, count int, pattern string) (uint64, []string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	set, err := ds.lookupSet(key, false)
+	if set == nil {
+		return 0, []string{}, err
+	}
+	matched := []string{}
+	match := func(member string, _ struct{}) {
+		if pattern == "" || glob.Match(pattern, member) {
+			matched = append(matched, member)
+		}
+	}
+	if set.members == nil {
+		for _, member := range set.Members() {
+			match(member, struct{}{})
+		}
+		return 0, matched, nil
+	}
+	return set.members.scanCount(cursor, count, match), matched, nil
+}
@@ -0,0 +1,79 @@
+package datastore
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// TestSetEncoding tests the intset encoding and its conversion to a hash table
+func TestSetEncoding(t *testing.T) {
+	s := NewSet()
+	for _, member := range []string{"3", "1", "2", "1"} {
+		s.Add(member)
+	}
+	if s.Encoding() != "intset" || s.Len() != 3 {
+		t.Fatalf("Expected intset with 3 members, got %s with %d", s.Encoding(), s.Len())
+	}
+	if got := s.Members(); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("Expected sorted intset members, got %v", got)
+	}
+	if s.Contains("01") {
+		t.Errorf("Expected non-canonical integer not to match")
+	}
+	s.Add("01")
+	if s.Encoding() != "hashtable" || !s.Contains("01") || !s.Contains("1") {
+		t.Errorf("Expected conversion to hashtable keeping every member")
+	}
+
+	old := setMaxIntsetEntries.Load()
+	setMaxIntsetEntries.Store(4)
+	defer setMaxIntsetEntries.Store(old)
+	s = NewSet()
+	for i := 0; i < 4; i++ {
+		s.Add(fmt.Sprint(i))
+	}
+	if s.Encoding() != "intset" {
+		t.Errorf("Expected intset at the threshold")
+	}
+	s.Add("4")
+	if s.Encoding() != "hashtable" || s.Len() != 5 {
+		t.Errorf("Expected hashtable past the threshold")
+	}
+}
+
+// TestSetAlgebra tests intersection, union and difference
+func TestSetAlgebra(t *testing.T) {
+	ds := GetDataStore()
+	ds.SAdd("algebra-a", "a", "b", "c", "d")
+	ds.SAdd("algebra-b", "c", "d", "e")
+
+	tests := []struct {
+		op       SetOp
+		keys     []string
+		expected string
+	}{
+		{SetInter, []string{"algebra-a", "algebra-b"}, "[c d]"},
+		{SetInter, []string{"algebra-a", "algebra-missing"}, "[]"},
+		{SetUnion, []string{"algebra-a", "algebra-b"}, "[a b c d e]"},
+		{SetDiff, []string{"algebra-a", "algebra-b"}, "[a b]"},
+		{SetDiff, []string{"algebra-a", "algebra-missing"}, "[a b c d]"},
+	}
+	for _, test := range tests {
+		got, err := ds.SetAlgebra(test.op, test.keys...)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != test.expected {
+			t.Errorf("SetAlgebra(%d, %v) = %v, expected %s", test.op, test.keys, got, test.expected)
+		}
+	}
+
+	if n, _ := ds.SetAlgebraStore(SetInter, "algebra-dest", "algebra-a", "algebra-missing"); n != 0 {
+		t.Errorf("Expected empty intersection, got %d", n)
+	}
+	if _, found := ds.Encoding("algebra-dest"); found {
+		t.Errorf("Expected empty result to leave no destination key")
+	}
+}
@@ -0,0 +1,624 @@
+package datastore
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/manimovassagh/Godis/internal/config"
+)
+
+// streamNodeMaxEntries is the largest number of entries stored in a single
+// chunk of a stream. Approximate trimming only ever removes whole chunks.
+var streamNodeMaxEntries atomic.Int64
+
+func init() {
+	streamNodeMaxEntries.Store(100)
+	config.Register(config.IntParam("stream-node-max-entries", &streamNodeMaxEntries, 1, 1<<30))
+}
+
+var (
+	// ErrStreamIDTooSmall is returned by XAdd when the new ID is not greater
+	// than the last ID of the stream.
+	ErrStreamIDTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	// ErrStreamIDZero is returned by XAdd for the explicit ID 0-0.
+	ErrStreamIDZero = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	// ErrStreamExhausted is returned by XAdd when no ID is left after the last
+	// one of the stream.
+	ErrStreamExhausted = errors.New("ERR The stream has exhausted the last possible ID, unable to add more items")
+)
+
+// StreamID identifies a stream entry: the Unix time in milliseconds at which
+// it was added and a sequence number for entries added in the same
+// millisecond.
+type StreamID struct {
+	Ms, Seq uint64
+}
+
+// MaxStreamID is the greatest possible stream ID.
+var MaxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+// String formats the ID as "<ms>-<seq>".
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less reports whether id sorts before other.
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// next returns the ID that immediately follows id, or false if id is the
+// greatest possible ID.
+func (id StreamID) next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the ID that immediately precedes id, or false if id is 0-0.
+func (id StreamID) prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// StreamEntry is a single entry of a stream: its ID followed by a flat list of
+// field-value pairs.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// streamChunk holds a run of consecutive entries, sorted by ID.
+type streamChunk struct {
+	entries []StreamEntry
+}
+
+func (c *streamChunk) first() StreamID { return c.entries[0].ID }
+func (c *streamChunk) last() StreamID  { return c.entries[len(c.entries)-1].ID }
+
+// search returns the index of the first entry whose ID is not less than id.
+func (c *streamChunk) search(id StreamID) int {
+	return sort.Search(len(c.entries), func(i int) bool { return !c.entries[i].ID.Less(id) })
+}
+
+// Stream is an append-only log of entries ordered by ID. Entries are kept in a
+// list of chunks of up to stream-node-max-entries each, so that appends are
+// cheap and trimming from the head can drop whole chunks at once.
+type Stream struct {
+	chunks       []*streamChunk
+	length       int
+	lastID       StreamID
+	maxDeletedID StreamID
+	entriesAdded uint64
+	groups       map[string]*consumerGroup
+}
+
+// NewStream returns an empty Stream.
+func NewStream() *Stream {
+	return &Stream{}
+}
+
+// Len returns the number of entries in the stream.
+func (s *Stream) Len() int {
+	return s.length
+}
+
+// clone returns a copy of the stream, including its consumer groups. The
+// fields of the entries are shared, as they are never modified in place.
+func (s *Stream) clone() *Stream {
+	c := *s
+	c.chunks = make([]*streamChunk, len(s.chunks))
+	for i, chunk := range s.chunks {
+		c.chunks[i] = &streamChunk{entries: slices.Clone(chunk.entries)}
+	}
+	if s.groups != nil {
+		c.groups = make(map[string]*consumerGroup, len(s.groups))
+		for name, group := range s.groups {
+			c.groups[name] = group.clone()
+		}
+	}
+	return &c
+}
+
+// LastID returns the greatest ID ever added to the stream, even if that entry
+// has since been deleted.
+func (s *Stream) LastID() StreamID {
+	return s.lastID
+}
+
+// nextID returns the ID XADD generates for "*" at the current time.
+func (s *Stream) nextID() (StreamID, error) {
+	ms := uint64(Now())
+	if ms > s.lastID.Ms {
+		return StreamID{ms, 0}, nil
+	}
+	id, ok := s.lastID.next()
+	if !ok {
+		return id, ErrStreamExhausted
+	}
+	return id, nil
+}
+
+// append adds an entry at the end of the stream. Its ID must be greater than
+// the last ID of the stream.
+func (s *Stream) append(entry StreamEntry) {
+	n := len(s.chunks)
+	if n == 0 || int64(len(s.chunks[n-1].entries)) >= streamNodeMaxEntries.Load() {
+		s.chunks = append(s.chunks, &streamChunk{})
+		n++
+	}
+	chunk := s.chunks[n-1]
+	chunk.entries = append(chunk.entries, entry)
+	s.length++
+	s.lastID = entry.ID
+	s.entriesAdded++
+}
+
+// locate returns the chunk index and entry index of the first entry whose ID
+// is not less than id. The chunk index is len(s.chunks) if there is none.
+func (s *Stream) locate(id StreamID) (int, int) {
+	i := sort.Search(len(s.chunks), func(i int) bool { return !s.chunks[i].last().Less(id) })
+	if i == len(s.chunks) {
+		return i, 0
+	}
+	return i, s.chunks[i].search(id)
+}
+
+// Delete removes the entry with the given ID and reports whether it existed.
+func (s *Stream) Delete(id StreamID) bool {
+	i, j := s.locate(id)
+	if i == len(s.chunks) || s.chunks[i].entries[j].ID != id {
+		return false
+	}
+	chunk := s.chunks[i]
+	chunk.entries = append(chunk.entries[:j], chunk.entries[j+1:]...)
+	if len(chunk.entries) == 0 {
+		s.chunks = append(s.chunks[:i], s.chunks[i+1:]...)
+	}
+	s.length--
+	if s.maxDeletedID.Less(id) {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+// Range returns up to count entries (all of them if count is negative) whose
+// IDs lie within [start, end], in ascending order, or in descending order
+// when rev is set.
+func (s *Stream) Range(start, end StreamID, count int, rev bool) []StreamEntry {
+	var entries []StreamEntry
+	if end.Less(start) {
+		return entries
+	}
+	full := func() bool { return count >= 0 && len(entries) >= count }
+	if !rev {
+		i, j := s.locate(start)
+		for ; i < len(s.chunks) && !full(); i, j = i+1, 0 {
+			for ; j < len(s.chunks[i].entries) && !full(); j++ {
+				entry := s.chunks[i].entries[j]
+				if end.Less(entry.ID) {
+					return entries
+				}
+				entries = append(entries, entry)
+			}
+		}
+		return entries
+	}
+
+	// Find the last chunk starting at or before end, then the last entry in
+	// it that is not after end.
+	i := sort.Search(len(s.chunks), func(i int) bool { return end.Less(s.chunks[i].first()) }) - 1
+	if i < 0 {
+		return entries
+	}
+	j := s.chunks[i].search(end)
+	if j == len(s.chunks[i].entries) || s.chunks[i].entries[j].ID != end {
+		j--
+	}
+	for ; i >= 0 && !full(); i-- {
+		if j < 0 {
+			j = len(s.chunks[i].entries) - 1
+		}
+		for ; j >= 0 && !full(); j-- {
+			entry := s.chunks[i].entries[j]
+			if entry.ID.Less(start) {
+				return entries
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// First returns the first entry of the stream, or false if it is empty.
+func (s *Stream) First() (StreamEntry, bool) {
+	if s.length == 0 {
+		return StreamEntry{}, false
+	}
+	return s.chunks[0].entries[0], true
+}
+
+// Last returns the last entry of the stream, or false if it is empty.
+func (s *Stream) Last() (StreamEntry, bool) {
+	if s.length == 0 {
+		return StreamEntry{}, false
+	}
+	chunk := s.chunks[len(s.chunks)-1]
+	return chunk.entries[len(chunk.entries)-1], true
+}
+
+// TrimStrategy selects how a stream is trimmed.
+type TrimStrategy int
+
+const (
+	TrimNone TrimStrategy = iota
+	TrimMaxLen
+	TrimMinID
+)
+
+// StreamTrim describes the MAXLEN or MINID trimming of XADD and XTRIM.
+type StreamTrim struct {
+	Strategy TrimStrategy
+	MaxLen   int64
+	MinID    StreamID
+	// Approx trims only whole chunks, which may leave a few more entries
+	// than requested, and removes at most Limit entries (no limit if 0).
+	Approx bool
+	Limit  int64
+}
+
+// Args formats the trim as XTRIM arguments.
+func (t StreamTrim) Args() []string {
+	args := []string{"MAXLEN", strconv.FormatInt(t.MaxLen, 10)}
+	if t.Strategy == TrimMinID {
+		args = []string{"MINID", t.MinID.String()}
+	}
+	if t.Approx {
+		args = []string{args[0], "~", args[1], "LIMIT", strconv.FormatInt(t.Limit, 10)}
+	}
+	return args
+}
+
+// keeps reports whether the trim leaves the entry at the head of a stream of
+// the given length in place.
+func (t StreamTrim) keeps(id StreamID, length int) bool {
+	if t.Strategy == TrimMinID {
+		return !id.Less(t.MinID)
+	}
+	return int64(length) <= t.MaxLen
+}
+
+// Trim removes entries from the head of the stream according to t. It returns
+// the number of entries removed.
+func (s *Stream) Trim(t StreamTrim) int {
+	removed := 0
+	if t.Strategy == TrimNone {
+		return removed
+	}
+	for len(s.chunks) > 0 {
+		chunk := s.chunks[0]
+		if t.keeps(chunk.first(), s.length) {
+			break
+		}
+		n := len(chunk.entries)
+		if t.Approx {
+			// Only drop the chunk if every entry in it goes.
+			if !(t.Strategy == TrimMinID && chunk.last().Less(t.MinID)) &&
+				!(t.Strategy == TrimMaxLen && int64(s.length-n) >= t.MaxLen) {
+				break
+			}
+			if t.Limit > 0 && int64(removed+n) > t.Limit {
+				break
+			}
+			s.chunks = s.chunks[1:]
+		} else {
+			n = 0
+			for n < len(chunk.entries) && !t.keeps(chunk.entries[n].ID, s.length-n) {
+				n++
+			}
+			if n == len(chunk.entries) {
+				s.chunks = s.chunks[1:]
+			} else {
+				chunk.entries = chunk.entries[n:]
+			}
+		}
+		s.length -= n
+		removed += n
+	}
+	return removed
+}
+
+// exactTrim returns an exact trim that reproduces the state of the stream's
+// head, used to log trims deterministically.
+func (s *Stream) exactTrim() *StreamTrim {
+	if first, ok := s.First(); ok {
+		return &StreamTrim{Strategy: TrimMinID, MinID: first.ID}
+	}
+	return &StreamTrim{Strategy: TrimMaxLen}
+}
+
+// lookupStream returns the stream stored at key. If the key does not exist it
+// returns nil, or a new stream stored at key when create is true. Unlike the
+// other aggregate types, an empty stream is kept around, as in Redis. The
+// caller must hold the write lock.
+func (ds *DataStore) lookupStream(key string, create bool) (*Stream, error) {
+	value, found := ds.lookup(key)
+	if !found {
+		if !create {
+			return nil, nil
+		}
+		stream := NewStream()
+		ds.data.Set(key, stream)
+		return stream, nil
+	}
+	stream, ok := value.(*Stream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return stream, nil
+}
+
+// XAddArgs holds the parsed arguments of XADD.
+type XAddArgs struct {
+	NoMkStream bool
+	Trim       StreamTrim
+	// ID is the explicit ID of the new entry. With AutoID it is generated
+	// from the clock, and with AutoSeq only its sequence number is.
+	ID      StreamID
+	AutoID  bool
+	AutoSeq bool
+	Fields  []string
+}
+
+// XAddResult reports the outcome of XAdd.
+type XAddResult struct {
+	ID StreamID
+	// Added is false if NoMkStream was set and the stream did not exist.
+	Added bool
+	// Trimmed is an exact trim equivalent to the trimming that was done, or
+	// nil if no entry was removed.
+	Trimmed *StreamTrim
+}
+
+// XAdd appends an entry to the stream stored at key, creating the stream
+// unless args.NoMkStream is set, and then trims it according to args.Trim.
+func (ds *DataStore) XAdd(key string, args XAddArgs) (XAddResult, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var result XAddResult
+	stream, err := ds.lookupStream(key, !args.NoMkStream)
+	if stream == nil {
+		return result, err
+	}
+
+	id := args.ID
+	switch {
+	case args.AutoID:
+		id, err = stream.nextID()
+		if err != nil {
+			return result, err
+		}
+	case args.AutoSeq:
+		if id.Ms < stream.lastID.Ms {
+			return result, ErrStreamIDTooSmall
+		}
+		if id.Ms == stream.lastID.Ms {
+			if stream.lastID.Seq == math.MaxUint64 {
+				return result, ErrStreamIDTooSmall
+			}
+			id.Seq = stream.lastID.Seq + 1
+		}
+	case id == StreamID{}:
+		return result, ErrStreamIDZero
+	case !stream.lastID.Less(id):
+		return result, ErrStreamIDTooSmall
+	}
+
+	stream.append(StreamEntry{ID: id, Fields: append([]string(nil), args.Fields...)})
+	result.ID, result.Added = id, true
+	ds.signalModifiedKey(key)
+	ds.signalKeyAsReady(key)
+	if stream.Trim(args.Trim) > 0 {
+		result.Trimmed = stream.exactTrim()
+	}
+	return result, nil
+}
+
+// XTrim trims the stream stored at key according to t. It returns the number
+// of entries removed and, if there were any, an equivalent exact trim.
+func (ds *DataStore) XTrim(key string, t StreamTrim) (int, *StreamTrim, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, false)
+	if stream == nil {
+		return 0, nil, err
+	}
+	removed := stream.Trim(t)
+	if removed == 0 {
+		return 0, nil, nil
+	}
+	ds.signalModifiedKey(key)
+	return removed, stream.exactTrim(), nil
+}
+
+// XDel removes the entries with the given IDs from the stream stored at key
+// and returns the number of entries removed.
+func (ds *DataStore) XDel(key string, ids ...StreamID) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, false)
+	if stream == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, id := range ids {
+		if stream.Delete(id) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		ds.signalModifiedKey(key)
+	}
+	return removed, nil
+}
+
+// XLen returns the number of entries in the stream stored at key.
+func (ds *DataStore) XLen(key string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, false)
+	if stream == nil {
+		return 0, err
+	}
+	return stream.Len(), nil
+}
+
+// XRange returns up to count entries (all of them if count is negative) of
+// the stream stored at key whose IDs lie within [start, end], in descending
+// order when rev is set.
+func (ds *DataStore) XRange(key string, start, end StreamID, count int, rev bool) ([]StreamEntry, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, false)
+	if stream == nil {
+		return nil, err
+	}
+	return stream.Range(start, end, count, rev), nil
+}
+
+// XRead returns up to count entries (all of them if count is negative) of
+// the stream stored at key with IDs greater than after.
+func (ds *DataStore) XRead(key string, after StreamID, count int) ([]StreamEntry, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, false)
+	if stream == nil {
+		return nil, err
+	}
+	start, ok := after.next()
+	if !ok {
+		return nil, nil
+	}
+	return stream.Range(start, MaxStreamID, count, false), nil
+}
+
+// XLastID returns the last ID of the stream stored at key, or 0-0 if the key
+// does not exist. XREAD resolves "$" with it.
+func (ds *DataStore) XLastID(key string) (StreamID, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, false)
+	if stream == nil {
+		return StreamID{}, err
+	}
+	return stream.lastID, nil
+}
+
+// XSetIDArgs holds the parsed arguments of XSETID.
+type XSetIDArgs struct {
+	ID StreamID
+	// EntriesAdded is -1 when ENTRIESADDED is not given, and MaxDeletedID
+	// is 0-0 when MAXDELETEDID is not.
+	EntriesAdded int64
+	MaxDeletedID StreamID
+}
+
+// XSetID sets the last ID of the stream stored at key, and the number of
+// entries ever added to it and its greatest deleted ID if they are given.
+// It returns ErrNoSuchKey if the key does not exist.
+func (ds *DataStore) XSetID(key string, args XSetIDArgs) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, false)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		return ErrNoSuchKey
+	}
+	if args.ID.Less(stream.maxDeletedID) {
+		return errors.New("ERR The ID specified in XSETID is smaller than current max_deleted_entry_id")
+	}
+	if last, ok := stream.Last(); ok {
+		if args.ID.Less(last.ID) {
+			return errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")
+		}
+		if args.EntriesAdded >= 0 && int64(stream.length) > args.EntriesAdded {
+			return errors.New("ERR The entries_added specified in XSETID is smaller than the target stream length")
+		}
+	}
+	stream.lastID = args.ID
+	if args.EntriesAdded >= 0 {
+		stream.entriesAdded = uint64(args.EntriesAdded)
+	}
+	if args.MaxDeletedID != (StreamID{}) {
+		stream.maxDeletedID = args.MaxDeletedID
+	}
+	ds.signalModifiedKey(key)
+	return nil
+}
+
+// StreamInfo holds the fields reported by XINFO STREAM.
+type StreamInfo struct {
+	Length          int
+	Chunks          int
+	LastID          StreamID
+	MaxDeletedID    StreamID
+	EntriesAdded    uint64
+	RecordedFirstID StreamID
+	First, Last     *StreamEntry
+	// Groups describes the consumer groups. XINFO STREAM reports only their
+	// number unless FULL is given.
+	Groups []GroupInfo
+	// Entries holds the entries reported by XINFO STREAM FULL.
+	Entries []StreamEntry
+}
+
+// XInfoStream describes the stream stored at key. When full is set, the
+// first count entries (all of them if count is 0) are included as well. It
+// returns ErrNoSuchKey if the key does not exist.
+func (ds *DataStore) XInfoStream(key string, full bool, count int) (StreamInfo, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var info StreamInfo
+	stream, err := ds.lookupStream(key, false)
+	if err != nil {
+		return info, err
+	}
+	if stream == nil {
+		return info, ErrNoSuchKey
+	}
+	info.Length = stream.length
+	info.Chunks = len(stream.chunks)
+	info.LastID = stream.lastID
+	info.MaxDeletedID = stream.maxDeletedID
+	info.EntriesAdded = stream.entriesAdded
+	info.Groups = stream.groupsInfo()
+	if first, ok := stream.First(); ok {
+		info.RecordedFirstID = first.ID
+		info.First = &first
+	}
+	if last, ok := stream.Last(); ok {
+		info.Last = &last
+	}
+	if full {
+		if count == 0 {
+			count = -1
+		}
+		info.Entries = stream.Range(StreamID{}, MaxStreamID, count, false)
+	}
+	return info, nil
+}
@@ -0,0 +1,312 @@
+package datastore
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidStreamID is returned for arguments that are not valid stream IDs.
+var ErrInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// ParseStreamID parses an ID of the form "<ms>-<seq>" or "<ms>", in which
+// case the sequence number is missingSeq.
+func ParseStreamID(arg string, missingSeq uint64) (StreamID, error) {
+	id, seqGiven, err := parseStreamID(arg)
+	if err == nil && !seqGiven {
+		id.Seq = missingSeq
+	}
+	return id, err
+}
+
+// parseStreamID parses an ID of the form "<ms>-<seq>" or "<ms>" and reports
+// whether the sequence number was given. "<ms>-*" is accepted with seqGiven
+// false.
+func parseStreamID(arg string) (StreamID, bool, error) {
+	var id StreamID
+	msPart, seqPart, seqGiven := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return id, false, ErrInvalidStreamID
+	}
+	id.Ms = ms
+	if !seqGiven || seqPart == "*" {
+		return id, false, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return id, false, ErrInvalidStreamID
+	}
+	id.Seq = seq
+	return id, true, nil
+}
+
+// ParseStreamRangeBound parses the start (when isStart is set) or end
+// argument of XRANGE. "-" and "+" stand for the smallest and greatest IDs, a
+// missing sequence number extends the bound to the whole millisecond, and a
+// leading '(' makes the bound exclusive.
+func ParseStreamRangeBound(arg string, isStart bool) (StreamID, error) {
+	switch arg {
+	case "-":
+		return StreamID{}, nil
+	case "+":
+		return MaxStreamID, nil
+	}
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	var missingSeq uint64
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+	id, err := ParseStreamID(arg, missingSeq)
+	if err != nil || strings.HasSuffix(arg, "-*") {
+		return id, ErrInvalidStreamID
+	}
+	if !exclusive {
+		return id, nil
+	}
+	var ok bool
+	if isStart {
+		if id, ok = id.next(); !ok {
+			return id, errors.New("ERR invalid start ID for the interval")
+		}
+	} else if id, ok = id.prev(); !ok {
+		return id, errors.New("ERR invalid end ID for the interval")
+	}
+	return id, nil
+}
+
+// ParseStreamTrim parses the trimming options of XADD and XTRIM starting at
+// args[i], which must be MAXLEN or MINID. It returns the index of the first
+// argument after them.
+func ParseStreamTrim(args []string, i int) (StreamTrim, int, error) {
+	var t StreamTrim
+	switch strings.ToUpper(args[i]) {
+	case "MAXLEN":
+		t.Strategy = TrimMaxLen
+	case "MINID":
+		t.Strategy = TrimMinID
+	default:
+		return t, i, ErrSyntax
+	}
+	i++
+	if i < len(args) && (args[i] == "~" || args[i] == "=") {
+		t.Approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return t, i, ErrSyntax
+	}
+	if t.Strategy == TrimMaxLen {
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return t, i, errNotInteger
+		}
+		if n < 0 {
+			return t, i, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		t.MaxLen = n
+	} else {
+		id, err := ParseStreamID(args[i], 0)
+		if err != nil {
+			return t, i, err
+		}
+		t.MinID = id
+	}
+	i++
+	if t.Approx {
+		t.Limit = 100 * streamNodeMaxEntries.Load()
+	}
+	if i+1 < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			return t, i, errNotInteger
+		}
+		if n < 0 {
+			return t, i, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+		if !t.Approx {
+			return t, i, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		t.Limit = n
+		i += 2
+	}
+	return t, i, nil
+}
+
+// ParseXAddArgs parses the arguments of XADD that follow the key. It does not
+// check that the field-value pairs are complete.
+func ParseXAddArgs(args []string) (XAddArgs, error) {
+	var x XAddArgs
+	i := 0
+	for i < len(args) {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			x.NoMkStream = true
+			i++
+			continue
+		case "MAXLEN", "MINID":
+			var err error
+			if x.Trim, i, err = ParseStreamTrim(args, i); err != nil {
+				return x, err
+			}
+			continue
+		}
+		break
+	}
+	if i >= len(args) {
+		return x, ErrSyntax
+	}
+	if args[i] == "*" {
+		x.AutoID = true
+	} else {
+		id, err := ParseStreamID(args[i], 0)
+		if err != nil {
+			return x, err
+		}
+		x.ID = id
+		x.AutoSeq = strings.HasSuffix(args[i], "-*")
+	}
+	x.Fields = args[i+1:]
+	return x, nil
+}
+
+// ParseXSetIDArgs parses the arguments of XSETID that follow the key.
+func ParseXSetIDArgs(args []string) (XSetIDArgs, error) {
+	x := XSetIDArgs{EntriesAdded: -1}
+	id, err := ParseStreamID(args[0], 0)
+	if err != nil {
+		return x, err
+	}
+	x.ID = id
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return x, ErrSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return x, errNotInteger
+			}
+			if n < 0 {
+				return x, errors.New("ERR entries_added must be positive")
+			}
+			x.EntriesAdded = n
+		case "MAXDELETEDID":
+			id, err := ParseStreamID(args[i+1], 0)
+			if err != nil {
+				return x, err
+			}
+			if x.ID.Less(id) {
+				return x, errors.New("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+			x.MaxDeletedID = id
+		default:
+			return x, ErrSyntax
+		}
+	}
+	return x, nil
+}
+
+// ParseGroupStart parses the arguments of XGROUP CREATE and XGROUP SETID that
+// follow the group name: "$" or an ID, then the ENTRIESREAD option and, if
+// allowMkStream is set, MKSTREAM. It also reports whether MKSTREAM was given.
+func ParseGroupStart(args []string, allowMkStream bool) (GroupStart, bool, error) {
+	start := GroupStart{EntriesRead: -1}
+	mkStream := false
+	if len(args) == 0 {
+		return start, false, ErrSyntax
+	}
+	if args[0] == "$" {
+		start.Last = true
+	} else {
+		id, err := ParseStreamID(args[0], 0)
+		if err != nil {
+			return start, false, err
+		}
+		start.ID = id
+	}
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "MKSTREAM" && allowMkStream:
+			mkStream = true
+		case opt == "ENTRIESREAD" && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return start, false, errNotInteger
+			}
+			if n < -1 {
+				return start, false, errors.New("ERR value for ENTRIESREAD must be positive or -1")
+			}
+			start.EntriesRead = n
+			i++
+		default:
+			return start, false, ErrSyntax
+		}
+	}
+	return start, mkStream, nil
+}
+
+// ParseXClaimArgs parses the arguments of XCLAIM that follow the consumer
+// name: the minimum idle time, the IDs to claim and the options.
+func ParseXClaimArgs(args []string) (int64, []StreamID, XClaimOptions, error) {
+	opts := XClaimOptions{Idle: -1, Time: -1, RetryCount: -1}
+	if len(args) < 2 {
+		return 0, nil, opts, ErrSyntax
+	}
+	minIdle, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, nil, opts, errors.New("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	minIdle = max(minIdle, 0)
+	i := 1
+	var ids []StreamID
+	for ; i < len(args); i++ {
+		id, err := ParseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return 0, nil, opts, ErrInvalidStreamID
+	}
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i])
+		switch {
+		case opt == "FORCE":
+			opts.Force = true
+		case opt == "JUSTID":
+			opts.JustID = true
+		case (opt == "IDLE" || opt == "TIME" || opt == "RETRYCOUNT") && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return 0, nil, opts, errors.New("ERR Invalid " + opt + " option argument for XCLAIM")
+			}
+			n = max(n, 0)
+			switch opt {
+			case "IDLE":
+				opts.Idle = n
+			case "TIME":
+				opts.Time = n
+			default:
+				opts.RetryCount = n
+			}
+			i++
+		case opt == "LASTID" && i+1 < len(args):
+			id, err := ParseStreamID(args[i+1], 0)
+			if err != nil {
+				return 0, nil, opts, err
+			}
+			opts.LastID = &id
+			i++
+		default:
+			return 0, nil, opts, errors.New("ERR Unrecognized XCLAIM option '" + args[i] + "'")
+		}
+	}
+	return minIdle, ids, opts, nil
+}
@@ -0,0 +1,719 @@
+package datastore
+
+import (
+	"errors"
+	"slices"
+	"sort"
+)
+
+var (
+	// ErrBusyGroup is returned by XGroupCreate when the group already exists.
+	ErrBusyGroup = errors.New("BUSYGROUP Consumer Group name already exists")
+	// ErrStreamKeyRequired is returned by the XGROUP operations when the key
+	// does not exist.
+	ErrStreamKeyRequired = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+)
+
+// NoGroupError is returned when a consumer group or its stream does not
+// exist.
+type NoGroupError struct {
+	Key, Group string
+}
+
+func (e *NoGroupError) Error() string {
+	return "NOGROUP No such key '" + e.Key + "' or consumer group '" + e.Group + "'"
+}
+
+// streamNACK is an entry of a pending entries list: a message that was
+// delivered to a consumer but not acknowledged yet.
+type streamNACK struct {
+	consumer      *streamConsumer
+	deliveryTime  int64
+	deliveryCount int64
+}
+
+// streamConsumer is a member of a consumer group.
+type streamConsumer struct {
+	name string
+	// seenTime is the last time the consumer interacted with the group, and
+	// activeTime the last time it read or claimed an entry (-1 if never).
+	seenTime   int64
+	activeTime int64
+	pending    map[StreamID]*streamNACK
+}
+
+// consumerGroup tracks which entries of a stream were delivered to whom.
+type consumerGroup struct {
+	name   string
+	lastID StreamID
+	// entriesRead counts the entries delivered to the group since the stream
+	// was created, or is -1 when it cannot be known, e.g. after XDEL.
+	entriesRead int64
+	pel         map[StreamID]*streamNACK
+	// pelIDs holds the keys of pel in ascending order.
+	pelIDs    []StreamID
+	consumers map[string]*streamConsumer
+}
+
+func newConsumerGroup(name string, lastID StreamID, entriesRead int64) *consumerGroup {
+	return &consumerGroup{
+		name:        name,
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pel:         make(map[StreamID]*streamNACK),
+		consumers:   make(map[string]*streamConsumer),
+	}
+}
+
+// clone returns a copy of the group whose pending entries point to the
+// copies of its consumers.
+func (g *consumerGroup) clone() *consumerGroup {
+	c := newConsumerGroup(g.name, g.lastID, g.entriesRead)
+	c.pelIDs = slices.Clone(g.pelIDs)
+	for name, consumer := range g.consumers {
+		c.consumers[name] = &streamConsumer{
+			name:       consumer.name,
+			seenTime:   consumer.seenTime,
+			activeTime: consumer.activeTime,
+			pending:    make(map[StreamID]*streamNACK, len(consumer.pending)),
+		}
+	}
+	for id, nack := range g.pel {
+		copied := &streamNACK{
+			consumer:      c.consumers[nack.consumer.name],
+			deliveryTime:  nack.deliveryTime,
+			deliveryCount: nack.deliveryCount,
+		}
+		c.pel[id] = copied
+		copied.consumer.pending[id] = copied
+	}
+	return c
+}
+
+// consumer returns the named consumer, creating it if create is set. It
+// reports whether the consumer was created.
+func (g *consumerGroup) consumer(name string, create bool) (*streamConsumer, bool) {
+	if c, ok := g.consumers[name]; ok || !create {
+		return c, false
+	}
+	c := &streamConsumer{name: name, seenTime: Now(), activeTime: -1, pending: make(map[StreamID]*streamNACK)}
+	g.consumers[name] = c
+	return c, true
+}
+
+// pelSearch returns the index in pelIDs of the first ID not less than id.
+func (g *consumerGroup) pelSearch(id StreamID) int {
+	return sort.Search(len(g.pelIDs), func(i int) bool { return !g.pelIDs[i].Less(id) })
+}
+
+// assign makes the entry pending for consumer c, moving it from another
+// consumer if needed, and returns its NACK.
+func (g *consumerGroup) assign(id StreamID, c *streamConsumer) *streamNACK {
+	nack, ok := g.pel[id]
+	if !ok {
+		nack = &streamNACK{}
+		g.pel[id] = nack
+		g.pelIDs = slices.Insert(g.pelIDs, g.pelSearch(id), id)
+	} else if nack.consumer != nil {
+		delete(nack.consumer.pending, id)
+	}
+	nack.consumer = c
+	c.pending[id] = nack
+	return nack
+}
+
+// ack removes the entry from the pending entries list and reports whether it
+// was pending.
+func (g *consumerGroup) ack(id StreamID) bool {
+	nack, ok := g.pel[id]
+	if !ok {
+		return false
+	}
+	delete(nack.consumer.pending, id)
+	delete(g.pel, id)
+	i := g.pelSearch(id)
+	g.pelIDs = slices.Delete(g.pelIDs, i, i+1)
+	return true
+}
+
+// lag returns the number of entries not yet delivered to the group, or false
+// if it cannot be known.
+func (s *Stream) lag(g *consumerGroup) (int64, bool) {
+	if g.lastID == s.lastID {
+		return 0, true
+	}
+	if g.entriesRead < 0 {
+		return 0, false
+	}
+	return int64(s.entriesAdded) - g.entriesRead, true
+}
+
+// delivered advances the group past the given entries, keeping entriesRead
+// exact as long as no entry in between was deleted.
+func (s *Stream) delivered(g *consumerGroup, entries []StreamEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	last := entries[len(entries)-1].ID
+	switch {
+	case last == s.lastID:
+		g.entriesRead = int64(s.entriesAdded)
+	case g.entriesRead >= 0 && !g.lastID.Less(s.maxDeletedID):
+		g.entriesRead += int64(len(entries))
+	default:
+		g.entriesRead = -1
+	}
+	g.lastID = last
+}
+
+// entry returns the entry with the given ID, or false if it was deleted.
+func (s *Stream) entry(id StreamID) (StreamEntry, bool) {
+	entries := s.Range(id, id, 1, false)
+	if len(entries) == 0 {
+		return StreamEntry{}, false
+	}
+	return entries[0], true
+}
+
+// lookupGroup returns the stream stored at key and its named consumer group,
+// or a *NoGroupError if either does not exist. The caller must hold the write
+// lock.
+func (ds *DataStore) lookupGroup(key, group string) (*Stream, *consumerGroup, error) {
+	stream, err := ds.lookupStream(key, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stream != nil {
+		if g, ok := stream.groups[group]; ok {
+			return stream, g, nil
+		}
+	}
+	return nil, nil, &NoGroupError{Key: key, Group: group}
+}
+
+// GroupStart is the position a consumer group starts reading from, as given
+// to XGROUP CREATE and XGROUP SETID.
+type GroupStart struct {
+	// ID is the last delivered ID, unless Last is set, in which case it is
+	// the last ID of the stream ("$").
+	ID   StreamID
+	Last bool
+	// EntriesRead is the number of entries read so far, or -1 if unknown.
+	EntriesRead int64
+}
+
+// resolve returns the last delivered ID and entries read for the group.
+func (s *Stream) resolve(start GroupStart) (StreamID, int64) {
+	switch {
+	case start.Last || start.ID == s.lastID:
+		return s.lastID, int64(s.entriesAdded)
+	case start.EntriesRead >= 0:
+		return start.ID, start.EntriesRead
+	case start.ID == StreamID{}:
+		return start.ID, 0
+	}
+	return start.ID, -1
+}
+
+// XGroupCreate creates a consumer group on the stream stored at key, creating
+// an empty stream if mkStream is set. It returns the resolved start, which
+// reproduces the group when passed back.
+func (ds *DataStore) XGroupCreate(key, group string, start GroupStart, mkStream bool) (GroupStart, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, mkStream)
+	if err != nil {
+		return start, err
+	}
+	if stream == nil {
+		return start, ErrStreamKeyRequired
+	}
+	if _, ok := stream.groups[group]; ok {
+		return start, ErrBusyGroup
+	}
+	if stream.groups == nil {
+		stream.groups = make(map[string]*consumerGroup)
+	}
+	id, entriesRead := stream.resolve(start)
+	stream.groups[group] = newConsumerGroup(group, id, entriesRead)
+	ds.signalModifiedKey(key)
+	return GroupStart{ID: id, EntriesRead: entriesRead}, nil
+}
+
+// XGroupSetID moves the last delivered ID of a consumer group. It returns the
+// resolved start.
+func (ds *DataStore) XGroupSetID(key, group string, start GroupStart) (GroupStart, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, g, err := ds.lookupGroup(key, group)
+	if err != nil {
+		return start, err
+	}
+	g.lastID, g.entriesRead = stream.resolve(start)
+	ds.signalModifiedKey(key)
+	return GroupStart{ID: g.lastID, EntriesRead: g.entriesRead}, nil
+}
+
+// XGroupDestroy deletes a consumer group and reports whether it existed.
+func (ds *DataStore) XGroupDestroy(key, group string) (bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, false)
+	if err != nil {
+		return false, err
+	}
+	if stream == nil {
+		return false, ErrStreamKeyRequired
+	}
+	if _, ok := stream.groups[group]; !ok {
+		return false, nil
+	}
+	delete(stream.groups, group)
+	ds.signalModifiedKey(key)
+	return true, nil
+}
+
+// XGroupCreateConsumer adds a consumer to a group and reports whether it was
+// created.
+func (ds *DataStore) XGroupCreateConsumer(key, group, consumer string) (bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	_, g, err := ds.lookupGroup(key, group)
+	if err != nil {
+		return false, err
+	}
+	_, created := g.consumer(consumer, true)
+	if created {
+		ds.signalModifiedKey(key)
+	}
+	return created, nil
+}
+
+// XGroupDelConsumer removes a consumer from a group, dropping its pending
+// entries, and returns how many entries it had pending.
+func (ds *DataStore) XGroupDelConsumer(key, group, consumer string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	_, g, err := ds.lookupGroup(key, group)
+	if err != nil {
+		return 0, err
+	}
+	c, _ := g.consumer(consumer, false)
+	if c == nil {
+		return 0, nil
+	}
+	pending := len(c.pending)
+	for id := range c.pending {
+		g.ack(id)
+	}
+	delete(g.consumers, consumer)
+	ds.signalModifiedKey(key)
+	return pending, nil
+}
+
+// PendingEntry describes an entry of a pending entries list. Idle is the
+// number of milliseconds since it was last delivered.
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  int64
+	DeliveryCount int64
+	Idle          int64
+}
+
+func pendingEntry(id StreamID, nack *streamNACK) PendingEntry {
+	return PendingEntry{id, nack.consumer.name, nack.deliveryTime, nack.deliveryCount, Now() - nack.deliveryTime}
+}
+
+// XReadGroupResult reports the outcome of XReadGroup.
+type XReadGroupResult struct {
+	// Entries holds the entries read. In a history read, entries that were
+	// deleted from the stream have nil Fields.
+	Entries []StreamEntry
+	// Delivered holds the resulting state of every pending entry that was
+	// delivered and is still in the stream, and ConsumerCreated reports
+	// whether the consumer was new.
+	Delivered       []PendingEntry
+	ConsumerCreated bool
+	// Group is the resulting position of the group after a read of new
+	// entries.
+	Group GroupStart
+}
+
+// XReadGroup reads entries of the stream stored at key on behalf of a
+// consumer of the group. With newOnly set it delivers up to count entries
+// (all of them if count is negative) never delivered to the group before,
+// adding them to the consumer's pending entries unless noAck is set.
+// Otherwise it returns the consumer's pending entries with IDs greater than
+// after.
+func (ds *DataStore) XReadGroup(key, group, consumer string, after StreamID, newOnly bool, count int, noAck bool) (XReadGroupResult, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var result XReadGroupResult
+	stream, g, err := ds.lookupGroup(key, group)
+	if err != nil {
+		return result, err
+	}
+	c, created := g.consumer(consumer, true)
+	result.ConsumerCreated = created
+	t := Now()
+	c.seenTime = t
+	defer func() {
+		if created || len(result.Entries) > 0 {
+			ds.signalModifiedKey(key)
+		}
+	}()
+
+	if newOnly {
+		start, ok := g.lastID.next()
+		if ok {
+			result.Entries = stream.Range(start, MaxStreamID, count, false)
+		}
+		stream.delivered(g, result.Entries)
+		result.Group = GroupStart{ID: g.lastID, EntriesRead: g.entriesRead}
+		if len(result.Entries) > 0 {
+			c.activeTime = t
+		}
+		if noAck {
+			return result, nil
+		}
+		for _, entry := range result.Entries {
+			nack := g.assign(entry.ID, c)
+			nack.deliveryTime, nack.deliveryCount = t, 1
+			result.Delivered = append(result.Delivered, pendingEntry(entry.ID, nack))
+		}
+		return result, nil
+	}
+
+	result.Entries = []StreamEntry{}
+	for i := g.pelSearch(after); i < len(g.pelIDs) && (count < 0 || len(result.Entries) < count); i++ {
+		id := g.pelIDs[i]
+		nack := g.pel[id]
+		if nack.consumer != c || id == after {
+			continue
+		}
+		nack.deliveryTime = t
+		nack.deliveryCount++
+		entry, ok := stream.entry(id)
+		if !ok {
+			result.Entries = append(result.Entries, StreamEntry{ID: id})
+			continue
+		}
+		result.Entries = append(result.Entries, entry)
+		result.Delivered = append(result.Delivered, pendingEntry(id, nack))
+	}
+	return result, nil
+}
+
+// XAck acknowledges entries of a consumer group and returns the number of
+// entries that were pending.
+func (ds *DataStore) XAck(key, group string, ids ...StreamID) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	_, g, err := ds.lookupGroup(key, group)
+	var noGroup *NoGroupError
+	if errors.As(err, &noGroup) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.ack(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		ds.signalModifiedKey(key)
+	}
+	return acked, nil
+}
+
+// PendingSummary is the reply of XPENDING without a range.
+type PendingSummary struct {
+	Count     int
+	Min, Max  StreamID
+	Consumers []ConsumerPending
+}
+
+// ConsumerPending counts the pending entries of a consumer.
+type ConsumerPending struct {
+	Name    string
+	Pending int
+}
+
+// XPendingSummary summarizes the pending entries of a consumer group.
+func (ds *DataStore) XPendingSummary(key, group string) (PendingSummary, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var summary PendingSummary
+	_, g, err := ds.lookupGroup(key, group)
+	if err != nil {
+		return summary, err
+	}
+	summary.Count = len(g.pelIDs)
+	if summary.Count == 0 {
+		return summary, nil
+	}
+	summary.Min, summary.Max = g.pelIDs[0], g.pelIDs[len(g.pelIDs)-1]
+	for _, name := range sortedKeys(g.consumers) {
+		if n := len(g.consumers[name].pending); n > 0 {
+			summary.Consumers = append(summary.Consumers, ConsumerPending{name, n})
+		}
+	}
+	return summary, nil
+}
+
+// XPending returns up to count pending entries of a consumer group with IDs
+// within [start, end], only those of the named consumer if it is not empty,
+// and only those idle for at least minIdle milliseconds.
+func (ds *DataStore) XPending(key, group string, start, end StreamID, count int, consumer string, minIdle int64) ([]PendingEntry, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	_, g, err := ds.lookupGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+	entries := []PendingEntry{}
+	t := Now()
+	for i := g.pelSearch(start); i < len(g.pelIDs) && len(entries) < count; i++ {
+		id := g.pelIDs[i]
+		if end.Less(id) {
+			break
+		}
+		nack := g.pel[id]
+		if (consumer != "" && nack.consumer.name != consumer) || t-nack.deliveryTime < minIdle {
+			continue
+		}
+		entries = append(entries, pendingEntry(id, nack))
+	}
+	return entries, nil
+}
+
+// XClaimOptions holds the options of XCLAIM. Idle, Time and RetryCount are
+// ignored when negative.
+type XClaimOptions struct {
+	Idle       int64
+	Time       int64
+	RetryCount int64
+	Force      bool
+	JustID     bool
+	LastID     *StreamID
+}
+
+// XClaimResult reports the outcome of XClaim and XAutoClaim.
+type XClaimResult struct {
+	// Entries holds the claimed entries, with nil Fields when JustID is set.
+	Entries []StreamEntry
+	// Claimed holds the resulting state of every claimed pending entry.
+	Claimed []PendingEntry
+	// Deleted holds the IDs dropped from the pending entries list because
+	// the entries no longer exist in the stream.
+	Deleted []StreamID
+	// Next is the cursor to continue XAutoClaim from, 0-0 when done.
+	Next StreamID
+	// Group is the resulting position of the group if LastID moved it.
+	Group *GroupStart
+}
+
+// claim transfers a pending entry to consumer c and records it in result. It
+// skips entries that are not pending (unless forced) or not idle enough, and
+// drops pending entries that were deleted from the stream.
+func (s *Stream) claim(g *consumerGroup, c *streamConsumer, id StreamID, minIdle int64, opts XClaimOptions, t int64, result *XClaimResult) {
+	nack, pending := g.pel[id]
+	entry, exists := s.entry(id)
+	if !pending && !(opts.Force && exists) {
+		return
+	}
+	if !exists {
+		g.ack(id)
+		result.Deleted = append(result.Deleted, id)
+		return
+	}
+	if pending && minIdle > 0 && t-nack.deliveryTime < minIdle {
+		return
+	}
+	nack = g.assign(id, c)
+	switch {
+	case opts.Idle >= 0:
+		nack.deliveryTime = t - opts.Idle
+	case opts.Time >= 0:
+		nack.deliveryTime = opts.Time
+	default:
+		nack.deliveryTime = t
+	}
+	switch {
+	case opts.RetryCount >= 0:
+		nack.deliveryCount = opts.RetryCount
+	case !opts.JustID:
+		nack.deliveryCount++
+	}
+	c.activeTime = t
+	if opts.JustID {
+		entry.Fields = nil
+	}
+	result.Entries = append(result.Entries, entry)
+	result.Claimed = append(result.Claimed, pendingEntry(id, nack))
+}
+
+// XClaim transfers pending entries idle for at least minIdle milliseconds to
+// consumer, creating it if needed.
+func (ds *DataStore) XClaim(key, group, consumer string, minIdle int64, ids []StreamID, opts XClaimOptions) (XClaimResult, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	result := XClaimResult{Entries: []StreamEntry{}}
+	stream, g, err := ds.lookupGroup(key, group)
+	if err != nil {
+		return result, err
+	}
+	if opts.LastID != nil && g.lastID.Less(*opts.LastID) {
+		g.lastID = *opts.LastID
+		result.Group = &GroupStart{ID: g.lastID, EntriesRead: g.entriesRead}
+	}
+	c, _ := g.consumer(consumer, true)
+	t := Now()
+	c.seenTime = t
+	for _, id := range ids {
+		stream.claim(g, c, id, minIdle, opts, t, &result)
+	}
+	ds.signalModifiedKey(key)
+	return result, nil
+}
+
+// XAutoClaim scans the pending entries of a group starting at start and
+// transfers up to count of those idle for at least minIdle milliseconds to
+// consumer. It examines at most 10 times count entries, and the returned
+// Next cursor tells where to continue.
+func (ds *DataStore) XAutoClaim(key, group, consumer string, minIdle int64, start StreamID, count int, justID bool) (XClaimResult, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	result := XClaimResult{Entries: []StreamEntry{}, Deleted: []StreamID{}}
+	stream, g, err := ds.lookupGroup(key, group)
+	if err != nil {
+		return result, err
+	}
+	c, _ := g.consumer(consumer, true)
+	t := Now()
+	c.seenTime = t
+	opts := XClaimOptions{Idle: -1, Time: -1, RetryCount: -1, JustID: justID}
+	attempts := 10 * count
+	i := g.pelSearch(start)
+	for ; i < len(g.pelIDs) && attempts > 0 && len(result.Entries) < count; attempts-- {
+		id := g.pelIDs[i]
+		deleted := len(result.Deleted)
+		stream.claim(g, c, id, minIdle, opts, t, &result)
+		if len(result.Deleted) == deleted {
+			// Deleted IDs were removed from pelIDs, shifting the next one
+			// into place.
+			i++
+		}
+	}
+	if i < len(g.pelIDs) {
+		result.Next = g.pelIDs[i]
+	}
+	ds.signalModifiedKey(key)
+	return result, nil
+}
+
+// ConsumerInfo describes a consumer of a group. Idle is the number of
+// milliseconds since SeenTime, and Inactive since ActiveTime (-1 if the
+// consumer never read or claimed an entry).
+type ConsumerInfo struct {
+	Name       string
+	SeenTime   int64
+	ActiveTime int64
+	Idle       int64
+	Inactive   int64
+	Pending    []PendingEntry
+}
+
+// GroupInfo describes a consumer group.
+type GroupInfo struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64
+	// Lag is -1 when it cannot be known.
+	Lag       int64
+	Pending   []PendingEntry
+	Consumers []ConsumerInfo
+}
+
+// groupInfo describes the group, including its pending entries and
+// consumers.
+func (s *Stream) groupInfo(g *consumerGroup) GroupInfo {
+	info := GroupInfo{Name: g.name, LastID: g.lastID, EntriesRead: g.entriesRead, Lag: -1}
+	if lag, ok := s.lag(g); ok {
+		info.Lag = lag
+	}
+	for _, id := range g.pelIDs {
+		info.Pending = append(info.Pending, pendingEntry(id, g.pel[id]))
+	}
+	for _, name := range sortedKeys(g.consumers) {
+		info.Consumers = append(info.Consumers, consumerInfo(g, g.consumers[name]))
+	}
+	return info
+}
+
+func consumerInfo(g *consumerGroup, c *streamConsumer) ConsumerInfo {
+	t := Now()
+	info := ConsumerInfo{Name: c.name, SeenTime: c.seenTime, ActiveTime: c.activeTime, Idle: t - c.seenTime, Inactive: -1}
+	if c.activeTime >= 0 {
+		info.Inactive = t - c.activeTime
+	}
+	for _, id := range g.pelIDs {
+		if nack := g.pel[id]; nack.consumer == c {
+			info.Pending = append(info.Pending, pendingEntry(id, nack))
+		}
+	}
+	return info
+}
+
+// groupsInfo describes every group of the stream, ordered by name.
+func (s *Stream) groupsInfo() []GroupInfo {
+	groups := []GroupInfo{}
+	for _, name := range sortedKeys(s.groups) {
+		groups = append(groups, s.groupInfo(s.groups[name]))
+	}
+	return groups
+}
+
+// XInfoGroups describes the consumer groups of the stream stored at key. It
+// returns ErrNoSuchKey if the key does not exist.
+func (ds *DataStore) XInfoGroups(key string) ([]GroupInfo, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stream, err := ds.lookupStream(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil {
+		return nil, ErrNoSuchKey
+	}
+	return stream.groupsInfo(), nil
+}
+
+// XInfoConsumers describes the consumers of a group, ordered by name.
+func (ds *DataStore) XInfoConsumers(key, group string) ([]ConsumerInfo, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	_, g, err := ds.lookupGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+	consumers := []ConsumerInfo{}
+	for _, name := range sortedKeys(g.consumers) {
+		consumers = append(consumers, consumerInfo(g, g.consumers[name]))
+	}
+	return consumers, nil
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
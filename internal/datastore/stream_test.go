@@ -0,0 +1,198 @@
+package datastore
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestStreamRange tests forward and reverse ranges across chunk boundaries
+func TestStreamRange(t *testing.T) {
+	old := streamNodeMaxEntries.Load()
+	streamNodeMaxEntries.Store(3)
+	defer streamNodeMaxEntries.Store(old)
+
+	s := NewStream()
+	for i := uint64(1); i <= 10; i++ {
+		s.append(StreamEntry{ID: StreamID{i, 0}, Fields: []string{"f", "v"}})
+	}
+	s.Delete(StreamID{4, 0})
+	s.Delete(StreamID{5, 0})
+	s.Delete(StreamID{6, 0})
+	if len(s.chunks) != 3 || s.Len() != 7 {
+		t.Fatalf("Expected the emptied chunk to be dropped, got %d chunks and %d entries", len(s.chunks), s.Len())
+	}
+
+	ids := func(entries []StreamEntry) []uint64 {
+		var out []uint64
+		for _, e := range entries {
+			out = append(out, e.ID.Ms)
+		}
+		return out
+	}
+	tests := []struct {
+		start, end uint64
+		count      int
+		rev        bool
+		expected   []uint64
+	}{
+		{2, 8, -1, false, []uint64{2, 3, 7, 8}},
+		{2, 8, -1, true, []uint64{8, 7, 3, 2}},
+		{5, 6, -1, true, nil},
+		{0, 100, 2, true, []uint64{10, 9}},
+		{4, 100, 2, false, []uint64{7, 8}},
+	}
+	for _, tt := range tests {
+		got := ids(s.Range(StreamID{tt.start, 0}, StreamID{tt.end, 0}, tt.count, tt.rev))
+		if len(got) != len(tt.expected) {
+			t.Errorf("Range(%d, %d, %d, %v): expected %v, got %v", tt.start, tt.end, tt.count, tt.rev, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("Range(%d, %d, %d, %v): expected %v, got %v", tt.start, tt.end, tt.count, tt.rev, tt.expected, got)
+				break
+			}
+		}
+	}
+}
+
+// TestStreamTrim tests exact and approximate trimming
+func TestStreamTrim(t *testing.T) {
+	old := streamNodeMaxEntries.Load()
+	streamNodeMaxEntries.Store(4)
+	defer streamNodeMaxEntries.Store(old)
+
+	s := NewStream()
+	for i := uint64(1); i <= 10; i++ {
+		s.append(StreamEntry{ID: StreamID{i, 0}})
+	}
+	if n := s.Trim(StreamTrim{Strategy: TrimMaxLen, MaxLen: 5, Approx: true}); n != 4 || s.Len() != 6 {
+		t.Errorf("Expected approximate MAXLEN to drop one whole chunk, removed %d", n)
+	}
+	if n := s.Trim(StreamTrim{Strategy: TrimMaxLen, MaxLen: 5}); n != 1 || s.Len() != 5 {
+		t.Errorf("Expected exact MAXLEN to remove 1 entry, removed %d", n)
+	}
+	if n := s.Trim(StreamTrim{Strategy: TrimMinID, MinID: StreamID{8, 0}, Approx: true}); n != 0 {
+		t.Errorf("Expected approximate MINID to keep a partial chunk, removed %d", n)
+	}
+	if n := s.Trim(StreamTrim{Strategy: TrimMinID, MinID: StreamID{8, 0}}); n != 2 {
+		t.Errorf("Expected exact MINID to remove 2 entries, removed %d", n)
+	}
+	if first, _ := s.First(); first.ID != (StreamID{8, 0}) {
+		t.Errorf("Expected 8-0 first, got %s", first.ID)
+	}
+	if exact := s.exactTrim(); exact.Strategy != TrimMinID || exact.MinID != (StreamID{8, 0}) {
+		t.Errorf("Unexpected exact trim %+v", exact)
+	}
+}
+
+// TestXAddIDs tests ID generation and validation in XADD
+func TestXAddIDs(t *testing.T) {
+	ds := GetDataStore()
+	fakeNow.Store(1000)
+	defer fakeNow.Store(0)
+
+	add := func(arg string) (StreamID, error) {
+		x, err := ParseXAddArgs([]string{arg, "f", "v"})
+		if err != nil {
+			return StreamID{}, err
+		}
+		result, err := ds.XAdd("stream-ids", x)
+		return result.ID, err
+	}
+	for _, tt := range []struct {
+		arg      string
+		expected string
+	}{
+		{"*", "1000-0"},
+		{"*", "1000-1"},
+		{"1000-*", "1000-2"},
+		{"2000-*", "2000-0"},
+		{"2000-5", "2000-5"},
+		{"*", "2000-6"},
+	} {
+		id, err := add(tt.arg)
+		if err != nil || id.String() != tt.expected {
+			t.Errorf("XADD %s: expected %s, got %s (%v)", tt.arg, tt.expected, id, err)
+		}
+	}
+	if _, err := add("2000-6"); err != ErrStreamIDTooSmall {
+		t.Errorf("Expected ErrStreamIDTooSmall, got %v", err)
+	}
+	if _, err := add("1999-*"); err != ErrStreamIDTooSmall {
+		t.Errorf("Expected ErrStreamIDTooSmall, got %v", err)
+	}
+	if _, err := add("0-0"); err != ErrStreamIDZero {
+		t.Errorf("Expected ErrStreamIDZero, got %v", err)
+	}
+}
+
+// TestConsumerGroups tests delivery, acknowledgement and claiming of entries
+func TestConsumerGroups(t *testing.T) {
+	ds := GetDataStore()
+	clock := int64(5000)
+	fakeNow.Store(clock)
+	defer fakeNow.Store(0)
+
+	for i := uint64(1); i <= 5; i++ {
+		x := XAddArgs{ID: StreamID{i, 0}, Fields: []string{"n", "v"}}
+		if _, err := ds.XAdd("stream-groups", x); err != nil {
+			t.Fatalf("XAdd failed: %v", err)
+		}
+	}
+	if _, err := ds.XGroupCreate("stream-groups", "g", GroupStart{EntriesRead: -1}, false); err != nil {
+		t.Fatalf("XGroupCreate failed: %v", err)
+	}
+	if _, err := ds.XGroupCreate("stream-groups", "g", GroupStart{Last: true}, false); err != ErrBusyGroup {
+		t.Errorf("Expected ErrBusyGroup, got %v", err)
+	}
+
+	read, err := ds.XReadGroup("stream-groups", "g", "alice", StreamID{}, true, 3, false)
+	if err != nil || len(read.Entries) != 3 || !read.ConsumerCreated || read.Group.EntriesRead != 3 {
+		t.Fatalf("Unexpected first read: %+v, %v", read, err)
+	}
+	read, _ = ds.XReadGroup("stream-groups", "g", "bob", StreamID{}, true, -1, false)
+	if len(read.Entries) != 2 || read.Group.ID != (StreamID{5, 0}) {
+		t.Fatalf("Expected bob to get the remaining entries, got %+v", read)
+	}
+	if n, _ := ds.XAck("stream-groups", "g", StreamID{1, 0}, StreamID{9, 0}); n != 1 {
+		t.Errorf("Expected 1 entry acknowledged, got %d", n)
+	}
+	summary, _ := ds.XPendingSummary("stream-groups", "g")
+	if summary.Count != 4 || summary.Min != (StreamID{2, 0}) || len(summary.Consumers) != 2 {
+		t.Errorf("Unexpected pending summary: %+v", summary)
+	}
+
+	// History reads redeliver the consumer's own pending entries.
+	clock = fakeNow.Add(1000)
+	read, _ = ds.XReadGroup("stream-groups", "g", "alice", StreamID{}, false, -1, false)
+	if len(read.Entries) != 2 || read.Delivered[0].DeliveryCount != 2 {
+		t.Errorf("Unexpected history read: %+v", read)
+	}
+
+	ds.XDel("stream-groups", StreamID{4, 0})
+	clock = fakeNow.Add(1000)
+	claimed, err := ds.XAutoClaim("stream-groups", "g", "carol", 500, StreamID{}, 10, false)
+	if err != nil {
+		t.Fatalf("XAutoClaim failed: %v", err)
+	}
+	if len(claimed.Entries) != 3 || len(claimed.Deleted) != 1 || claimed.Deleted[0] != (StreamID{4, 0}) {
+		t.Errorf("Unexpected autoclaim result: %+v", claimed)
+	}
+	pending, _ := ds.XPending("stream-groups", "g", StreamID{}, MaxStreamID, 10, "carol", 0)
+	if len(pending) != 3 || pending[0].DeliveryCount != 3 || pending[0].Idle != 0 {
+		t.Errorf("Unexpected pending entries for carol: %+v", pending)
+	}
+
+	groups, _ := ds.XInfoGroups("stream-groups")
+	if len(groups) != 1 || groups[0].Lag != 0 || len(groups[0].Consumers) != 3 {
+		t.Errorf("Unexpected group info: %+v", groups)
+	}
+	if n, _ := ds.XGroupDelConsumer("stream-groups", "g", "carol"); n != 3 {
+		t.Errorf("Expected carol to have 3 pending entries, got %d", n)
+	}
+	var noGroup *NoGroupError
+	if _, err := ds.XPendingSummary("stream-groups", "missing"); !errors.As(err, &noGroup) {
+		t.Errorf("Expected NoGroupError, got %v", err)
+	}
+}
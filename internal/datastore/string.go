@@ -0,0 +1,342 @@
+package datastore
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Strings are stored as Go strings, except those holding the canonical
+// decimal form of a 64-bit integer, which are stored as int64 like the int
+// encoding of Redis, so that counters do not allocate on each increment.
+
+// maxStringLength is the largest string APPEND and SETRANGE may produce, as
+// with the default proto-max-bulk-len of Redis.
+const maxStringLength = 512 << 20
+
+// ErrStringTooLong is returned when a string would grow past maxStringLength.
+var ErrStringTooLong = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+
+// parseInteger parses s if it is the canonical decimal form of a 64-bit
+// integer: no sign other than a leading minus, no leading zeros and no
+// surrounding spaces.
+func parseInteger(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// newString returns the value stored for the string s, an int64 if s is an
+// integer.
+func newString(s string) any {
+	if n, ok := parseInteger(s); ok {
+		return n
+	}
+	return s
+}
+
+// asString returns the string held by a stored value, or false if the value
+// is not a string.
+func asString(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	}
+	return "", false
+}
+
+// lookupString returns the string stored at key, or ErrWrongType if the key
+// holds another type. The caller must hold the write lock.
+func (ds *DataStore) lookupString(key string) (string, bool, error) {
+	value, found := ds.lookup(key)
+	if !found {
+		return "", false, nil
+	}
+	str, ok := asString(value)
+	if !ok {
+		return "", false, ErrWrongType
+	}
+	return str, true, nil
+}
+
+// SetArgs holds the options of SET.
+type SetArgs struct {
+	// NX only sets the key if it does not exist, and XX only if it does.
+	NX bool
+	XX bool
+	// Get makes SetWithArgs fail with ErrWrongType instead of overwriting a
+	// key holding another type.
+	Get bool
+	// KeepTTL keeps the expiry of the key. Otherwise the key expires at
+	// ExpireAt, in Unix milliseconds, or never if ExpireAt is 0.
+	KeepTTL  bool
+	ExpireAt int64
+}
+
+// SetResult reports the outcome of SetWithArgs.
+type SetResult struct {
+	// Old is the string the key held before, if Found.
+	Old   string
+	Found bool
+	// Done is false if the NX or XX condition prevented the write.
+	Done bool
+	// Expired is set when the deadline had passed already, so the key was
+	// deleted rather than set.
+	Expired bool
+}
+
+// SetWithArgs sets key to value according to args. A deadline in the past
+// leaves the key deleted.
+func (ds *DataStore) SetWithArgs(key, value string, args SetArgs) (SetResult, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var result SetResult
+	old, exists := ds.lookup(key)
+	if exists {
+		result.Old, result.Found = asString(old)
+		if !result.Found && args.Get {
+			return SetResult{}, ErrWrongType
+		}
+	}
+	if (args.NX && exists) || (args.XX && !exists) {
+		return result, nil
+	}
+	result.Done = true
+	if args.ExpireAt != 0 && deadlinePassed(args.ExpireAt) {
+		ds.deleteKey(key)
+		result.Expired = true
+		return result, nil
+	}
+	ds.data.Set(key, newString(value))
+	if args.ExpireAt != 0 {
+		ds.expires[key] = args.ExpireAt
+	} else if !args.KeepTTL {
+		delete(ds.expires, key)
+	}
+	ds.signalModifiedKey(key)
+	return result, nil
+}
+
+// GetDel returns the string stored at key and deletes the key.
+func (ds *DataStore) GetDel(key string) (string, bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	str, found, err := ds.lookupString(key)
+	if found {
+		ds.deleteKey(key)
+	}
+	return str, found, err
+}
+
+// GetEx returns the string stored at key and, if the key exists, changes its
+// expiry: persist removes it, and otherwise a non-zero at sets the deadline
+// to at, in Unix milliseconds. A deadline in the past deletes the key, which
+// expired reports.
+func (ds *DataStore) GetEx(key string, at int64, persist bool) (str string, found, expired bool, err error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	str, found, err = ds.lookupString(key)
+	if !found {
+		return str, found, false, err
+	}
+	switch {
+	case persist:
+		if _, ok := ds.expires[key]; ok {
+			delete(ds.expires, key)
+			ds.signalModifiedKey(key)
+		}
+	case at != 0 && deadlinePassed(at):
+		ds.deleteKey(key)
+		expired = true
+	case at != 0:
+		ds.expires[key] = at
+		ds.signalModifiedKey(key)
+	}
+	return str, found, expired, nil
+}
+
+// IncrBy adds delta to the integer stored at key, starting from 0 if the key
+// does not exist, and returns the new value. The expiry of the key is kept.
+func (ds *DataStore) IncrBy(key string, delta int64) (int64, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var current int64
+	if value, found := ds.lookup(key); found {
+		switch v := value.(type) {
+		case int64:
+			current = v
+		case string:
+			return 0, errNotInteger
+		default:
+			return 0, ErrWrongType
+		}
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	current += delta
+	ds.data.Set(key, current)
+	ds.signalModifiedKey(key)
+	return current, nil
+}
+
+// IncrByFloat adds delta to the number stored at key, starting from 0 if the
+// key does not exist, and returns the new value formatted the way it is
+// stored. The expiry of the key is kept.
+func (ds *DataStore) IncrByFloat(key string, delta float64) (string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	str, found, err := ds.lookupString(key)
+	if err != nil {
+		return "", err
+	}
+	var current float64
+	if found {
+		current, err = strconv.ParseFloat(str, 64)
+		if err != nil || math.IsNaN(current) || math.IsInf(current, 0) {
+			return "", ErrNotFloat
+		}
+	}
+	current += delta
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return "", ErrNaNOrInfinity
+	}
+	value := strconv.FormatFloat(current, 'f', -1, 64)
+	ds.data.Set(key, newString(value))
+	ds.signalModifiedKey(key)
+	return value, nil
+}
+
+// Append appends value to the string stored at key, creating it if needed,
+// and returns the length of the string afterwards.
+func (ds *DataStore) Append(key, value string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	str, _, err := ds.lookupString(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(str)+len(value) > maxStringLength {
+		return 0, ErrStringTooLong
+	}
+	str += value
+	ds.data.Set(key, newString(str))
+	ds.signalModifiedKey(key)
+	return len(str), nil
+}
+
+// StrLen returns the length of the string stored at key, or 0 if the key
+// does not exist.
+func (ds *DataStore) StrLen(key string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	str, _, err := ds.lookupString(key)
+	return len(str), err
+}
+
+// GetRange returns the substring of the string stored at key between the
+// offsets start and end, both inclusive. Negative offsets count from the end
+// of the string, and out of range offsets are clamped to it.
+func (ds *DataStore) GetRange(key string, start, end int) (string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	str, _, err := ds.lookupString(key)
+	if err != nil {
+		return "", err
+	}
+	if start < 0 && end < 0 && start > end {
+		return "", nil
+	}
+	if start < 0 {
+		start += len(str)
+	}
+	if end < 0 {
+		end += len(str)
+	}
+	start, end = max(start, 0), min(max(end, 0), len(str)-1)
+	if start > end || len(str) == 0 {
+		return "", nil
+	}
+	return str[start : end+1], nil
+}
+
+// SetRange overwrites the string stored at key with value, starting at
+// offset. The string is padded with zero bytes if it is shorter than offset,
+// and created if the key does not exist, unless value is empty. It returns
+// the length of the string afterwards.
+func (ds *DataStore) SetRange(key string, offset int, value string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	str, _, err := ds.lookupString(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) == 0 {
+		return len(str), nil
+	}
+	if offset > maxStringLength-len(value) {
+		return 0, ErrStringTooLong
+	}
+	buf := []byte(str)
+	if n := offset + len(value); n > len(buf) {
+		buf = append(buf, make([]byte, n-len(buf))...)
+	}
+	copy(buf[offset:], value)
+	ds.data.Set(key, newString(string(buf)))
+	ds.signalModifiedKey(key)
+	return len(buf), nil
+}
+
+// MGet returns the strings stored at keys, with nil for the keys that do not
+// exist or do not hold a string.
+func (ds *DataStore) MGet(keys ...string) []*string {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	values := make([]*string, len(keys))
+	for i, key := range keys {
+		if str, found, err := ds.lookupString(key); found && err == nil {
+			values[i] = &str
+		}
+	}
+	return values
+}
+
+// MSet sets the alternating keys and values of pairs atomically, discarding
+// any expiry the keys had.
+func (ds *DataStore) MSet(pairs ...string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.mset(pairs)
+}
+
+// MSetNX sets the alternating keys and values of pairs atomically, unless
+// one of the keys exists. It returns whether the keys were set.
+func (ds *DataStore) MSetNX(pairs ...string) bool {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for i := 0; i < len(pairs); i += 2 {
+		if _, found := ds.lookup(pairs[i]); found {
+			return false
+		}
+	}
+	ds.mset(pairs)
+	return true
+}
+
+// mset sets the alternating keys and values of pairs. The caller must hold
+// the write lock.
+func (ds *DataStore) mset(pairs []string) {
+	for i := 0; i < len(pairs); i += 2 {
+		ds.preserve(pairs[i])
+		ds.data.Set(pairs[i], newString(pairs[i+1]))
+		delete(ds.expires, pairs[i])
+		ds.signalModifiedKey(pairs[i])
+	}
+}
@@ -0,0 +1,63 @@
+package datastore
+
+// WATCH is implemented with per-key versions: every write bumps the version
+// of the key it modifies, and EXEC compares the versions recorded by WATCH
+// with the current ones. Only watched keys are tracked, so keys nobody
+// watches cost nothing.
+
+// watchedKey holds the number of clients watching a key and its version.
+type watchedKey struct {
+	clients int
+	version uint64
+}
+
+// WatchKey records one more client watching key and returns the current
+// version of the key. A key whose deadline has passed is deleted first, so
+// its expiry does not count as a later modification.
+func (ds *DataStore) WatchKey(key string) uint64 {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.expireIfNeeded(key)
+	w, found := ds.watched[key]
+	if !found {
+		w = &watchedKey{}
+		ds.watched[key] = w
+	}
+	w.clients++
+	return w.version
+}
+
+// UnwatchKey records that a client stopped watching key.
+func (ds *DataStore) UnwatchKey(key string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	w, found := ds.watched[key]
+	if !found {
+		return
+	}
+	if w.clients--; w.clients == 0 {
+		delete(ds.watched, key)
+	}
+}
+
+// KeyVersion returns the current version of a watched key. A key whose
+// deadline has passed is deleted first, which counts as a modification.
+func (ds *DataStore) KeyVersion(key string) uint64 {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.expireIfNeeded(key)
+	if w, found := ds.watched[key]; found {
+		return w.version
+	}
+	return 0
+}
+
+// signalModifiedKey is called after every change to key, including its
+// deletion. It bumps the version of key if it is watched, and updates the
+// memory accounted to it. The caller must hold the write lock.
+func (ds *DataStore) signalModifiedKey(key string) {
+	if w, found := ds.watched[key]; found {
+		w.version++
+	}
+	ds.updateMemory(key)
+}
@@ -0,0 +1,709 @@
+package datastore
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/manimovassagh/Godis/internal/glob"
+)
+
+// ErrScoreNaN is returned when an increment makes a score NaN.
+var ErrScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+
+// ZMember is a sorted set member together with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZSet is a sorted set: a dict from member to score for O(1) lookups plus a
+// skiplist ordered by (score, member) for range and rank queries.
+type ZSet struct {
+	dict *dict[float64]
+	zsl  *skiplist
+}
+
+// NewZSet returns an empty ZSet.
+func NewZSet() *ZSet {
+	return &ZSet{
+		dict: newDict[float64](),
+		zsl:  newSkiplist(),
+	}
+}
+
+// Len returns the number of members in the sorted set.
+func (z *ZSet) Len() int {
+	return z.dict.Len()
+}
+
+// clone returns a copy of the sorted set.
+func (z *ZSet) clone() *ZSet {
+	c := NewZSet()
+	for member, score := range z.dict.All() {
+		c.Add(member, score)
+	}
+	return c
+}
+
+// Score returns the score of member.
+func (z *ZSet) Score(member string) (float64, bool) {
+	return z.dict.Get(member)
+}
+
+// Add sets the score of member, inserting it if needed. It returns true if
+// the member is new.
+func (z *ZSet) Add(member string, score float64) bool {
+	current, found := z.dict.Get(member)
+	if found {
+		if current != score {
+			z.zsl.delete(current, member)
+			z.zsl.insert(score, member)
+			z.dict.Set(member, score)
+		}
+		return false
+	}
+	z.zsl.insert(score, member)
+	z.dict.Set(member, score)
+	return true
+}
+
+// Remove deletes member. It returns false if it was not present.
+func (z *ZSet) Remove(member string) bool {
+	score, found := z.dict.Delete(member)
+	if !found {
+		return false
+	}
+	z.zsl.delete(score, member)
+	return true
+}
+
+// Rank returns the 0-based rank of member, counted from the highest score
+// when rev is true.
+func (z *ZSet) Rank(member string, rev bool) (int, bool) {
+	score, found := z.dict.Get(member)
+	if !found {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member) - 1
+	if rev {
+		rank = z.Len() - 1 - rank
+	}
+	return rank, true
+}
+
+// Members returns every member in ascending order.
+func (z *ZSet) Members() []ZMember {
+	members := make([]ZMember, 0, z.Len())
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		members = append(members, ZMember{x.member, x.score})
+	}
+	return members
+}
+
+// ScoreRange is an interval of scores whose bounds may be exclusive.
+type ScoreRange struct {
+	Min, Max     float64
+	MinEx, MaxEx bool
+}
+
+func (r ScoreRange) aboveMin(score float64) bool {
+	if r.MinEx {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r ScoreRange) belowMax(score float64) bool {
+	if r.MaxEx {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+// lexBound is one end of a LexRange. inf is -1 for "-", +1 for "+" and 0 for
+// a regular value.
+type lexBound struct {
+	value     string
+	inf       int
+	exclusive bool
+}
+
+// LexRange is an interval of members, for sorted sets whose members all share
+// the same score.
+type LexRange struct {
+	Min, Max lexBound
+}
+
+func (r LexRange) aboveMin(member string) bool {
+	switch {
+	case r.Min.inf < 0:
+		return true
+	case r.Min.inf > 0:
+		return false
+	case r.Min.exclusive:
+		return member > r.Min.value
+	}
+	return member >= r.Min.value
+}
+
+func (r LexRange) belowMax(member string) bool {
+	switch {
+	case r.Max.inf > 0:
+		return true
+	case r.Max.inf < 0:
+		return false
+	case r.Max.exclusive:
+		return member < r.Max.value
+	}
+	return member <= r.Max.value
+}
+
+// ZRangeBy selects how a ZRangeSpec interprets its bounds.
+type ZRangeBy int
+
+const (
+	// ZRangeByRank selects members by index.
+	ZRangeByRank ZRangeBy = iota
+	// ZRangeByScore selects members by score.
+	ZRangeByScore
+	// ZRangeByLex selects members lexicographically.
+	ZRangeByLex
+)
+
+// ZRangeSpec describes a range query over a sorted set, as expressed by the
+// unified ZRANGE syntax.
+type ZRangeSpec struct {
+	By          ZRangeBy
+	Start, Stop int
+	Score       ScoreRange
+	Lex         LexRange
+	Rev         bool
+	Offset      int
+	Count       int // negative means no limit
+}
+
+// aboveMin and belowMax report whether node lies past the lower, respectively
+// before the upper, bound of a score or lex range.
+func (spec ZRangeSpec) aboveMin(x *skiplistNode) bool {
+	if spec.By == ZRangeByLex {
+		return spec.Lex.aboveMin(x.member)
+	}
+	return spec.Score.aboveMin(x.score)
+}
+
+func (spec ZRangeSpec) belowMax(x *skiplistNode) bool {
+	if spec.By == ZRangeByLex {
+		return spec.Lex.belowMax(x.member)
+	}
+	return spec.Score.belowMax(x.score)
+}
+
+// Range returns the members selected by spec, in ascending order or in
+// descending order when spec.Rev is set.
+func (z *ZSet) Range(spec ZRangeSpec) []ZMember {
+	result := []ZMember{}
+	next := func(x *skiplistNode) *skiplistNode {
+		if spec.Rev {
+			return x.backward
+		}
+		return x.level[0].forward
+	}
+
+	if spec.By == ZRangeByRank {
+		start, stop, ok := normalizeRange(spec.Start, spec.Stop, z.Len())
+		if !ok {
+			return result
+		}
+		rank := start + 1
+		if spec.Rev {
+			rank = z.Len() - start
+		}
+		x := z.zsl.byRank(rank)
+		for i := start; i <= stop && x != nil; i++ {
+			result = append(result, ZMember{x.member, x.score})
+			x = next(x)
+		}
+		return result
+	}
+
+	// Start at the first member past the bound we walk away from, then stop at
+	// the first member outside the opposite bound.
+	var x *skiplistNode
+	inRange := spec.belowMax
+	if spec.Rev {
+		x = z.zsl.lastMatching(spec.belowMax)
+		inRange = spec.aboveMin
+	} else {
+		x = z.zsl.firstMatching(spec.aboveMin)
+	}
+	for i := 0; x != nil && i < spec.Offset && inRange(x); i++ {
+		x = next(x)
+	}
+	for x != nil && inRange(x) && (spec.Count < 0 || len(result) < spec.Count) {
+		result = append(result, ZMember{x.member, x.score})
+		x = next(x)
+	}
+	return result
+}
+
+// Count returns the number of members within the score or lex range of spec.
+func (z *ZSet) Count(spec ZRangeSpec) int {
+	first := z.zsl.firstMatching(spec.aboveMin)
+	if first == nil || !spec.belowMax(first) {
+		return 0
+	}
+	last := z.zsl.lastMatching(spec.belowMax)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// lookupZSet returns the sorted set stored at key. If the key does not exist
+// it returns nil, or a new sorted set stored at key when create is true. The
+// caller must hold the write lock.
+func (ds *DataStore) lookupZSet(key string, create bool) (*ZSet, error) {
+	value, found := ds.lookup(key)
+	if !found {
+		if !create {
+			return nil, nil
+		}
+		zset := NewZSet()
+		ds.data.Set(key, zset)
+		return zset, nil
+	}
+	zset, ok := value.(*ZSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return zset, nil
+}
+
+// deleteIfEmptyZSet removes the key once its sorted set has no members left.
+// The caller must hold the write lock.
+func (ds *DataStore) deleteIfEmptyZSet(key string, zset *ZSet) {
+	if zset.Len() == 0 {
+		ds.deleteKey(key)
+	}
+}
+
+// ZAddOptions holds the flags of ZADD.
+type ZAddOptions struct {
+	NX, XX, GT, LT bool
+	Incr           bool
+}
+
+// ZAddResult reports the outcome of ZAdd.
+type ZAddResult struct {
+	Added   int
+	Updated int
+	// Score is the final score of the last member that passed the NX, XX, GT
+	// and LT conditions, and Applied is false if none did. It is the reply of
+	// ZADD INCR.
+	Score   float64
+	Applied bool
+	// Changed holds the final score of every member that was added or updated.
+	Changed []ZMember
+}
+
+// ZAdd adds or updates the given members of the sorted set stored at key
+// according to opts. With opts.Incr, each score is added to the current score
+// instead of replacing it.
+func (ds *DataStore) ZAdd(key string, opts ZAddOptions, members ...ZMember) (ZAddResult, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var result ZAddResult
+	zset, err := ds.lookupZSet(key, !opts.XX)
+	if zset == nil {
+		return result, err
+	}
+	for _, m := range members {
+		current, exists := zset.Score(m.Member)
+		if (opts.NX && exists) || (opts.XX && !exists) {
+			continue
+		}
+		score := m.Score
+		if opts.Incr && exists {
+			score += current
+			if math.IsNaN(score) {
+				ds.deleteIfEmptyZSet(key, zset)
+				return result, ErrScoreNaN
+			}
+		}
+		if exists && ((opts.GT && score <= current) || (opts.LT && score >= current)) {
+			continue
+		}
+		result.Score, result.Applied = score, true
+		if exists {
+			if score == current {
+				continue
+			}
+			result.Updated++
+		} else {
+			result.Added++
+		}
+		zset.Add(m.Member, score)
+		result.Changed = append(result.Changed, ZMember{m.Member, score})
+	}
+	if len(result.Changed) > 0 {
+		ds.signalModifiedKey(key)
+	}
+	if result.Added > 0 {
+		ds.signalKeyAsReady(key)
+	}
+	ds.deleteIfEmptyZSet(key, zset)
+	return result, nil
+}
+
+// ZRem removes members from the sorted set stored at key and returns the
+// number of members that were removed.
+func (ds *DataStore) ZRem(key string, members ...string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if zset.Remove(member) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		ds.signalModifiedKey(key)
+	}
+	ds.deleteIfEmptyZSet(key, zset)
+	return removed, nil
+}
+
+// ZScore returns the scores of members in the sorted set stored at key. A nil
+// entry means the member does not exist.
+func (ds *DataStore) ZScore(key string, members ...string) ([]*float64, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	scores := make([]*float64, len(members))
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return scores, err
+	}
+	for i, member := range members {
+		if score, found := zset.Score(member); found {
+			scores[i] = &score
+		}
+	}
+	return scores, nil
+}
+
+// ZCard returns the number of members of the sorted set stored at key.
+func (ds *DataStore) ZCard(key string) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return 0, err
+	}
+	return zset.Len(), nil
+}
+
+// ZRank returns the 0-based rank and the score of member in the sorted set
+// stored at key, counted from the highest score when rev is true.
+func (ds *DataStore) ZRank(key, member string, rev bool) (int, float64, bool, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return 0, 0, false, err
+	}
+	rank, found := zset.Rank(member, rev)
+	score, _ := zset.Score(member)
+	return rank, score, found, nil
+}
+
+// ZRange returns the members of the sorted set stored at key selected by spec.
+func (ds *DataStore) ZRange(key string, spec ZRangeSpec) ([]ZMember, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return []ZMember{}, err
+	}
+	return zset.Range(spec), nil
+}
+
+// ZRangeStore stores the members of the sorted set at source selected by spec
+// into destination, replacing whatever it held, and returns how many members
+// were stored.
+func (ds *DataStore) ZRangeStore(destination, source string, spec ZRangeSpec) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(source, false)
+	if err != nil {
+		return 0, err
+	}
+	var members []ZMember
+	if zset != nil {
+		members = zset.Range(spec)
+	}
+	ds.storeZSet(destination, members)
+	return len(members), nil
+}
+
+// storeZSet replaces destination with a sorted set holding members, or
+// deletes it if members is empty. The caller must hold the write lock.
+func (ds *DataStore) storeZSet(destination string, members []ZMember) {
+	ds.deleteKey(destination)
+	if len(members) == 0 {
+		return
+	}
+	zset := NewZSet()
+	for _, m := range members {
+		zset.Add(m.Member, m.Score)
+	}
+	ds.data.Set(destination, zset)
+	ds.signalKeyAsReady(destination)
+}
+
+// ZCount returns the number of members of the sorted set stored at key within
+// the score or lex range of spec.
+func (ds *DataStore) ZCount(key string, spec ZRangeSpec) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return 0, err
+	}
+	return zset.Count(spec), nil
+}
+
+// ZRemRange removes the members of the sorted set stored at key selected by
+// spec and returns them.
+func (ds *DataStore) ZRemRange(key string, spec ZRangeSpec) ([]string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return []string{}, err
+	}
+	members := zset.Range(spec)
+	removed := make([]string, len(members))
+	for i, m := range members {
+		zset.Remove(m.Member)
+		removed[i] = m.Member
+	}
+	if len(removed) > 0 {
+		ds.signalModifiedKey(key)
+	}
+	ds.deleteIfEmptyZSet(key, zset)
+	return removed, nil
+}
+
+// ZPop removes and returns up to count members with the lowest scores from
+// the sorted set stored at key, or with the highest scores when highest is
+// true.
+func (ds *DataStore) ZPop(key string, count int, highest bool) ([]ZMember, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return []ZMember{}, err
+	}
+	members := zset.Range(ZRangeSpec{By: ZRangeByRank, Start: 0, Stop: count - 1, Rev: highest})
+	for _, m := range members {
+		zset.Remove(m.Member)
+	}
+	if len(members) > 0 {
+		ds.signalModifiedKey(key)
+	}
+	ds.deleteIfEmptyZSet(key, zset)
+	return members, nil
+}
+
+// ZRandMember returns random members of the sorted set stored at key. A
+// non-negative count returns up to count distinct members, while a negative
+// count returns exactly -count members that may repeat.
+func (ds *DataStore) ZRandMember(key string, count int) ([]ZMember, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return []ZMember{}, err
+	}
+	members := zset.Members()
+	if count < 0 {
+		result := make([]ZMember, -count)
+		for i := range result {
+			result[i] = members[rand.IntN(len(members))]
+		}
+		return result, nil
+	}
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	return members[:min(count, len(members))], nil
+}
+
+// ZScan visits about count members of the sorted set stored at key,
+// starting at cursor, and returns those that match pattern (an empty pattern
+// matches everything), along with the cursor to continue from, which is 0
+// once the iteration is complete. See dict.Scan for the guarantees.
+func (ds *DataStore) ZScan(key string, cursor uint64, count int, pattern string) (uint64, []ZMember, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	zset, err := ds.lookupZSet(key, false)
+	if zset == nil {
+		return 0, []ZMember{}, err
+	}
+	matched := []ZMember{}
+	cursor = zset.dict.scanCount(cursor, count, func(member string, score float64) {
+		if pattern == "" || glob.Match(pattern, member) {
+			matched = append(matched, ZMember{member, score})
+		}
+	})
+	return cursor, matched, nil
+}
+
+// Aggregate selects how ZUNION and ZINTER combine the scores of a member
+// present in several inputs.
+type Aggregate int
+
+const (
+	// AggregateSum adds the scores.
+	AggregateSum Aggregate = iota
+	// AggregateMin keeps the lowest score.
+	AggregateMin
+	// AggregateMax keeps the highest score.
+	AggregateMax
+)
+
+func (agg Aggregate) apply(a, b float64) float64 {
+	switch agg {
+	case AggregateMin:
+		return math.Min(a, b)
+	case AggregateMax:
+		return math.Max(a, b)
+	}
+	sum := a + b
+	if math.IsNaN(sum) {
+		// inf + -inf is defined as 0, as in Redis.
+		return 0
+	}
+	return sum
+}
+
+// ZCombineSpec describes a ZUNION, ZINTER or ZDIFF operation.
+type ZCombineSpec struct {
+	Op         SetOp
+	Keys       []string
+	Weights    []float64
+	Aggregate  Aggregate
+	WithScores bool
+}
+
+// zcombine computes spec over the sorted sets, or plain sets whose members
+// count with a score of 1, stored at spec.Keys. The caller must hold the
+// write lock.
+func (ds *DataStore) zcombine(spec ZCombineSpec) ([]ZMember, error) {
+	inputs := make([]map[string]float64, len(spec.Keys))
+	for i, key := range spec.Keys {
+		value, found := ds.lookup(key)
+		if !found {
+			continue
+		}
+		switch v := value.(type) {
+		case *ZSet:
+			inputs[i] = maps.Collect(v.dict.All())
+		case *Set:
+			inputs[i] = make(map[string]float64, v.Len())
+			for _, member := range v.Members() {
+				inputs[i][member] = 1
+			}
+		default:
+			return nil, ErrWrongType
+		}
+	}
+	weight := func(i int) float64 {
+		if spec.Weights == nil {
+			return 1
+		}
+		return spec.Weights[i]
+	}
+	weighted := func(score float64, i int) float64 {
+		score *= weight(i)
+		if math.IsNaN(score) {
+			return 0
+		}
+		return score
+	}
+
+	scores := make(map[string]float64)
+	switch spec.Op {
+	case SetUnion:
+		for i, input := range inputs {
+			for member, score := range input {
+				score = weighted(score, i)
+				if current, found := scores[member]; found {
+					score = spec.Aggregate.apply(current, score)
+				}
+				scores[member] = score
+			}
+		}
+	case SetInter:
+		if len(inputs) > 0 && inputs[0] != nil {
+		next:
+			for member, score := range inputs[0] {
+				score = weighted(score, 0)
+				for i, input := range inputs[1:] {
+					other, found := input[member]
+					if !found {
+						continue next
+					}
+					score = spec.Aggregate.apply(score, weighted(other, i+1))
+				}
+				scores[member] = score
+			}
+		}
+	case SetDiff:
+		if len(inputs) > 0 && inputs[0] != nil {
+		outer:
+			for member, score := range inputs[0] {
+				for _, input := range inputs[1:] {
+					if _, found := input[member]; found {
+						continue outer
+					}
+				}
+				scores[member] = score
+			}
+		}
+	}
+
+	result := make([]ZMember, 0, len(scores))
+	for member, score := range scores {
+		result = append(result, ZMember{member, score})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score < result[j].Score
+		}
+		return result[i].Member < result[j].Member
+	})
+	return result, nil
+}
+
+// ZCombine returns the result of spec.
+func (ds *DataStore) ZCombine(spec ZCombineSpec) ([]ZMember, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.zcombine(spec)
+}
+
+// ZCombineStore stores the result of spec in destination, replacing whatever
+// it held, and returns its cardinality.
+func (ds *DataStore) ZCombineStore(destination string, spec ZCombineSpec) (int, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	members, err := ds.zcombine(spec)
+	if err != nil {
+		return 0, err
+	}
+	ds.storeZSet(destination, members)
+	return len(members), nil
+}
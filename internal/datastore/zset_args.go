@@ -0,0 +1,219 @@
+package datastore
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNotFloat is returned when a score argument is not a valid float.
+	ErrNotFloat = errors.New("ERR value is not a valid float")
+	// ErrSyntax is returned for malformed command options.
+	ErrSyntax = errors.New("ERR syntax error")
+
+	errMinMaxNotFloat  = errors.New("ERR min or max is not a float")
+	errMinMaxNotString = errors.New("ERR min or max not valid string range item")
+	errNotInteger      = errors.New("ERR value is not an integer or out of range")
+)
+
+// The sorted set commands share a fair amount of argument syntax, and their
+// store variants are replayed from the AOF as-is, so the parsers live next to
+// the data type where both the command handlers and the AOF loader can reach
+// them.
+
+// ParseScore parses a sorted set score. It accepts "inf", "+inf" and "-inf"
+// but rejects NaN.
+func ParseScore(arg string) (float64, error) {
+	score, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		var numErr *strconv.NumError
+		// Out of range values parse to ±Inf, which Redis accepts as well.
+		if !errors.As(err, &numErr) || numErr.Err != strconv.ErrRange {
+			return 0, ErrNotFloat
+		}
+	}
+	if math.IsNaN(score) {
+		return 0, ErrNotFloat
+	}
+	return score, nil
+}
+
+// FormatScore formats a score the way Redis replies with it.
+func FormatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// ParseScoreRange parses the min and max arguments of ZRANGEBYSCORE, where a
+// leading '(' makes a bound exclusive.
+func ParseScoreRange(min, max string) (ScoreRange, error) {
+	var r ScoreRange
+	var err1, err2 error
+	r.Min, r.MinEx, err1 = parseScoreBound(min)
+	r.Max, r.MaxEx, err2 = parseScoreBound(max)
+	if err1 != nil || err2 != nil {
+		return r, errMinMaxNotFloat
+	}
+	return r, nil
+}
+
+func parseScoreBound(arg string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, err := ParseScore(arg)
+	return score, exclusive, err
+}
+
+// ParseLexRange parses the min and max arguments of ZRANGEBYLEX: "-" and "+"
+// stand for the infinities, otherwise a bound must start with '[' (inclusive)
+// or '(' (exclusive).
+func ParseLexRange(min, max string) (LexRange, error) {
+	var r LexRange
+	var ok1, ok2 bool
+	r.Min, ok1 = parseLexBound(min)
+	r.Max, ok2 = parseLexBound(max)
+	if !ok1 || !ok2 {
+		return r, errMinMaxNotString
+	}
+	return r, nil
+}
+
+func parseLexBound(arg string) (lexBound, bool) {
+	switch {
+	case arg == "-":
+		return lexBound{inf: -1}, true
+	case arg == "+":
+		return lexBound{inf: 1}, true
+	case strings.HasPrefix(arg, "["):
+		return lexBound{value: arg[1:]}, true
+	case strings.HasPrefix(arg, "("):
+		return lexBound{value: arg[1:], exclusive: true}, true
+	}
+	return lexBound{}, false
+}
+
+// ParseZRangeArgs parses the arguments of the unified ZRANGE syntax that
+// follow the key: start, stop and the BYSCORE, BYLEX, REV, LIMIT and
+// WITHSCORES options. It also reports whether WITHSCORES was given.
+func ParseZRangeArgs(args []string) (ZRangeSpec, bool, error) {
+	spec := ZRangeSpec{Count: -1}
+	withScores, limit := false, false
+	if len(args) < 2 {
+		return spec, false, ErrSyntax
+	}
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "BYSCORE":
+			spec.By = ZRangeByScore
+		case opt == "BYLEX":
+			spec.By = ZRangeByLex
+		case opt == "REV":
+			spec.Rev = true
+		case opt == "WITHSCORES":
+			withScores = true
+		case opt == "LIMIT" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(args[i+1])
+			count, err2 := strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return spec, false, errNotInteger
+			}
+			spec.Offset, spec.Count, limit = offset, count, true
+			i += 2
+		default:
+			return spec, false, ErrSyntax
+		}
+	}
+	if limit && spec.By == ZRangeByRank {
+		return spec, false, errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && spec.By == ZRangeByLex {
+		return spec, false, errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	if spec.Offset < 0 {
+		// A negative offset returns an empty range, as in Redis.
+		spec.Count = 0
+	}
+
+	// With REV, BYSCORE and BYLEX take the bounds as max followed by min.
+	start, stop := args[0], args[1]
+	if spec.Rev && spec.By != ZRangeByRank {
+		start, stop = stop, start
+	}
+	var err error
+	switch spec.By {
+	case ZRangeByRank:
+		var err1, err2 error
+		spec.Start, err1 = strconv.Atoi(start)
+		spec.Stop, err2 = strconv.Atoi(stop)
+		if err1 != nil || err2 != nil {
+			err = errNotInteger
+		}
+	case ZRangeByScore:
+		spec.Score, err = ParseScoreRange(start, stop)
+	case ZRangeByLex:
+		spec.Lex, err = ParseLexRange(start, stop)
+	}
+	return spec, withScores, err
+}
+
+// ParseZCombineArgs parses the arguments of ZUNION, ZINTER and ZDIFF (and
+// their store variants) starting at numkeys: the keys followed by the
+// WEIGHTS, AGGREGATE and, if allowWithScores is set, WITHSCORES options.
+// ZDIFF accepts neither WEIGHTS nor AGGREGATE.
+func ParseZCombineArgs(op SetOp, name string, args []string, allowWithScores bool) (ZCombineSpec, error) {
+	spec := ZCombineSpec{Op: op}
+	if len(args) == 0 {
+		return spec, ErrSyntax
+	}
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return spec, errNotInteger
+	}
+	if numKeys <= 0 {
+		return spec, errors.New("ERR at least 1 input key is needed for '" + strings.ToLower(name) + "' command")
+	}
+	if numKeys > len(args)-1 {
+		return spec, ErrSyntax
+	}
+	spec.Keys = args[1 : 1+numKeys]
+	for i := 1 + numKeys; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "WEIGHTS" && op != SetDiff && i+numKeys < len(args):
+			spec.Weights = make([]float64, numKeys)
+			for j := range spec.Weights {
+				weight, err := ParseScore(args[i+1+j])
+				if err != nil {
+					return spec, errors.New("ERR weight value is not a float")
+				}
+				spec.Weights[j] = weight
+			}
+			i += numKeys
+		case opt == "AGGREGATE" && op != SetDiff && i+1 < len(args):
+			switch strings.ToUpper(args[i+1]) {
+			case "SUM":
+				spec.Aggregate = AggregateSum
+			case "MIN":
+				spec.Aggregate = AggregateMin
+			case "MAX":
+				spec.Aggregate = AggregateMax
+			default:
+				return spec, ErrSyntax
+			}
+			i++
+		case opt == "WITHSCORES" && allowWithScores:
+			spec.WithScores = true
+		default:
+			return spec, ErrSyntax
+		}
+	}
+	return spec, nil
+}
@@ -0,0 +1,75 @@
+package datastore
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestZSetRanks tests that the skiplist keeps members ordered and ranks consistent
+func TestZSetRanks(t *testing.T) {
+	z := NewZSet()
+	for i := 0; i < 1000; i++ {
+		z.Add(fmt.Sprintf("m%04d", i), float64(i%10))
+	}
+	z.Add("m0005", -1)
+	for i := 0; i < 1000; i += 7 {
+		z.Remove(fmt.Sprintf("m%04d", i))
+	}
+	members := z.Members()
+	if len(members) != z.Len() {
+		t.Fatalf("Expected %d members, got %d", z.Len(), len(members))
+	}
+	for i, m := range members {
+		if i > 0 {
+			prev := members[i-1]
+			if prev.Score > m.Score || (prev.Score == m.Score && prev.Member >= m.Member) {
+				t.Fatalf("Members out of order at %d: %v before %v", i, prev, m)
+			}
+		}
+		if rank, ok := z.Rank(m.Member, false); !ok || rank != i {
+			t.Fatalf("Expected rank %d for %s, got %d", i, m.Member, rank)
+		}
+		if rank, _ := z.Rank(m.Member, true); rank != len(members)-1-i {
+			t.Fatalf("Expected reverse rank %d for %s, got %d", len(members)-1-i, m.Member, rank)
+		}
+	}
+	if members[0].Member != "m0005" {
+		t.Errorf("Expected updated member first, got %s", members[0].Member)
+	}
+}
+
+// TestZSetRange tests ranges by rank, score and lex
+func TestZSetRange(t *testing.T) {
+	z := NewZSet()
+	for i, member := range []string{"a", "b", "c", "d", "e"} {
+		z.Add(member, float64(i))
+	}
+	names := func(members []ZMember) string {
+		var out []string
+		for _, m := range members {
+			out = append(out, m.Member)
+		}
+		return fmt.Sprint(out)
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"0", "-1"}, "[a b c d e]"},
+		{[]string{"-2", "-1", "REV"}, "[b a]"},
+		{[]string{"(1", "3", "BYSCORE"}, "[c d]"},
+		{[]string{"+inf", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2"}, "[d c]"},
+		{[]string{"[b", "(d", "BYLEX"}, "[b c]"},
+		{[]string{"+", "-", "BYLEX", "REV", "LIMIT", "0", "1"}, "[e]"},
+	}
+	for _, tt := range tests {
+		spec, _, err := ParseZRangeArgs(tt.args)
+		if err != nil {
+			t.Fatalf("Unexpected error for %v: %v", tt.args, err)
+		}
+		if got := names(z.Range(spec)); got != tt.expected {
+			t.Errorf("Range %v: expected %s, got %s", tt.args, tt.expected, got)
+		}
+	}
+}
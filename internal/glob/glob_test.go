@@ -0,0 +1,36 @@
+package glob
+
+import "testing"
+
+// TestMatch tests the supported glob syntax
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		s        string
+		expected bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:email", false},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	}
+	for _, test := range tests {
+		if got := Match(test.pattern, test.s); got != test.expected {
+			t.Errorf("Match(%q, %q) = %v, expected %v", test.pattern, test.s, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package lua
+
+// The parser produces a tree of statements and expressions in which every
+// variable is already resolved to a local slot of its function, an upvalue
+// captured from an enclosing function, or a global.
+
+type expr interface{}
+
+type (
+	nilExpr    struct{}
+	trueExpr   struct{}
+	falseExpr  struct{}
+	varargExpr struct{}
+	numberExpr struct{ value float64 }
+	stringExpr struct{ value string }
+
+	// localExpr reads a local variable of the running function.
+	localExpr struct {
+		name string
+		slot int
+	}
+	// upvalExpr reads a variable captured from an enclosing function.
+	upvalExpr struct {
+		name  string
+		index int
+	}
+	globalExpr struct{ name string }
+
+	indexExpr struct {
+		obj, key expr
+		line     int
+	}
+	callExpr struct {
+		fn   expr
+		args []expr
+		line int
+	}
+	methodCallExpr struct {
+		obj  expr
+		name string
+		args []expr
+		line int
+	}
+	// parenExpr truncates a multi-valued expression to its first value.
+	parenExpr struct{ inner expr }
+	funcExpr  struct{ proto *funcProto }
+
+	binaryExpr struct {
+		op          tokenKind
+		left, right expr
+		line        int
+	}
+	unaryExpr struct {
+		op      tokenKind
+		operand expr
+		line    int
+	}
+	tableExpr struct {
+		items []tableItem
+		line  int
+	}
+)
+
+// tableItem is a field of a table constructor. Positional items have a nil
+// key.
+type tableItem struct {
+	key, value expr
+}
+
+type stmt interface{}
+
+type block []stmt
+
+type (
+	localStmt struct {
+		slots []int
+		exprs []expr
+		line  int
+	}
+	localFuncStmt struct {
+		slot  int
+		proto *funcProto
+	}
+	assignStmt struct {
+		targets []expr
+		exprs   []expr
+		line    int
+	}
+	callStmt  struct{ call expr }
+	doStmt    struct{ body block }
+	whileStmt struct {
+		cond expr
+		body block
+	}
+	repeatStmt struct {
+		body block
+		cond expr
+	}
+	ifStmt struct {
+		conds  []expr
+		blocks []block
+		orElse block
+	}
+	numForStmt struct {
+		slot               int
+		start, limit, step expr
+		body               block
+		line               int
+	}
+	genForStmt struct {
+		slots []int
+		exprs []expr
+		body  block
+		line  int
+	}
+	returnStmt struct {
+		exprs []expr
+		line  int
+	}
+	breakStmt struct{}
+)
+
+// funcProto is a compiled function.
+type funcProto struct {
+	name     string
+	chunk    string
+	line     int
+	params   []int
+	isVararg bool
+	numSlots int
+	upvals   []upvalDesc
+	body     block
+}
+
+// upvalDesc tells where a closure finds an upvalue when it is created: in a
+// local slot of the enclosing function, or among its upvalues.
+type upvalDesc struct {
+	fromLocal bool
+	index     int
+}
@@ -0,0 +1,281 @@
+package lua
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// register adds functions to a library table, in name order so that
+// traversing the table is deterministic.
+func register(t *Table, funcs map[string]GoFunction) {
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		t.SetString(name, NewFunction(name, funcs[name]))
+	}
+}
+
+// arg returns the argument at index i, or nil if it is missing.
+func arg(args []Value, i int) Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// argError raises an error about the argument at index i of function fname.
+func (s *State) argError(i int, fname, msg string) {
+	s.Raise("bad argument #%d to '%s' (%s)", i+1, fname, msg)
+}
+
+// typeError raises an error about an argument of the wrong type.
+func (s *State) typeError(args []Value, i int, fname, expected string) {
+	got := "no value"
+	if i < len(args) {
+		got = TypeName(args[i])
+	}
+	s.argError(i, fname, expected+" expected, got "+got)
+}
+
+func (s *State) checkAny(args []Value, i int, fname string) Value {
+	if i >= len(args) {
+		s.argError(i, fname, "value expected")
+	}
+	return args[i]
+}
+
+func (s *State) checkNumber(args []Value, i int, fname string) float64 {
+	n, ok := ToNumber(arg(args, i))
+	if !ok {
+		s.typeError(args, i, fname, "number")
+	}
+	return n
+}
+
+func (s *State) checkInt(args []Value, i int, fname string) int {
+	return int(s.checkNumber(args, i, fname))
+}
+
+func (s *State) optInt(args []Value, i int, fname string, def int) int {
+	if arg(args, i) == nil {
+		return def
+	}
+	return s.checkInt(args, i, fname)
+}
+
+func (s *State) checkString(args []Value, i int, fname string) string {
+	str, ok := ToString(arg(args, i))
+	if !ok {
+		s.typeError(args, i, fname, "string")
+	}
+	return str
+}
+
+func (s *State) optString(args []Value, i int, fname, def string) string {
+	if arg(args, i) == nil {
+		return def
+	}
+	return s.checkString(args, i, fname)
+}
+
+func (s *State) checkTable(args []Value, i int, fname string) *Table {
+	t, ok := arg(args, i).(*Table)
+	if !ok {
+		s.typeError(args, i, fname, "table")
+	}
+	return t
+}
+
+// tostring converts any value to a string, like the tostring function.
+func tostring(v Value) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return FormatNumber(v)
+	case string:
+		return v
+	}
+	return fmt.Sprintf("%s: %p", TypeName(v), v)
+}
+
+// pcall calls fn, catching the errors it raises except fatal ones.
+func (s *State) pcall(fn Value, args []Value) (results []Value, err *Error) {
+	frames, depth := len(s.frames), s.depth
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok || e.fatal {
+				panic(r)
+			}
+			s.frames, s.depth = s.frames[:frames], depth
+			err = e
+		}
+	}()
+	return s.call(fn, args), nil
+}
+
+func (s *State) openBase() {
+	s.SetGlobal("_G", s.globals)
+	s.SetGlobal("_VERSION", "Lua 5.1")
+	register(s.globals, map[string]GoFunction{
+		"assert": func(s *State, args []Value) []Value {
+			if !Truthy(s.checkAny(args, 0, "assert")) {
+				s.Raise("%s", s.optString(args, 1, "assert", "assertion failed!"))
+			}
+			return args
+		},
+		"error": func(s *State, args []Value) []Value {
+			v := arg(args, 0)
+			level := s.optInt(args, 1, "error", 1)
+			if msg, ok := v.(string); ok && level > 0 {
+				v = s.where(level) + msg
+			}
+			s.RaiseValue(v)
+			return nil
+		},
+		"getmetatable": func(s *State, args []Value) []Value {
+			t, ok := s.checkAny(args, 0, "getmetatable").(*Table)
+			if !ok || t.meta == nil {
+				return []Value{nil}
+			}
+			if protected := t.meta.GetString("__metatable"); protected != nil {
+				return []Value{protected}
+			}
+			return []Value{t.meta}
+		},
+		"ipairs": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "ipairs")
+			iter := NewFunction("ipairs_iter", func(s *State, args []Value) []Value {
+				i := args[1].(float64) + 1
+				if v := args[0].(*Table).Get(i); v != nil {
+					return []Value{i, v}
+				}
+				return nil
+			})
+			return []Value{iter, t, float64(0)}
+		},
+		"next": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "next")
+			k, v, ok := t.Next(arg(args, 1))
+			if !ok {
+				s.Raise("invalid key to 'next'")
+			}
+			if k == nil {
+				return []Value{nil}
+			}
+			return []Value{k, v}
+		},
+		"pairs": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "pairs")
+			return []Value{s.globals.GetString("next"), t, nil}
+		},
+		"pcall": func(s *State, args []Value) []Value {
+			fn := s.checkAny(args, 0, "pcall")
+			results, err := s.pcall(fn, args[1:])
+			if err != nil {
+				return []Value{false, err.Value}
+			}
+			return append([]Value{true}, results...)
+		},
+		"xpcall": func(s *State, args []Value) []Value {
+			fn := s.checkAny(args, 0, "xpcall")
+			handler := arg(args, 1)
+			results, err := s.pcall(fn, nil)
+			if err != nil {
+				return append([]Value{false}, s.call(handler, []Value{err.Value})...)
+			}
+			return append([]Value{true}, results...)
+		},
+		"rawequal": func(s *State, args []Value) []Value {
+			return []Value{s.checkAny(args, 0, "rawequal") == s.checkAny(args, 1, "rawequal")}
+		},
+		"rawget": func(s *State, args []Value) []Value {
+			return []Value{s.checkTable(args, 0, "rawget").Get(s.checkAny(args, 1, "rawget"))}
+		},
+		"rawset": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "rawset")
+			s.rawSet(t, s.checkAny(args, 1, "rawset"), s.checkAny(args, 2, "rawset"))
+			return []Value{t}
+		},
+		"select": func(s *State, args []Value) []Value {
+			if arg(args, 0) == "#" {
+				return []Value{float64(len(args) - 1)}
+			}
+			n := s.checkInt(args, 0, "select")
+			if n < 0 {
+				n += len(args)
+			}
+			if n < 1 {
+				s.argError(0, "select", "index out of range")
+			}
+			if n >= len(args) {
+				return nil
+			}
+			return args[n:]
+		},
+		"setmetatable": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "setmetatable")
+			mt, ok := arg(args, 1).(*Table)
+			if !ok && arg(args, 1) != nil {
+				s.argError(1, "setmetatable", "nil or table expected")
+			}
+			if t.metamethod("__metatable") != nil {
+				s.Raise("cannot change a protected metatable")
+			}
+			t.meta = mt
+			return []Value{t}
+		},
+		"tonumber": func(s *State, args []Value) []Value {
+			v := s.checkAny(args, 0, "tonumber")
+			base := s.optInt(args, 1, "tonumber", 10)
+			if base == 10 {
+				if n, ok := ToNumber(v); ok {
+					return []Value{n}
+				}
+				return []Value{nil}
+			}
+			if base < 2 || base > 36 {
+				s.argError(1, "tonumber", "base out of range")
+			}
+			str := strings.ToLower(strings.TrimSpace(s.checkString(args, 0, "tonumber")))
+			if n, err := strconv.ParseInt(str, base, 64); err == nil {
+				return []Value{float64(n)}
+			}
+			return []Value{nil}
+		},
+		"tostring": func(s *State, args []Value) []Value {
+			v := s.checkAny(args, 0, "tostring")
+			if h := metamethod(v, "__tostring"); h != nil {
+				return []Value{first(s.call(h, []Value{v}))}
+			}
+			return []Value{tostring(v)}
+		},
+		"type": func(s *State, args []Value) []Value {
+			return []Value{TypeName(s.checkAny(args, 0, "type"))}
+		},
+		"unpack": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "unpack")
+			i := s.optInt(args, 1, "unpack", 1)
+			j := s.optInt(args, 2, "unpack", t.Len())
+			if i > j {
+				return nil
+			}
+			if j-i >= 8000 {
+				s.Raise("too many results to unpack")
+			}
+			results := make([]Value, 0, j-i+1)
+			for k := i; k <= j; k++ {
+				results = append(results, t.Get(float64(k)))
+			}
+			return results
+		},
+	})
+}
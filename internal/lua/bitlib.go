@@ -0,0 +1,78 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// openBit loads the bit library of LuaBitOp, which Redis provides to
+// scripts. Its functions work on 32-bit integers and return signed ones.
+func (s *State) openBit() {
+	t := NewTable()
+	s.SetGlobal("bit", t)
+	unary := func(name string, fn func(uint32) uint32) GoFunction {
+		return func(s *State, args []Value) []Value {
+			return []Value{bitResult(fn(s.checkBit(args, 0, name)))}
+		}
+	}
+	fold := func(name string, fn func(x, y uint32) uint32) GoFunction {
+		return func(s *State, args []Value) []Value {
+			x := s.checkBit(args, 0, name)
+			for i := 1; i < len(args); i++ {
+				x = fn(x, s.checkBit(args, i, name))
+			}
+			return []Value{bitResult(x)}
+		}
+	}
+	shift := func(name string, fn func(x uint32, n uint) uint32) GoFunction {
+		return func(s *State, args []Value) []Value {
+			x, n := s.checkBit(args, 0, name), s.checkBit(args, 1, name)
+			return []Value{bitResult(fn(x, uint(n&31)))}
+		}
+	}
+	register(t, map[string]GoFunction{
+		"tobit":   unary("tobit", func(x uint32) uint32 { return x }),
+		"bnot":    unary("bnot", func(x uint32) uint32 { return ^x }),
+		"bswap":   unary("bswap", bits.ReverseBytes32),
+		"band":    fold("band", func(x, y uint32) uint32 { return x & y }),
+		"bor":     fold("bor", func(x, y uint32) uint32 { return x | y }),
+		"bxor":    fold("bxor", func(x, y uint32) uint32 { return x ^ y }),
+		"lshift":  shift("lshift", func(x uint32, n uint) uint32 { return x << n }),
+		"rshift":  shift("rshift", func(x uint32, n uint) uint32 { return x >> n }),
+		"arshift": shift("arshift", func(x uint32, n uint) uint32 { return uint32(int32(x) >> n) }),
+		"rol":     shift("rol", func(x uint32, n uint) uint32 { return bits.RotateLeft32(x, int(n)) }),
+		"ror":     shift("ror", func(x uint32, n uint) uint32 { return bits.RotateLeft32(x, -int(n)) }),
+		"tohex": func(s *State, args []Value) []Value {
+			x := s.checkBit(args, 0, "tohex")
+			n := 8
+			if arg(args, 1) != nil {
+				n = int(int32(s.checkBit(args, 1, "tohex")))
+			}
+			format := "%0*x"
+			if n < 0 {
+				format, n = "%0*X", -n
+			}
+			n = min(n, 8)
+			if n < 8 {
+				x &= 1<<(4*n) - 1
+			}
+			return []Value{fmt.Sprintf(format, n, x)}
+		},
+	})
+}
+
+// checkBit returns the argument at index i converted to a 32-bit integer
+// the way LuaBitOp does: rounded to the nearest integer, modulo 2^32.
+func (s *State) checkBit(args []Value, i int, fname string) uint32 {
+	x := s.checkNumber(args, i, fname)
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0
+	}
+	return uint32(int64(math.Mod(math.RoundToEven(x), 1<<32)))
+}
+
+// bitResult returns x as the signed number the bit functions return.
+func bitResult(x uint32) Value {
+	return float64(int32(x))
+}
@@ -0,0 +1,367 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// cjsonMaxDepth bounds the nesting of the tables encoded and of the arrays
+// and objects decoded by cjson, as in Lua CJSON.
+const cjsonMaxDepth = 1000
+
+// cjsonNull is the cjson.null value, which JSON null decodes to.
+var cjsonNull = &Userdata{name: "cjson.null"}
+
+// openCjson loads the cjson library of Lua CJSON, which Redis provides to
+// scripts, with its default settings: arrays may not be sparser than half
+// their length past 10 elements, and numbers are encoded with 14 digits.
+func (s *State) openCjson() {
+	t := NewTable()
+	s.SetGlobal("cjson", t)
+	t.SetString("null", cjsonNull)
+	register(t, map[string]GoFunction{
+		"encode": func(s *State, args []Value) []Value {
+			if len(args) != 1 {
+				s.argError(0, "encode", "expected 1 argument")
+			}
+			var sb strings.Builder
+			s.jsonEncode(&sb, args[0], 0)
+			return []Value{sb.String()}
+		},
+		"decode": func(s *State, args []Value) []Value {
+			if len(args) != 1 {
+				s.argError(0, "decode", "expected 1 argument")
+			}
+			d := &jsonDecoder{s: s, src: s.checkString(args, 0, "decode")}
+			v := d.value(d.next(), 0)
+			if tok := d.next(); tok.kind != jsonEnd {
+				d.unexpected("the end", tok)
+			}
+			return []Value{v}
+		},
+	})
+}
+
+// jsonEncode appends v encoded as JSON to sb. Tables whose keys are all
+// positive integers are encoded as arrays, other tables as objects.
+func (s *State) jsonEncode(sb *strings.Builder, v Value, depth int) {
+	switch v := v.(type) {
+	case nil:
+		sb.WriteString("null")
+	case bool:
+		sb.WriteString(strconv.FormatBool(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			s.Raise("Cannot serialise number: must not be NaN or Inf")
+		}
+		sb.WriteString(formatJSONNumber(v))
+	case string:
+		jsonQuote(sb, v)
+	case *Table:
+		if depth++; depth > cjsonMaxDepth {
+			s.Raise("Cannot serialise, excessive nesting (%d)", depth)
+		}
+		if n := s.jsonArrayLength(v); n > 0 {
+			sb.WriteByte('[')
+			for i := 1; i <= n; i++ {
+				if i > 1 {
+					sb.WriteByte(',')
+				}
+				s.jsonEncode(sb, v.Get(float64(i)), depth)
+			}
+			sb.WriteByte(']')
+			return
+		}
+		sb.WriteByte('{')
+		comma := false
+		v.ForEach(func(key, value Value) {
+			if comma {
+				sb.WriteByte(',')
+			}
+			comma = true
+			switch k := key.(type) {
+			case float64:
+				sb.WriteString(`"` + formatJSONNumber(k) + `"`)
+			case string:
+				jsonQuote(sb, k)
+			default:
+				s.Raise("Cannot serialise table: table key must be a number or string")
+			}
+			sb.WriteByte(':')
+			s.jsonEncode(sb, value, depth)
+		})
+		sb.WriteByte('}')
+	default:
+		if v == cjsonNull {
+			sb.WriteString("null")
+			return
+		}
+		s.Raise("Cannot serialise %s: type not supported", TypeName(v))
+	}
+}
+
+// jsonArrayLength returns the length of t if it is to be encoded as an
+// array, or 0 if it is to be encoded as an object. It raises an error for
+// arrays with too many holes.
+func (s *State) jsonArrayLength(t *Table) int {
+	n, items, array := 0, 0, true
+	t.ForEach(func(key, _ Value) {
+		k, ok := key.(float64)
+		if !ok || k < 1 || k != math.Floor(k) {
+			array = false
+			return
+		}
+		n = max(n, int(k))
+		items++
+	})
+	if !array {
+		return 0
+	}
+	if n > 10 && n > items*2 {
+		s.Raise("Cannot serialise table: excessively sparse array")
+	}
+	return n
+}
+
+// formatJSONNumber formats n the way Lua CJSON does, with the C format
+// "%.14g".
+func formatJSONNumber(n float64) string {
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// jsonQuote appends str quoted as a JSON string to sb, escaping the
+// characters Lua CJSON escapes.
+func jsonQuote(sb *strings.Builder, str string) {
+	sb.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '"', '\\', '/':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(sb, `\u%04x`, c)
+			} else {
+				sb.WriteByte(c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+}
+
+// jsonTokenKind is the kind of a token of JSON text.
+type jsonTokenKind int
+
+const (
+	jsonObjectBegin jsonTokenKind = iota
+	jsonObjectEnd
+	jsonArrayBegin
+	jsonArrayEnd
+	jsonString
+	jsonNumber
+	jsonBoolean
+	jsonNull
+	jsonColon
+	jsonComma
+	jsonEnd
+	jsonInvalid
+)
+
+// jsonTokenNames names the kinds of tokens in decoding errors.
+var jsonTokenNames = [...]string{
+	jsonObjectBegin: "'{'", jsonObjectEnd: "'}'", jsonArrayBegin: "'['", jsonArrayEnd: "']'",
+	jsonString: "string", jsonNumber: "number", jsonBoolean: "boolean", jsonNull: "null",
+	jsonColon: "colon", jsonComma: "comma", jsonEnd: "end", jsonInvalid: "invalid token",
+}
+
+// jsonPunctuation maps the punctuation characters of JSON to their tokens.
+var jsonPunctuation = map[byte]jsonTokenKind{
+	'{': jsonObjectBegin, '}': jsonObjectEnd, '[': jsonArrayBegin, ']': jsonArrayEnd, ':': jsonColon, ',': jsonComma,
+}
+
+// jsonToken is a token of JSON text, with its value for strings, numbers
+// and booleans, and its position as a 1-based character index.
+type jsonToken struct {
+	kind  jsonTokenKind
+	value Value
+	pos   int
+}
+
+// jsonEscapes maps the characters following a backslash in a JSON string,
+// other than u, to the characters they stand for.
+var jsonEscapes = map[byte]byte{'"': '"', '\\': '\\', '/': '/', 'b': '\b', 'f': '\f', 'n': '\n', 'r': '\r', 't': '\t'}
+
+// jsonDecoder decodes JSON text into Lua values, as cjson.decode.
+type jsonDecoder struct {
+	s   *State
+	src string
+	pos int
+}
+
+// next returns the next token.
+func (d *jsonDecoder) next() jsonToken {
+	for d.pos < len(d.src) && strings.IndexByte(" \t\n\r", d.src[d.pos]) >= 0 {
+		d.pos++
+	}
+	tok := jsonToken{pos: d.pos + 1}
+	if d.pos == len(d.src) {
+		tok.kind = jsonEnd
+		return tok
+	}
+	switch c := d.src[d.pos]; {
+	case c == '{', c == '}', c == '[', c == ']', c == ':', c == ',':
+		d.pos++
+		tok.kind = jsonPunctuation[c]
+		return tok
+	case c == '"':
+		return d.string(tok)
+	case c == '-' || c >= '0' && c <= '9':
+		end := d.pos + 1
+		for end < len(d.src) && strings.IndexByte("0123456789+-.eE", d.src[end]) >= 0 {
+			end++
+		}
+		if n, err := strconv.ParseFloat(d.src[d.pos:end], 64); err == nil {
+			d.pos = end
+			tok.kind, tok.value = jsonNumber, n
+			return tok
+		}
+	case strings.HasPrefix(d.src[d.pos:], "true"):
+		d.pos += 4
+		tok.kind, tok.value = jsonBoolean, true
+		return tok
+	case strings.HasPrefix(d.src[d.pos:], "false"):
+		d.pos += 5
+		tok.kind, tok.value = jsonBoolean, false
+		return tok
+	case strings.HasPrefix(d.src[d.pos:], "null"):
+		d.pos += 4
+		tok.kind, tok.value = jsonNull, cjsonNull
+		return tok
+	}
+	tok.kind = jsonInvalid
+	return tok
+}
+
+// string reads the string token starting at d.pos.
+func (d *jsonDecoder) string(tok jsonToken) jsonToken {
+	var sb strings.Builder
+	tok.kind = jsonInvalid
+	for i := d.pos + 1; i < len(d.src); i++ {
+		c := d.src[i]
+		switch {
+		case c == '"':
+			d.pos = i + 1
+			tok.kind, tok.value = jsonString, sb.String()
+			return tok
+		case c != '\\':
+			sb.WriteByte(c)
+			continue
+		case i+1 == len(d.src):
+			return tok
+		}
+		i++
+		if c := d.src[i]; c != 'u' {
+			escaped, ok := jsonEscapes[c]
+			if !ok {
+				return tok
+			}
+			sb.WriteByte(escaped)
+			continue
+		}
+		r, ok := d.hex4(i + 1)
+		if !ok {
+			return tok
+		}
+		i += 4
+		if utf16.IsSurrogate(r) {
+			low, ok := d.hex4(i + 3)
+			if !ok || d.src[i+1:i+3] != `\u` {
+				return tok
+			}
+			if r = utf16.DecodeRune(r, low); r == utf8.RuneError {
+				return tok
+			}
+			i += 6
+		}
+		sb.WriteRune(r)
+	}
+	return tok
+}
+
+// hex4 parses the 4 hexadecimal digits at i.
+func (d *jsonDecoder) hex4(i int) (rune, bool) {
+	if i < 0 || i+4 > len(d.src) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(d.src[i:i+4], 16, 16)
+	return rune(n), err == nil
+}
+
+// value decodes the value starting with tok, nested depth levels deep.
+func (d *jsonDecoder) value(tok jsonToken, depth int) Value {
+	switch tok.kind {
+	case jsonString, jsonNumber, jsonBoolean, jsonNull:
+		return tok.value
+	case jsonObjectBegin, jsonArrayBegin:
+		if depth++; depth > cjsonMaxDepth {
+			d.s.Raise("Found too many nested data structures (%d) at character %d", depth, tok.pos)
+		}
+	default:
+		d.unexpected("value", tok)
+	}
+	t := NewTable()
+	if tok.kind == jsonArrayBegin {
+		tok = d.next()
+		if tok.kind == jsonArrayEnd {
+			return t
+		}
+		for i := 1; ; i++ {
+			t.Set(float64(i), d.value(tok, depth))
+			if tok = d.next(); tok.kind == jsonArrayEnd {
+				return t
+			} else if tok.kind != jsonComma {
+				d.unexpected("comma or array end", tok)
+			}
+			tok = d.next()
+		}
+	}
+	tok = d.next()
+	if tok.kind == jsonObjectEnd {
+		return t
+	}
+	for {
+		if tok.kind != jsonString {
+			d.unexpected("object key string", tok)
+		}
+		key := tok.value
+		if tok = d.next(); tok.kind != jsonColon {
+			d.unexpected("colon", tok)
+		}
+		t.Set(key, d.value(d.next(), depth))
+		if tok = d.next(); tok.kind == jsonObjectEnd {
+			return t
+		} else if tok.kind != jsonComma {
+			d.unexpected("comma or object end", tok)
+		}
+		tok = d.next()
+	}
+}
+
+// unexpected raises the error for finding tok where expected was.
+func (d *jsonDecoder) unexpected(expected string, tok jsonToken) {
+	d.s.Raise("Expected %s but found %s at character %d", expected, jsonTokenNames[tok.kind], tok.pos)
+}
@@ -0,0 +1,787 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// maxCallDepth bounds the nesting of function calls, so that runaway
+// recursion fails with a Lua error instead of exhausting the Go stack.
+const maxCallDepth = 200
+
+// interruptInterval is the number of loop iterations and calls between two
+// checks of the interrupt hook.
+const interruptInterval = 1000
+
+// maxMetaLoop bounds the chains of __index and __newindex tables, as in Lua.
+const maxMetaLoop = 100
+
+// Error is a Lua error raised by a script or the interpreter.
+type Error struct {
+	// Value is the error value, usually a message string.
+	Value Value
+	// fatal errors, such as interruptions, can not be caught by pcall.
+	fatal bool
+}
+
+func (e *Error) Error() string {
+	if s, ok := ToString(e.Value); ok {
+		return s
+	}
+	if e.Value == nil {
+		return "nil"
+	}
+	return "(error object is a " + TypeName(e.Value) + " value)"
+}
+
+// State is an interpreter: a global environment in which chunks run. A State
+// must not be used by several goroutines at once.
+type State struct {
+	globals *Table
+	strings *Table
+	frames  []*frame
+	depth   int
+	steps   int
+
+	interrupt     func() error
+	strictGlobals bool
+}
+
+// frame is the activation record of a Lua function.
+type frame struct {
+	fn      *Function
+	locals  []*cell
+	varargs []Value
+	line    int
+}
+
+// NewState returns a State with the standard libraries loaded.
+func NewState() *State {
+	s := &State{globals: NewTable()}
+	s.openBase()
+	s.openString()
+	s.openTable()
+	s.openMath()
+	s.openBit()
+	s.openCjson()
+	return s
+}
+
+// SetGlobal assigns a global variable.
+func (s *State) SetGlobal(name string, v Value) {
+	s.globals.SetString(name, v)
+}
+
+// GetGlobal returns the value of a global variable.
+func (s *State) GetGlobal(name string) Value {
+	return s.globals.GetString(name)
+}
+
+// SetStrictGlobals makes reading an undefined global variable and assigning
+// a new one errors, so that scripts can not leak state through globals.
+func (s *State) SetStrictGlobals(strict bool) {
+	s.strictGlobals = strict
+}
+
+// SetInterrupt installs a hook called periodically while a script runs. When
+// it returns an error, the script is aborted with that error, which pcall
+// can not catch.
+func (s *State) SetInterrupt(fn func() error) {
+	s.interrupt = fn
+}
+
+// Run runs a chunk with the given arguments and returns its results. Lua
+// errors are returned as *Error.
+func (s *State) Run(chunk *Chunk, args ...Value) ([]Value, error) {
+	return s.Call(&Function{name: "main chunk", proto: chunk.proto}, args...)
+}
+
+// Call calls a function value and returns its results. Lua errors are
+// returned as *Error.
+func (s *State) Call(fn Value, args ...Value) (results []Value, err error) {
+	frames, depth := len(s.frames), s.depth
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			s.frames, s.depth = s.frames[:frames], depth
+			err = e
+		}
+	}()
+	return s.call(fn, args), nil
+}
+
+// Raise raises an error with a message prefixed by the current position in
+// the script, like the error function does.
+func (s *State) Raise(format string, args ...any) {
+	panic(&Error{Value: s.where(1) + fmt.Sprintf(format, args...)})
+}
+
+// RaiseValue raises an error with any value, without position information.
+func (s *State) RaiseValue(v Value) {
+	panic(&Error{Value: v})
+}
+
+// where returns the position of the Lua function at the given level of the
+// call stack, 1 being the innermost, as a "chunk:line: " prefix.
+func (s *State) where(level int) string {
+	i := len(s.frames) - level
+	if level <= 0 || i < 0 {
+		return ""
+	}
+	f := s.frames[i]
+	return fmt.Sprintf("%s:%d: ", f.fn.proto.chunk, f.line)
+}
+
+// tick counts a step of the script and calls the interrupt hook
+// periodically.
+func (s *State) tick() {
+	s.steps++
+	if s.steps%interruptInterval != 0 || s.interrupt == nil {
+		return
+	}
+	if err := s.interrupt(); err != nil {
+		panic(&Error{Value: err.Error(), fatal: true})
+	}
+}
+
+// call calls a function value with arguments.
+func (s *State) call(fv Value, args []Value) []Value {
+	fn, ok := fv.(*Function)
+	if !ok {
+		h, ok := metamethod(fv, "__call").(*Function)
+		if !ok {
+			s.Raise("attempt to call a %s value", TypeName(fv))
+		}
+		return s.call(h, append([]Value{fv}, args...))
+	}
+	if s.depth >= maxCallDepth {
+		s.Raise("stack overflow")
+	}
+	s.tick()
+	s.depth++
+	defer func() { s.depth-- }()
+	if fn.native != nil {
+		return fn.native(s, args)
+	}
+
+	p := fn.proto
+	f := &frame{fn: fn, locals: make([]*cell, p.numSlots), line: p.line}
+	for i, slot := range p.params {
+		var v Value
+		if i < len(args) {
+			v = args[i]
+		}
+		f.locals[slot] = &cell{v}
+	}
+	if p.isVararg && len(args) > len(p.params) {
+		f.varargs = args[len(p.params):]
+	}
+	s.frames = append(s.frames, f)
+	_, results := s.execBlock(f, p.body)
+	s.frames = s.frames[:len(s.frames)-1]
+	return results
+}
+
+// control tells how a block finished.
+type control int
+
+const (
+	ctrlNone control = iota
+	ctrlBreak
+	ctrlReturn
+)
+
+func (s *State) execBlock(f *frame, b block) (control, []Value) {
+	for _, st := range b {
+		if ctrl, results := s.exec(f, st); ctrl != ctrlNone {
+			return ctrl, results
+		}
+	}
+	return ctrlNone, nil
+}
+
+func (s *State) exec(f *frame, st stmt) (control, []Value) {
+	switch st := st.(type) {
+	case *localStmt:
+		f.line = st.line
+		values := s.evalList(f, st.exprs, len(st.slots))
+		for i, slot := range st.slots {
+			f.locals[slot] = &cell{values[i]}
+		}
+	case *localFuncStmt:
+		c := &cell{}
+		f.locals[st.slot] = c
+		c.v = s.closure(f, st.proto)
+	case *assignStmt:
+		f.line = st.line
+		s.assign(f, st)
+	case *callStmt:
+		s.evalMulti(f, st.call)
+	case *doStmt:
+		return s.execBlock(f, st.body)
+	case *whileStmt:
+		for Truthy(s.eval(f, st.cond)) {
+			s.tick()
+			if ctrl, results := s.execBlock(f, st.body); ctrl == ctrlBreak {
+				break
+			} else if ctrl == ctrlReturn {
+				return ctrl, results
+			}
+		}
+	case *repeatStmt:
+		for {
+			s.tick()
+			if ctrl, results := s.execBlock(f, st.body); ctrl == ctrlBreak {
+				break
+			} else if ctrl == ctrlReturn {
+				return ctrl, results
+			}
+			if Truthy(s.eval(f, st.cond)) {
+				break
+			}
+		}
+	case *ifStmt:
+		for i, cond := range st.conds {
+			if Truthy(s.eval(f, cond)) {
+				return s.execBlock(f, st.blocks[i])
+			}
+		}
+		return s.execBlock(f, st.orElse)
+	case *numForStmt:
+		return s.numFor(f, st)
+	case *genForStmt:
+		return s.genFor(f, st)
+	case *returnStmt:
+		f.line = st.line
+		return ctrlReturn, s.evalList(f, st.exprs, -1)
+	case *breakStmt:
+		return ctrlBreak, nil
+	}
+	return ctrlNone, nil
+}
+
+func (s *State) numFor(f *frame, st *numForStmt) (control, []Value) {
+	f.line = st.line
+	start, ok1 := ToNumber(s.eval(f, st.start))
+	limit, ok2 := ToNumber(s.eval(f, st.limit))
+	step, ok3 := 1.0, true
+	if st.step != nil {
+		step, ok3 = ToNumber(s.eval(f, st.step))
+	}
+	switch {
+	case !ok1:
+		s.Raise("'for' initial value must be a number")
+	case !ok2:
+		s.Raise("'for' limit must be a number")
+	case !ok3:
+		s.Raise("'for' step must be a number")
+	}
+	for v := start; (step > 0 && v <= limit) || (step <= 0 && v >= limit); v += step {
+		s.tick()
+		f.locals[st.slot] = &cell{v}
+		if ctrl, results := s.execBlock(f, st.body); ctrl == ctrlBreak {
+			break
+		} else if ctrl == ctrlReturn {
+			return ctrl, results
+		}
+	}
+	return ctrlNone, nil
+}
+
+func (s *State) genFor(f *frame, st *genForStmt) (control, []Value) {
+	f.line = st.line
+	init := s.evalList(f, st.exprs, 3)
+	fn, state, control := init[0], init[1], init[2]
+	for {
+		s.tick()
+		f.line = st.line
+		results := s.call(fn, []Value{state, control})
+		if len(results) == 0 || results[0] == nil {
+			return ctrlNone, nil
+		}
+		control = results[0]
+		for i, slot := range st.slots {
+			var v Value
+			if i < len(results) {
+				v = results[i]
+			}
+			f.locals[slot] = &cell{v}
+		}
+		if ctrl, results := s.execBlock(f, st.body); ctrl == ctrlBreak {
+			return ctrlNone, nil
+		} else if ctrl == ctrlReturn {
+			return ctrl, results
+		}
+	}
+}
+
+// assign runs an assignment: the tables and keys of the targets are
+// evaluated first, then the values, and the targets are assigned last.
+func (s *State) assign(f *frame, st *assignStmt) {
+	if len(st.targets) == 1 && len(st.exprs) == 1 {
+		if ix, ok := st.targets[0].(*indexExpr); ok {
+			obj, key := s.eval(f, ix.obj), s.eval(f, ix.key)
+			s.setIndex(ix.obj, obj, key, s.eval(f, st.exprs[0]))
+			return
+		}
+		s.setVar(f, st.targets[0], s.eval(f, st.exprs[0]))
+		return
+	}
+	type ref struct{ obj, key Value }
+	refs := make([]ref, len(st.targets))
+	for i, t := range st.targets {
+		if ix, ok := t.(*indexExpr); ok {
+			refs[i] = ref{s.eval(f, ix.obj), s.eval(f, ix.key)}
+		}
+	}
+	values := s.evalList(f, st.exprs, len(st.targets))
+	for i, t := range st.targets {
+		if ix, ok := t.(*indexExpr); ok {
+			s.setIndex(ix.obj, refs[i].obj, refs[i].key, values[i])
+		} else {
+			s.setVar(f, t, values[i])
+		}
+	}
+}
+
+func (s *State) setVar(f *frame, target expr, v Value) {
+	switch t := target.(type) {
+	case *localExpr:
+		f.locals[t.slot].v = v
+	case *upvalExpr:
+		f.fn.upvals[t.index].v = v
+	case *globalExpr:
+		if s.strictGlobals && s.globals.GetString(t.name) == nil {
+			s.Raise("Script attempted to create global variable '%s'", t.name)
+		}
+		s.globals.SetString(t.name, v)
+	}
+}
+
+// setIndex assigns obj[key] = v, through the __newindex metamethod of
+// tables that lack the key. objExpr is the expression obj was read from,
+// used to describe it in error messages.
+func (s *State) setIndex(objExpr expr, obj, key, v Value) {
+	for range maxMetaLoop {
+		t, ok := obj.(*Table)
+		if !ok {
+			s.Raise("attempt to index %s", describe(objExpr, obj))
+		}
+		h := t.metamethod("__newindex")
+		if h == nil || t.Get(key) != nil {
+			s.rawSet(t, key, v)
+			return
+		}
+		if fn, ok := h.(*Function); ok {
+			s.call(fn, []Value{t, key, v})
+			return
+		}
+		obj, objExpr = h, nil
+	}
+	s.Raise("loop in settable")
+}
+
+// rawSet assigns t[key] = v, rejecting invalid keys.
+func (s *State) rawSet(t *Table, key, v Value) {
+	switch k := key.(type) {
+	case nil:
+		s.Raise("table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			s.Raise("table index is NaN")
+		}
+	}
+	t.Set(key, v)
+}
+
+// describe names the variable an erroneous value was read from, as in
+// "global 'x' (a nil value)".
+func describe(e expr, v Value) string {
+	kind := "a " + TypeName(v) + " value"
+	switch e := e.(type) {
+	case *globalExpr:
+		return fmt.Sprintf("global '%s' (%s)", e.name, kind)
+	case *localExpr:
+		return fmt.Sprintf("local '%s' (%s)", e.name, kind)
+	case *upvalExpr:
+		return fmt.Sprintf("upvalue '%s' (%s)", e.name, kind)
+	case *indexExpr:
+		if k, ok := e.key.(*stringExpr); ok {
+			return fmt.Sprintf("field '%s' (%s)", k.value, kind)
+		}
+	case *methodCallExpr:
+		return fmt.Sprintf("method '%s' (%s)", e.name, kind)
+	}
+	return kind
+}
+
+// closure creates a function value for a nested function, capturing its
+// upvalues from the running function.
+func (s *State) closure(f *frame, p *funcProto) *Function {
+	fn := &Function{name: p.name, proto: p, upvals: make([]*cell, len(p.upvals))}
+	for i, u := range p.upvals {
+		if u.fromLocal {
+			if f.locals[u.index] == nil {
+				f.locals[u.index] = &cell{}
+			}
+			fn.upvals[i] = f.locals[u.index]
+		} else {
+			fn.upvals[i] = f.fn.upvals[u.index]
+		}
+	}
+	return fn
+}
+
+// evalList evaluates a list of expressions. The last one contributes all
+// its values. If want is not negative the values are adjusted to that
+// number, dropping extra ones or padding with nil.
+func (s *State) evalList(f *frame, exprs []expr, want int) []Value {
+	var values []Value
+	if want >= 0 {
+		values = make([]Value, 0, want)
+	}
+	for i, e := range exprs {
+		if i == len(exprs)-1 {
+			values = append(values, s.evalMulti(f, e)...)
+		} else {
+			values = append(values, s.eval(f, e))
+		}
+	}
+	if want < 0 {
+		return values
+	}
+	for len(values) < want {
+		values = append(values, nil)
+	}
+	return values[:want]
+}
+
+// evalMulti evaluates an expression that may have several values.
+func (s *State) evalMulti(f *frame, e expr) []Value {
+	switch e := e.(type) {
+	case *callExpr:
+		fn := s.eval(f, e.fn)
+		args := s.evalList(f, e.args, -1)
+		f.line = e.line
+		if !callable(fn) {
+			s.Raise("attempt to call %s", describe(e.fn, fn))
+		}
+		return s.call(fn, args)
+	case *methodCallExpr:
+		obj := s.eval(f, e.obj)
+		fn := s.index(e.obj, obj, e.name)
+		args := append([]Value{obj}, s.evalList(f, e.args, -1)...)
+		f.line = e.line
+		if !callable(fn) {
+			s.Raise("attempt to call %s", describe(e, fn))
+		}
+		return s.call(fn, args)
+	case *varargExpr:
+		return append([]Value(nil), f.varargs...)
+	}
+	return []Value{s.eval(f, e)}
+}
+
+// eval evaluates an expression to a single value.
+func (s *State) eval(f *frame, e expr) Value {
+	switch e := e.(type) {
+	case *nilExpr:
+		return nil
+	case *trueExpr:
+		return true
+	case *falseExpr:
+		return false
+	case *numberExpr:
+		return e.value
+	case *stringExpr:
+		return e.value
+	case *localExpr:
+		return f.locals[e.slot].v
+	case *upvalExpr:
+		return f.fn.upvals[e.index].v
+	case *globalExpr:
+		v := s.globals.GetString(e.name)
+		if v == nil && s.strictGlobals {
+			s.Raise("Script attempted to access nonexistent global variable '%s'", e.name)
+		}
+		return v
+	case *indexExpr:
+		obj := s.eval(f, e.obj)
+		key := s.eval(f, e.key)
+		f.line = e.line
+		return s.index(e.obj, obj, key)
+	case *callExpr, *methodCallExpr, *varargExpr:
+		if values := s.evalMulti(f, e); len(values) > 0 {
+			return values[0]
+		}
+		return nil
+	case *parenExpr:
+		return s.eval(f, e.inner)
+	case *funcExpr:
+		return s.closure(f, e.proto)
+	case *tableExpr:
+		return s.table(f, e)
+	case *unaryExpr:
+		return s.unary(f, e)
+	case *binaryExpr:
+		return s.binary(f, e)
+	}
+	panic(fmt.Sprintf("lua: unknown expression %T", e))
+}
+
+// index returns obj[key], through the __index metamethod of tables that
+// lack the key. Strings are indexed in the string library, so that methods
+// such as s:upper() work.
+func (s *State) index(objExpr expr, obj, key Value) Value {
+	for range maxMetaLoop {
+		switch o := obj.(type) {
+		case *Table:
+			if v := o.Get(key); v != nil {
+				return v
+			}
+			h := o.metamethod("__index")
+			if h == nil {
+				return nil
+			}
+			if fn, ok := h.(*Function); ok {
+				return first(s.call(fn, []Value{o, key}))
+			}
+			obj, objExpr = h, nil
+			continue
+		case string:
+			return s.strings.Get(key)
+		}
+		s.Raise("attempt to index %s", describe(objExpr, obj))
+	}
+	s.Raise("loop in gettable")
+	return nil
+}
+
+func (s *State) table(f *frame, e *tableExpr) *Table {
+	t := NewTable()
+	n := 1
+	for i, item := range e.items {
+		if item.key != nil {
+			key := s.eval(f, item.key)
+			f.line = e.line
+			s.rawSet(t, key, s.eval(f, item.value))
+			continue
+		}
+		if i == len(e.items)-1 {
+			for _, v := range s.evalMulti(f, item.value) {
+				t.Set(float64(n), v)
+				n++
+			}
+			continue
+		}
+		t.Set(float64(n), s.eval(f, item.value))
+		n++
+	}
+	return t
+}
+
+func (s *State) unary(f *frame, e *unaryExpr) Value {
+	v := s.eval(f, e.operand)
+	f.line = e.line
+	switch e.op {
+	case tokNot:
+		return !Truthy(v)
+	case '-':
+		n, ok := ToNumber(v)
+		if !ok {
+			if h := metamethod(v, "__unm"); h != nil {
+				return first(s.call(h, []Value{v, v}))
+			}
+			s.Raise("attempt to perform arithmetic on %s", describe(e.operand, v))
+		}
+		return -n
+	default: // '#'
+		switch v := v.(type) {
+		case string:
+			return float64(len(v))
+		case *Table:
+			return float64(v.Len())
+		}
+		s.Raise("attempt to get length of %s", describe(e.operand, v))
+		return nil
+	}
+}
+
+func (s *State) binary(f *frame, e *binaryExpr) Value {
+	switch e.op {
+	case tokAnd:
+		if left := s.eval(f, e.left); !Truthy(left) {
+			return left
+		}
+		return s.eval(f, e.right)
+	case tokOr:
+		if left := s.eval(f, e.left); Truthy(left) {
+			return left
+		}
+		return s.eval(f, e.right)
+	}
+	a, b := s.eval(f, e.left), s.eval(f, e.right)
+	f.line = e.line
+	switch e.op {
+	case tokEq:
+		return s.equal(a, b)
+	case tokNe:
+		return !s.equal(a, b)
+	case '<':
+		return s.compare(a, b, false)
+	case '>':
+		return s.compare(b, a, false)
+	case tokLe:
+		return s.compare(a, b, true)
+	case tokGe:
+		return s.compare(b, a, true)
+	case tokConcat:
+		x, ok1 := ToString(a)
+		y, ok2 := ToString(b)
+		if !ok1 || !ok2 {
+			if h := binaryMetamethod(a, b, "__concat"); h != nil {
+				return first(s.call(h, []Value{a, b}))
+			}
+		}
+		if !ok1 {
+			s.Raise("attempt to concatenate %s", describe(e.left, a))
+		}
+		if !ok2 {
+			s.Raise("attempt to concatenate %s", describe(e.right, b))
+		}
+		return x + y
+	}
+	x, ok1 := ToNumber(a)
+	y, ok2 := ToNumber(b)
+	if !ok1 || !ok2 {
+		if h := binaryMetamethod(a, b, arithEvents[e.op]); h != nil {
+			return first(s.call(h, []Value{a, b}))
+		}
+	}
+	if !ok1 {
+		s.Raise("attempt to perform arithmetic on %s", describe(e.left, a))
+	}
+	if !ok2 {
+		s.Raise("attempt to perform arithmetic on %s", describe(e.right, b))
+	}
+	return arith(e.op, x, y)
+}
+
+// arithEvents maps the arithmetic operators to their metamethods.
+var arithEvents = map[tokenKind]string{
+	'+': "__add", '-': "__sub", '*': "__mul", '/': "__div", '%': "__mod", '^': "__pow",
+}
+
+func arith(op tokenKind, x, y float64) float64 {
+	switch op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	case '/':
+		return x / y
+	case '%':
+		return x - math.Floor(x/y)*y
+	default: // '^'
+		return math.Pow(x, y)
+	}
+}
+
+// compare reports whether a < b, or a <= b if orEqual is set. Only two
+// numbers, two strings or two values sharing a __lt or __le metamethod can
+// be compared. Without __le, a <= b is computed as not (b < a).
+func (s *State) compare(a, b Value, orEqual bool) bool {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return x < y || (orEqual && x == y)
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			c := strings.Compare(x, y)
+			return c < 0 || (orEqual && c == 0)
+		}
+	}
+	ta, tb := TypeName(a), TypeName(b)
+	if ta != tb {
+		s.Raise("attempt to compare %s with %s", ta, tb)
+	}
+	if !orEqual {
+		if h := sharedMetamethod(a, b, "__lt"); h != nil {
+			return Truthy(first(s.call(h, []Value{a, b})))
+		}
+	} else if h := sharedMetamethod(a, b, "__le"); h != nil {
+		return Truthy(first(s.call(h, []Value{a, b})))
+	} else if h := sharedMetamethod(a, b, "__lt"); h != nil {
+		return !Truthy(first(s.call(h, []Value{b, a})))
+	}
+	s.Raise("attempt to compare two %s values", ta)
+	return false
+}
+
+// equal reports whether a == b: two values are equal if they are the same
+// value, or tables sharing an __eq metamethod that returns true.
+func (s *State) equal(a, b Value) bool {
+	if a == b {
+		return true
+	}
+	if _, ok := a.(*Table); !ok {
+		return false
+	}
+	if _, ok := b.(*Table); !ok {
+		return false
+	}
+	if h := sharedMetamethod(a, b, "__eq"); h != nil {
+		return Truthy(first(s.call(h, []Value{a, b})))
+	}
+	return false
+}
+
+// metamethod returns the handler of event in the metatable of v, or nil.
+// Only tables have metatables.
+func metamethod(v Value, event string) Value {
+	if t, ok := v.(*Table); ok {
+		return t.metamethod(event)
+	}
+	return nil
+}
+
+// binaryMetamethod returns the handler of event for a binary operation:
+// the one of a, or else the one of b.
+func binaryMetamethod(a, b Value, event string) Value {
+	if h := metamethod(a, event); h != nil {
+		return h
+	}
+	return metamethod(b, event)
+}
+
+// sharedMetamethod returns the handler of event if a and b have the same
+// one, as comparisons require, or nil.
+func sharedMetamethod(a, b Value, event string) Value {
+	h := metamethod(a, event)
+	if h == nil || h != metamethod(b, event) {
+		return nil
+	}
+	return h
+}
+
+// callable reports whether v can be called: a function, or a table with a
+// __call metamethod.
+func callable(v Value) bool {
+	_, ok := v.(*Function)
+	return ok || metamethod(v, "__call") != nil
+}
+
+// first returns the first of values, or nil if there are none.
+func first(values []Value) Value {
+	if len(values) > 0 {
+		return values[0]
+	}
+	return nil
+}
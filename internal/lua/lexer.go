@@ -0,0 +1,355 @@
+package lua
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind identifies the kind of a token. Single-character operators use
+// their own byte value.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota + 256
+	tokName
+	tokString
+	tokNumber
+
+	// Keywords.
+	tokAnd
+	tokBreak
+	tokDo
+	tokElse
+	tokElseif
+	tokEnd
+	tokFalse
+	tokFor
+	tokFunction
+	tokIf
+	tokIn
+	tokLocal
+	tokNil
+	tokNot
+	tokOr
+	tokRepeat
+	tokReturn
+	tokThen
+	tokTrue
+	tokUntil
+	tokWhile
+
+	// Multi-character operators.
+	tokConcat // ..
+	tokDots   // ...
+	tokEq     // ==
+	tokGe     // >=
+	tokLe     // <=
+	tokNe     // ~=
+)
+
+var keywords = map[string]tokenKind{
+	"and": tokAnd, "break": tokBreak, "do": tokDo, "else": tokElse,
+	"elseif": tokElseif, "end": tokEnd, "false": tokFalse, "for": tokFor,
+	"function": tokFunction, "if": tokIf, "in": tokIn, "local": tokLocal,
+	"nil": tokNil, "not": tokNot, "or": tokOr, "repeat": tokRepeat,
+	"return": tokReturn, "then": tokThen, "true": tokTrue, "until": tokUntil,
+	"while": tokWhile,
+}
+
+// token is a lexical token. For names and strings text holds the name or
+// the decoded string, and for numbers num holds the value.
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	line int
+}
+
+// String returns the token as it appears in error messages.
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "<eof>"
+	case tokName, tokString:
+		return t.text
+	case tokNumber:
+		return FormatNumber(t.num)
+	case tokConcat:
+		return ".."
+	case tokDots:
+		return "..."
+	case tokEq:
+		return "=="
+	case tokGe:
+		return ">="
+	case tokLe:
+		return "<="
+	case tokNe:
+		return "~="
+	}
+	for word, kind := range keywords {
+		if kind == t.kind {
+			return word
+		}
+	}
+	return string(rune(t.kind))
+}
+
+// lexer splits Lua source code into tokens.
+type lexer struct {
+	chunk string
+	src   string
+	pos   int
+	line  int
+}
+
+// SyntaxError is returned when a script fails to compile.
+type SyntaxError struct {
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Message
+}
+
+// errorf aborts compilation with a syntax error at the current line.
+func (l *lexer) errorf(line int, format string, args ...any) {
+	panic(&SyntaxError{fmt.Sprintf("%s:%d: %s", l.chunk, line, fmt.Sprintf(format, args...))})
+}
+
+// peekByte returns the byte at offset from the current position, or 0 past
+// the end of the source.
+func (l *lexer) peekByte(offset int) byte {
+	if l.pos+offset < len(l.src) {
+		return l.src[l.pos+offset]
+	}
+	return 0
+}
+
+// next returns the next token.
+func (l *lexer) next() token {
+	l.skipSpaceAndComments()
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, line: l.line}
+	}
+	line := l.line
+	c := l.src[l.pos]
+	switch {
+	case isAlpha(c):
+		start := l.pos
+		for l.pos < len(l.src) && (isAlpha(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		word := l.src[start:l.pos]
+		if kind, ok := keywords[word]; ok {
+			return token{kind: kind, line: line}
+		}
+		return token{kind: tokName, text: word, line: line}
+	case isDigit(c) || (c == '.' && isDigit(l.peekByte(1))):
+		return l.number()
+	case c == '"' || c == '\'':
+		return token{kind: tokString, text: l.shortString(c), line: line}
+	case c == '[' && (l.peekByte(1) == '[' || l.peekByte(1) == '='):
+		if level, ok := l.longBracketLevel(); ok {
+			return token{kind: tokString, text: l.longString(level), line: line}
+		}
+	}
+
+	two := ""
+	if l.pos+1 < len(l.src) {
+		two = l.src[l.pos : l.pos+2]
+	}
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokDots, line: line}
+	case two == "..":
+		l.pos += 2
+		return token{kind: tokConcat, line: line}
+	case two == "==":
+		l.pos += 2
+		return token{kind: tokEq, line: line}
+	case two == ">=":
+		l.pos += 2
+		return token{kind: tokGe, line: line}
+	case two == "<=":
+		l.pos += 2
+		return token{kind: tokLe, line: line}
+	case two == "~=":
+		l.pos += 2
+		return token{kind: tokNe, line: line}
+	}
+	if strings.IndexByte("+-*/%^#=<>(){}[];:,.", c) < 0 {
+		l.errorf(line, "unexpected symbol near '%c'", c)
+	}
+	l.pos++
+	return token{kind: tokenKind(c), line: line}
+}
+
+// skipSpaceAndComments skips whitespace, comments and a leading "#!" line.
+func (l *lexer) skipSpaceAndComments() {
+	if l.pos == 0 && strings.HasPrefix(l.src, "#") {
+		for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+			l.pos++
+		}
+	}
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			l.pos++
+		case c == '-' && l.peekByte(1) == '-':
+			l.pos += 2
+			if l.peekByte(0) == '[' {
+				if level, ok := l.longBracketLevel(); ok {
+					l.longString(level)
+					continue
+				}
+			}
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// longBracketLevel checks for an opening long bracket "[" "="* "[" at the
+// current position and returns its level without consuming it.
+func (l *lexer) longBracketLevel() (int, bool) {
+	level := 0
+	for l.peekByte(1+level) == '=' {
+		level++
+	}
+	return level, l.peekByte(1+level) == '['
+}
+
+// longString reads a long string or comment of the given level. A newline
+// right after the opening bracket is skipped.
+func (l *lexer) longString(level int) string {
+	line := l.line
+	l.pos += level + 2
+	if l.peekByte(0) == '\r' {
+		l.pos++
+	}
+	if l.peekByte(0) == '\n' {
+		l.line++
+		l.pos++
+	}
+	closing := "]" + strings.Repeat("=", level) + "]"
+	end := strings.Index(l.src[l.pos:], closing)
+	if end < 0 {
+		l.errorf(line, "unfinished long string")
+	}
+	s := l.src[l.pos : l.pos+end]
+	l.line += strings.Count(s, "\n")
+	l.pos += end + len(closing)
+	return s
+}
+
+// shortString reads a string delimited by quote, decoding escape sequences.
+func (l *lexer) shortString(quote byte) string {
+	line := l.line
+	l.pos++
+	var sb strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			l.errorf(line, "unfinished string")
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == quote:
+			l.pos++
+			return sb.String()
+		case c == '\n':
+			l.errorf(line, "unfinished string")
+		case c == '\\':
+			l.pos++
+			l.escape(&sb, line)
+		default:
+			sb.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+// escape decodes the escape sequence following a backslash.
+func (l *lexer) escape(sb *strings.Builder, line int) {
+	if l.pos >= len(l.src) {
+		l.errorf(line, "unfinished string")
+	}
+	c := l.src[l.pos]
+	l.pos++
+	switch c {
+	case 'a':
+		sb.WriteByte('\a')
+	case 'b':
+		sb.WriteByte('\b')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'r':
+		sb.WriteByte('\r')
+	case 't':
+		sb.WriteByte('\t')
+	case 'v':
+		sb.WriteByte('\v')
+	case '\\', '"', '\'':
+		sb.WriteByte(c)
+	case '\n':
+		l.line++
+		sb.WriteByte('\n')
+	default:
+		if !isDigit(c) {
+			// Unknown escapes stand for the character itself.
+			sb.WriteByte(c)
+			return
+		}
+		n := int(c - '0')
+		for i := 0; i < 2 && isDigit(l.peekByte(0)); i++ {
+			n = n*10 + int(l.src[l.pos]-'0')
+			l.pos++
+		}
+		if n > 255 {
+			l.errorf(line, "escape sequence too large")
+		}
+		sb.WriteByte(byte(n))
+	}
+}
+
+// number reads a numeric literal.
+func (l *lexer) number() token {
+	line := l.line
+	start := l.pos
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if (c == '+' || c == '-') && (l.src[l.pos-1] == 'e' || l.src[l.pos-1] == 'E') &&
+			!strings.HasPrefix(strings.ToLower(l.src[start:]), "0x") {
+			l.pos++
+			continue
+		}
+		if !isAlpha(c) && !isDigit(c) && c != '.' {
+			break
+		}
+		l.pos++
+	}
+	text := l.src[start:l.pos]
+	n, ok := ParseNumber(text)
+	if !ok {
+		l.errorf(line, "malformed number near '%s'", text)
+	}
+	return token{kind: tokNumber, num: n, line: line}
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,268 @@
+package lua
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// run compiles and runs src in a new state and formats its results.
+func run(t *testing.T, src string) (string, error) {
+	t.Helper()
+	chunk, err := Compile(src, "test")
+	if err != nil {
+		return "", err
+	}
+	results, err := NewState().Run(chunk)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(results))
+	for i, v := range results {
+		parts[i] = tostring(v)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// TestLanguage tests expressions, statements and closures
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{`return 1 + 2 * 3, 2 ^ 3 ^ 2, -2 ^ 2, 7 % 3, -7 % 3, 10 / 4`, "7 512 -4 1 2 2.5"},
+		{`return "a" .. "b" .. 1, #"abc", "10" + 1, 1 == 1.0, "a" < "b"`, "ab1 3 11 true true"},
+		{`return nil or false, false or "x", 1 and 2, nil and 1, not 0`, "false x 2 nil false"},
+		{`local t = {} for i = 1, 5 do t[#t + 1] = i * i end return table.concat(t, ",")`, "1,4,9,16,25"},
+		{`local s = 0 for i = 10, 1, -3 do s = s + i end return s`, "22"},
+		{`local s = 0 for _, v in ipairs({1, 2, nil, 4}) do s = s + v end return s`, "3"},
+		{`local n = 0 for k, v in pairs({a = 1, b = 2, 3}) do n = n + v end return n`, "6"},
+		{`local i = 0 repeat local j = i i = i + 1 until j >= 3 return i`, "4"},
+		{`local i = 0 while true do i = i + 1 if i == 5 then break end end return i`, "5"},
+		{`local function fib(n) if n < 2 then return n end return fib(n - 1) + fib(n - 2) end return fib(15)`, "610"},
+		{`local fs = {} for i = 1, 3 do fs[i] = function() return i end end return fs[1](), fs[3]()`, "1 3"},
+		{`local function counter() local n = 0 return function() n = n + 1 return n end end
+		  local c = counter() c() return c(), counter()()`, "2 1"},
+		{`local function f(...) local a, b = ... return select('#', ...), a, b end return f(1, nil, 3)`, "3 1 nil"},
+		{`local function f() return 1, 2 end return ({f(), f()})[3], (f())`, "2 1"},
+		{`local a, b, c = (function() return 1, 2, 3 end)() return c, b, a`, "3 2 1"},
+		{`local a, b = 1, 2 a, b = b, a return a, b`, "2 1"},
+		{`local t = {x = {y = 1}} t.x.y = t.x.y + 1 t["z"] = 3 return t.x.y, t.z`, "2 3"},
+		{`local obj = {n = 2} function obj:double() return self.n * 2 end return obj:double()`, "4"},
+		{`local t = {[1] = "a", [2] = "b", n = 2} return #t, t.n`, "2 2"},
+		{`return [[long
+string]], "\65\066\x", 0x10, 1e2`, "long\nstring ABx 16 100"},
+	}
+	for _, tt := range tests {
+		got, err := run(t, tt.src)
+		if err != nil {
+			t.Errorf("For %q unexpected error: %v", tt.src, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("For %q expected %q, got %q", tt.src, tt.expected, got)
+		}
+	}
+}
+
+// TestLibraries tests the string, table, math, bit and cjson libraries
+func TestLibraries(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{`return string.format("%d|%5.2f|%-3s|%q|%x|%g", 42, 3.14159, "x", 'a"b', 255, 0.1)`, `42| 3.14|x  |"a\"b"|ff|0.1`},
+		{`return ("hello"):upper(), ("Hello"):lower(), ("abc"):rep(2), ("abc"):reverse(), ("abc"):len()`, "HELLO hello abcabc cba 3"},
+		{`return ("hello"):sub(2, -2), ("hello"):sub(-3), ("hello"):byte(1), string.char(104, 105)`, "ell llo 104 hi"},
+		{`return (string.find("hello world", "o w")), string.find("a.b", ".", 1, true)`, "5 2 2"},
+		{`return string.find("key=value", "(%w+)=(%w+)")`, "1 9 key value"},
+		{`return string.match("  trim  ", "^%s*(.-)%s*$"), string.match("2024-01-02", "(%d+)-(%d+)")`, "trim 2024 01"},
+		{`return string.gsub("hello world", "(%w+)", "<%1>")`, "<hello> <world> 2"},
+		{`return string.gsub("abc", "", "-")`, "-a-b-c- 4"},
+		{`return string.gsub("$a $b", "%$(%w+)", {a = 1}), string.gsub("x", "x", function() end)`, "1 $b x 1"},
+		{`local r = {} for k, v in string.gmatch("a=1, b=2", "(%w+)=(%w+)") do r[#r + 1] = k .. v end return table.concat(r, ";")`, "a1;b2"},
+		{`return string.match("f(a(b)c)", "%b()"), string.find("THE (quick) fox", "%f[%a]%a+", 5)`, "(a(b)c) 6 10"},
+		{`local t = {5, 2, 8, 1} table.sort(t) return table.concat(t, " ")`, "1 2 5 8"},
+		{`local t = {5, 2, 8, 1} table.sort(t, function(a, b) return a > b end) return unpack(t)`, "8 5 2 1"},
+		{`local t = {1, 2, 3} table.insert(t, 4) table.insert(t, 1, 0) local v = table.remove(t, 2) return table.concat(t, ","), v`, "0,2,3,4 1"},
+		{`return math.max(1, 5, 3), math.min(2, -1), math.floor(3.7), math.ceil(3.2), math.abs(-2), math.huge`, "5 -1 3 4 2 inf"},
+		{`return tostring(nil), tostring(1.5), tonumber("0x10"), tonumber("z", 36), tonumber("abc"), type({})`, "nil 1.5 16 35 nil table"},
+		{`return select(-1, 1, 2, 3), select('#'), rawequal("a", "a"), rawget({x = 1}, "x")`, "3 0 true 1"},
+		{`return math.floor(2^70), math.floor(-2.5), 2^53 + 1`, "1.1805916207174e+21 -3 9.007199254741e+15"},
+		{`return bit.band(0xff, 0x0f), bit.bor(1, 2, 4), bit.bxor(3, 1), bit.bnot(0), bit.tobit(2^32 + 1)`, "15 7 2 -1 1"},
+		{`return bit.lshift(1, 31), bit.rshift(-1, 28), bit.arshift(-16, 2), bit.rol(0x80000000, 1), bit.ror(1, 1)`, "-2147483648 15 -4 1 -2147483648"},
+		{`return bit.tohex(255), bit.tohex(-1, -4), bit.tohex(0x1234, 2), bit.bswap(0x12345678) == 0x78563412`, "000000ff FFFF 34 true"},
+		{`return cjson.encode({1, "a/b", true, {}}), cjson.encode({x = {y = cjson.null}}), cjson.encode(0.1)`, `[1,"a\/b",true,{}] {"x":{"y":null}} 0.1`},
+		{`return cjson.encode("\1\n\"")`, `"\u0001\n\""`},
+		{`local v = cjson.decode('{"a": [1, 2.5, "x\\u00e9"], "b": null, "c": false}')
+		  return v.a[1], v.a[2], v.a[3], v.b == cjson.null, v.c, #v.a`, "1 2.5 xé true false 3"},
+		{`return cjson.decode('"\\ud83d\\ude00"'), cjson.encode(cjson.decode('[1,[2,[3]]]'))`, "😀 [1,[2,[3]]]"},
+	}
+	for _, tt := range tests {
+		got, err := run(t, tt.src)
+		if err != nil {
+			t.Errorf("For %q unexpected error: %v", tt.src, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("For %q expected %q, got %q", tt.src, tt.expected, got)
+		}
+	}
+}
+
+// TestErrors tests syntax errors, runtime errors and pcall
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{`return (`, "test:1: unexpected symbol near <eof>"},
+		{`if x then`, "test:1: 'end' expected near <eof>"},
+		{"for i = 1, 2 do\n\nx = 1", "test:3: 'end' expected (to close 'for' at line 1) near <eof>"},
+		{`break`, "test:1: no loop to break near <eof>"},
+		{`x = "abc`, "test:1: unfinished string"},
+		{`local t = nil return t.x`, "test:1: attempt to index local 't' (a nil value)"},
+		{"\nundefined()", "test:2: attempt to call global 'undefined' (a nil value)"},
+		{`return {} + 1`, "test:1: attempt to perform arithmetic on a table value"},
+		{`return 1 < "x"`, "test:1: attempt to compare number with string"},
+		{`return ("x"):rep()`, "test:1: bad argument #2 to 'rep' (number expected, got no value)"},
+		{`error("boom")`, "test:1: boom"},
+		{`error("boom", 0)`, "boom"},
+		{`local function f() f() end f()`, "test:1: stack overflow"},
+		{`return string.find("a", "(")`, "test:1: unfinished capture"},
+		{`return string.find("a", "[a")`, "test:1: malformed pattern (missing ']')"},
+		{`return cjson.encode({[1] = 1, [20] = 2})`, "test:1: Cannot serialise table: excessively sparse array"},
+		{`return cjson.encode(function() end)`, "test:1: Cannot serialise function: type not supported"},
+		{`return cjson.encode(0/0)`, "test:1: Cannot serialise number: must not be NaN or Inf"},
+		{`return cjson.decode('{"a" 1}')`, "test:1: Expected colon but found number at character 6"},
+		{`return cjson.decode('[1, 2')`, "test:1: Expected comma or array end but found end at character 6"},
+		{`return cjson.decode('[1] x')`, "test:1: Expected the end but found invalid token at character 5"},
+		{`return cjson.decode(string.rep("[", 1001))`, "test:1: Found too many nested data structures (1001) at character 1001"},
+		{`return setmetatable({}, 1)`, "test:1: bad argument #2 to 'setmetatable' (nil or table expected)"},
+		{`local t = setmetatable({}, {__metatable = 1}) setmetatable(t, {})`, "test:1: cannot change a protected metatable"},
+		{`return {} < {}`, "test:1: attempt to compare two table values"},
+	}
+	for _, tt := range tests {
+		_, err := run(t, tt.src)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("For %q expected error %q, got %v", tt.src, tt.expected, err)
+		}
+	}
+
+	got, err := run(t, `return pcall(function() error("boom") end)`)
+	if err != nil || got != "false test:1: boom" {
+		t.Errorf("Unexpected pcall results %q, %v", got, err)
+	}
+	got, err = run(t, `local ok, e = pcall(error, {code = 1}) return ok, e.code, pcall(function() return 1, 2 end)`)
+	if err != nil || got != "false 1 true 1 2" {
+		t.Errorf("Unexpected pcall results %q, %v", got, err)
+	}
+	got, err = run(t, `return xpcall(function() error("x", 0) end, function(e) return "handled " .. e end)`)
+	if err != nil || got != "false handled x" {
+		t.Errorf("Unexpected xpcall results %q, %v", got, err)
+	}
+}
+
+// TestMetatables tests metamethods and the metatable functions
+func TestMetatables(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{`local t = setmetatable({}, {__index = {x = 1}}) return t.x, rawget(t, "x")`, "1 nil"},
+		{`local t = setmetatable({}, {__index = function(t, k) return k .. "!" end}) return t.a`, "a!"},
+		{`local t = setmetatable({}, {__newindex = function(t, k, v) rawset(t, k, v * 2) end})
+		  t.x = 1 local first = t.x t.x = 5 return first, t.x`, "2 5"},
+		{`local store = {} local t = setmetatable({}, {__newindex = store}) t.x = 1 return rawget(t, "x"), store.x`, "nil 1"},
+		{`local t = setmetatable({}, {__call = function(self, a, b) return a + b end}) return t(1, 2)`, "3"},
+		{`local mt = {} mt.__add = function(a, b) return setmetatable({v = a.v + b.v}, mt) end
+		  mt.__unm = function(a) return -a.v end mt.__concat = function(a, b) return "c" end
+		  local a, b = setmetatable({v = 1}, mt), setmetatable({v = 2}, mt) return (a + b).v, -a, a .. "x", 1 .. a`, "3 -1 c c"},
+		{`local mt = {__eq = function() return true end, __lt = function(a, b) return a.v < b.v end}
+		  local a, b = setmetatable({v = 1}, mt), setmetatable({v = 2}, mt)
+		  return a == b, a ~= b, a < b, a <= b, a > b, a == {}`, "true false true true false false"},
+		{`local t = setmetatable({}, {__tostring = function() return "T" end}) return tostring(t)`, "T"},
+		{`local mt = {} local t = setmetatable({}, mt) return getmetatable(t) == mt, getmetatable(1)`, "true nil"},
+		{`return getmetatable(setmetatable({}, {__metatable = "locked"}))`, "locked"},
+		{`local t = setmetatable({}, {}) setmetatable(t, nil) return getmetatable(t)`, "nil"},
+	}
+	for _, tt := range tests {
+		got, err := run(t, tt.src)
+		if err != nil {
+			t.Errorf("For %q unexpected error: %v", tt.src, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("For %q expected %q, got %q", tt.src, tt.expected, got)
+		}
+	}
+}
+
+// TestStrictGlobals tests that strict states reject undefined globals
+func TestStrictGlobals(t *testing.T) {
+	s := NewState()
+	s.SetGlobal("defined", 1.0)
+	s.SetStrictGlobals(true)
+	for src, expected := range map[string]string{
+		`return undefined`: "test:1: Script attempted to access nonexistent global variable 'undefined'",
+		`created = 1`:      "test:1: Script attempted to create global variable 'created'",
+	} {
+		chunk, err := Compile(src, "test")
+		if err != nil {
+			t.Fatalf("Unexpected compile error: %v", err)
+		}
+		if _, err := s.Run(chunk); err == nil || err.Error() != expected {
+			t.Errorf("For %q expected error %q, got %v", src, expected, err)
+		}
+	}
+	chunk, _ := Compile(`defined = defined + 1 local x = 2 return defined + x`, "test")
+	if results, err := s.Run(chunk); err != nil || fmt.Sprint(results) != "[4]" {
+		t.Errorf("Expected [4], got %v, %v", results, err)
+	}
+}
+
+// TestInterrupt tests that the interrupt hook stops a script, even through
+// pcall
+func TestInterrupt(t *testing.T) {
+	s := NewState()
+	calls := 0
+	stop := errors.New("stopped")
+	s.SetInterrupt(func() error {
+		if calls++; calls == 3 {
+			return stop
+		}
+		return nil
+	})
+	chunk, err := Compile(`while true do pcall(function() while true do end end) end`, "test")
+	if err != nil {
+		t.Fatalf("Unexpected compile error: %v", err)
+	}
+	if _, err := s.Run(chunk); err == nil || err.Error() != "stopped" {
+		t.Errorf("Expected the script to be stopped, got %v", err)
+	}
+}
+
+// TestTable tests the array and hash parts of tables and their traversal
+func TestTable(t *testing.T) {
+	tbl := NewTable()
+	tbl.Set(2.0, "b")
+	tbl.Set("k", "v")
+	tbl.Set(1.0, "a")
+	if tbl.Len() != 2 {
+		t.Errorf("Expected the integer keys to move to the array part, got length %d", tbl.Len())
+	}
+	var keys []string
+	tbl.ForEach(func(k, _ Value) { keys = append(keys, tostring(k)) })
+	if fmt.Sprint(keys) != "[1 2 k]" {
+		t.Errorf("Unexpected traversal order %v", keys)
+	}
+	tbl.Set(2.0, nil)
+	tbl.Set("k", nil)
+	if k, _, ok := tbl.Next(1.0); !ok || k != nil {
+		t.Errorf("Expected the traversal to end after key 1, got %v, %v", k, ok)
+	}
+	if _, _, ok := tbl.Next("missing"); ok {
+		t.Errorf("Expected Next to reject a missing key")
+	}
+}
@@ -0,0 +1,100 @@
+package lua
+
+import (
+	"math"
+	"math/rand"
+)
+
+func (s *State) openMath() {
+	t := NewTable()
+	s.SetGlobal("math", t)
+	t.SetString("pi", math.Pi)
+	t.SetString("huge", math.Inf(1))
+	// Scripts must behave the same on every run, so the generator starts
+	// from a fixed seed unless math.randomseed is called.
+	rng := rand.New(rand.NewSource(0))
+	unary := func(name string, fn func(float64) float64) GoFunction {
+		return func(s *State, args []Value) []Value {
+			return []Value{fn(s.checkNumber(args, 0, name))}
+		}
+	}
+	register(t, map[string]GoFunction{
+		"abs":   unary("abs", math.Abs),
+		"acos":  unary("acos", math.Acos),
+		"asin":  unary("asin", math.Asin),
+		"atan":  unary("atan", math.Atan),
+		"ceil":  unary("ceil", math.Ceil),
+		"cos":   unary("cos", math.Cos),
+		"cosh":  unary("cosh", math.Cosh),
+		"deg":   unary("deg", func(x float64) float64 { return x * 180 / math.Pi }),
+		"exp":   unary("exp", math.Exp),
+		"floor": unary("floor", math.Floor),
+		"log":   unary("log", math.Log),
+		"log10": unary("log10", math.Log10),
+		"rad":   unary("rad", func(x float64) float64 { return x * math.Pi / 180 }),
+		"sin":   unary("sin", math.Sin),
+		"sinh":  unary("sinh", math.Sinh),
+		"sqrt":  unary("sqrt", math.Sqrt),
+		"tan":   unary("tan", math.Tan),
+		"tanh":  unary("tanh", math.Tanh),
+		"atan2": func(s *State, args []Value) []Value {
+			return []Value{math.Atan2(s.checkNumber(args, 0, "atan2"), s.checkNumber(args, 1, "atan2"))}
+		},
+		"fmod": func(s *State, args []Value) []Value {
+			return []Value{math.Mod(s.checkNumber(args, 0, "fmod"), s.checkNumber(args, 1, "fmod"))}
+		},
+		"frexp": func(s *State, args []Value) []Value {
+			frac, exp := math.Frexp(s.checkNumber(args, 0, "frexp"))
+			return []Value{frac, float64(exp)}
+		},
+		"ldexp": func(s *State, args []Value) []Value {
+			return []Value{math.Ldexp(s.checkNumber(args, 0, "ldexp"), s.checkInt(args, 1, "ldexp"))}
+		},
+		"max": func(s *State, args []Value) []Value {
+			m := s.checkNumber(args, 0, "max")
+			for i := 1; i < len(args); i++ {
+				m = max(m, s.checkNumber(args, i, "max"))
+			}
+			return []Value{m}
+		},
+		"min": func(s *State, args []Value) []Value {
+			m := s.checkNumber(args, 0, "min")
+			for i := 1; i < len(args); i++ {
+				m = min(m, s.checkNumber(args, i, "min"))
+			}
+			return []Value{m}
+		},
+		"modf": func(s *State, args []Value) []Value {
+			i, frac := math.Modf(s.checkNumber(args, 0, "modf"))
+			return []Value{i, frac}
+		},
+		"pow": func(s *State, args []Value) []Value {
+			return []Value{math.Pow(s.checkNumber(args, 0, "pow"), s.checkNumber(args, 1, "pow"))}
+		},
+		"random": func(s *State, args []Value) []Value {
+			r := rng.Float64()
+			switch len(args) {
+			case 0:
+				return []Value{r}
+			case 1:
+				m := s.checkInt(args, 0, "random")
+				if m < 1 {
+					s.argError(0, "random", "interval is empty")
+				}
+				return []Value{math.Floor(r*float64(m)) + 1}
+			case 2:
+				lo, hi := s.checkInt(args, 0, "random"), s.checkInt(args, 1, "random")
+				if lo > hi {
+					s.argError(1, "random", "interval is empty")
+				}
+				return []Value{math.Floor(r*float64(hi-lo+1)) + float64(lo)}
+			}
+			s.Raise("wrong number of arguments")
+			return nil
+		},
+		"randomseed": func(s *State, args []Value) []Value {
+			rng.Seed(int64(s.checkNumber(args, 0, "randomseed")))
+			return nil
+		},
+	})
+}
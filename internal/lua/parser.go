@@ -0,0 +1,644 @@
+package lua
+
+// Chunk is a compiled script, ready to be run by any State.
+type Chunk struct {
+	proto *funcProto
+}
+
+// Compile parses the source code of a script. chunkName prefixes the
+// positions of syntax and runtime errors, as in "chunkName:3: message".
+func Compile(src, chunkName string) (chunk *Chunk, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*SyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = se
+		}
+	}()
+	p := &parser{lex: &lexer{chunk: chunkName, src: src, line: 1}}
+	p.advance()
+	fs := p.openFunction("main chunk", 0)
+	fs.proto.isVararg = true
+	fs.proto.body = p.block()
+	if p.tok.kind != tokEOF {
+		p.errorExpected("<eof>")
+	}
+	p.closeFunction()
+	return &Chunk{proto: fs.proto}, nil
+}
+
+// parser is a recursive descent parser for Lua, resolving variables as it
+// goes.
+type parser struct {
+	lex      *lexer
+	tok      token
+	ahead    token
+	hasAhead bool
+	fs       *funcState
+}
+
+// funcState tracks the function being parsed: its scopes of local variables
+// and the upvalues it captures.
+type funcState struct {
+	parent     *funcState
+	proto      *funcProto
+	scopes     [][]localVar
+	upvalNames map[string]int
+	loops      int
+}
+
+type localVar struct {
+	name string
+	slot int
+}
+
+// advance moves to the next token.
+func (p *parser) advance() {
+	if p.hasAhead {
+		p.tok, p.hasAhead = p.ahead, false
+		return
+	}
+	p.tok = p.lex.next()
+}
+
+// peek returns the token after the current one without consuming it.
+func (p *parser) peek() token {
+	if !p.hasAhead {
+		p.ahead, p.hasAhead = p.lex.next(), true
+	}
+	return p.ahead
+}
+
+// near describes the current token in an error message.
+func (p *parser) near() string {
+	if p.tok.kind == tokEOF {
+		return "<eof>"
+	}
+	return "'" + p.tok.String() + "'"
+}
+
+func (p *parser) errorf(format string, args ...any) {
+	p.lex.errorf(p.tok.line, format, args...)
+}
+
+func (p *parser) errorExpected(what string) {
+	if what != "<eof>" {
+		what = "'" + what + "'"
+	}
+	p.errorf("%s expected near %s", what, p.near())
+}
+
+// accept consumes the current token if it is of the given kind.
+func (p *parser) accept(kind tokenKind) bool {
+	if p.tok.kind != kind {
+		return false
+	}
+	p.advance()
+	return true
+}
+
+// expect consumes a token of the given kind or fails.
+func (p *parser) expect(kind tokenKind) {
+	if !p.accept(kind) {
+		p.errorExpected(token{kind: kind}.String())
+	}
+}
+
+// expectMatch consumes the token closing a construct opened by open at the
+// given line, mentioning the opening token if it is on another line.
+func (p *parser) expectMatch(kind, open tokenKind, line int) {
+	if p.accept(kind) {
+		return
+	}
+	if line == p.tok.line {
+		p.errorExpected(token{kind: kind}.String())
+	}
+	p.errorf("'%s' expected (to close '%s' at line %d) near %s",
+		token{kind: kind}, token{kind: open}, line, p.near())
+}
+
+func (p *parser) expectName() string {
+	if p.tok.kind != tokName {
+		p.errorExpected("<name>")
+	}
+	name := p.tok.text
+	p.advance()
+	return name
+}
+
+// openFunction starts parsing a nested function.
+func (p *parser) openFunction(name string, line int) *funcState {
+	fs := &funcState{
+		parent:     p.fs,
+		proto:      &funcProto{name: name, chunk: p.lex.chunk, line: line},
+		upvalNames: make(map[string]int),
+	}
+	fs.openScope()
+	p.fs = fs
+	return fs
+}
+
+func (p *parser) closeFunction() {
+	p.fs = p.fs.parent
+}
+
+func (fs *funcState) openScope() {
+	fs.scopes = append(fs.scopes, nil)
+}
+
+func (fs *funcState) closeScope() {
+	fs.scopes = fs.scopes[:len(fs.scopes)-1]
+}
+
+// declare adds a local variable to the innermost scope and returns its slot.
+func (fs *funcState) declare(name string) int {
+	slot := fs.proto.numSlots
+	fs.proto.numSlots++
+	top := len(fs.scopes) - 1
+	fs.scopes[top] = append(fs.scopes[top], localVar{name, slot})
+	return slot
+}
+
+func (fs *funcState) findLocal(name string) (int, bool) {
+	for i := len(fs.scopes) - 1; i >= 0; i-- {
+		vars := fs.scopes[i]
+		for j := len(vars) - 1; j >= 0; j-- {
+			if vars[j].name == name {
+				return vars[j].slot, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// findUpval returns the upvalue index of a variable of an enclosing
+// function, capturing it on first use.
+func (fs *funcState) findUpval(name string) (int, bool) {
+	if i, ok := fs.upvalNames[name]; ok {
+		return i, true
+	}
+	if fs.parent == nil {
+		return 0, false
+	}
+	var desc upvalDesc
+	if slot, ok := fs.parent.findLocal(name); ok {
+		desc = upvalDesc{fromLocal: true, index: slot}
+	} else if i, ok := fs.parent.findUpval(name); ok {
+		desc = upvalDesc{index: i}
+	} else {
+		return 0, false
+	}
+	i := len(fs.proto.upvals)
+	fs.proto.upvals = append(fs.proto.upvals, desc)
+	fs.upvalNames[name] = i
+	return i, true
+}
+
+// resolve returns the expression reading the variable name.
+func (fs *funcState) resolve(name string) expr {
+	if slot, ok := fs.findLocal(name); ok {
+		return &localExpr{name: name, slot: slot}
+	}
+	if i, ok := fs.findUpval(name); ok {
+		return &upvalExpr{name: name, index: i}
+	}
+	return &globalExpr{name: name}
+}
+
+// blockFollow reports whether the current token ends a block.
+func (p *parser) blockFollow() bool {
+	switch p.tok.kind {
+	case tokElse, tokElseif, tokEnd, tokUntil, tokEOF:
+		return true
+	}
+	return false
+}
+
+// block parses a block in a new scope.
+func (p *parser) block() block {
+	p.fs.openScope()
+	defer p.fs.closeScope()
+	return p.statements()
+}
+
+// statements parses statements up to the end of the block. return and break
+// must be the last statement of a block.
+func (p *parser) statements() block {
+	var stmts block
+	for !p.blockFollow() {
+		switch p.tok.kind {
+		case ';':
+			p.advance()
+			continue
+		case tokReturn:
+			stmts = append(stmts, p.returnStmt())
+			return stmts
+		case tokBreak:
+			p.advance()
+			if p.fs.loops == 0 {
+				p.errorf("no loop to break near %s", p.near())
+			}
+			p.accept(';')
+			return append(stmts, &breakStmt{})
+		}
+		stmts = append(stmts, p.statement())
+	}
+	return stmts
+}
+
+func (p *parser) statement() stmt {
+	line := p.tok.line
+	switch p.tok.kind {
+	case tokIf:
+		return p.ifStmt(line)
+	case tokWhile:
+		p.advance()
+		cond := p.expr(0)
+		p.expect(tokDo)
+		body := p.loopBody()
+		p.expectMatch(tokEnd, tokWhile, line)
+		return &whileStmt{cond: cond, body: body}
+	case tokDo:
+		p.advance()
+		body := p.block()
+		p.expectMatch(tokEnd, tokDo, line)
+		return &doStmt{body: body}
+	case tokFor:
+		return p.forStmt(line)
+	case tokRepeat:
+		p.advance()
+		p.fs.loops++
+		p.fs.openScope()
+		body := p.statements()
+		p.expectMatch(tokUntil, tokRepeat, line)
+		p.fs.loops--
+		cond := p.expr(0)
+		p.fs.closeScope()
+		return &repeatStmt{body: body, cond: cond}
+	case tokFunction:
+		return p.functionStmt(line)
+	case tokLocal:
+		p.advance()
+		if p.accept(tokFunction) {
+			name := p.expectName()
+			slot := p.fs.declare(name)
+			return &localFuncStmt{slot: slot, proto: p.funcBody(name, false, line)}
+		}
+		return p.localStmt(line)
+	}
+	return p.exprStmt(line)
+}
+
+// loopBody parses the block of a loop, where break is allowed.
+func (p *parser) loopBody() block {
+	p.fs.loops++
+	defer func() { p.fs.loops-- }()
+	return p.block()
+}
+
+func (p *parser) ifStmt(line int) stmt {
+	s := &ifStmt{}
+	for {
+		p.advance() // if or elseif
+		s.conds = append(s.conds, p.expr(0))
+		p.expect(tokThen)
+		s.blocks = append(s.blocks, p.block())
+		if p.tok.kind != tokElseif {
+			break
+		}
+	}
+	if p.accept(tokElse) {
+		s.orElse = p.block()
+	}
+	p.expectMatch(tokEnd, tokIf, line)
+	return s
+}
+
+func (p *parser) forStmt(line int) stmt {
+	p.advance()
+	name := p.expectName()
+	if p.accept('=') {
+		s := &numForStmt{line: line}
+		s.start = p.expr(0)
+		p.expect(',')
+		s.limit = p.expr(0)
+		if p.accept(',') {
+			s.step = p.expr(0)
+		}
+		p.expect(tokDo)
+		p.fs.openScope()
+		s.slot = p.fs.declare(name)
+		s.body = p.loopBody()
+		p.fs.closeScope()
+		p.expectMatch(tokEnd, tokFor, line)
+		return s
+	}
+	names := []string{name}
+	for p.accept(',') {
+		names = append(names, p.expectName())
+	}
+	if p.tok.kind != tokIn {
+		p.errorExpected("=' or 'in")
+	}
+	p.advance()
+	s := &genForStmt{line: line, exprs: p.exprList()}
+	p.expect(tokDo)
+	p.fs.openScope()
+	for _, name := range names {
+		s.slots = append(s.slots, p.fs.declare(name))
+	}
+	s.body = p.loopBody()
+	p.fs.closeScope()
+	p.expectMatch(tokEnd, tokFor, line)
+	return s
+}
+
+// functionStmt parses "function a.b.c:m() ... end" into an assignment.
+func (p *parser) functionStmt(line int) stmt {
+	p.advance()
+	name := p.expectName()
+	fullName := name
+	target := p.fs.resolve(name)
+	method := false
+	for p.tok.kind == '.' || p.tok.kind == ':' {
+		method = p.tok.kind == ':'
+		p.advance()
+		key := p.expectName()
+		fullName += "." + key
+		target = &indexExpr{obj: target, key: &stringExpr{key}, line: line}
+		if method {
+			break
+		}
+	}
+	fn := &funcExpr{p.funcBody(fullName, method, line)}
+	return &assignStmt{targets: []expr{target}, exprs: []expr{fn}, line: line}
+}
+
+func (p *parser) localStmt(line int) stmt {
+	names := []string{p.expectName()}
+	for p.accept(',') {
+		names = append(names, p.expectName())
+	}
+	s := &localStmt{line: line}
+	if p.accept('=') {
+		s.exprs = p.exprList()
+	}
+	// The new variables are only visible after the statement.
+	for _, name := range names {
+		s.slots = append(s.slots, p.fs.declare(name))
+	}
+	return s
+}
+
+func (p *parser) returnStmt() stmt {
+	line := p.tok.line
+	p.advance()
+	s := &returnStmt{line: line}
+	if !p.blockFollow() && p.tok.kind != ';' {
+		s.exprs = p.exprList()
+	}
+	p.accept(';')
+	return s
+}
+
+// exprStmt parses an assignment or a function call.
+func (p *parser) exprStmt(line int) stmt {
+	e := p.suffixedExpr()
+	if p.tok.kind != '=' && p.tok.kind != ',' {
+		switch e.(type) {
+		case *callExpr, *methodCallExpr:
+			return &callStmt{call: e}
+		}
+		p.errorf("syntax error near %s", p.near())
+	}
+	targets := []expr{e}
+	for p.accept(',') {
+		targets = append(targets, p.suffixedExpr())
+	}
+	for _, t := range targets {
+		switch t.(type) {
+		case *localExpr, *upvalExpr, *globalExpr, *indexExpr:
+		default:
+			p.errorf("syntax error near %s", p.near())
+		}
+	}
+	p.expect('=')
+	return &assignStmt{targets: targets, exprs: p.exprList(), line: line}
+}
+
+func (p *parser) exprList() []expr {
+	list := []expr{p.expr(0)}
+	for p.accept(',') {
+		list = append(list, p.expr(0))
+	}
+	return list
+}
+
+// binaryPriority returns the left and right priorities of a binary
+// operator, as in the reference implementation.
+func binaryPriority(op tokenKind) (int, int, bool) {
+	switch op {
+	case tokOr:
+		return 1, 1, true
+	case tokAnd:
+		return 2, 2, true
+	case '<', '>', tokLe, tokGe, tokNe, tokEq:
+		return 3, 3, true
+	case tokConcat:
+		return 5, 4, true
+	case '+', '-':
+		return 6, 6, true
+	case '*', '/', '%':
+		return 7, 7, true
+	case '^':
+		return 10, 9, true
+	}
+	return 0, 0, false
+}
+
+const unaryPriority = 8
+
+// expr parses an expression whose binary operators bind tighter than limit.
+func (p *parser) expr(limit int) expr {
+	var left expr
+	switch op := p.tok.kind; op {
+	case tokNot, '-', '#':
+		line := p.tok.line
+		p.advance()
+		operand := p.expr(unaryPriority)
+		if n, ok := operand.(*numberExpr); ok && op == '-' {
+			left = &numberExpr{-n.value}
+		} else {
+			left = &unaryExpr{op: op, operand: operand, line: line}
+		}
+	default:
+		left = p.simpleExpr()
+	}
+	for {
+		op := p.tok.kind
+		lp, rp, ok := binaryPriority(op)
+		if !ok || lp <= limit {
+			return left
+		}
+		line := p.tok.line
+		p.advance()
+		right := p.expr(rp)
+		left = &binaryExpr{op: op, left: left, right: right, line: line}
+	}
+}
+
+func (p *parser) simpleExpr() expr {
+	t := p.tok
+	switch t.kind {
+	case tokNumber:
+		p.advance()
+		return &numberExpr{t.num}
+	case tokString:
+		p.advance()
+		return &stringExpr{t.text}
+	case tokNil:
+		p.advance()
+		return &nilExpr{}
+	case tokTrue:
+		p.advance()
+		return &trueExpr{}
+	case tokFalse:
+		p.advance()
+		return &falseExpr{}
+	case tokDots:
+		if !p.fs.proto.isVararg {
+			p.errorf("cannot use '...' outside a vararg function near '...'")
+		}
+		p.advance()
+		return &varargExpr{}
+	case '{':
+		return p.tableConstructor()
+	case tokFunction:
+		p.advance()
+		return &funcExpr{p.funcBody("anonymous", false, t.line)}
+	}
+	return p.suffixedExpr()
+}
+
+func (p *parser) primaryExpr() expr {
+	switch p.tok.kind {
+	case tokName:
+		name := p.tok.text
+		p.advance()
+		return p.fs.resolve(name)
+	case '(':
+		line := p.tok.line
+		p.advance()
+		e := p.expr(0)
+		p.expectMatch(')', '(', line)
+		return &parenExpr{e}
+	}
+	p.errorf("unexpected symbol near %s", p.near())
+	return nil
+}
+
+// suffixedExpr parses a primary expression followed by field accesses,
+// indexing and calls.
+func (p *parser) suffixedExpr() expr {
+	e := p.primaryExpr()
+	for {
+		line := p.tok.line
+		switch p.tok.kind {
+		case '.':
+			p.advance()
+			e = &indexExpr{obj: e, key: &stringExpr{p.expectName()}, line: line}
+		case '[':
+			p.advance()
+			key := p.expr(0)
+			p.expect(']')
+			e = &indexExpr{obj: e, key: key, line: line}
+		case ':':
+			p.advance()
+			name := p.expectName()
+			e = &methodCallExpr{obj: e, name: name, args: p.callArgs(), line: line}
+		case '(', tokString, '{':
+			e = &callExpr{fn: e, args: p.callArgs(), line: line}
+		default:
+			return e
+		}
+	}
+}
+
+func (p *parser) callArgs() []expr {
+	switch p.tok.kind {
+	case tokString:
+		s := p.tok.text
+		p.advance()
+		return []expr{&stringExpr{s}}
+	case '{':
+		return []expr{p.tableConstructor()}
+	case '(':
+		line := p.tok.line
+		p.advance()
+		var args []expr
+		if p.tok.kind != ')' {
+			args = p.exprList()
+		}
+		p.expectMatch(')', '(', line)
+		return args
+	}
+	p.errorf("function arguments expected near %s", p.near())
+	return nil
+}
+
+func (p *parser) tableConstructor() expr {
+	line := p.tok.line
+	p.expect('{')
+	t := &tableExpr{line: line}
+	for p.tok.kind != '}' {
+		switch {
+		case p.tok.kind == '[':
+			p.advance()
+			key := p.expr(0)
+			p.expect(']')
+			p.expect('=')
+			t.items = append(t.items, tableItem{key: key, value: p.expr(0)})
+		case p.tok.kind == tokName && p.peek().kind == '=':
+			key := p.tok.text
+			p.advance()
+			p.advance()
+			t.items = append(t.items, tableItem{key: &stringExpr{key}, value: p.expr(0)})
+		default:
+			t.items = append(t.items, tableItem{value: p.expr(0)})
+		}
+		if !p.accept(',') && !p.accept(';') {
+			break
+		}
+	}
+	p.expectMatch('}', '{', line)
+	return t
+}
+
+// funcBody parses the parameters and body of a function. Methods get an
+// implicit self parameter.
+func (p *parser) funcBody(name string, method bool, line int) *funcProto {
+	fs := p.openFunction(name, line)
+	if method {
+		fs.proto.params = append(fs.proto.params, fs.declare("self"))
+	}
+	p.expect('(')
+	if p.tok.kind != ')' {
+		for {
+			if p.accept(tokDots) {
+				fs.proto.isVararg = true
+				break
+			}
+			fs.proto.params = append(fs.proto.params, fs.declare(p.expectName()))
+			if !p.accept(',') {
+				break
+			}
+		}
+	}
+	p.expect(')')
+	fs.proto.body = p.block()
+	p.expectMatch(tokEnd, tokFunction, line)
+	p.closeFunction()
+	return fs.proto
+}
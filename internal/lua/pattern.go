@@ -0,0 +1,350 @@
+package lua
+
+// This file implements Lua patterns, following the matcher of the reference
+// implementation.
+
+const (
+	maxCaptures    = 32
+	capUnfinished  = -1
+	capPosition    = -2
+	patternSpecial = "^$*+?.([%-"
+)
+
+type capture struct {
+	init, len int
+}
+
+// matchState holds the state of a match of a pattern against a subject
+// string. Positions are byte offsets; -1 means no match.
+type matchState struct {
+	s        *State
+	src, pat string
+	level    int
+	captures [maxCaptures]capture
+}
+
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case '%':
+		if p >= len(ms.pat) {
+			ms.s.Raise("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if p < len(ms.pat) && ms.pat[p] == '^' {
+			p++
+		}
+		for {
+			// The first character is part of the set even if it is ']'.
+			if p >= len(ms.pat) {
+				ms.s.Raise("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == '%' && p < len(ms.pat) {
+				p++
+			}
+			if p < len(ms.pat) && ms.pat[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+func matchClass(c, class byte) bool {
+	var res bool
+	switch class | 0x20 {
+	case 'a':
+		res = isAlpha(c) && c != '_'
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = isDigit(c)
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = c > 32 && c < 127 && !isAlpha(c) && !isDigit(c) || c == '_'
+	case 's':
+		res = c == ' ' || (c >= '\t' && c <= '\r')
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = (isAlpha(c) && c != '_') || isDigit(c)
+	case 'x':
+		res = isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'f')
+	case 'z':
+		res = c == 0
+	default:
+		return class == c
+	}
+	if class >= 'A' && class <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+// matchBracketClass matches c against the set between the brackets at p
+// and ec.
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < ec; p++ {
+		switch {
+		case ms.pat[p] == '%':
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		case ms.pat[p+1] == '-' && p+2 < ec:
+			if ms.pat[p] <= c && c <= ms.pat[p+2] {
+				return sig
+			}
+			p += 2
+		case ms.pat[p] == c:
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	}
+	return ms.pat[p] == c
+}
+
+// match matches the pattern from p against the subject from s and returns
+// the end of the match, or -1.
+func (ms *matchState) match(s, p int) int {
+	ms.s.tick()
+	for {
+		if p >= len(ms.pat) {
+			return s
+		}
+		switch ms.pat[p] {
+		case '(':
+			if p+1 < len(ms.pat) && ms.pat[p+1] == ')' {
+				return ms.startCapture(s, p+2, capPosition)
+			}
+			return ms.startCapture(s, p+1, capUnfinished)
+		case ')':
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) {
+				if s == len(ms.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			if p+1 >= len(ms.pat) {
+				break
+			}
+			switch next := ms.pat[p+1]; {
+			case next == 'b':
+				s = ms.matchBalance(s, p+2)
+				if s == -1 {
+					return -1
+				}
+				p += 4
+				continue
+			case next == 'f':
+				p += 2
+				if p >= len(ms.pat) || ms.pat[p] != '[' {
+					ms.s.Raise("missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p)
+				var prev, cur byte
+				if s > 0 {
+					prev = ms.src[s-1]
+				}
+				if s < len(ms.src) {
+					cur = ms.src[s]
+				}
+				if ms.matchBracketClass(prev, p, ep-1) || !ms.matchBracketClass(cur, p, ep-1) {
+					return -1
+				}
+				p = ep
+				continue
+			case isDigit(next):
+				s = ms.matchCapture(s, next)
+				if s == -1 {
+					return -1
+				}
+				p += 2
+				continue
+			}
+		}
+
+		ep := ms.classEnd(p)
+		m := ms.singleMatch(s, p, ep)
+		var op byte
+		if ep < len(ms.pat) {
+			op = ms.pat[ep]
+		}
+		switch op {
+		case '?':
+			if m {
+				if res := ms.match(s+1, ep+1); res != -1 {
+					return res
+				}
+			}
+			p = ep + 1
+		case '*':
+			return ms.maxExpand(s, p, ep)
+		case '+':
+			if !m {
+				return -1
+			}
+			return ms.maxExpand(s+1, p, ep)
+		case '-':
+			return ms.minExpand(s, p, ep)
+		default:
+			if !m {
+				return -1
+			}
+			s++
+			p = ep
+		}
+	}
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if res := ms.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res != -1 {
+			return res
+		}
+		if !ms.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= maxCaptures {
+		ms.s.Raise("too many captures")
+	}
+	ms.captures[ms.level] = capture{init: s, len: what}
+	ms.level++
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.level--
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := -1
+	for i := ms.level - 1; i >= 0; i-- {
+		if ms.captures[i].len == capUnfinished {
+			l = i
+			break
+		}
+	}
+	if l == -1 {
+		ms.s.Raise("invalid pattern capture")
+	}
+	ms.captures[l].len = s - ms.captures[l].init
+	res := ms.match(s, p)
+	if res == -1 {
+		ms.captures[l].len = capUnfinished
+	}
+	return res
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.s.Raise("missing arguments to '%%b'")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	open, close := ms.pat[p], ms.pat[p+1]
+	depth := 1
+	for s++; s < len(ms.src); s++ {
+		switch ms.src[s] {
+		case close:
+			if depth--; depth == 0 {
+				return s + 1
+			}
+		case open:
+			depth++
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) matchCapture(s int, digit byte) int {
+	l := int(digit - '1')
+	if l < 0 || l >= ms.level || ms.captures[l].len == capUnfinished {
+		ms.s.Raise("invalid capture index")
+	}
+	c := ms.captures[l]
+	if len(ms.src)-s >= c.len && ms.src[c.init:c.init+c.len] == ms.src[s:s+c.len] {
+		return s + c.len
+	}
+	return -1
+}
+
+// capture returns capture i of a match from s to e. Without captures, the
+// whole match is capture 0.
+func (ms *matchState) capture(i, s, e int) Value {
+	if i >= ms.level {
+		if i != 0 {
+			ms.s.Raise("invalid capture index")
+		}
+		return ms.src[s:e]
+	}
+	c := ms.captures[i]
+	switch c.len {
+	case capUnfinished:
+		ms.s.Raise("unfinished capture")
+	case capPosition:
+		return float64(c.init + 1)
+	}
+	return ms.src[c.init : c.init+c.len]
+}
+
+// allCaptures returns the captures of a match from s to e, or the whole
+// match if the pattern has none and wholeMatch is set.
+func (ms *matchState) allCaptures(s, e int, wholeMatch bool) []Value {
+	n := ms.level
+	if n == 0 && wholeMatch {
+		n = 1
+	}
+	values := make([]Value, n)
+	for i := range values {
+		values[i] = ms.capture(i, s, e)
+	}
+	return values
+}
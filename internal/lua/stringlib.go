@@ -0,0 +1,322 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// strPos converts a possibly negative string position to an absolute one.
+func strPos(pos, length int) int {
+	if pos < 0 {
+		return length + pos + 1
+	}
+	return pos
+}
+
+func (s *State) openString() {
+	s.strings = NewTable()
+	s.SetGlobal("string", s.strings)
+	register(s.strings, map[string]GoFunction{
+		"byte": func(s *State, args []Value) []Value {
+			str := s.checkString(args, 0, "byte")
+			i := strPos(s.optInt(args, 1, "byte", 1), len(str))
+			j := strPos(s.optInt(args, 2, "byte", i), len(str))
+			i = max(i, 1)
+			j = min(j, len(str))
+			var results []Value
+			for k := i; k <= j; k++ {
+				results = append(results, float64(str[k-1]))
+			}
+			return results
+		},
+		"char": func(s *State, args []Value) []Value {
+			b := make([]byte, len(args))
+			for i := range args {
+				c := s.checkInt(args, i, "char")
+				if c < 0 || c > 255 {
+					s.argError(i, "char", "invalid value")
+				}
+				b[i] = byte(c)
+			}
+			return []Value{string(b)}
+		},
+		"find": func(s *State, args []Value) []Value {
+			return s.find(args, "find", true)
+		},
+		"format": func(s *State, args []Value) []Value {
+			return []Value{s.format(args)}
+		},
+		"gmatch": func(s *State, args []Value) []Value {
+			str := s.checkString(args, 0, "gmatch")
+			pat := s.checkString(args, 1, "gmatch")
+			pos := 0
+			return []Value{NewFunction("gmatch_iter", func(s *State, _ []Value) []Value {
+				ms := &matchState{s: s, src: str, pat: pat}
+				for ; pos <= len(str); pos++ {
+					ms.level = 0
+					if e := ms.match(pos, 0); e != -1 {
+						start := pos
+						pos = e
+						if e == start {
+							pos++
+						}
+						return ms.allCaptures(start, e, true)
+					}
+				}
+				return nil
+			})}
+		},
+		"gsub": func(s *State, args []Value) []Value {
+			return s.gsub(args)
+		},
+		"len": func(s *State, args []Value) []Value {
+			return []Value{float64(len(s.checkString(args, 0, "len")))}
+		},
+		"lower": func(s *State, args []Value) []Value {
+			return []Value{strings.ToLower(s.checkString(args, 0, "lower"))}
+		},
+		"match": func(s *State, args []Value) []Value {
+			return s.find(args, "match", false)
+		},
+		"rep": func(s *State, args []Value) []Value {
+			str := s.checkString(args, 0, "rep")
+			n := s.checkInt(args, 1, "rep")
+			if n <= 0 {
+				return []Value{""}
+			}
+			if len(str)*n > 512*1024*1024 {
+				s.Raise("resulting string too large")
+			}
+			return []Value{strings.Repeat(str, n)}
+		},
+		"reverse": func(s *State, args []Value) []Value {
+			b := []byte(s.checkString(args, 0, "reverse"))
+			for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+				b[i], b[j] = b[j], b[i]
+			}
+			return []Value{string(b)}
+		},
+		"sub": func(s *State, args []Value) []Value {
+			str := s.checkString(args, 0, "sub")
+			i := max(strPos(s.checkInt(args, 1, "sub"), len(str)), 1)
+			j := min(strPos(s.optInt(args, 2, "sub", -1), len(str)), len(str))
+			if i > j {
+				return []Value{""}
+			}
+			return []Value{str[i-1 : j]}
+		},
+		"upper": func(s *State, args []Value) []Value {
+			return []Value{strings.ToUpper(s.checkString(args, 0, "upper"))}
+		},
+	})
+}
+
+// find implements string.find and string.match.
+func (s *State) find(args []Value, fname string, find bool) []Value {
+	str := s.checkString(args, 0, fname)
+	pat := s.checkString(args, 1, fname)
+	init := strPos(s.optInt(args, 2, fname, 1), len(str)) - 1
+	init = min(max(init, 0), len(str))
+	if find && (Truthy(arg(args, 3)) || !strings.ContainsAny(pat, patternSpecial)) {
+		if i := strings.Index(str[init:], pat); i >= 0 {
+			return []Value{float64(init + i + 1), float64(init + i + len(pat))}
+		}
+		return []Value{nil}
+	}
+	anchor := strings.HasPrefix(pat, "^")
+	if anchor {
+		pat = pat[1:]
+	}
+	ms := &matchState{s: s, src: str, pat: pat}
+	for start := init; start <= len(str); start++ {
+		ms.level = 0
+		if e := ms.match(start, 0); e != -1 {
+			if find {
+				return append([]Value{float64(start + 1), float64(e)}, ms.allCaptures(start, e, false)...)
+			}
+			return ms.allCaptures(start, e, true)
+		}
+		if anchor {
+			break
+		}
+	}
+	return []Value{nil}
+}
+
+// gsub implements string.gsub.
+func (s *State) gsub(args []Value) []Value {
+	str := s.checkString(args, 0, "gsub")
+	pat := s.checkString(args, 1, "gsub")
+	repl := arg(args, 2)
+	switch repl.(type) {
+	case float64, string, *Table, *Function:
+	default:
+		s.typeError(args, 2, "gsub", "string/function/table")
+	}
+	maxN := s.optInt(args, 3, "gsub", len(str)+1)
+	anchor := strings.HasPrefix(pat, "^")
+	if anchor {
+		pat = pat[1:]
+	}
+	ms := &matchState{s: s, src: str, pat: pat}
+	var sb strings.Builder
+	src, n := 0, 0
+	for n < maxN {
+		ms.level = 0
+		e := ms.match(src, 0)
+		if e != -1 {
+			n++
+			s.addValue(ms, &sb, src, e, repl)
+		}
+		if e != -1 && e > src {
+			src = e
+		} else if src < len(str) {
+			sb.WriteByte(str[src])
+			src++
+		} else {
+			break
+		}
+		if anchor {
+			break
+		}
+	}
+	sb.WriteString(str[src:])
+	return []Value{sb.String(), float64(n)}
+}
+
+// addValue appends the replacement of a match from start to e.
+func (s *State) addValue(ms *matchState, sb *strings.Builder, start, e int, repl Value) {
+	var v Value
+	switch r := repl.(type) {
+	case float64, string:
+		tmpl, _ := ToString(r)
+		for i := 0; i < len(tmpl); i++ {
+			c := tmpl[i]
+			if c != '%' || i+1 >= len(tmpl) {
+				sb.WriteByte(c)
+				continue
+			}
+			i++
+			c = tmpl[i]
+			switch {
+			case c == '0':
+				sb.WriteString(ms.src[start:e])
+			case isDigit(c):
+				str, _ := ToString(ms.capture(int(c-'1'), start, e))
+				sb.WriteString(str)
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return
+	case *Table:
+		v = r.Get(ms.capture(0, start, e))
+	case *Function:
+		results := s.call(r, ms.allCaptures(start, e, true))
+		if len(results) > 0 {
+			v = results[0]
+		}
+	}
+	if !Truthy(v) {
+		sb.WriteString(ms.src[start:e])
+		return
+	}
+	str, ok := ToString(v)
+	if !ok {
+		s.Raise("invalid replacement value (a %s)", TypeName(v))
+	}
+	sb.WriteString(str)
+}
+
+// format implements string.format on top of the fmt package.
+func (s *State) format(args []Value) string {
+	f := s.checkString(args, 0, "format")
+	var sb strings.Builder
+	n := 0
+	for i := 0; i < len(f); i++ {
+		c := f[i]
+		if c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		if i < len(f) && f[i] == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+		start := i
+		for i < len(f) && strings.IndexByte("-+ #0", f[i]) >= 0 {
+			i++
+		}
+		for j := 0; j < 2 && i < len(f) && isDigit(f[i]); j++ {
+			i++
+		}
+		hasPrecision := i < len(f) && f[i] == '.'
+		if hasPrecision {
+			i++
+			for j := 0; j < 2 && i < len(f) && isDigit(f[i]); j++ {
+				i++
+			}
+		}
+		if i >= len(f) || isDigit(f[i]) {
+			s.Raise("invalid format (width or precision too long)")
+		}
+		spec := "%" + f[start:i]
+		n++
+		switch conv := f[i]; conv {
+		case 'd', 'i':
+			sb.WriteString(fmt.Sprintf(spec+"d", int64(s.checkNumber(args, n, "format"))))
+		case 'u':
+			sb.WriteString(fmt.Sprintf(spec+"d", uint64(int64(s.checkNumber(args, n, "format")))))
+		case 'c':
+			sb.WriteByte(byte(s.checkInt(args, n, "format")))
+		case 'o', 'x', 'X':
+			sb.WriteString(fmt.Sprintf(spec+string(conv), uint64(int64(s.checkNumber(args, n, "format")))))
+		case 'e', 'E', 'f', 'g', 'G':
+			x := s.checkNumber(args, n, "format")
+			if math.IsInf(x, 0) || math.IsNaN(x) {
+				str := FormatNumber(x)
+				if conv == 'E' || conv == 'G' {
+					str = strings.ToUpper(str)
+				}
+				sb.WriteString(fmt.Sprintf(strings.TrimRight(spec, ".0123456789")+"s", str))
+				continue
+			}
+			if !hasPrecision && (conv == 'g' || conv == 'G') {
+				spec += ".6"
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(conv), x))
+		case 'q':
+			sb.WriteString(quoteString(s.checkString(args, n, "format")))
+		case 's':
+			sb.WriteString(fmt.Sprintf(spec+"s", s.checkString(args, n, "format")))
+		default:
+			s.Raise("invalid option '%%%c' to 'format'", conv)
+		}
+	}
+	return sb.String()
+}
+
+// quoteString quotes a string so that Lua can read it back, as "%q" does.
+func quoteString(str string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString("\\\n")
+		case '\r':
+			sb.WriteString("\\r")
+		case 0:
+			sb.WriteString("\\000")
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
@@ -0,0 +1,96 @@
+package lua
+
+import (
+	"sort"
+	"strings"
+)
+
+func (s *State) openTable() {
+	t := NewTable()
+	s.SetGlobal("table", t)
+	register(t, map[string]GoFunction{
+		"concat": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "concat")
+			sep := s.optString(args, 1, "concat", "")
+			i := s.optInt(args, 2, "concat", 1)
+			j := s.optInt(args, 3, "concat", t.Len())
+			var sb strings.Builder
+			for k := i; k <= j; k++ {
+				str, ok := ToString(t.Get(float64(k)))
+				if !ok {
+					s.Raise("invalid value (at index %d) in table for 'concat'", k)
+				}
+				sb.WriteString(str)
+				if k < j {
+					sb.WriteString(sep)
+				}
+			}
+			return []Value{sb.String()}
+		},
+		"getn": func(s *State, args []Value) []Value {
+			return []Value{float64(s.checkTable(args, 0, "getn").Len())}
+		},
+		"insert": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "insert")
+			n := t.Len()
+			switch len(args) {
+			case 2:
+				t.Set(float64(n+1), args[1])
+			case 3:
+				pos := s.checkInt(args, 1, "insert")
+				for i := n; i >= pos; i-- {
+					t.Set(float64(i+1), t.Get(float64(i)))
+				}
+				s.rawSet(t, float64(pos), args[2])
+			default:
+				s.Raise("wrong number of arguments to 'insert'")
+			}
+			return nil
+		},
+		"maxn": func(s *State, args []Value) []Value {
+			maxN := 0.0
+			s.checkTable(args, 0, "maxn").ForEach(func(k, _ Value) {
+				if n, ok := k.(float64); ok && n > maxN {
+					maxN = n
+				}
+			})
+			return []Value{maxN}
+		},
+		"remove": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "remove")
+			n := t.Len()
+			pos := s.optInt(args, 1, "remove", n)
+			if n == 0 {
+				return nil
+			}
+			v := t.Get(float64(pos))
+			for ; pos < n; pos++ {
+				t.Set(float64(pos), t.Get(float64(pos+1)))
+			}
+			t.Set(float64(pos), nil)
+			return []Value{v}
+		},
+		"sort": func(s *State, args []Value) []Value {
+			t := s.checkTable(args, 0, "sort")
+			less := func(a, b Value) bool { return s.compare(a, b, false) }
+			if fn := arg(args, 1); fn != nil {
+				if _, ok := fn.(*Function); !ok {
+					s.typeError(args, 1, "sort", "function")
+				}
+				less = func(a, b Value) bool {
+					results := s.call(fn, []Value{a, b})
+					return len(results) > 0 && Truthy(results[0])
+				}
+			}
+			values := make([]Value, t.Len())
+			for i := range values {
+				values[i] = t.Get(float64(i + 1))
+			}
+			sort.Slice(values, func(i, j int) bool { return less(values[i], values[j]) })
+			for i, v := range values {
+				t.Set(float64(i+1), v)
+			}
+			return nil
+		},
+	})
+}
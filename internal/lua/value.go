@@ -0,0 +1,380 @@
+// Package lua implements an interpreter for the subset of Lua 5.1 used by
+// server-side scripts: the whole language except coroutines and goto, with
+// the base, string, table and math libraries, and the bit and cjson
+// libraries that Redis loads.
+//
+// Lua values are represented by Go values: nil, bool, float64, string,
+// *Table and *Function, and *Userdata for values such as cjson.null.
+package lua
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value is a Lua value: nil, bool, float64, string, *Table, *Function or
+// *Userdata.
+type Value any
+
+// Userdata is an opaque value, compared by identity, such as cjson.null.
+type Userdata struct {
+	name string
+}
+
+// GoFunction is a function implemented in Go. It receives the call arguments
+// and returns the results. Errors are raised with State.Raise or
+// State.RaiseValue.
+type GoFunction func(s *State, args []Value) []Value
+
+// Function is a Lua function value, either a closure over a compiled
+// function or a Go function.
+type Function struct {
+	name   string
+	proto  *funcProto
+	upvals []*cell
+	native GoFunction
+}
+
+// NewFunction returns a function value implemented by fn. name is used in
+// error messages.
+func NewFunction(name string, fn GoFunction) *Function {
+	return &Function{name: name, native: fn}
+}
+
+// cell holds a local variable, shared with the closures capturing it.
+type cell struct {
+	v Value
+}
+
+// TypeName returns the Lua type name of v.
+func TypeName(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *Table:
+		return "table"
+	case *Function:
+		return "function"
+	}
+	return "userdata"
+}
+
+// Truthy reports whether v counts as true in a condition: everything but
+// nil and false.
+func Truthy(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// FormatNumber formats n the way Lua's tostring does, with the C format
+// "%.14g".
+func FormatNumber(n float64) string {
+	switch {
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	case math.IsNaN(n):
+		return "nan"
+	case n == math.Trunc(n) && math.Abs(n) < 1e14:
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// ParseNumber converts a string to a number the way Lua does: decimal
+// numbers with an optional exponent, or hexadecimal integers prefixed with
+// 0x, surrounded by optional spaces.
+func ParseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	body, neg := s, false
+	if body[0] == '-' || body[0] == '+' {
+		neg = body[0] == '-'
+		body = body[1:]
+	}
+	if len(body) > 2 && body[0] == '0' && (body[1] == 'x' || body[1] == 'X') {
+		n, err := strconv.ParseUint(body[2:], 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		if neg {
+			return -float64(n), true
+		}
+		return float64(n), true
+	}
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if !(c >= '0' && c <= '9') && c != '.' && c != 'e' && c != 'E' && c != '+' && c != '-' {
+			// Rejects "inf", "nan" and Go-only syntax such as "1_000".
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// ToNumber converts v to a number, accepting numbers and numeric strings.
+func ToNumber(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		return ParseNumber(v)
+	}
+	return 0, false
+}
+
+// ToString converts v to a string, accepting strings and numbers.
+func ToString(v Value) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return FormatNumber(v), true
+	}
+	return "", false
+}
+
+// Table is a Lua table. Positive integer keys starting at 1 are stored in an
+// array part, other keys in a hash part that remembers insertion order so
+// that iteration is deterministic.
+type Table struct {
+	array   []Value
+	hash    map[Value]int
+	entries []tableEntry
+	removed int
+	// meta is the metatable set by setmetatable, or nil.
+	meta *Table
+}
+
+type tableEntry struct {
+	key   Value
+	value Value
+}
+
+// NewTable returns an empty table.
+func NewTable() *Table {
+	return &Table{}
+}
+
+// metamethod returns the handler of event in the metatable of t, or nil.
+func (t *Table) metamethod(event string) Value {
+	if t.meta == nil {
+		return nil
+	}
+	return t.meta.GetString(event)
+}
+
+// arrayIndex returns the array index of key if key is a positive integer.
+func arrayIndex(key Value) (int, bool) {
+	n, ok := key.(float64)
+	if !ok || n < 1 || n != math.Trunc(n) || n > math.MaxInt32 {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// normalizeKey maps -0 to 0 so both index the same slot.
+func normalizeKey(key Value) Value {
+	if n, ok := key.(float64); ok && n == 0 {
+		return float64(0)
+	}
+	return key
+}
+
+// Get returns t[key], without invoking metamethods.
+func (t *Table) Get(key Value) Value {
+	if i, ok := arrayIndex(key); ok && i <= len(t.array) {
+		return t.array[i-1]
+	}
+	if t.hash == nil {
+		return nil
+	}
+	if i, ok := t.hash[normalizeKey(key)]; ok {
+		return t.entries[i].value
+	}
+	return nil
+}
+
+// GetString returns t[key] for a string key.
+func (t *Table) GetString(key string) Value {
+	return t.Get(key)
+}
+
+// Set assigns t[key] = value. The key must not be nil or NaN; callers
+// raising Lua errors check it first.
+func (t *Table) Set(key, value Value) {
+	if i, ok := arrayIndex(key); ok {
+		switch {
+		case i <= len(t.array):
+			t.array[i-1] = value
+			if value == nil && i == len(t.array) {
+				t.trimArray()
+			}
+			return
+		case i == len(t.array)+1 && value != nil:
+			t.deleteHash(key)
+			t.array = append(t.array, value)
+			t.migrate()
+			return
+		}
+	}
+	key = normalizeKey(key)
+	if value == nil {
+		t.deleteHash(key)
+		return
+	}
+	if t.hash == nil {
+		t.hash = make(map[Value]int)
+	}
+	if i, ok := t.hash[key]; ok {
+		t.entries[i].value = value
+		return
+	}
+	if t.removed > 16 && t.removed > len(t.entries)/2 {
+		t.compact()
+	}
+	t.hash[key] = len(t.entries)
+	t.entries = append(t.entries, tableEntry{key, value})
+}
+
+// SetString assigns t[key] = value for a string key.
+func (t *Table) SetString(key string, value Value) {
+	t.Set(key, value)
+}
+
+// Append assigns value to the first free array slot, like table.insert.
+func (t *Table) Append(value Value) {
+	t.Set(float64(t.Len()+1), value)
+}
+
+// deleteHash clears the hash entry of key. The entry stays in place, with a
+// nil value, so that a traversal clearing fields can continue from it.
+func (t *Table) deleteHash(key Value) {
+	if t.hash == nil {
+		return
+	}
+	if i, ok := t.hash[key]; ok && t.entries[i].value != nil {
+		t.entries[i].value = nil
+		t.removed++
+	}
+}
+
+// trimArray drops the trailing nils of the array part.
+func (t *Table) trimArray() {
+	n := len(t.array)
+	for n > 0 && t.array[n-1] == nil {
+		n--
+	}
+	clear(t.array[n:])
+	t.array = t.array[:n]
+}
+
+// migrate moves the integer keys following the array part from the hash
+// part into it.
+func (t *Table) migrate() {
+	for t.hash != nil {
+		key := float64(len(t.array) + 1)
+		i, ok := t.hash[key]
+		if !ok || t.entries[i].value == nil {
+			return
+		}
+		t.array = append(t.array, t.entries[i].value)
+		t.entries[i].value = nil
+		t.removed++
+	}
+}
+
+// compact drops the cleared entries of the hash part.
+func (t *Table) compact() {
+	entries := t.entries[:0]
+	clear(t.hash)
+	for _, e := range t.entries {
+		if e.value != nil {
+			t.hash[e.key] = len(entries)
+			entries = append(entries, e)
+		}
+	}
+	clear(t.entries[len(entries):])
+	t.entries = entries
+	t.removed = 0
+}
+
+// Len returns the length of the table as the # operator does: the size of
+// its array part.
+func (t *Table) Len() int {
+	return len(t.array)
+}
+
+// Next returns the key and value following key in the traversal order of
+// the table, starting with the first one if key is nil. It returns a nil key
+// once the traversal is over, and false if key is not in the table.
+func (t *Table) Next(key Value) (Value, Value, bool) {
+	start := 0
+	if key != nil {
+		if i, ok := arrayIndex(key); ok && i <= len(t.array) {
+			start = i
+		} else if i, ok := t.hash[normalizeKey(key)]; ok {
+			return t.nextHash(i + 1)
+		} else if isArrayKey(key) {
+			// The key was in the array part, which shrank since.
+			return t.nextHash(0)
+		} else {
+			return nil, nil, false
+		}
+	}
+	for i := start; i < len(t.array); i++ {
+		if t.array[i] != nil {
+			return float64(i + 1), t.array[i], true
+		}
+	}
+	return t.nextHash(0)
+}
+
+// isArrayKey reports whether key is a positive integer.
+func isArrayKey(key Value) bool {
+	_, ok := arrayIndex(key)
+	return ok
+}
+
+// nextHash returns the first live hash entry at or after index i.
+func (t *Table) nextHash(i int) (Value, Value, bool) {
+	for ; i < len(t.entries); i++ {
+		if e := t.entries[i]; e.value != nil {
+			return e.key, e.value, true
+		}
+	}
+	return nil, nil, true
+}
+
+// ForEach calls fn for every key and value of the table, in traversal order.
+func (t *Table) ForEach(fn func(key, value Value)) {
+	for i, v := range t.array {
+		if v != nil {
+			fn(float64(i+1), v)
+		}
+	}
+	for _, e := range t.entries {
+		if e.value != nil {
+			fn(e.key, e.value)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -10,51 +11,116 @@ import (
 	"strings"
 ) 
 
-// ParseRequest parses a client request from the connection
+// Limits on the requests accepted by ParseRequest, as in Redis: at most
+// maxArgs arguments of at most maxBulkLength bytes each.
+const (
+	maxArgs       = 1024 * 1024
+	maxBulkLength = 512 << 20
+)
+
+// bulkChunkSize is the size above which bulk strings are read in chunks, so
+// that a large announced length does not allocate memory for data that never
+// arrives.
+const bulkChunkSize = 64 << 10
+
+// ParseRequest parses a client request from the connection. Arguments are
+// read as raw bytes of the announced length, so they may hold any byte,
+// including CR, LF and NUL.
 func ParseRequest(reader *bufio.Reader) ([]string, error) {
-	line, err := reader.ReadString('\n')
+	line, err := readLine(reader)
 	if err != nil {
 		return nil, err
 	}
-	line = strings.TrimSpace(line)
 	if len(line) == 0 || line[0] != '*' {
 		return nil, errors.New("protocol error: expected '*'")
 	}
-	numArgs, err := strconv.Atoi(line[1:])
-	if err != nil {
+	numArgs, ok := parseLength(line[1:], maxArgs)
+	if !ok {
 		return nil, errors.New("protocol error: invalid array length")
 	}
 	args := make([]string, numArgs)
 	for i := 0; i < numArgs; i++ {
-		line, err = reader.ReadString('\n')
+		line, err = readLine(reader)
 		if err != nil {
 			return nil, err
 		}
 		if len(line) == 0 || line[0] != '$' {
 			return nil, errors.New("protocol error: expected '$'")
 		}
-		argLen, err := strconv.Atoi(strings.TrimSpace(line[1:]))
-		if err != nil {
+		argLen, ok := parseLength(line[1:], maxBulkLength)
+		if !ok {
 			return nil, errors.New("protocol error: invalid bulk string length")
 		}
-		arg := make([]byte, argLen+2) // +2 for \r\n
-		_, err = io.ReadFull(reader, arg)
+		arg, err := readBulk(reader, argLen)
 		if err != nil {
 			return nil, err
 		}
-		args[i] = string(arg[:argLen])
+		args[i] = string(arg)
 	}
 	return args, nil
 }
 
+// readLine reads a header line and returns it without its CRLF terminator.
+// The returned slice is only valid until the next read.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errors.New("protocol error: too big header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errors.New("protocol error: expected CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+// parseLength parses the length in an array or bulk string header, which
+// must be between 0 and limit.
+func parseLength(b []byte, limit int) (int, bool) {
+	n, err := strconv.Atoi(string(b))
+	return n, err == nil && n >= 0 && n <= limit
+}
+
+// readBulk reads the n bytes of a bulk string followed by its CRLF, and
+// returns the bytes.
+func readBulk(reader *bufio.Reader, n int) ([]byte, error) {
+	var buf []byte
+	if n+2 <= bulkChunkSize {
+		buf = make([]byte, n+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		var b bytes.Buffer
+		b.Grow(bulkChunkSize)
+		if _, err := io.CopyN(&b, reader, int64(n+2)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		buf = b.Bytes()
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, errors.New("protocol error: expected CRLF after bulk string")
+	}
+	return buf[:n], nil
+}
+
+// lineReplacer replaces the CR and LF characters that can not appear in
+// simple strings and errors, which may quote arguments sent by the client.
+var lineReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
 // WriteSimpleString writes a simple string response to the client
 func WriteSimpleString(conn net.Conn, message string) {
-	fmt.Fprintf(conn, "+%s\r\n", message)
+	fmt.Fprintf(conn, "+%s\r\n", lineReplacer.Replace(message))
 }
 
 // WriteError writes an error response to the client
 func WriteError(conn net.Conn, message string) {
-	fmt.Fprintf(conn, "-%s\r\n", message)
+	fmt.Fprintf(conn, "-%s\r\n", lineReplacer.Replace(message))
 }
 
 // WriteBulkString writes a bulk string response to the client
@@ -62,11 +128,37 @@ func WriteBulkString(conn net.Conn, message string) {
 	fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(message), message)
 }
 
+// WriteInteger writes an integer response to the client
+func WriteInteger(conn net.Conn, n int64) {
+	fmt.Fprintf(conn, ":%d\r\n", n)
+}
+
 // WriteNullBulkString writes a null bulk string response to the client
 func WriteNullBulkString(conn net.Conn) {
 	fmt.Fprint(conn, "$-1\r\n")
 }
 
+// WriteArray writes an array of bulk strings response to the client
+func WriteArray(conn net.Conn, values []string) {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("*%d\r\n", len(values)))
+	for _, value := range values {
+		sb.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(value), value))
+	}
+	conn.Write([]byte(sb.String()))
+}
+
+// WriteArrayHeader writes the header of an array of n elements to the client.
+// The elements must be written by the caller right after it.
+func WriteArrayHeader(conn net.Conn, n int) {
+	fmt.Fprintf(conn, "*%d\r\n", n)
+}
+
+// WriteNullArray writes a null array response to the client
+func WriteNullArray(conn net.Conn) {
+	fmt.Fprint(conn, "*-1\r\n")
+}
+
 // FormatCommand formats a command for sending to the server
 func FormatCommand(args []string) string {
 	var sb strings.Builder
@@ -84,44 +176,68 @@ func WriteCommand(conn net.Conn, args []string) error {
 	return err
 }
 
-// ReadResponse reads and parses the server's response
-func ReadResponse(reader *bufio.Reader) (string, error) {
+// Reply is a parsed RESP reply. Type is the type byte of the reply: '+' for
+// a simple string, '-' for an error, ':' for an integer, '$' for a bulk
+// string and '*' for an array. Null bulk strings and null arrays have Null
+// set.
+type Reply struct {
+	Type     byte
+	Str      string
+	Int      int64
+	Null     bool
+	Elements []Reply
+}
+
+// ReadReply reads a complete reply, including nested arrays.
+func ReadReply(reader *bufio.Reader) (Reply, error) {
 	line, err := reader.ReadString('\n')
 	if err != nil {
-		return "", err
+		return Reply{}, err
 	}
-
-	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
 	if len(line) == 0 {
-		return "", errors.New("empty response")
+		return Reply{}, errors.New("empty response")
 	}
-
-	switch line[0] {
-	case '+':
-		// Simple string
-		return line[1:], nil
-	case '-':
-		// Error
-		return "(error) " + line[1:], nil
+	r := Reply{Type: line[0]}
+	switch r.Type {
+	case '+', '-':
+		r.Str = line[1:]
 	case ':':
-		// Integer
-		return line[1:], nil
+		r.Int, err = strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return Reply{}, errors.New("invalid integer reply")
+		}
 	case '$':
-		// Bulk string
 		length, err := strconv.Atoi(line[1:])
 		if err != nil {
-			return "", errors.New("invalid bulk string length")
+			return Reply{}, errors.New("invalid bulk string length")
 		}
-		if length == -1 {
-			return "(nil)", nil
+		if length < 0 {
+			r.Null = true
+			return r, nil
 		}
 		buf := make([]byte, length+2) // +2 for \r\n
-		_, err = io.ReadFull(reader, buf)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return Reply{}, err
+		}
+		r.Str = string(buf[:length])
+	case '*':
+		n, err := strconv.Atoi(line[1:])
 		if err != nil {
-			return "", err
+			return Reply{}, errors.New("invalid array length")
+		}
+		if n < 0 {
+			r.Null = true
+			return r, nil
+		}
+		r.Elements = make([]Reply, n)
+		for i := range r.Elements {
+			if r.Elements[i], err = ReadReply(reader); err != nil {
+				return Reply{}, err
+			}
 		}
-		return string(buf[:length]), nil
 	default:
-		return "", errors.New("unknown response type")
+		return Reply{}, errors.New("unknown response type")
 	}
+	return r, nil
 }
@@ -1,8 +1,12 @@
 package protocol
 
 import (
+	"bufio"
 	"bytes"
+	"io"
+	"math/rand"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -63,6 +67,120 @@ func TestWriteBulkString(t *testing.T) {
 	}
 }
 
+// TestReadReply tests if nested replies are parsed correctly
+func TestReadReply(t *testing.T) {
+	input := "*4\r\n+OK\r\n:-3\r\n$-1\r\n*2\r\n$5\r\nhello\r\n-ERR bad\r\n"
+	reply, err := ReadReply(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reply.Type != '*' || len(reply.Elements) != 4 {
+		t.Fatalf("Expected an array of 4 elements, got %+v", reply)
+	}
+	if e := reply.Elements[0]; e.Type != '+' || e.Str != "OK" {
+		t.Errorf("Expected status OK, got %+v", e)
+	}
+	if e := reply.Elements[1]; e.Type != ':' || e.Int != -3 {
+		t.Errorf("Expected integer -3, got %+v", e)
+	}
+	if e := reply.Elements[2]; e.Type != '$' || !e.Null {
+		t.Errorf("Expected a null bulk string, got %+v", e)
+	}
+	nested := reply.Elements[3]
+	if len(nested.Elements) != 2 || nested.Elements[0].Str != "hello" ||
+		nested.Elements[1].Type != '-' || nested.Elements[1].Str != "ERR bad" {
+		t.Errorf("Unexpected nested array %+v", nested)
+	}
+}
+
+// randomArgs returns n random byte strings of up to maxLen bytes, biased
+// towards the bytes that matter to the protocol.
+func randomArgs(rng *rand.Rand, n, maxLen int) []string {
+	special := []byte{'\r', '\n', 0, '$', '*', ' ', 0xff}
+	args := make([]string, n)
+	for i := range args {
+		b := make([]byte, rng.Intn(maxLen+1))
+		for j := range b {
+			if rng.Intn(4) == 0 {
+				b[j] = special[rng.Intn(len(special))]
+			} else {
+				b[j] = byte(rng.Intn(256))
+			}
+		}
+		args[i] = string(b)
+	}
+	return args
+}
+
+// TestParseRequestRoundTrip tests that random binary arguments survive
+// FormatCommand and ParseRequest unchanged
+func TestParseRequestRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		args := randomArgs(rng, 1+rng.Intn(5), 64)
+		parsed, err := ParseRequest(bufio.NewReader(strings.NewReader(FormatCommand(args))))
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", args, err)
+		}
+		if len(parsed) != len(args) {
+			t.Fatalf("Expected %d args, got %d", len(args), len(parsed))
+		}
+		for j := range args {
+			if parsed[j] != args[j] {
+				t.Fatalf("Expected arg %q, got %q", args[j], parsed[j])
+			}
+		}
+	}
+
+	// Arguments larger than a chunk are read in several parts.
+	large := randomArgs(rng, 1, 3*bulkChunkSize)
+	large[0] += strings.Repeat("\r\n", bulkChunkSize)
+	parsed, err := ParseRequest(bufio.NewReader(strings.NewReader(FormatCommand(large))))
+	if err != nil || len(parsed) != 1 || parsed[0] != large[0] {
+		t.Errorf("Failed to round-trip a %d byte argument: %v", len(large[0]), err)
+	}
+}
+
+// TestParseRequestErrors tests that malformed requests are rejected
+func TestParseRequestErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"PING\r\n", "protocol error: expected '*'"},
+		{"*1\n$4\r\nPING\r\n", "protocol error: expected CRLF"},
+		{"*-1\r\n", "protocol error: invalid array length"},
+		{"*1048577\r\n", "protocol error: invalid array length"},
+		{"*1\r\n+PING\r\n", "protocol error: expected '$'"},
+		{"*1\r\n$ 4\r\nPING\r\n", "protocol error: invalid bulk string length"},
+		{"*1\r\n$-4\r\nPING\r\n", "protocol error: invalid bulk string length"},
+		{"*1\r\n$536870913\r\n", "protocol error: invalid bulk string length"},
+		{"*1\r\n$4\r\nPINGXX", "protocol error: expected CRLF after bulk string"},
+		{"*1\r\n$4\r\nPI", io.ErrUnexpectedEOF.Error()},
+		{"*1\r\n$100000\r\nPING\r\n", io.ErrUnexpectedEOF.Error()},
+		{"*1\r\n$" + strings.Repeat("1", 5000) + "\r\n", "protocol error: too big header"},
+	}
+	for _, test := range tests {
+		_, err := ParseRequest(bufio.NewReader(strings.NewReader(test.input)))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("For %q expected error %q, got %v", test.input, test.expected, err)
+		}
+	}
+}
+
+// TestWriteErrorNewlines tests that CR and LF never end an error early
+func TestWriteErrorNewlines(t *testing.T) {
+	var buf bytes.Buffer
+	conn := &mockConn{&buf}
+
+	WriteError(conn, "ERR unknown command 'a\r\nb'")
+
+	expected := "-ERR unknown command 'a  b'\r\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
 // mockConn is a mock implementation of net.Conn for testing
 type mockConn struct {
 	writer *bytes.Buffer
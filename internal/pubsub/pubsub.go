@@ -0,0 +1,334 @@
+// Package pubsub implements the broker behind SUBSCRIBE, PSUBSCRIBE and
+// PUBLISH. Clients register a Subscriber with the broker and drain its
+// queue from their own goroutine, so PUBLISH never waits on a slow
+// connection: a subscriber whose queue grows past the output buffer limit is
+// dropped and its overflow callback disconnects the client.
+package pubsub
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/manimovassagh/Godis/internal/config"
+	"github.com/manimovassagh/Godis/internal/glob"
+)
+
+// outputBufferLimit is the number of bytes of undelivered messages a
+// subscriber may hold before it is disconnected. Zero disables the limit.
+var outputBufferLimit atomic.Int64
+
+func init() {
+	outputBufferLimit.Store(32 << 20)
+	config.Register(config.IntParam("pubsub-output-buffer-limit", &outputBufferLimit, 0, 1<<40))
+}
+
+// Message is a message delivered to a subscriber. Pattern is set for
+// messages matched by a PSUBSCRIBE pattern.
+type Message struct {
+	Pattern string
+	Channel string
+	Payload string
+}
+
+// size is the number of bytes the message accounts for in the output buffer.
+func (m Message) size() int64 {
+	return int64(len(m.Pattern) + len(m.Channel) + len(m.Payload))
+}
+
+// Subscriber is a client registered with the broker.
+type Subscriber struct {
+	// channels and patterns are guarded by the broker lock.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	mu         sync.Mutex
+	queue      []Message
+	pending    int64
+	closed     bool
+	ready      chan struct{}
+	onOverflow func()
+}
+
+// Broker routes published messages to the subscribers of the channel and of
+// the patterns matching it.
+type Broker struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscriber]struct{}
+	patterns map[string]map[*Subscriber]struct{}
+}
+
+var (
+	instance *Broker
+	once     sync.Once
+)
+
+// GetBroker returns the singleton Broker. It is safe to call from multiple
+// goroutines.
+func GetBroker() *Broker {
+	once.Do(func() {
+		instance = &Broker{
+			channels: make(map[string]map[*Subscriber]struct{}),
+			patterns: make(map[string]map[*Subscriber]struct{}),
+		}
+	})
+	return instance
+}
+
+// NewSubscriber returns a subscriber with no subscriptions. onOverflow is
+// called, from the publishing goroutine, if the subscriber is dropped
+// because its queue exceeded the output buffer limit.
+func (b *Broker) NewSubscriber(onOverflow func()) *Subscriber {
+	return &Subscriber{
+		channels:   make(map[string]struct{}),
+		patterns:   make(map[string]struct{}),
+		ready:      make(chan struct{}, 1),
+		onOverflow: onOverflow,
+	}
+}
+
+// Subscribe subscribes s to channel. It returns the number of channels and
+// patterns s is subscribed to afterwards.
+func (b *Broker) Subscribe(s *Subscriber, channel string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, found := s.channels[channel]; !found && !s.isClosed() {
+		s.channels[channel] = struct{}{}
+		add(b.channels, channel, s)
+	}
+	return len(s.channels) + len(s.patterns)
+}
+
+// Unsubscribe unsubscribes s from channel. It returns the number of channels
+// and patterns s is still subscribed to.
+func (b *Broker) Unsubscribe(s *Subscriber, channel string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, found := s.channels[channel]; found {
+		delete(s.channels, channel)
+		remove(b.channels, channel, s)
+	}
+	return len(s.channels) + len(s.patterns)
+}
+
+// PSubscribe subscribes s to the channels matching the glob pattern. It
+// returns the number of channels and patterns s is subscribed to afterwards.
+func (b *Broker) PSubscribe(s *Subscriber, pattern string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, found := s.patterns[pattern]; !found && !s.isClosed() {
+		s.patterns[pattern] = struct{}{}
+		add(b.patterns, pattern, s)
+	}
+	return len(s.channels) + len(s.patterns)
+}
+
+// PUnsubscribe unsubscribes s from pattern. It returns the number of
+// channels and patterns s is still subscribed to.
+func (b *Broker) PUnsubscribe(s *Subscriber, pattern string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, found := s.patterns[pattern]; found {
+		delete(s.patterns, pattern)
+		remove(b.patterns, pattern, s)
+	}
+	return len(s.channels) + len(s.patterns)
+}
+
+// Channels returns the channels s is subscribed to, sorted.
+func (b *Broker) Channels(s *Subscriber) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return sortedKeys(s.channels)
+}
+
+// Patterns returns the patterns s is subscribed to, sorted.
+func (b *Broker) Patterns(s *Subscriber) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return sortedKeys(s.patterns)
+}
+
+// Close removes every subscription of s and wakes up its reader, which then
+// sees the subscriber as closed.
+func (b *Broker) Close(s *Subscriber) {
+	b.mu.Lock()
+	b.drop(s)
+	b.mu.Unlock()
+	s.close()
+}
+
+// drop removes every subscription of s. The caller must hold the write lock.
+func (b *Broker) drop(s *Subscriber) {
+	for channel := range s.channels {
+		remove(b.channels, channel, s)
+	}
+	for pattern := range s.patterns {
+		remove(b.patterns, pattern, s)
+	}
+	clear(s.channels)
+	clear(s.patterns)
+}
+
+// Publish delivers payload to the subscribers of channel and of the patterns
+// matching it. It returns the number of deliveries, a subscriber matching
+// through several patterns counting once per pattern.
+func (b *Broker) Publish(channel, payload string) int {
+	var overflowed []*Subscriber
+	receivers := 0
+	b.mu.RLock()
+	for s := range b.channels[channel] {
+		receivers++
+		if !s.push(Message{Channel: channel, Payload: payload}) {
+			overflowed = append(overflowed, s)
+		}
+	}
+	for pattern, subscribers := range b.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		for s := range subscribers {
+			receivers++
+			if !s.push(Message{Pattern: pattern, Channel: channel, Payload: payload}) {
+				overflowed = append(overflowed, s)
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	if len(overflowed) > 0 {
+		b.mu.Lock()
+		for _, s := range overflowed {
+			b.drop(s)
+		}
+		b.mu.Unlock()
+		for _, s := range overflowed {
+			if s.close() && s.onOverflow != nil {
+				s.onOverflow()
+			}
+		}
+	}
+	return receivers
+}
+
+// ActiveChannels returns the channels with at least one subscriber that
+// match the glob pattern, sorted. An empty pattern matches every channel.
+func (b *Broker) ActiveChannels(pattern string) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var channels []string
+	for channel := range b.channels {
+		if pattern == "" || glob.Match(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// NumSub returns the number of subscribers of channel, not counting pattern
+// subscriptions.
+func (b *Broker) NumSub(channel string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.channels[channel])
+}
+
+// NumPat returns the number of distinct patterns subscribed to.
+func (b *Broker) NumPat() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.patterns)
+}
+
+// push queues m. It returns false if the subscriber overflowed its output
+// buffer limit, in which case m is not queued.
+func (s *Subscriber) push(m Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return true
+	}
+	limit := outputBufferLimit.Load()
+	if limit > 0 && s.pending+m.size() > limit {
+		return false
+	}
+	s.queue = append(s.queue, m)
+	s.pending += m.size()
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Next waits for queued messages and returns them in publication order. It
+// returns false once the subscriber is closed.
+func (s *Subscriber) Next() ([]Message, bool) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return nil, false
+		}
+		if len(s.queue) > 0 {
+			messages := s.queue
+			s.queue, s.pending = nil, 0
+			s.mu.Unlock()
+			return messages, true
+		}
+		s.mu.Unlock()
+		<-s.ready
+	}
+}
+
+// close marks the subscriber as closed and wakes up Next. It reports whether
+// the subscriber was open.
+func (s *Subscriber) close() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closed, s.queue, s.pending = true, nil, 0
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// isClosed reports whether the subscriber was closed.
+func (s *Subscriber) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// add records s as a subscriber of name in index.
+func add(index map[string]map[*Subscriber]struct{}, name string, s *Subscriber) {
+	subscribers, found := index[name]
+	if !found {
+		subscribers = make(map[*Subscriber]struct{})
+		index[name] = subscribers
+	}
+	subscribers[s] = struct{}{}
+}
+
+// remove forgets s as a subscriber of name in index.
+func remove(index map[string]map[*Subscriber]struct{}, name string, s *Subscriber) {
+	delete(index[name], s)
+	if len(index[name]) == 0 {
+		delete(index, name)
+	}
+}
+
+// sortedKeys returns the keys of m in ascending order.
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
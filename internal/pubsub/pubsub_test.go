@@ -0,0 +1,91 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestPublish tests delivery to channel and pattern subscribers
+func TestPublish(t *testing.T) {
+	b := GetBroker()
+	s := b.NewSubscriber(nil)
+	defer b.Close(s)
+
+	if n := b.Subscribe(s, "test:news"); n != 1 {
+		t.Errorf("Expected 1 subscription, got %d", n)
+	}
+	if n := b.PSubscribe(s, "test:*"); n != 2 {
+		t.Errorf("Expected 2 subscriptions, got %d", n)
+	}
+	if n := b.Subscribe(s, "test:news"); n != 2 {
+		t.Errorf("Expected subscribing twice to be a no-op, got %d subscriptions", n)
+	}
+
+	if n := b.Publish("test:news", "a"); n != 2 {
+		t.Errorf("Expected 2 receivers, got %d", n)
+	}
+	if n := b.Publish("test:sport", "b"); n != 1 {
+		t.Errorf("Expected 1 receiver, got %d", n)
+	}
+	if n := b.Publish("other", "c"); n != 0 {
+		t.Errorf("Expected no receivers, got %d", n)
+	}
+	messages, ok := s.Next()
+	if !ok || fmt.Sprint(messages) != "[{ test:news a} {test:* test:news a} {test:* test:sport b}]" {
+		t.Errorf("Unexpected messages %v, %v", messages, ok)
+	}
+
+	if got := b.ActiveChannels("test:*"); fmt.Sprint(got) != "[test:news]" {
+		t.Errorf("Expected [test:news], got %v", got)
+	}
+	if n := b.Unsubscribe(s, "test:news"); n != 1 {
+		t.Errorf("Expected 1 subscription left, got %d", n)
+	}
+	if n := b.NumSub("test:news"); n != 0 {
+		t.Errorf("Expected no subscribers, got %d", n)
+	}
+
+	b.Close(s)
+	if _, ok := s.Next(); ok {
+		t.Errorf("Expected Next to report the subscriber as closed")
+	}
+	if n := b.NumPat(); n != 0 {
+		t.Errorf("Expected no patterns after Close, got %d", n)
+	}
+}
+
+// TestOutputBufferLimit tests that a subscriber that does not keep up is
+// dropped without affecting the others
+func TestOutputBufferLimit(t *testing.T) {
+	old := outputBufferLimit.Load()
+	defer outputBufferLimit.Store(old)
+	outputBufferLimit.Store(100)
+
+	b := GetBroker()
+	overflowed := 0
+	slow := b.NewSubscriber(func() { overflowed++ })
+	fast := b.NewSubscriber(nil)
+	defer b.Close(fast)
+	b.Subscribe(slow, "limit")
+	b.Subscribe(fast, "limit")
+
+	payload := strings.Repeat("x", 40)
+	for i := 0; i < 3; i++ {
+		if n := b.Publish("limit", payload); n != 2 {
+			t.Fatalf("Expected 2 receivers for message %d, got %d", i, n)
+		}
+		if _, ok := fast.Next(); !ok {
+			t.Fatalf("Expected the fast subscriber to stay open")
+		}
+	}
+	if overflowed != 1 {
+		t.Errorf("Expected the slow subscriber to overflow once, got %d", overflowed)
+	}
+	if n := b.NumSub("limit"); n != 1 {
+		t.Errorf("Expected the slow subscriber to be dropped, got %d subscribers", n)
+	}
+	if _, ok := slow.Next(); ok {
+		t.Errorf("Expected the slow subscriber to be closed")
+	}
+}